@@ -0,0 +1,15 @@
+// Package api holds the types and headers the service and its clients
+// exchange. Ids are the ObjectIDs of the bson primitive package and training
+// data is the horizon model, it imports no other package of the service nor
+// gin, so that clients do not pull in the server.
+package api
+
+const (
+	// RequestIdHeader carries the id of a request, echoed in its response.
+	RequestIdHeader = "X-Request-Id"
+	// IdempotencyKeyHeader makes a write safe to retry.
+	IdempotencyKeyHeader = "Idempotency-Key"
+	// IdempotentReplayedHeader marks the stored response of an earlier
+	// request with the same idempotency key.
+	IdempotentReplayedHeader = "Idempotent-Replayed"
+)
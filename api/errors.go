@@ -0,0 +1,44 @@
+package api
+
+// ErrorKind classifies service errors independently of the transport.
+type ErrorKind string
+
+const (
+	KindNotFound   ErrorKind = "not_found"
+	KindConflict   ErrorKind = "conflict"
+	KindValidation ErrorKind = "validation"
+	KindForbidden  ErrorKind = "forbidden"
+	KindQuota      ErrorKind = "quota_exceeded"
+	KindTooLarge   ErrorKind = "payload_too_large"
+	// KindUnsupportedMediaType is a request body in a format the route does
+	// not accept.
+	KindUnsupportedMediaType ErrorKind = "unsupported_media_type"
+	// KindUnprocessable is a well formed request that cannot be honoured, such
+	// as a reused idempotency key.
+	KindUnprocessable ErrorKind = "unprocessable_entity"
+	// KindPrecondition is a failed If-Match, KindPreconditionRequired a
+	// missing one on a request that needs it.
+	KindPrecondition         ErrorKind = "precondition_failed"
+	KindPreconditionRequired ErrorKind = "precondition_required"
+	// KindFailedDependency is an operation left out because another one it
+	// was grouped with failed.
+	KindFailedDependency ErrorKind = "failed_dependency"
+	KindInternal         ErrorKind = "internal"
+)
+
+// FieldError points a validation failure at a single input field.
+type FieldError struct {
+	Field   string `json:"field"`
+	Message string `json:"message"`
+}
+
+// Problem is the RFC 7807 body sent for every failed request.
+type Problem struct {
+	Type      string       `json:"type"`
+	Title     string       `json:"title"`
+	Status    int          `json:"status"`
+	Detail    string       `json:"detail"`
+	Code      ErrorKind    `json:"code"`
+	Errors    []FieldError `json:"errors,omitempty"`
+	RequestId string       `json:"requestId,omitempty"`
+}
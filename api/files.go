@@ -0,0 +1,137 @@
+package api
+
+import (
+	"github.com/draco121/horizon/models"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"time"
+)
+
+// FileMetadata holds the per-file details that do not fit in models.Files.
+type FileMetadata struct {
+	FileId    primitive.ObjectID `json:"fileId" bson:"_id"`
+	ProjectId primitive.ObjectID `json:"projectId" bson:"projectId"`
+	BotId     primitive.ObjectID `json:"botId" bson:"botId"`
+	Owner     primitive.ObjectID `json:"owner" bson:"owner"`
+	FileName  string             `json:"fileName" bson:"fileName"`
+	Extension string             `json:"extension" bson:"extension"`
+	MimeType  string             `json:"mimeType" bson:"mimeType"`
+	Size      int64              `json:"size" bson:"size"`
+	Checksum  string             `json:"checksum" bson:"checksum"`
+	// Folder is the slash separated folder of the file, empty at the root.
+	Folder string            `json:"folder" bson:"folder"`
+	Tags   []string          `json:"tags" bson:"tags"`
+	Labels map[string]string `json:"labels" bson:"labels"`
+	// ScanStatus is one of the scanner statuses. Quarantined files are kept
+	// out of the bot space and cannot be downloaded.
+	ScanStatus    string    `json:"scanStatus" bson:"scanStatus"`
+	ScanSignature string    `json:"scanSignature,omitempty" bson:"scanSignature,omitempty"`
+	ScannedAt     time.Time `json:"scannedAt,omitempty" bson:"scannedAt,omitempty"`
+	Quarantined   bool      `json:"quarantined" bson:"quarantined"`
+	Encrypted     bool      `json:"encrypted" bson:"encrypted"`
+	// Revision counts the contents the file had, replacing it in place
+	// increments it. Files stored before revisions count as revision 1.
+	Revision  int64     `json:"revision" bson:"revision"`
+	CreatedAt time.Time `json:"createdAt" bson:"createdAt"`
+	UpdatedAt time.Time `json:"updatedAt,omitempty" bson:"updatedAt,omitempty"`
+}
+
+// FileFilter narrows a file listing. Empty fields do not filter; Tags must
+// all be present and Labels must all match.
+type FileFilter struct {
+	Folder string
+	// Recursive also matches the files in the subfolders of Folder.
+	Recursive bool
+	Tags      []string
+	Labels    map[string]string
+}
+
+// TagChange edits the tags and labels of files.
+type TagChange struct {
+	AddTags      []string          `json:"addTags"`
+	RemoveTags   []string          `json:"removeTags"`
+	SetLabels    map[string]string `json:"setLabels"`
+	RemoveLabels []string          `json:"removeLabels"`
+}
+
+// FileRevision is a previous content of a file that was replaced in place.
+// Current is only set on the entry standing for the content being served.
+type FileRevision struct {
+	Id         primitive.ObjectID `json:"id" bson:"_id"`
+	FileId     primitive.ObjectID `json:"fileId" bson:"fileId"`
+	ProjectId  primitive.ObjectID `json:"projectId" bson:"projectId"`
+	BotId      primitive.ObjectID `json:"botId" bson:"botId"`
+	Owner      primitive.ObjectID `json:"owner" bson:"owner"`
+	Revision   int64              `json:"revision" bson:"revision"`
+	FileName   string             `json:"fileName" bson:"fileName"`
+	Extension  string             `json:"extension" bson:"extension"`
+	MimeType   string             `json:"mimeType" bson:"mimeType"`
+	Size       int64              `json:"size" bson:"size"`
+	Checksum   string             `json:"checksum" bson:"checksum"`
+	Encrypted  bool               `json:"encrypted" bson:"encrypted"`
+	CreatedAt  time.Time          `json:"createdAt" bson:"createdAt"`
+	ReplacedAt time.Time          `json:"replacedAt,omitempty" bson:"replacedAt"`
+	Current    bool               `json:"current" bson:"-"`
+}
+
+// UploadResult lists the files stored by an upload and those the upload policy
+// turned away. Skipped lists the archive entries that were not extracted.
+type UploadResult struct {
+	Files    []models.Files  `json:"files"`
+	Rejected []FileRejection `json:"rejected"`
+	Skipped  []FileRejection `json:"skipped,omitempty"`
+}
+
+// FileRejection explains why a single file of an upload was not stored.
+// Archive names the uploaded archive an extracted file came from.
+type FileRejection struct {
+	FileName string `json:"fileName"`
+	Archive  string `json:"archive,omitempty"`
+	Code     string `json:"code"`
+	Reason   string `json:"reason"`
+}
+
+// MoveFilesRequest is the body of a bulk move.
+type MoveFilesRequest struct {
+	FileIds []primitive.ObjectID `json:"fileIds"`
+	Folder  string               `json:"folder"`
+}
+
+// TagFilesRequest is the body of a bulk retag.
+type TagFilesRequest struct {
+	FileIds []primitive.ObjectID `json:"fileIds"`
+	TagChange
+}
+
+// BatchRequest is the body of a batch over files. An atomic batch is applied
+// as a whole or not at all.
+type BatchRequest struct {
+	Atomic     bool             `json:"atomic"`
+	Operations []BatchOperation `json:"operations"`
+}
+
+// BatchItemResult is the outcome of an operation on one file, Error is set
+// when it was not applied.
+type BatchItemResult struct {
+	Operation int                `json:"operation"`
+	Op        string             `json:"op"`
+	FileId    primitive.ObjectID `json:"fileId"`
+	Status    int                `json:"status"`
+	Error     *Problem           `json:"error,omitempty"`
+}
+
+type BatchResponse struct {
+	Atomic  bool              `json:"atomic"`
+	Applied int               `json:"applied"`
+	Failed  int               `json:"failed"`
+	Results []BatchItemResult `json:"results"`
+}
+
+// BatchOperation is one operation of a batch over files of a bot. Folder is
+// used by move, the tag change by tag and Revision by restore.
+type BatchOperation struct {
+	Op       string               `json:"op"`
+	FileIds  []primitive.ObjectID `json:"fileIds"`
+	Folder   string               `json:"folder,omitempty"`
+	Revision int64                `json:"revision,omitempty"`
+	TagChange
+}
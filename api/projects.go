@@ -0,0 +1,67 @@
+package api
+
+import (
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"time"
+)
+
+// UploadPolicy restricts what may be uploaded into the bots of a project.
+// Zero limits are treated as unlimited and an empty allowlist accepts any type.
+type UploadPolicy struct {
+	Id               primitive.ObjectID `json:"-" bson:"_id,omitempty"`
+	ProjectId        primitive.ObjectID `json:"projectId" bson:"projectId"`
+	Owner            primitive.ObjectID `json:"owner" bson:"owner"`
+	AllowedMimeTypes []string           `json:"allowedMimeTypes" bson:"allowedMimeTypes"`
+	MaxFileSize      int64              `json:"maxFileSize" bson:"maxFileSize"`
+	MaxRequestSize   int64              `json:"maxRequestSize" bson:"maxRequestSize"`
+	MaxFilesPerBot   int64              `json:"maxFilesPerBot" bson:"maxFilesPerBot"`
+	UpdatedAt        time.Time          `json:"updatedAt" bson:"updatedAt"`
+}
+
+// Usage is the running storage total of a single bot.
+type Usage struct {
+	ProjectId primitive.ObjectID `json:"projectId" bson:"projectId"`
+	BotId     primitive.ObjectID `json:"botId" bson:"botId"`
+	Owner     primitive.ObjectID `json:"owner" bson:"owner"`
+	Bytes     int64              `json:"bytes" bson:"bytes"`
+	Files     int64              `json:"files" bson:"files"`
+	UpdatedAt time.Time          `json:"updatedAt" bson:"updatedAt"`
+}
+
+// UsageEvent is an entry in the usage history of a project.
+type UsageEvent struct {
+	ID        primitive.ObjectID `json:"id" bson:"_id"`
+	ProjectId primitive.ObjectID `json:"projectId" bson:"projectId"`
+	BotId     primitive.ObjectID `json:"botId" bson:"botId"`
+	Owner     primitive.ObjectID `json:"owner" bson:"owner"`
+	Kind      string             `json:"kind" bson:"kind"`
+	Bytes     int64              `json:"bytes" bson:"bytes"`
+	Files     int64              `json:"files" bson:"files"`
+	Message   string             `json:"message,omitempty" bson:"message,omitempty"`
+	CreatedAt time.Time          `json:"createdAt" bson:"createdAt"`
+}
+
+// QuotaLimits caps storage in bytes and number of files. Zero means unlimited.
+type QuotaLimits struct {
+	MaxBytes int64 `json:"maxBytes"`
+	MaxFiles int64 `json:"maxFiles"`
+}
+
+// QuotaPolicy holds the hard and soft limits applied per project and per bot.
+// Uploads crossing a hard limit are rejected, uploads crossing a soft limit are
+// accepted and recorded as a warning in the usage history.
+type QuotaPolicy struct {
+	ProjectHard QuotaLimits `json:"projectHard"`
+	ProjectSoft QuotaLimits `json:"projectSoft"`
+	BotHard     QuotaLimits `json:"botHard"`
+	BotSoft     QuotaLimits `json:"botSoft"`
+}
+
+type ProjectUsage struct {
+	ProjectId primitive.ObjectID `json:"projectId"`
+	Bytes     int64              `json:"bytes"`
+	Files     int64              `json:"files"`
+	Policy    QuotaPolicy        `json:"policy"`
+	Bots      []Usage            `json:"bots"`
+	History   []UsageEvent       `json:"history"`
+}
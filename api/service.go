@@ -0,0 +1,44 @@
+package api
+
+import (
+	"time"
+)
+
+// DependencyCheck is the outcome of probing one dependency.
+type DependencyCheck struct {
+	Name      string         `json:"name"`
+	Status    string         `json:"status"`
+	LatencyMs float64        `json:"latencyMs"`
+	Error     string         `json:"error,omitempty"`
+	Details   map[string]any `json:"details,omitempty"`
+}
+
+type Readiness struct {
+	Ready        bool              `json:"ready"`
+	ShuttingDown bool              `json:"shuttingDown"`
+	Checks       []DependencyCheck `json:"checks"`
+}
+
+// WorkerState describes a background job started by the service.
+type WorkerState struct {
+	Name       string     `json:"name"`
+	State      string     `json:"state"`
+	StartedAt  time.Time  `json:"startedAt"`
+	FinishedAt *time.Time `json:"finishedAt,omitempty"`
+	Error      string     `json:"error,omitempty"`
+}
+
+type BuildInfo struct {
+	Version   string `json:"version"`
+	Revision  string `json:"revision,omitempty"`
+	GoVersion string `json:"goVersion"`
+}
+
+type StatusReport struct {
+	Build     BuildInfo     `json:"build"`
+	StartedAt time.Time     `json:"startedAt"`
+	Uptime    string        `json:"uptime"`
+	Readiness Readiness     `json:"readiness"`
+	Config    any           `json:"config"`
+	Workers   []WorkerState `json:"workers"`
+}
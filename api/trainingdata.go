@@ -0,0 +1,12 @@
+package api
+
+import (
+	"github.com/draco121/horizon/models"
+)
+
+// VersionedTrainingData is a training data document along with its version,
+// which every write increments.
+type VersionedTrainingData struct {
+	models.TrainingData `bson:",inline"`
+	Version             int64 `json:"version" bson:"version"`
+}
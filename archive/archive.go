@@ -0,0 +1,102 @@
+package archive
+
+import (
+	"archive/tar"
+	"archive/zip"
+	"compress/gzip"
+	"errors"
+	"io"
+	"time"
+)
+
+const (
+	FormatZip   = "zip"
+	FormatTarGz = "tar.gz"
+)
+
+var ErrUnsupportedFormat = errors.New("unsupported archive format")
+
+// Entry is a file to put in an archive. Open is only called when the entry
+// is written, so that files are read one at a time.
+type Entry struct {
+	Name    string
+	ModTime time.Time
+	Open    func() (io.ReadCloser, int64, error)
+}
+
+// ContentType is the media type of an archive format.
+func ContentType(format string) string {
+	switch format {
+	case FormatZip:
+		return "application/zip"
+	case FormatTarGz:
+		return "application/gzip"
+	}
+	return "application/octet-stream"
+}
+
+// Write streams entries as an archive of format to w. The archive is only
+// finished when every entry was written, a failure leaves it truncated so
+// that readers notice.
+func Write(w io.Writer, format string, entries []Entry) error {
+	switch format {
+	case FormatZip:
+		return writeZip(w, entries)
+	case FormatTarGz:
+		return writeTarGz(w, entries)
+	}
+	return ErrUnsupportedFormat
+}
+
+func writeZip(w io.Writer, entries []Entry) error {
+	writer := zip.NewWriter(w)
+	for _, entry := range entries {
+		content, _, err := entry.Open()
+		if err != nil {
+			return err
+		}
+		target, err := writer.CreateHeader(&zip.FileHeader{
+			Name:     entry.Name,
+			Method:   zip.Deflate,
+			Modified: entry.ModTime,
+		})
+		if err == nil {
+			_, err = io.Copy(target, content)
+		}
+		_ = content.Close()
+		if err != nil {
+			return err
+		}
+	}
+	return writer.Close()
+}
+
+func writeTarGz(w io.Writer, entries []Entry) error {
+	compressor := gzip.NewWriter(w)
+	writer := tar.NewWriter(compressor)
+	for _, entry := range entries {
+		content, size, err := entry.Open()
+		if err != nil {
+			return err
+		}
+		err = writer.WriteHeader(&tar.Header{
+			Typeflag: tar.TypeReg,
+			Name:     entry.Name,
+			Size:     size,
+			Mode:     0644,
+			ModTime:  entry.ModTime,
+		})
+		if err == nil {
+			_, err = io.Copy(writer, content)
+		}
+		_ = content.Close()
+		if err != nil {
+			return err
+		}
+	}
+	err := writer.Close()
+	if err != nil {
+		return err
+	}
+	return compressor.Close()
+}
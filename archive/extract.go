@@ -0,0 +1,340 @@
+package archive
+
+import (
+	"archive/tar"
+	"archive/zip"
+	"bytes"
+	"compress/gzip"
+	"errors"
+	"fmt"
+	"io"
+	"mime/multipart"
+	"path"
+	"pulse/naming"
+	"strings"
+)
+
+// FormatTar is only read, downloads are always compressed.
+const FormatTar = "tar"
+
+var ErrArchiveTooLarge = errors.New("archive expands beyond the allowed limits")
+
+const (
+	SkipUnsafePath  = "unsafe_path"
+	SkipNotRegular  = "not_a_regular_file"
+	SkipSystemFile  = "system_file"
+	SkipUnsupported = "unsupported_entry"
+)
+
+const notRegularReason = "links, devices and other special entries are not extracted"
+
+// ratioFloor is the expanded size up to which the compression ratio is not
+// checked, as small archives of text legitimately compress very well.
+const ratioFloor = 10 << 20
+
+// Limits bound what the archives of an upload may expand to. MaxEntries and
+// MaxTotalSize hold for all the archives of the upload together, MaxRatio for
+// each of them. Zero values are treated as unlimited.
+type Limits struct {
+	MaxEntries   int
+	MaxTotalSize int64
+	MaxRatio     int64
+}
+
+// Budget is what is left of the limits of an upload as its archives are
+// extracted one after the other.
+type Budget struct {
+	limits  Limits
+	entries int
+	total   int64
+}
+
+func NewBudget(limits Limits) *Budget {
+	return &Budget{limits: limits}
+}
+
+// Skipped is an archive entry that was not extracted.
+type Skipped struct {
+	Path   string
+	Code   string
+	Reason string
+}
+
+// ExtractedFile is an archive entry turned into an upload, along with the
+// folder it had in the archive.
+type ExtractedFile struct {
+	Header *multipart.FileHeader
+	Folder string
+}
+
+// Extraction holds the entries of an expanded archive. Close removes the
+// temporary files backing them.
+type Extraction struct {
+	Files   []ExtractedFile
+	Skipped []Skipped
+	form    *multipart.Form
+}
+
+func (e *Extraction) Close() error {
+	if e.form == nil {
+		return nil
+	}
+	return e.form.RemoveAll()
+}
+
+// systemFiles are written by archiving tools and never training data.
+var systemFiles = map[string]bool{".DS_Store": true, "Thumbs.db": true, "desktop.ini": true}
+
+// Detect tells whether an upload is an archive that can be extracted and
+// returns its format, or "" for any other file.
+func Detect(file *multipart.FileHeader) (string, error) {
+	reader, err := file.Open()
+	if err != nil {
+		return "", err
+	}
+	defer reader.Close()
+	header := make([]byte, 512)
+	n, err := io.ReadFull(reader, header)
+	if err != nil && !errors.Is(err, io.ErrUnexpectedEOF) && !errors.Is(err, io.EOF) {
+		return "", err
+	}
+	header = header[:n]
+	switch {
+	case bytes.HasPrefix(header, []byte("PK\x03\x04")), bytes.HasPrefix(header, []byte("PK\x05\x06")):
+		return FormatZip, nil
+	case isTar(header):
+		return FormatTar, nil
+	case bytes.HasPrefix(header, []byte{0x1f, 0x8b}):
+		_, err = reader.Seek(0, io.SeekStart)
+		if err != nil {
+			return "", err
+		}
+		decompressor, err := gzip.NewReader(reader)
+		if err != nil {
+			return "", nil
+		}
+		n, _ = io.ReadFull(decompressor, header[:cap(header)])
+		if isTar(header[:n]) {
+			return FormatTarGz, nil
+		}
+	}
+	return "", nil
+}
+
+func isTar(header []byte) bool {
+	return len(header) >= 262 && string(header[257:262]) == "ustar"
+}
+
+// Extract expands an uploaded archive into uploads of its own, keeping up to
+// maxMemory of them in memory and the rest in temporary files. Unsafe or
+// unusable entries are skipped and reported; exceeding what is left of budget
+// fails the whole archive with ErrArchiveTooLarge. The entries of an archive
+// are only taken from budget when it is extracted.
+func Extract(file *multipart.FileHeader, format string, budget *Budget, maxMemory int64) (*Extraction, error) {
+	reader, err := file.Open()
+	if err != nil {
+		return nil, err
+	}
+	defer reader.Close()
+	pipeReader, pipeWriter := io.Pipe()
+	writer := multipart.NewWriter(pipeWriter)
+	extractor := &extractor{writer: writer, limits: budget.limits}
+	if budget.limits.MaxEntries > 0 {
+		extractor.maxEntries = budget.limits.MaxEntries - budget.entries
+	}
+	if budget.limits.MaxTotalSize > 0 {
+		extractor.maxTotal = budget.limits.MaxTotalSize - budget.total
+	}
+	if budget.limits.MaxRatio > 0 {
+		byRatio := max(budget.limits.MaxRatio*file.Size, ratioFloor)
+		if budget.limits.MaxTotalSize <= 0 || byRatio < extractor.maxTotal {
+			extractor.maxTotal = byRatio
+			extractor.byRatio = true
+		}
+	}
+	done := make(chan error, 1)
+	go func() {
+		var err error
+		switch format {
+		case FormatZip:
+			err = extractor.zip(reader, file.Size)
+		case FormatTar:
+			err = extractor.tar(reader)
+		case FormatTarGz:
+			var decompressor *gzip.Reader
+			decompressor, err = gzip.NewReader(reader)
+			if err == nil {
+				err = extractor.tar(decompressor)
+			}
+		default:
+			err = ErrUnsupportedFormat
+		}
+		if err == nil {
+			err = writer.Close()
+		}
+		_ = pipeWriter.CloseWithError(err)
+		done <- err
+	}()
+	form, readErr := multipart.NewReader(pipeReader, writer.Boundary()).ReadForm(maxMemory)
+	_ = pipeReader.CloseWithError(errors.New("extraction aborted"))
+	err = <-done
+	if err == nil {
+		err = readErr
+	}
+	if err != nil {
+		if form != nil {
+			_ = form.RemoveAll()
+		}
+		return nil, err
+	}
+	budget.entries += extractor.entries
+	budget.total += extractor.total
+	extraction := &Extraction{Skipped: extractor.skipped, form: form}
+	for i, header := range form.File["files"] {
+		extraction.Files = append(extraction.Files, ExtractedFile{Header: header, Folder: extractor.folders[i]})
+	}
+	return extraction, nil
+}
+
+// extractor re-encodes archive entries as parts of a multipart form, which
+// turns them into uploads like any other. maxEntries and maxTotal are what
+// the archive may add to the upload, byRatio tells that maxTotal comes from
+// the compression ratio.
+type extractor struct {
+	writer     *multipart.Writer
+	limits     Limits
+	maxEntries int
+	maxTotal   int64
+	byRatio    bool
+	entries    int
+	total      int64
+	folders    []string
+	skipped    []Skipped
+}
+
+func (e *extractor) skip(name string, code string, reason string) {
+	e.skipped = append(e.skipped, Skipped{Path: name, Code: code, Reason: reason})
+}
+
+func (e *extractor) zip(reader io.ReaderAt, size int64) error {
+	archive, err := zip.NewReader(reader, size)
+	if err != nil {
+		return err
+	}
+	if e.limits.MaxEntries > 0 && len(archive.File) > e.maxEntries {
+		return e.tooManyEntries()
+	}
+	for _, file := range archive.File {
+		mode := file.Mode()
+		if mode.IsDir() {
+			continue
+		} else if !mode.IsRegular() {
+			e.skip(file.Name, SkipNotRegular, notRegularReason)
+			continue
+		} else if file.Flags&0x1 != 0 {
+			e.skip(file.Name, SkipUnsupported, "encrypted entries are not supported")
+			continue
+		}
+		content, err := file.Open()
+		if err != nil {
+			e.skip(file.Name, SkipUnsupported, err.Error())
+			continue
+		}
+		err = e.add(file.Name, content)
+		_ = content.Close()
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func (e *extractor) tar(reader io.Reader) error {
+	archive := tar.NewReader(reader)
+	for {
+		header, err := archive.Next()
+		if errors.Is(err, io.EOF) {
+			return nil
+		} else if err != nil {
+			return err
+		}
+		switch header.Typeflag {
+		case tar.TypeDir:
+			continue
+		case tar.TypeReg:
+			err = e.add(header.Name, archive)
+			if err != nil {
+				return err
+			}
+		default:
+			e.skip(header.Name, SkipNotRegular, notRegularReason)
+		}
+	}
+}
+
+func (e *extractor) add(name string, content io.Reader) error {
+	folder, base, err := entryPath(name)
+	if err != nil {
+		e.skip(name, SkipUnsafePath, err.Error())
+		return nil
+	}
+	if systemFiles[base] || strings.HasPrefix(base, "._") || strings.HasPrefix(folder+"/", "__MACOSX/") {
+		e.skip(name, SkipSystemFile, "archiver metadata is not extracted")
+		return nil
+	}
+	e.entries++
+	if e.limits.MaxEntries > 0 && e.entries > e.maxEntries {
+		return e.tooManyEntries()
+	}
+	part, err := e.writer.CreateFormFile("files", base)
+	if err != nil {
+		return err
+	}
+	limited := e.limits.MaxTotalSize > 0 || e.limits.MaxRatio > 0
+	if limited {
+		content = io.LimitReader(content, max(e.maxTotal-e.total, 0)+1)
+	}
+	n, err := io.Copy(part, content)
+	e.total += n
+	if limited && e.total > e.maxTotal {
+		if e.byRatio {
+			return fmt.Errorf("%w: expands more than %d times its size", ErrArchiveTooLarge, e.limits.MaxRatio)
+		}
+		return fmt.Errorf("%w: the upload expands to more than %d bytes", ErrArchiveTooLarge, e.limits.MaxTotalSize)
+	} else if err != nil {
+		return err
+	}
+	e.folders = append(e.folders, folder)
+	return nil
+}
+
+func (e *extractor) tooManyEntries() error {
+	return fmt.Errorf("%w: the upload expands to more than %d entries", ErrArchiveTooLarge, e.limits.MaxEntries)
+}
+
+// entryPath splits an archive entry name into its folder and file name. Names
+// that are absolute or climb out of the archive are refused outright rather
+// than cleaned, and every element must be a valid file name.
+func entryPath(name string) (string, string, error) {
+	name = strings.ReplaceAll(name, `\`, "/")
+	if strings.HasPrefix(name, "/") || (len(name) > 1 && name[1] == ':') {
+		return "", "", errors.New("absolute paths are not allowed")
+	}
+	elements := make([]string, 0)
+	for _, element := range strings.Split(name, "/") {
+		if element == "" || element == "." {
+			continue
+		} else if element == ".." {
+			return "", "", errors.New("paths leaving the archive are not allowed")
+		}
+		normalized, err := naming.NormalizeFileName(element)
+		if err != nil {
+			return "", "", err
+		}
+		elements = append(elements, normalized)
+	}
+	if len(elements) == 0 {
+		return "", "", errors.New("empty path")
+	}
+	return path.Join(elements[:len(elements)-1]...), elements[len(elements)-1], nil
+}
@@ -0,0 +1,280 @@
+package archive
+
+import (
+	"archive/tar"
+	"archive/zip"
+	"bytes"
+	"compress/gzip"
+	"errors"
+	"io"
+	"mime/multipart"
+	"os"
+	"sort"
+	"testing"
+)
+
+// entry is a file of a crafted archive, a non empty link makes it a
+// symbolic link to it.
+type entry struct {
+	name    string
+	content string
+	link    string
+}
+
+func zipArchive(t *testing.T, entries ...entry) []byte {
+	t.Helper()
+	var buf bytes.Buffer
+	writer := zip.NewWriter(&buf)
+	for _, e := range entries {
+		header := &zip.FileHeader{Name: e.name, Method: zip.Deflate}
+		content := e.content
+		if e.link != "" {
+			header.SetMode(os.ModeSymlink | 0o777)
+			content = e.link
+		}
+		w, err := writer.CreateHeader(header)
+		if err != nil {
+			t.Fatal(err)
+		}
+		_, _ = w.Write([]byte(content))
+	}
+	if err := writer.Close(); err != nil {
+		t.Fatal(err)
+	}
+	return buf.Bytes()
+}
+
+func tarGzArchive(t *testing.T, entries ...entry) []byte {
+	t.Helper()
+	var buf bytes.Buffer
+	compressor := gzip.NewWriter(&buf)
+	writer := tar.NewWriter(compressor)
+	for _, e := range entries {
+		header := &tar.Header{Name: e.name, Mode: 0o644, Size: int64(len(e.content)), Typeflag: tar.TypeReg}
+		if e.link != "" {
+			header = &tar.Header{Name: e.name, Mode: 0o777, Linkname: e.link, Typeflag: tar.TypeSymlink}
+		}
+		if err := writer.WriteHeader(header); err != nil {
+			t.Fatal(err)
+		}
+		_, _ = writer.Write([]byte(e.content))
+	}
+	if err := writer.Close(); err != nil {
+		t.Fatal(err)
+	}
+	if err := compressor.Close(); err != nil {
+		t.Fatal(err)
+	}
+	return buf.Bytes()
+}
+
+// upload turns data into the file of a multipart upload.
+func upload(t *testing.T, name string, data []byte) *multipart.FileHeader {
+	t.Helper()
+	var body bytes.Buffer
+	writer := multipart.NewWriter(&body)
+	part, err := writer.CreateFormFile("files", name)
+	if err != nil {
+		t.Fatal(err)
+	}
+	_, _ = part.Write(data)
+	_ = writer.Close()
+	form, err := multipart.NewReader(&body, writer.Boundary()).ReadForm(int64(len(data)) + 1<<20)
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { _ = form.RemoveAll() })
+	return form.File["files"][0]
+}
+
+func extract(t *testing.T, file *multipart.FileHeader, budget *Budget) (*Extraction, error) {
+	t.Helper()
+	format, err := Detect(file)
+	if err != nil || format == "" {
+		t.Fatalf("Detect(%s) = %q, %v", file.Filename, format, err)
+	}
+	extraction, err := Extract(file, format, budget, 1<<20)
+	if extraction != nil {
+		t.Cleanup(func() { _ = extraction.Close() })
+	}
+	return extraction, err
+}
+
+// contents reads the extracted files by their folder and name.
+func contents(t *testing.T, extraction *Extraction) map[string]string {
+	t.Helper()
+	files := map[string]string{}
+	for _, file := range extraction.Files {
+		reader, err := file.Header.Open()
+		if err != nil {
+			t.Fatal(err)
+		}
+		content, _ := io.ReadAll(reader)
+		_ = reader.Close()
+		files[file.Folder+"|"+file.Header.Filename] = string(content)
+	}
+	return files
+}
+
+func skippedCodes(extraction *Extraction) map[string]string {
+	codes := map[string]string{}
+	for _, skipped := range extraction.Skipped {
+		codes[skipped.Path] = skipped.Code
+	}
+	return codes
+}
+
+func TestExtractSkipsUnsafeEntries(t *testing.T) {
+	entries := []entry{
+		{name: "docs/guide.txt", content: "guide"},
+		{name: "notes.txt", content: "notes"},
+		{name: "../evil.txt", content: "slip"},
+		{name: "docs/../../evil.txt", content: "slip"},
+		{name: "/etc/passwd", content: "root"},
+		{name: `C:\Windows\evil.txt`, content: "drive"},
+		{name: "link", link: "/etc/passwd"},
+		{name: "docs/up", link: "../../.."},
+		{name: "__MACOSX/._notes.txt", content: "resource fork"},
+	}
+	wantSkipped := map[string]string{
+		"../evil.txt":          SkipUnsafePath,
+		"docs/../../evil.txt":  SkipUnsafePath,
+		"/etc/passwd":          SkipUnsafePath,
+		`C:\Windows\evil.txt`:  SkipUnsafePath,
+		"link":                 SkipNotRegular,
+		"docs/up":              SkipNotRegular,
+		"__MACOSX/._notes.txt": SkipSystemFile,
+	}
+	archives := map[string][]byte{
+		"a.zip":    zipArchive(t, entries...),
+		"a.tar.gz": tarGzArchive(t, entries...),
+	}
+	for name, data := range archives {
+		t.Run(name, func(t *testing.T) {
+			extraction, err := extract(t, upload(t, name, data), NewBudget(Limits{}))
+			if err != nil {
+				t.Fatal(err)
+			}
+			files := contents(t, extraction)
+			want := map[string]string{"docs|guide.txt": "guide", "|notes.txt": "notes"}
+			if len(files) != len(want) || files["docs|guide.txt"] != "guide" || files["|notes.txt"] != "notes" {
+				t.Fatalf("extracted %v, want %v", files, want)
+			}
+			codes := skippedCodes(extraction)
+			if len(codes) != len(wantSkipped) {
+				t.Fatalf("skipped %v, want %v", codes, wantSkipped)
+			}
+			for path, code := range wantSkipped {
+				if codes[path] != code {
+					t.Errorf("%s skipped as %q, want %q", path, codes[path], code)
+				}
+			}
+		})
+	}
+}
+
+func TestExtractEntryLimit(t *testing.T) {
+	entries := []entry{{name: "a.txt", content: "a"}, {name: "b.txt", content: "b"}, {name: "c.txt", content: "c"}}
+	archives := map[string][]byte{
+		"a.zip":    zipArchive(t, entries...),
+		"a.tar.gz": tarGzArchive(t, entries...),
+	}
+	for name, data := range archives {
+		t.Run(name, func(t *testing.T) {
+			_, err := extract(t, upload(t, name, data), NewBudget(Limits{MaxEntries: 2}))
+			if !errors.Is(err, ErrArchiveTooLarge) {
+				t.Fatalf("err = %v, want ErrArchiveTooLarge", err)
+			}
+			extraction, err := extract(t, upload(t, name, data), NewBudget(Limits{MaxEntries: 3}))
+			if err != nil || len(extraction.Files) != 3 {
+				t.Fatalf("extraction at the limit = %+v, %v", extraction, err)
+			}
+		})
+	}
+}
+
+func TestExtractTotalSizeLimit(t *testing.T) {
+	entries := []entry{{name: "a.txt", content: "0123456789"}, {name: "b.txt", content: "0123456789"}}
+	archives := map[string][]byte{
+		"a.zip":    zipArchive(t, entries...),
+		"a.tar.gz": tarGzArchive(t, entries...),
+	}
+	for name, data := range archives {
+		t.Run(name, func(t *testing.T) {
+			_, err := extract(t, upload(t, name, data), NewBudget(Limits{MaxTotalSize: 19}))
+			if !errors.Is(err, ErrArchiveTooLarge) {
+				t.Fatalf("err = %v, want ErrArchiveTooLarge", err)
+			}
+			_, err = extract(t, upload(t, name, data), NewBudget(Limits{MaxTotalSize: 20}))
+			if err != nil {
+				t.Fatalf("extraction at the limit: %v", err)
+			}
+		})
+	}
+}
+
+func TestExtractRatioLimit(t *testing.T) {
+	// zeros compress about a thousand times, past the floor of the ratio
+	bomb := entry{name: "zeros.bin", content: string(make([]byte, ratioFloor+1<<20))}
+	archives := map[string][]byte{
+		"bomb.zip":    zipArchive(t, bomb),
+		"bomb.tar.gz": tarGzArchive(t, bomb),
+	}
+	for name, data := range archives {
+		t.Run(name, func(t *testing.T) {
+			_, err := extract(t, upload(t, name, data), NewBudget(Limits{MaxRatio: 100}))
+			if !errors.Is(err, ErrArchiveTooLarge) {
+				t.Fatalf("err = %v, want ErrArchiveTooLarge", err)
+			}
+			_, err = extract(t, upload(t, name, data), NewBudget(Limits{MaxRatio: 100000}))
+			if err != nil {
+				t.Fatalf("extraction within the ratio: %v", err)
+			}
+		})
+	}
+}
+
+func TestBudgetSpansArchives(t *testing.T) {
+	budget := NewBudget(Limits{MaxEntries: 3, MaxTotalSize: 100})
+	first := zipArchive(t, entry{name: "a.txt", content: "a"}, entry{name: "b.txt", content: "b"})
+	second := tarGzArchive(t, entry{name: "c.txt", content: "c"}, entry{name: "d.txt", content: "d"})
+	_, err := extract(t, upload(t, "first.zip", first), budget)
+	if err != nil {
+		t.Fatal(err)
+	}
+	_, err = extract(t, upload(t, "second.tar.gz", second), budget)
+	if !errors.Is(err, ErrArchiveTooLarge) {
+		t.Fatalf("err = %v, want the fourth entry of the upload refused", err)
+	}
+	// a refused archive takes nothing from the budget
+	extraction, err := extract(t, upload(t, "third.tar.gz", tarGzArchive(t, entry{name: "e.txt", content: "e"})), budget)
+	if err != nil || len(extraction.Files) != 1 {
+		t.Fatalf("third archive = %+v, %v", extraction, err)
+	}
+
+	budget = NewBudget(Limits{MaxTotalSize: 15})
+	ten := entry{name: "a.txt", content: "0123456789"}
+	_, err = extract(t, upload(t, "first.zip", zipArchive(t, ten)), budget)
+	if err != nil {
+		t.Fatal(err)
+	}
+	_, err = extract(t, upload(t, "second.zip", zipArchive(t, ten)), budget)
+	if !errors.Is(err, ErrArchiveTooLarge) {
+		t.Fatalf("err = %v, want the upload refused past 15 bytes", err)
+	}
+}
+
+func TestExtractNames(t *testing.T) {
+	extraction, err := extract(t, upload(t, "a.zip", zipArchive(t, entry{name: "b/a.txt", content: "1"}, entry{name: "./c/./d/e.txt", content: "2"})), NewBudget(Limits{}))
+	if err != nil {
+		t.Fatal(err)
+	}
+	var names []string
+	for name := range contents(t, extraction) {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	if len(names) != 2 || names[0] != "b|a.txt" || names[1] != "c/d|e.txt" {
+		t.Fatalf("extracted %v", names)
+	}
+}
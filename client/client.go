@@ -0,0 +1,333 @@
+// Package client calls the pulse API. Every route of routes.RegisterRoutes
+// has a typed method on IClient, request and response bodies are the types
+// of package api, which the service itself uses.
+package client
+
+import (
+	"bytes"
+	"context"
+	"crypto/rand"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"github.com/draco121/horizon/models"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"io"
+	"math/big"
+	"net/http"
+	"net/url"
+	"pulse/api"
+	"pulse/patch"
+	"strconv"
+	"strings"
+	"time"
+)
+
+const (
+	defaultMaxRetries = 3
+	defaultMinBackoff = 200 * time.Millisecond
+	defaultMaxBackoff = 5 * time.Second
+)
+
+// AnyVersion writes training data whatever its current version is, it is
+// sent as If-Match: *.
+const AnyVersion int64 = -1
+
+// Options configure a client. The zero value talks to the service
+// without credentials using http.DefaultClient.
+type Options struct {
+	// Token is sent in the Authorization header of every request.
+	Token string
+	// TokenSource is asked for the token before every attempt instead of
+	// Token, for tokens that expire.
+	TokenSource func(ctx context.Context) (string, error)
+	HTTPClient  *http.Client
+	// MaxRetries bounds the retries of a failed idempotent call, 3 when
+	// zero and none when negative.
+	MaxRetries int
+	// MinBackoff and MaxBackoff bound the wait before a retry, which doubles
+	// with every attempt and is randomized. A Retry-After sent by the service
+	// is honoured up to MaxBackoff.
+	MinBackoff time.Duration
+	MaxBackoff time.Duration
+	UserAgent  string
+}
+
+// IClient has a method for every route of the service. Failed calls return
+// an *Error, which errors.Is matches against ErrNotFound and the other
+// errors of this package.
+type IClient interface {
+	Upload(ctx context.Context, projectId primitive.ObjectID, botId primitive.ObjectID, files []UploadFile, options UploadOptions) (*api.UploadResult, error)
+	DeleteFile(ctx context.Context, projectId primitive.ObjectID, botId primitive.ObjectID, fileId primitive.ObjectID) error
+	// Download streams a file from offset, which is 0 for the whole file.
+	Download(ctx context.Context, projectId primitive.ObjectID, botId primitive.ObjectID, fileId primitive.ObjectID, offset int64) (*File, error)
+	DownloadTo(ctx context.Context, projectId primitive.ObjectID, botId primitive.ObjectID, fileId primitive.ObjectID, w io.Writer) (*File, error)
+	StatFile(ctx context.Context, projectId primitive.ObjectID, botId primitive.ObjectID, fileId primitive.ObjectID) (*File, error)
+	DownloadArchive(ctx context.Context, projectId primitive.ObjectID, botId primitive.ObjectID, format string, fileIds []primitive.ObjectID) (io.ReadCloser, error)
+	ListFiles(ctx context.Context, projectId primitive.ObjectID, botId primitive.ObjectID, filter api.FileFilter) ([]api.FileMetadata, error)
+	MoveFiles(ctx context.Context, projectId primitive.ObjectID, botId primitive.ObjectID, fileIds []primitive.ObjectID, folder string) ([]api.FileMetadata, error)
+	TagFiles(ctx context.Context, projectId primitive.ObjectID, botId primitive.ObjectID, fileIds []primitive.ObjectID, change api.TagChange) ([]api.FileMetadata, error)
+	RunBatch(ctx context.Context, projectId primitive.ObjectID, botId primitive.ObjectID, batch api.BatchRequest) (*api.BatchResponse, error)
+	ReplaceFile(ctx context.Context, projectId primitive.ObjectID, botId primitive.ObjectID, fileId primitive.ObjectID, file UploadFile, checksum string) (*api.FileMetadata, error)
+	ListRevisions(ctx context.Context, projectId primitive.ObjectID, botId primitive.ObjectID, fileId primitive.ObjectID) ([]api.FileRevision, error)
+	GetRevision(ctx context.Context, projectId primitive.ObjectID, botId primitive.ObjectID, fileId primitive.ObjectID, revision int64) (*File, error)
+	StatRevision(ctx context.Context, projectId primitive.ObjectID, botId primitive.ObjectID, fileId primitive.ObjectID, revision int64) (*File, error)
+	AddTrainingData(ctx context.Context, trainingData *models.TrainingData) (*api.VersionedTrainingData, error)
+	GetTrainingData(ctx context.Context, projectId primitive.ObjectID, botId primitive.ObjectID) (*api.VersionedTrainingData, error)
+	MergeTrainingData(ctx context.Context, projectId primitive.ObjectID, botId primitive.ObjectID, version int64, mergePatch any) (*api.VersionedTrainingData, error)
+	PatchTrainingData(ctx context.Context, projectId primitive.ObjectID, botId primitive.ObjectID, version int64, operations []patch.Operation) (*api.VersionedTrainingData, error)
+	ResetTrainingData(ctx context.Context, projectId primitive.ObjectID, botId primitive.ObjectID, version int64) (*api.VersionedTrainingData, error)
+	GetUsage(ctx context.Context, projectId primitive.ObjectID) (*api.ProjectUsage, error)
+	GetUploadPolicy(ctx context.Context, projectId primitive.ObjectID) (*api.UploadPolicy, error)
+	SetUploadPolicy(ctx context.Context, projectId primitive.ObjectID, policy api.UploadPolicy) (*api.UploadPolicy, error)
+	RotateKeys(ctx context.Context) (int, error)
+	Healthz(ctx context.Context) error
+	Readyz(ctx context.Context) (*api.Readiness, error)
+	DebugStatus(ctx context.Context) (*api.StatusReport, error)
+	Metrics(ctx context.Context) (io.ReadCloser, error)
+}
+
+type client struct {
+	IClient
+	baseUrl *url.URL
+	options Options
+	http    *http.Client
+}
+
+// NewClient returns a client of the service running at baseUrl, such as
+// https://pulse.internal.
+func NewClient(baseUrl string, options Options) (IClient, error) {
+	parsed, err := url.Parse(strings.TrimSuffix(baseUrl, "/"))
+	if err != nil {
+		return nil, err
+	}
+	if parsed.Scheme != "http" && parsed.Scheme != "https" {
+		return nil, errors.New("base url must be an http or https url")
+	}
+	if options.MaxRetries == 0 {
+		options.MaxRetries = defaultMaxRetries
+	}
+	if options.MinBackoff <= 0 {
+		options.MinBackoff = defaultMinBackoff
+	}
+	if options.MaxBackoff < options.MinBackoff {
+		options.MaxBackoff = max(defaultMaxBackoff, options.MinBackoff)
+	}
+	httpClient := options.HTTPClient
+	if httpClient == nil {
+		httpClient = http.DefaultClient
+	}
+	return &client{
+		baseUrl: parsed,
+		options: options,
+		http:    httpClient,
+	}, nil
+}
+
+// request describes a call. body is called again for every attempt, it is
+// nil for calls without a body.
+type request struct {
+	method      string
+	path        string
+	query       url.Values
+	header      http.Header
+	body        func() (io.Reader, error)
+	contentType string
+	// idempotent calls are retried when the service is unavailable or the
+	// connection fails.
+	idempotent bool
+	// accept are statuses besides 2xx whose body is the regular response.
+	accept []int
+}
+
+func jsonBody(value any) (func() (io.Reader, error), error) {
+	data, err := json.Marshal(value)
+	if err != nil {
+		return nil, err
+	}
+	return func() (io.Reader, error) {
+		return bytes.NewReader(data), nil
+	}, nil
+}
+
+type idempotencyKey struct{}
+
+// WithIdempotencyKey sets the Idempotency-Key sent by the POST calls made
+// with ctx. Without it every call gets a random key, which makes its own
+// retries safe but not a repeat of the whole call.
+func WithIdempotencyKey(ctx context.Context, key string) context.Context {
+	return context.WithValue(ctx, idempotencyKey{}, key)
+}
+
+// withIdempotencyKey makes a POST safe to retry, the service answers the
+// repeats of a key with the response of the first request.
+func withIdempotencyKey(ctx context.Context, r request) request {
+	key, _ := ctx.Value(idempotencyKey{}).(string)
+	if key == "" {
+		buf := make([]byte, 16)
+		_, _ = rand.Read(buf)
+		key = hex.EncodeToString(buf)
+	}
+	if r.header == nil {
+		r.header = http.Header{}
+	}
+	r.header.Set(api.IdempotencyKeyHeader, key)
+	return r
+}
+
+// send runs a call, retrying idempotent calls, and returns the response of
+// an accepted status with its body left to the caller. Any other status is
+// returned as an *Error.
+func (c *client) send(ctx context.Context, r request) (*http.Response, error) {
+	for attempt := 0; ; attempt++ {
+		response, err := c.attempt(ctx, r)
+		retry := r.idempotent && attempt < c.options.MaxRetries && ctx.Err() == nil
+		if err == nil && !retryableStatus(response.StatusCode) {
+			retry = false
+		}
+		if err != nil && !retryableError(err) {
+			retry = false
+		}
+		if !retry {
+			if err != nil {
+				return nil, err
+			}
+			return c.accepted(response, r)
+		}
+		wait := c.backoff(attempt)
+		if err == nil {
+			if after, ok := retryAfter(response); ok {
+				wait = min(after, c.options.MaxBackoff)
+			}
+			drain(response)
+		}
+		timer := time.NewTimer(wait)
+		select {
+		case <-ctx.Done():
+			timer.Stop()
+			return nil, ctx.Err()
+		case <-timer.C:
+		}
+	}
+}
+
+func (c *client) attempt(ctx context.Context, r request) (*http.Response, error) {
+	target := *c.baseUrl
+	target.Path += r.path
+	target.RawQuery = r.query.Encode()
+	var body io.Reader
+	if r.body != nil {
+		var err error
+		body, err = r.body()
+		if err != nil {
+			return nil, err
+		}
+	}
+	httpRequest, err := http.NewRequestWithContext(ctx, r.method, target.String(), body)
+	if err != nil {
+		return nil, err
+	}
+	for name, values := range r.header {
+		httpRequest.Header[name] = values
+	}
+	if r.contentType != "" {
+		httpRequest.Header.Set("Content-Type", r.contentType)
+	}
+	if c.options.UserAgent != "" {
+		httpRequest.Header.Set("User-Agent", c.options.UserAgent)
+	}
+	token := c.options.Token
+	if c.options.TokenSource != nil {
+		token, err = c.options.TokenSource(ctx)
+		if err != nil {
+			return nil, err
+		}
+	}
+	if token != "" {
+		httpRequest.Header.Set("Authorization", token)
+	}
+	return c.http.Do(httpRequest)
+}
+
+// accepted hands out the response of a successful call and turns any other
+// into an *Error.
+func (c *client) accepted(response *http.Response, r request) (*http.Response, error) {
+	if response.StatusCode < http.StatusBadRequest {
+		return response, nil
+	}
+	for _, status := range r.accept {
+		if response.StatusCode == status {
+			return response, nil
+		}
+	}
+	defer drain(response)
+	return nil, errorFrom(response)
+}
+
+// call runs a call and decodes its JSON response into result, when not nil.
+func (c *client) call(ctx context.Context, r request, result any) (*http.Response, error) {
+	response, err := c.send(ctx, r)
+	if err != nil {
+		return nil, err
+	}
+	defer drain(response)
+	if result != nil && response.StatusCode != http.StatusNoContent && response.StatusCode != http.StatusNotModified {
+		err = json.NewDecoder(response.Body).Decode(result)
+		if err != nil {
+			return response, err
+		}
+	}
+	return response, nil
+}
+
+// drain reads what is left of a body so that the connection is reused.
+func drain(response *http.Response) {
+	_, _ = io.Copy(io.Discard, io.LimitReader(response.Body, 64<<10))
+	_ = response.Body.Close()
+}
+
+func retryableStatus(status int) bool {
+	switch status {
+	case http.StatusTooManyRequests, http.StatusBadGateway, http.StatusServiceUnavailable, http.StatusGatewayTimeout:
+		return true
+	}
+	return false
+}
+
+// retryableError tells a failed connection from an error of the caller,
+// such as a cancelled context.
+func retryableError(err error) bool {
+	if errors.Is(err, context.Canceled) || errors.Is(err, context.DeadlineExceeded) {
+		return false
+	}
+	var urlErr *url.Error
+	return errors.As(err, &urlErr)
+}
+
+// backoff is a random wait up to the exponential backoff of an attempt.
+func (c *client) backoff(attempt int) time.Duration {
+	ceiling := c.options.MaxBackoff
+	if attempt < 30 {
+		ceiling = min(c.options.MinBackoff<<attempt, c.options.MaxBackoff)
+	}
+	jitter, err := rand.Int(rand.Reader, big.NewInt(int64(ceiling-c.options.MinBackoff)+1))
+	if err != nil {
+		return ceiling
+	}
+	return c.options.MinBackoff + time.Duration(jitter.Int64())
+}
+
+// retryAfter reads a Retry-After header given in seconds or as a date.
+func retryAfter(response *http.Response) (time.Duration, bool) {
+	header := response.Header.Get("Retry-After")
+	if header == "" {
+		return 0, false
+	}
+	if seconds, err := strconv.Atoi(header); err == nil && seconds >= 0 {
+		return time.Duration(seconds) * time.Second, true
+	}
+	if date, err := http.ParseTime(header); err == nil {
+		return max(time.Until(date), 0), true
+	}
+	return 0, false
+}
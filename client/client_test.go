@@ -0,0 +1,324 @@
+package client
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"github.com/draco121/horizon/models"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"pulse/api"
+	"pulse/controllers/controllertest"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+// fault answers a request in place of the service, or returns false to let
+// the service answer it.
+type fault func(w http.ResponseWriter, r *http.Request, attempt int32) bool
+
+// newTestClient returns a client of the service routes over the memory
+// repositories. Requests go through fault first when it is not nil.
+func newTestClient(t *testing.T, options controllertest.Options, fault fault) (IClient, *controllertest.Server) {
+	t.Helper()
+	server := controllertest.NewServer(t, options)
+	service := server.Config.Handler
+	var attempts atomic.Int32
+	front := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		attempt := attempts.Add(1)
+		if fault != nil && fault(w, r, attempt) {
+			return
+		}
+		service.ServeHTTP(w, r)
+	}))
+	t.Cleanup(front.Close)
+	c, err := NewClient(front.URL, Options{Token: server.Token, MinBackoff: time.Millisecond, MaxBackoff: 5 * time.Millisecond})
+	if err != nil {
+		t.Fatal(err)
+	}
+	return c, server
+}
+
+func TestUploadDownloadDelete(t *testing.T) {
+	c, _ := newTestClient(t, controllertest.Options{}, nil)
+	ctx := context.Background()
+	projectId, botId := primitive.NewObjectID(), primitive.NewObjectID()
+	result, err := c.Upload(ctx, projectId, botId, []UploadFile{{Name: "notes.txt", Content: bytes.NewReader([]byte("hello world"))}}, UploadOptions{Folder: "docs"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(result.Files) != 1 || result.Files[0].FileName != "notes.txt" {
+		t.Fatalf("uploaded %+v", result)
+	}
+	fileId := result.Files[0].FileId
+
+	var content bytes.Buffer
+	file, err := c.DownloadTo(ctx, projectId, botId, fileId, &content)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if content.String() != "hello world" || file.Name != "notes.txt" || file.Checksum == "" {
+		t.Fatalf("downloaded %q as %+v", content.String(), file)
+	}
+
+	files, err := c.ListFiles(ctx, projectId, botId, api.FileFilter{Folder: "docs"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(files) != 1 || files[0].FileId != fileId || files[0].Folder != "docs" {
+		t.Fatalf("listed %+v", files)
+	}
+
+	err = c.DeleteFile(ctx, projectId, botId, fileId)
+	if err != nil {
+		t.Fatal(err)
+	}
+	_, err = c.DownloadTo(ctx, projectId, botId, fileId, io.Discard)
+	if !errors.Is(err, ErrNotFound) {
+		t.Fatalf("download of a deleted file: err = %v, want ErrNotFound", err)
+	}
+}
+
+func TestRetryUnavailable(t *testing.T) {
+	c, _ := newTestClient(t, controllertest.Options{}, func(w http.ResponseWriter, r *http.Request, attempt int32) bool {
+		switch attempt {
+		case 1:
+			w.WriteHeader(http.StatusServiceUnavailable)
+		case 2:
+			// longer than MaxBackoff, which bounds the wait
+			w.Header().Set("Retry-After", "30")
+			w.WriteHeader(http.StatusTooManyRequests)
+		default:
+			return false
+		}
+		return true
+	})
+	projectId := primitive.NewObjectID()
+	start := time.Now()
+	usage, err := c.GetUsage(context.Background(), projectId)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if usage.ProjectId != projectId || usage.Files != 0 {
+		t.Fatalf("usage = %+v", usage)
+	}
+	if elapsed := time.Since(start); elapsed > time.Second {
+		t.Fatalf("Retry-After was not capped by MaxBackoff, took %s", elapsed)
+	}
+}
+
+func TestRetriesExhausted(t *testing.T) {
+	var attempts atomic.Int32
+	c, _ := newTestClient(t, controllertest.Options{}, func(w http.ResponseWriter, r *http.Request, attempt int32) bool {
+		attempts.Store(attempt)
+		w.WriteHeader(http.StatusBadGateway)
+		return true
+	})
+	_, err := c.GetUsage(context.Background(), primitive.NewObjectID())
+	if !errors.Is(err, ErrUnavailable) {
+		t.Fatalf("err = %v, want ErrUnavailable", err)
+	}
+	if attempts.Load() != defaultMaxRetries+1 {
+		t.Fatalf("attempts = %d, want %d", attempts.Load(), defaultMaxRetries+1)
+	}
+}
+
+func TestNoRetryWithoutIdempotencyKey(t *testing.T) {
+	var attempts atomic.Int32
+	c, _ := newTestClient(t, controllertest.Options{}, func(w http.ResponseWriter, r *http.Request, attempt int32) bool {
+		attempts.Store(attempt)
+		if r.Header.Get(api.IdempotencyKeyHeader) != "" {
+			t.Errorf("batch sent an idempotency key")
+		}
+		w.WriteHeader(http.StatusServiceUnavailable)
+		return true
+	})
+	_, err := c.RunBatch(context.Background(), primitive.NewObjectID(), primitive.NewObjectID(), api.BatchRequest{})
+	if !errors.Is(err, ErrUnavailable) {
+		t.Fatalf("err = %v, want ErrUnavailable", err)
+	}
+	if attempts.Load() != 1 {
+		t.Fatalf("attempts = %d, want 1", attempts.Load())
+	}
+}
+
+func TestUploadRetryRewindsContent(t *testing.T) {
+	content := bytes.Repeat([]byte("pulse "), 10000)
+	var mu sync.Mutex
+	var keys []string
+	c, _ := newTestClient(t, controllertest.Options{}, func(w http.ResponseWriter, r *http.Request, attempt int32) bool {
+		if r.Method != http.MethodPost {
+			return false
+		}
+		mu.Lock()
+		keys = append(keys, r.Header.Get(api.IdempotencyKeyHeader))
+		mu.Unlock()
+		if attempt != 1 {
+			return false
+		}
+		// fail after the body was read in part
+		_, _ = io.CopyN(io.Discard, r.Body, 1000)
+		w.WriteHeader(http.StatusServiceUnavailable)
+		return true
+	})
+	ctx := context.Background()
+	projectId, botId := primitive.NewObjectID(), primitive.NewObjectID()
+	result, err := c.Upload(ctx, projectId, botId, []UploadFile{{Name: "a.txt", Content: bytes.NewReader(content)}}, UploadOptions{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(keys) != 2 || keys[0] == "" || keys[0] != keys[1] {
+		t.Fatalf("idempotency keys = %q, want the same key on both attempts", keys)
+	}
+	if len(result.Files) != 1 {
+		t.Fatalf("uploaded %+v", result)
+	}
+	var received bytes.Buffer
+	_, err = c.DownloadTo(ctx, projectId, botId, result.Files[0].FileId, &received)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !bytes.Equal(received.Bytes(), content) {
+		t.Fatalf("stored %d bytes, want the %d bytes of the file", received.Len(), len(content))
+	}
+}
+
+func TestUploadNotRetriedWhenNotSeekable(t *testing.T) {
+	var attempts atomic.Int32
+	c, _ := newTestClient(t, controllertest.Options{}, func(w http.ResponseWriter, r *http.Request, attempt int32) bool {
+		attempts.Store(attempt)
+		_, _ = io.Copy(io.Discard, r.Body)
+		w.WriteHeader(http.StatusServiceUnavailable)
+		return true
+	})
+	content := io.MultiReader(bytes.NewReader([]byte("pulse")))
+	_, err := c.Upload(context.Background(), primitive.NewObjectID(), primitive.NewObjectID(), []UploadFile{{Name: "a.txt", Content: content}}, UploadOptions{})
+	if !errors.Is(err, ErrUnavailable) {
+		t.Fatalf("err = %v, want ErrUnavailable", err)
+	}
+	if attempts.Load() != 1 {
+		t.Fatalf("attempts = %d, want 1", attempts.Load())
+	}
+}
+
+func TestProblemDecoding(t *testing.T) {
+	c, _ := newTestClient(t, controllertest.Options{}, nil)
+	_, err := c.MoveFiles(context.Background(), primitive.NewObjectID(), primitive.NewObjectID(), []primitive.ObjectID{primitive.NewObjectID()}, "../up")
+	var apiErr *Error
+	if !errors.As(err, &apiErr) {
+		t.Fatalf("err = %v, want an *Error", err)
+	}
+	if apiErr.StatusCode != http.StatusBadRequest || apiErr.Kind() != api.KindValidation || apiErr.RequestId == "" {
+		t.Fatalf("err = %+v", apiErr)
+	}
+	if len(apiErr.Problem.Errors) != 1 || apiErr.Problem.Errors[0].Field != "folder" {
+		t.Fatalf("field errors = %+v", apiErr.Problem.Errors)
+	}
+	if errors.Is(err, ErrNotFound) {
+		t.Fatal("a 400 matched ErrNotFound")
+	}
+}
+
+func TestErrorStatuses(t *testing.T) {
+	ctx := context.Background()
+	tests := []struct {
+		name   string
+		target error
+		// call makes the service answer with the status of target
+		call func(c IClient, server *controllertest.Server) error
+		// fault answers in place of the service, for statuses the client
+		// never gets from it
+		fault fault
+	}{
+		{
+			name:   "wrong token",
+			target: ErrUnauthorized,
+			call: func(c IClient, server *controllertest.Server) error {
+				other, err := NewClient(server.URL, Options{Token: "Bearer someone else"})
+				if err != nil {
+					return err
+				}
+				_, err = other.GetTrainingData(ctx, primitive.NewObjectID(), primitive.NewObjectID())
+				return err
+			},
+		},
+		{
+			name:   "missing training data",
+			target: ErrNotFound,
+			call: func(c IClient, server *controllertest.Server) error {
+				_, err := c.GetTrainingData(ctx, primitive.NewObjectID(), primitive.NewObjectID())
+				return err
+			},
+		},
+		{
+			name:   "training data added twice",
+			target: ErrConflict,
+			call: func(c IClient, server *controllertest.Server) error {
+				data := &models.TrainingData{ProjectId: primitive.NewObjectID(), BotId: primitive.NewObjectID()}
+				_, err := c.AddTrainingData(ctx, data)
+				if err != nil {
+					return err
+				}
+				_, err = c.AddTrainingData(ctx, data)
+				return err
+			},
+		},
+		{
+			name:   "stale version",
+			target: ErrPreconditionFailed,
+			call: func(c IClient, server *controllertest.Server) error {
+				data := &models.TrainingData{ProjectId: primitive.NewObjectID(), BotId: primitive.NewObjectID()}
+				_, err := c.AddTrainingData(ctx, data)
+				if err != nil {
+					return err
+				}
+				_, err = c.ResetTrainingData(ctx, data.ProjectId, data.BotId, 7)
+				return err
+			},
+		},
+		{
+			name:   "missing If-Match",
+			target: ErrPreconditionRequired,
+			call: func(c IClient, server *controllertest.Server) error {
+				_, err := c.ResetTrainingData(ctx, primitive.NewObjectID(), primitive.NewObjectID(), AnyVersion)
+				return err
+			},
+			// the client always sends If-Match, the service is asked
+			// without it
+			fault: func(w http.ResponseWriter, r *http.Request, attempt int32) bool {
+				r.Header.Del("If-Match")
+				return false
+			},
+		},
+		{
+			name:   "over quota",
+			target: ErrQuotaExceeded,
+			call: func(c IClient, server *controllertest.Server) error {
+				_, err := c.Upload(ctx, primitive.NewObjectID(), primitive.NewObjectID(), []UploadFile{{Name: "a.txt", Content: bytes.NewReader([]byte("more than ten bytes"))}}, UploadOptions{})
+				return err
+			},
+		},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			options := controllertest.Options{Quota: api.QuotaPolicy{ProjectHard: api.QuotaLimits{MaxBytes: 10}}}
+			c, server := newTestClient(t, options, test.fault)
+			err := test.call(c, server)
+			if !errors.Is(err, test.target) {
+				t.Fatalf("err = %v, want %v", err, test.target)
+			}
+			var apiErr *Error
+			if !errors.As(err, &apiErr) || apiErr.StatusCode == 0 {
+				t.Fatalf("err = %+v", err)
+			}
+			// the auth middleware answers with an empty body
+			if test.target != ErrUnauthorized && (apiErr.Problem.Status != apiErr.StatusCode || apiErr.RequestId == "") {
+				t.Fatalf("problem = %+v, want the one of the service", apiErr.Problem)
+			}
+		})
+	}
+}
@@ -0,0 +1,90 @@
+package client
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"mime"
+	"net/http"
+	"pulse/api"
+)
+
+// Errors an *Error matches with errors.Is, by the status of the response.
+var (
+	ErrUnauthorized         = errors.New("unauthorized")
+	ErrForbidden            = errors.New("forbidden")
+	ErrNotFound             = errors.New("not found")
+	ErrConflict             = errors.New("conflict")
+	ErrPreconditionFailed   = errors.New("precondition failed")
+	ErrPreconditionRequired = errors.New("precondition required")
+	ErrTooLarge             = errors.New("payload too large")
+	ErrQuotaExceeded        = errors.New("quota exceeded")
+	ErrUnavailable          = errors.New("service unavailable")
+)
+
+var errorByStatus = map[int]error{
+	http.StatusUnauthorized:          ErrUnauthorized,
+	http.StatusForbidden:             ErrForbidden,
+	http.StatusNotFound:              ErrNotFound,
+	http.StatusConflict:              ErrConflict,
+	http.StatusPreconditionFailed:    ErrPreconditionFailed,
+	http.StatusPreconditionRequired:  ErrPreconditionRequired,
+	http.StatusRequestEntityTooLarge: ErrTooLarge,
+	http.StatusInsufficientStorage:   ErrQuotaExceeded,
+	http.StatusServiceUnavailable:    ErrUnavailable,
+	http.StatusBadGateway:            ErrUnavailable,
+	http.StatusGatewayTimeout:        ErrUnavailable,
+}
+
+// Error is a call the service refused or failed. Problem is the RFC 7807
+// body of the response, only Status is set when the response had none, as
+// for requests refused by the auth middleware.
+type Error struct {
+	StatusCode int
+	Problem    api.Problem
+	RequestId  string
+}
+
+func (e *Error) Error() string {
+	message := fmt.Sprintf("pulse: %d %s", e.StatusCode, http.StatusText(e.StatusCode))
+	if e.Problem.Detail != "" {
+		message += ": " + e.Problem.Detail
+	}
+	for _, field := range e.Problem.Errors {
+		message += fmt.Sprintf("; %s %s", field.Field, field.Message)
+	}
+	if e.RequestId != "" {
+		message += " (request " + e.RequestId + ")"
+	}
+	return message
+}
+
+func (e *Error) Is(target error) bool {
+	return errorByStatus[e.StatusCode] == target
+}
+
+// Kind is the error kind the service reported, empty when it sent no
+// problem.
+func (e *Error) Kind() api.ErrorKind {
+	return e.Problem.Code
+}
+
+func errorFrom(response *http.Response) error {
+	apiErr := &Error{
+		StatusCode: response.StatusCode,
+		RequestId:  response.Header.Get(api.RequestIdHeader),
+	}
+	mediaType, _, _ := mime.ParseMediaType(response.Header.Get("Content-Type"))
+	if mediaType == "application/problem+json" || mediaType == "application/json" {
+		// a body that is not a problem still leaves the status to go by
+		_ = json.NewDecoder(io.LimitReader(response.Body, 1<<20)).Decode(&apiErr.Problem)
+	}
+	if apiErr.Problem.Status == 0 {
+		apiErr.Problem.Status = response.StatusCode
+	}
+	if apiErr.RequestId == "" {
+		apiErr.RequestId = apiErr.Problem.RequestId
+	}
+	return apiErr
+}
@@ -0,0 +1,388 @@
+package client
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"io"
+	"mime"
+	"mime/multipart"
+	"net/http"
+	"net/textproto"
+	"net/url"
+	"pulse/api"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+var ErrChecksumMismatch = errors.New("downloaded content does not match its checksum")
+
+// UploadFile is a file to upload. Content is streamed, uploads are only
+// retried when every Content is an io.Seeker, from the offset it was at.
+type UploadFile struct {
+	Name string
+	// ContentType defaults to application/octet-stream, the service detects
+	// the type of the content either way.
+	ContentType string
+	Content     io.Reader
+}
+
+type UploadOptions struct {
+	// Extract replaces zip and tar(.gz) archives by the files they contain.
+	Extract bool
+	// Folder is where the files are put.
+	Folder string
+}
+
+// File is a downloaded file. Body streams the content and must be closed,
+// it is nil for the Stat calls.
+type File struct {
+	Name        string
+	ContentType string
+	// Size is the length of Body, or of the whole file for the Stat calls.
+	Size int64
+	// Checksum is the hex SHA-256 of the whole content.
+	Checksum string
+	ModTime  time.Time
+	Body     io.ReadCloser
+}
+
+var quoteEscaper = strings.NewReplacer(`\`, `\\`, `"`, `\"`)
+
+// multipartBody streams files as a multipart form. Every call of open starts
+// the form over, stopping the previous stream and rewinding the files first.
+type multipartBody struct {
+	field    string
+	files    []UploadFile
+	starts   []int64
+	boundary string
+	mu       sync.Mutex
+	previous *io.PipeReader
+	done     chan struct{}
+}
+
+// newMultipartBody returns the body of an upload and whether it can be sent
+// again.
+func newMultipartBody(field string, files []UploadFile) (*multipartBody, bool) {
+	body := &multipartBody{field: field, files: files, boundary: multipart.NewWriter(nil).Boundary()}
+	for _, file := range files {
+		seeker, ok := file.Content.(io.Seeker)
+		if !ok {
+			return body, false
+		}
+		start, err := seeker.Seek(0, io.SeekCurrent)
+		if err != nil {
+			return body, false
+		}
+		body.starts = append(body.starts, start)
+	}
+	return body, true
+}
+
+func (b *multipartBody) contentType() string {
+	return "multipart/form-data; boundary=" + b.boundary
+}
+
+func (b *multipartBody) open() (io.Reader, error) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	if b.previous != nil {
+		_ = b.previous.CloseWithError(errors.New("upload restarted"))
+		<-b.done
+		for i, start := range b.starts {
+			_, err := b.files[i].Content.(io.Seeker).Seek(start, io.SeekStart)
+			if err != nil {
+				return nil, err
+			}
+		}
+	}
+	reader, writer := io.Pipe()
+	done := make(chan struct{})
+	b.previous, b.done = reader, done
+	go func() {
+		defer close(done)
+		_ = writer.CloseWithError(b.write(writer))
+	}()
+	return reader, nil
+}
+
+func (b *multipartBody) write(w io.Writer) error {
+	form := multipart.NewWriter(w)
+	err := form.SetBoundary(b.boundary)
+	if err != nil {
+		return err
+	}
+	for _, file := range b.files {
+		header := textproto.MIMEHeader{}
+		header.Set("Content-Disposition", fmt.Sprintf(`form-data; name="%s"; filename="%s"`, b.field, quoteEscaper.Replace(file.Name)))
+		contentType := file.ContentType
+		if contentType == "" {
+			contentType = "application/octet-stream"
+		}
+		header.Set("Content-Type", contentType)
+		part, err := form.CreatePart(header)
+		if err != nil {
+			return err
+		}
+		_, err = io.Copy(part, file.Content)
+		if err != nil {
+			return err
+		}
+	}
+	return form.Close()
+}
+
+func uploadRequest(method string, path string, field string, files []UploadFile) request {
+	body, rewindable := newMultipartBody(field, files)
+	return request{
+		method:      method,
+		path:        path,
+		body:        body.open,
+		contentType: body.contentType(),
+		idempotent:  rewindable,
+	}
+}
+
+func filesPath(projectId primitive.ObjectID, botId primitive.ObjectID) string {
+	return "/v1/files/" + projectId.Hex() + "/" + botId.Hex()
+}
+
+func (c *client) Upload(ctx context.Context, projectId primitive.ObjectID, botId primitive.ObjectID, files []UploadFile, options UploadOptions) (*api.UploadResult, error) {
+	r := uploadRequest(http.MethodPost, "/v1/upload/"+projectId.Hex()+"/"+botId.Hex(), "files", files)
+	r.query = url.Values{}
+	if options.Extract {
+		r.query.Set("extract", "true")
+	}
+	if options.Folder != "" {
+		r.query.Set("folder", options.Folder)
+	}
+	// every file turned away by the upload policy is answered with 422
+	r.accept = []int{http.StatusUnprocessableEntity}
+	r = withIdempotencyKey(ctx, r)
+	var result api.UploadResult
+	_, err := c.call(ctx, r, &result)
+	if err != nil {
+		return nil, err
+	}
+	return &result, nil
+}
+
+func (c *client) DeleteFile(ctx context.Context, projectId primitive.ObjectID, botId primitive.ObjectID, fileId primitive.ObjectID) error {
+	_, err := c.call(ctx, request{
+		method:     http.MethodDelete,
+		path:       "/v1/delete/" + projectId.Hex() + "/" + botId.Hex() + "/" + fileId.Hex(),
+		idempotent: true,
+	}, nil)
+	return err
+}
+
+// fileFrom reads the details of a served file from its headers.
+func fileFrom(response *http.Response) *File {
+	file := &File{
+		ContentType: response.Header.Get("Content-Type"),
+		Size:        response.ContentLength,
+		Checksum:    strings.Trim(response.Header.Get("ETag"), `"`),
+	}
+	if _, params, err := mime.ParseMediaType(response.Header.Get("Content-Disposition")); err == nil {
+		file.Name = params["filename"]
+	}
+	if modTime, err := http.ParseTime(response.Header.Get("Last-Modified")); err == nil {
+		file.ModTime = modTime
+	}
+	return file
+}
+
+func (c *client) download(ctx context.Context, path string, offset int64) (*File, error) {
+	r := request{method: http.MethodGet, path: path, idempotent: true}
+	if offset > 0 {
+		r.header = http.Header{"Range": {"bytes=" + strconv.FormatInt(offset, 10) + "-"}}
+	}
+	response, err := c.send(ctx, r)
+	if err != nil {
+		return nil, err
+	}
+	file := fileFrom(response)
+	file.Body = response.Body
+	return file, nil
+}
+
+func (c *client) stat(ctx context.Context, path string) (*File, error) {
+	response, err := c.call(ctx, request{method: http.MethodHead, path: path, idempotent: true}, nil)
+	if err != nil {
+		return nil, err
+	}
+	return fileFrom(response), nil
+}
+
+func filePath(projectId primitive.ObjectID, botId primitive.ObjectID, fileId primitive.ObjectID) string {
+	return "/v1/download/" + projectId.Hex() + "/" + botId.Hex() + "/" + fileId.Hex()
+}
+
+func (c *client) Download(ctx context.Context, projectId primitive.ObjectID, botId primitive.ObjectID, fileId primitive.ObjectID, offset int64) (*File, error) {
+	return c.download(ctx, filePath(projectId, botId, fileId), offset)
+}
+
+func (c *client) StatFile(ctx context.Context, projectId primitive.ObjectID, botId primitive.ObjectID, fileId primitive.ObjectID) (*File, error) {
+	return c.stat(ctx, filePath(projectId, botId, fileId))
+}
+
+// DownloadTo copies a whole file to w and checks it against the checksum
+// sent by the service.
+func (c *client) DownloadTo(ctx context.Context, projectId primitive.ObjectID, botId primitive.ObjectID, fileId primitive.ObjectID, w io.Writer) (*File, error) {
+	file, err := c.Download(ctx, projectId, botId, fileId, 0)
+	if err != nil {
+		return nil, err
+	}
+	defer file.Body.Close()
+	checksum := sha256.New()
+	_, err = io.Copy(io.MultiWriter(w, checksum), file.Body)
+	if err != nil {
+		return nil, err
+	}
+	if file.Checksum != "" && hex.EncodeToString(checksum.Sum(nil)) != file.Checksum {
+		return nil, ErrChecksumMismatch
+	}
+	file.Body = nil
+	return file, nil
+}
+
+// DownloadArchive streams the files of a bot, or only fileIds when given,
+// as a zip or tar.gz archive.
+func (c *client) DownloadArchive(ctx context.Context, projectId primitive.ObjectID, botId primitive.ObjectID, format string, fileIds []primitive.ObjectID) (io.ReadCloser, error) {
+	query := url.Values{}
+	if format != "" {
+		query.Set("format", format)
+	}
+	for _, fileId := range fileIds {
+		query.Add("fileId", fileId.Hex())
+	}
+	response, err := c.send(ctx, request{
+		method:     http.MethodGet,
+		path:       "/v1/download/" + projectId.Hex() + "/" + botId.Hex(),
+		query:      query,
+		idempotent: true,
+	})
+	if err != nil {
+		return nil, err
+	}
+	return response.Body, nil
+}
+
+// ListFiles lists the files of a bot. Only the files at the root are listed
+// for a zero filter, Recursive lists the whole tree below Folder.
+func (c *client) ListFiles(ctx context.Context, projectId primitive.ObjectID, botId primitive.ObjectID, filter api.FileFilter) ([]api.FileMetadata, error) {
+	query := url.Values{}
+	if filter.Folder != "" {
+		query.Set("folder", filter.Folder)
+	}
+	query.Set("recursive", strconv.FormatBool(filter.Recursive))
+	if len(filter.Tags) > 0 {
+		query.Set("tag", strings.Join(filter.Tags, ","))
+	}
+	for key, value := range filter.Labels {
+		query.Add("label", key+":"+value)
+	}
+	var files []api.FileMetadata
+	_, err := c.call(ctx, request{method: http.MethodGet, path: filesPath(projectId, botId), query: query, idempotent: true}, &files)
+	return files, err
+}
+
+func (c *client) MoveFiles(ctx context.Context, projectId primitive.ObjectID, botId primitive.ObjectID, fileIds []primitive.ObjectID, folder string) ([]api.FileMetadata, error) {
+	body, err := jsonBody(api.MoveFilesRequest{FileIds: fileIds, Folder: folder})
+	if err != nil {
+		return nil, err
+	}
+	var files []api.FileMetadata
+	_, err = c.call(ctx, request{
+		method:      http.MethodPost,
+		path:        filesPath(projectId, botId) + "/move",
+		body:        body,
+		contentType: "application/json",
+		// moving files to a folder twice leaves them where the first did
+		idempotent: true,
+	}, &files)
+	return files, err
+}
+
+func (c *client) TagFiles(ctx context.Context, projectId primitive.ObjectID, botId primitive.ObjectID, fileIds []primitive.ObjectID, change api.TagChange) ([]api.FileMetadata, error) {
+	body, err := jsonBody(api.TagFilesRequest{FileIds: fileIds, TagChange: change})
+	if err != nil {
+		return nil, err
+	}
+	var files []api.FileMetadata
+	_, err = c.call(ctx, request{
+		method:      http.MethodPost,
+		path:        filesPath(projectId, botId) + "/tags",
+		body:        body,
+		contentType: "application/json",
+		idempotent:  true,
+	}, &files)
+	return files, err
+}
+
+// RunBatch runs a batch over files of a bot. A batch that was only partly
+// applied, or not at all, is not an error, its results tell which
+// operations failed.
+func (c *client) RunBatch(ctx context.Context, projectId primitive.ObjectID, botId primitive.ObjectID, batch api.BatchRequest) (*api.BatchResponse, error) {
+	body, err := jsonBody(batch)
+	if err != nil {
+		return nil, err
+	}
+	var response api.BatchResponse
+	_, err = c.call(ctx, request{
+		method:      http.MethodPost,
+		path:        filesPath(projectId, botId) + "/batch",
+		body:        body,
+		contentType: "application/json",
+		accept:      []int{http.StatusMultiStatus, http.StatusUnprocessableEntity},
+	}, &response)
+	if err != nil {
+		return nil, err
+	}
+	return &response, nil
+}
+
+// ReplaceFile stores new content under a file, keeping the current one as a
+// revision. A non empty checksum must match the current content.
+func (c *client) ReplaceFile(ctx context.Context, projectId primitive.ObjectID, botId primitive.ObjectID, fileId primitive.ObjectID, file UploadFile, checksum string) (*api.FileMetadata, error) {
+	r := uploadRequest(http.MethodPut, filesPath(projectId, botId)+"/"+fileId.Hex(), "file", []UploadFile{file})
+	if checksum != "" {
+		r.header = http.Header{"If-Match": {`"` + checksum + `"`}}
+	} else {
+		// without a checksum a repeat would store the content a second time
+		r.idempotent = false
+	}
+	var metadata api.FileMetadata
+	_, err := c.call(ctx, r, &metadata)
+	if err != nil {
+		return nil, err
+	}
+	return &metadata, nil
+}
+
+func (c *client) ListRevisions(ctx context.Context, projectId primitive.ObjectID, botId primitive.ObjectID, fileId primitive.ObjectID) ([]api.FileRevision, error) {
+	var revisions []api.FileRevision
+	_, err := c.call(ctx, request{
+		method:     http.MethodGet,
+		path:       filesPath(projectId, botId) + "/" + fileId.Hex() + "/revisions",
+		idempotent: true,
+	}, &revisions)
+	return revisions, err
+}
+
+func revisionPath(projectId primitive.ObjectID, botId primitive.ObjectID, fileId primitive.ObjectID, revision int64) string {
+	return filesPath(projectId, botId) + "/" + fileId.Hex() + "/revisions/" + strconv.FormatInt(revision, 10)
+}
+
+func (c *client) GetRevision(ctx context.Context, projectId primitive.ObjectID, botId primitive.ObjectID, fileId primitive.ObjectID, revision int64) (*File, error) {
+	return c.download(ctx, revisionPath(projectId, botId, fileId, revision), 0)
+}
+
+func (c *client) StatRevision(ctx context.Context, projectId primitive.ObjectID, botId primitive.ObjectID, fileId primitive.ObjectID, revision int64) (*File, error) {
+	return c.stat(ctx, revisionPath(projectId, botId, fileId, revision))
+}
@@ -0,0 +1,97 @@
+package client
+
+import (
+	"context"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"io"
+	"net/http"
+	"pulse/api"
+)
+
+func (c *client) GetUsage(ctx context.Context, projectId primitive.ObjectID) (*api.ProjectUsage, error) {
+	var usage api.ProjectUsage
+	_, err := c.call(ctx, request{method: http.MethodGet, path: "/v1/usage/" + projectId.Hex(), idempotent: true}, &usage)
+	if err != nil {
+		return nil, err
+	}
+	return &usage, nil
+}
+
+func (c *client) GetUploadPolicy(ctx context.Context, projectId primitive.ObjectID) (*api.UploadPolicy, error) {
+	var policy api.UploadPolicy
+	_, err := c.call(ctx, request{method: http.MethodGet, path: "/v1/policy/" + projectId.Hex(), idempotent: true}, &policy)
+	if err != nil {
+		return nil, err
+	}
+	return &policy, nil
+}
+
+// SetUploadPolicy replaces the upload policy of a project, the project id
+// of policy is ignored.
+func (c *client) SetUploadPolicy(ctx context.Context, projectId primitive.ObjectID, policy api.UploadPolicy) (*api.UploadPolicy, error) {
+	body, err := jsonBody(policy)
+	if err != nil {
+		return nil, err
+	}
+	var result api.UploadPolicy
+	_, err = c.call(ctx, request{
+		method:      http.MethodPut,
+		path:        "/v1/policy/" + projectId.Hex(),
+		body:        body,
+		contentType: "application/json",
+		idempotent:  true,
+	}, &result)
+	if err != nil {
+		return nil, err
+	}
+	return &result, nil
+}
+
+// RotateKeys re-wraps the data keys with the active master key and returns
+// how many were rotated.
+func (c *client) RotateKeys(ctx context.Context) (int, error) {
+	var result struct {
+		Rotated int `json:"rotated"`
+	}
+	_, err := c.call(ctx, request{method: http.MethodPost, path: "/v1/keys/rotate", idempotent: true}, &result)
+	return result.Rotated, err
+}
+
+// Healthz fails when the service is not running.
+func (c *client) Healthz(ctx context.Context) error {
+	_, err := c.call(ctx, request{method: http.MethodGet, path: "/healthz"}, nil)
+	return err
+}
+
+// Readyz reports whether the service takes traffic. A service that is not
+// ready is not an error, the checks tell why.
+func (c *client) Readyz(ctx context.Context) (*api.Readiness, error) {
+	var readiness api.Readiness
+	_, err := c.call(ctx, request{
+		method: http.MethodGet,
+		path:   "/readyz",
+		accept: []int{http.StatusServiceUnavailable},
+	}, &readiness)
+	if err != nil {
+		return nil, err
+	}
+	return &readiness, nil
+}
+
+func (c *client) DebugStatus(ctx context.Context) (*api.StatusReport, error) {
+	var report api.StatusReport
+	_, err := c.call(ctx, request{method: http.MethodGet, path: "/debug/status", idempotent: true}, &report)
+	if err != nil {
+		return nil, err
+	}
+	return &report, nil
+}
+
+// Metrics streams the metrics in the Prometheus text format.
+func (c *client) Metrics(ctx context.Context) (io.ReadCloser, error) {
+	response, err := c.send(ctx, request{method: http.MethodGet, path: "/metrics", idempotent: true})
+	if err != nil {
+		return nil, err
+	}
+	return response.Body, nil
+}
@@ -0,0 +1,108 @@
+package client
+
+import (
+	"context"
+	"github.com/draco121/horizon/models"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"net/http"
+	"net/url"
+	"pulse/api"
+	"pulse/patch"
+	"strconv"
+)
+
+func trainingDataQuery(projectId primitive.ObjectID, botId primitive.ObjectID) url.Values {
+	return url.Values{"botId": {botId.Hex()}, "projectId": {projectId.Hex()}}
+}
+
+// ifMatch is the If-Match header of a write conditioned on version.
+func ifMatch(version int64) http.Header {
+	if version == AnyVersion {
+		return http.Header{"If-Match": {"*"}}
+	}
+	return http.Header{"If-Match": {`"` + strconv.FormatInt(version, 10) + `"`}}
+}
+
+func (c *client) AddTrainingData(ctx context.Context, trainingData *models.TrainingData) (*api.VersionedTrainingData, error) {
+	body, err := jsonBody(trainingData)
+	if err != nil {
+		return nil, err
+	}
+	r := withIdempotencyKey(ctx, request{
+		method:      http.MethodPost,
+		path:        "/v1/trainingdata",
+		body:        body,
+		contentType: "application/json",
+		idempotent:  true,
+	})
+	var result api.VersionedTrainingData
+	_, err = c.call(ctx, r, &result)
+	if err != nil {
+		return nil, err
+	}
+	return &result, nil
+}
+
+func (c *client) GetTrainingData(ctx context.Context, projectId primitive.ObjectID, botId primitive.ObjectID) (*api.VersionedTrainingData, error) {
+	var result api.VersionedTrainingData
+	_, err := c.call(ctx, request{
+		method:     http.MethodGet,
+		path:       "/v1/trainingdata",
+		query:      trainingDataQuery(projectId, botId),
+		idempotent: true,
+	}, &result)
+	if err != nil {
+		return nil, err
+	}
+	return &result, nil
+}
+
+func (c *client) patchTrainingData(ctx context.Context, projectId primitive.ObjectID, botId primitive.ObjectID, version int64, patchType string, value any) (*api.VersionedTrainingData, error) {
+	body, err := jsonBody(value)
+	if err != nil {
+		return nil, err
+	}
+	var result api.VersionedTrainingData
+	_, err = c.call(ctx, request{
+		method:      http.MethodPatch,
+		path:        "/v1/trainingdata",
+		query:       trainingDataQuery(projectId, botId),
+		header:      ifMatch(version),
+		body:        body,
+		contentType: patchType,
+		// a repeat fails the version check instead of applying twice
+		idempotent: version != AnyVersion,
+	}, &result)
+	if err != nil {
+		return nil, err
+	}
+	return &result, nil
+}
+
+// MergeTrainingData applies a JSON Merge Patch, any value marshalling to a
+// JSON object such as a json.RawMessage, when the training data is still at
+// version.
+func (c *client) MergeTrainingData(ctx context.Context, projectId primitive.ObjectID, botId primitive.ObjectID, version int64, mergePatch any) (*api.VersionedTrainingData, error) {
+	return c.patchTrainingData(ctx, projectId, botId, version, patch.MergePatchType, mergePatch)
+}
+
+// PatchTrainingData applies a JSON Patch when the training data is still at
+// version.
+func (c *client) PatchTrainingData(ctx context.Context, projectId primitive.ObjectID, botId primitive.ObjectID, version int64, operations []patch.Operation) (*api.VersionedTrainingData, error) {
+	return c.patchTrainingData(ctx, projectId, botId, version, patch.JSONPatchType, operations)
+}
+
+func (c *client) ResetTrainingData(ctx context.Context, projectId primitive.ObjectID, botId primitive.ObjectID, version int64) (*api.VersionedTrainingData, error) {
+	var result api.VersionedTrainingData
+	_, err := c.call(ctx, request{
+		method:     http.MethodDelete,
+		path:       "/v1/trainingdata",
+		query:      trainingDataQuery(projectId, botId),
+		header:     ifMatch(version),
+		idempotent: version != AnyVersion,
+	}, &result)
+	if err != nil {
+		return nil, err
+	}
+	return &result, nil
+}
@@ -0,0 +1,351 @@
+package main
+
+import (
+	"context"
+	"flag"
+	"fmt"
+	"github.com/draco121/horizon/models"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"io"
+	"io/fs"
+	"os"
+	"path"
+	"path/filepath"
+	"pulse/api"
+	"pulse/client"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+// uploadBatch bounds the files sent in one request so that large folders
+// stay below the request size limit of the upload policy.
+const uploadBatch = 20
+
+// localFile is a file found under a local folder. Path is relative to that
+// folder and slash separated.
+type localFile struct {
+	Path     string
+	FullPath string
+}
+
+// walkFiles lists the regular files of a folder, or the file itself, by
+// their relative paths.
+func walkFiles(root string) ([]localFile, error) {
+	info, err := os.Stat(root)
+	if err != nil {
+		return nil, err
+	}
+	if !info.IsDir() {
+		return []localFile{{Path: filepath.Base(root), FullPath: root}}, nil
+	}
+	files := make([]localFile, 0)
+	err = filepath.WalkDir(root, func(fullPath string, entry fs.DirEntry, err error) error {
+		if err != nil {
+			return err
+		}
+		if !entry.Type().IsRegular() {
+			return nil
+		}
+		rel, err := filepath.Rel(root, fullPath)
+		if err != nil {
+			return err
+		}
+		files = append(files, localFile{Path: filepath.ToSlash(rel), FullPath: fullPath})
+		return nil
+	})
+	return files, err
+}
+
+// uploadFiles uploads local files below folder, keeping the folders they
+// are in, a batch of files of the same folder at a time.
+func (c *cli) uploadFiles(ctx context.Context, t target, folder string, files []localFile, extract bool) (*api.UploadResult, error) {
+	byFolder := make(map[string][]localFile)
+	for _, file := range files {
+		dir := path.Join(folder, path.Dir(file.Path))
+		if dir == "." {
+			dir = ""
+		}
+		byFolder[dir] = append(byFolder[dir], file)
+	}
+	folders := make([]string, 0, len(byFolder))
+	for dir := range byFolder {
+		folders = append(folders, dir)
+	}
+	sort.Strings(folders)
+	result := &api.UploadResult{Files: make([]models.Files, 0), Rejected: make([]api.FileRejection, 0)}
+	for _, dir := range folders {
+		pending := byFolder[dir]
+		for len(pending) > 0 {
+			batch := pending[:min(uploadBatch, len(pending))]
+			pending = pending[len(batch):]
+			uploaded, err := c.uploadBatch(ctx, t, dir, batch, extract)
+			if err != nil {
+				return result, err
+			}
+			result.Files = append(result.Files, uploaded.Files...)
+			result.Rejected = append(result.Rejected, uploaded.Rejected...)
+			result.Skipped = append(result.Skipped, uploaded.Skipped...)
+		}
+	}
+	return result, nil
+}
+
+func (c *cli) uploadBatch(ctx context.Context, t target, folder string, batch []localFile, extract bool) (*api.UploadResult, error) {
+	uploads := make([]client.UploadFile, 0, len(batch))
+	for _, file := range batch {
+		opened, err := os.Open(file.FullPath)
+		if err != nil {
+			return nil, err
+		}
+		defer opened.Close()
+		uploads = append(uploads, client.UploadFile{Name: path.Base(file.Path), Content: opened})
+	}
+	return c.client.Upload(ctx, t.projectId, t.botId, uploads, client.UploadOptions{Extract: extract, Folder: folder})
+}
+
+func (c *cli) printUpload(result *api.UploadResult) error {
+	rows := make([][]string, 0, len(result.Files)+len(result.Rejected))
+	for _, file := range result.Files {
+		rows = append(rows, []string{"stored", file.FileId.Hex(), file.FileName, ""})
+	}
+	for _, rejection := range result.Rejected {
+		rows = append(rows, []string{"rejected", "", rejection.FileName, rejection.Reason})
+	}
+	for _, skipped := range result.Skipped {
+		rows = append(rows, []string{"skipped", "", skipped.Archive + "/" + skipped.FileName, skipped.Reason})
+	}
+	return c.out.table(result, []string{"STATUS", "FILE ID", "NAME", "REASON"}, rows)
+}
+
+func uploadCommand(ctx context.Context, c *cli, args []string) error {
+	flags := flag.NewFlagSet("upload", flag.ContinueOnError)
+	bot := botFlags(flags)
+	folder := flags.String("folder", "", "folder to upload into")
+	extract := flags.Bool("extract", false, "replace archives by the files they contain")
+	paths, err := parseFlags(flags, args)
+	if err != nil {
+		return err
+	}
+	t, err := bot()
+	if err != nil {
+		return err
+	}
+	if len(paths) == 0 {
+		return usageError("upload needs at least one file or folder")
+	}
+	result := &api.UploadResult{}
+	for _, root := range paths {
+		files, err := walkFiles(root)
+		if err != nil {
+			return err
+		}
+		uploaded, err := c.uploadFiles(ctx, t, *folder, files, *extract)
+		if uploaded != nil {
+			result.Files = append(result.Files, uploaded.Files...)
+			result.Rejected = append(result.Rejected, uploaded.Rejected...)
+			result.Skipped = append(result.Skipped, uploaded.Skipped...)
+		}
+		if err != nil {
+			_ = c.printUpload(result)
+			return err
+		}
+	}
+	err = c.printUpload(result)
+	if err == nil && len(result.Rejected) > 0 {
+		err = fmt.Errorf("%d of %d files were rejected", len(result.Rejected), len(result.Files)+len(result.Rejected))
+	}
+	return err
+}
+
+func listFilesCommand(ctx context.Context, c *cli, args []string) error {
+	flags := flag.NewFlagSet("files list", flag.ContinueOnError)
+	bot := botFlags(flags)
+	folder := flags.String("folder", "", "folder to list, the root by default")
+	recursive := flags.Bool("recursive", false, "also list the files in subfolders")
+	var tags stringList
+	flags.Var(&tags, "tag", "only list files with this tag, may be repeated")
+	_, err := parseFlags(flags, args)
+	if err != nil {
+		return err
+	}
+	t, err := bot()
+	if err != nil {
+		return err
+	}
+	files, err := c.client.ListFiles(ctx, t.projectId, t.botId, api.FileFilter{Folder: *folder, Recursive: *recursive, Tags: tags})
+	if err != nil {
+		return err
+	}
+	rows := make([][]string, 0, len(files))
+	for _, file := range files {
+		rows = append(rows, []string{
+			file.FileId.Hex(),
+			path.Join("/", file.Folder, file.FileName),
+			strconv.FormatInt(file.Size, 10),
+			file.MimeType,
+			strconv.FormatInt(file.Revision, 10),
+			strings.Join(file.Tags, ","),
+			formatTime(file.CreatedAt),
+		})
+	}
+	return c.out.table(files, []string{"FILE ID", "PATH", "SIZE", "TYPE", "REVISION", "TAGS", "CREATED"}, rows)
+}
+
+// stringList is a flag that may be repeated.
+type stringList []string
+
+func (l *stringList) String() string {
+	return strings.Join(*l, ",")
+}
+
+func (l *stringList) Set(value string) error {
+	*l = append(*l, value)
+	return nil
+}
+
+// downloadFile writes a file of a bot to localPath through a temporary
+// file, so that a failed download leaves nothing behind.
+func (c *cli) downloadFile(ctx context.Context, t target, fileId primitive.ObjectID, localPath string) (*client.File, error) {
+	err := os.MkdirAll(filepath.Dir(localPath), 0755)
+	if err != nil {
+		return nil, err
+	}
+	temp, err := os.CreateTemp(filepath.Dir(localPath), ".pulsectl-*")
+	if err != nil {
+		return nil, err
+	}
+	defer os.Remove(temp.Name())
+	file, err := c.client.DownloadTo(ctx, t.projectId, t.botId, fileId, temp)
+	closeErr := temp.Close()
+	if err != nil {
+		return nil, err
+	} else if closeErr != nil {
+		return nil, closeErr
+	}
+	return file, os.Rename(temp.Name(), localPath)
+}
+
+func downloadFilesCommand(ctx context.Context, c *cli, args []string) error {
+	flags := flag.NewFlagSet("files download", flag.ContinueOnError)
+	bot := botFlags(flags)
+	dir := flags.String("dir", ".", "folder to download into")
+	archiveFile := flags.String("archive", "", "download a single zip or tar.gz archive to this file instead")
+	values, err := parseFlags(flags, args)
+	if err != nil {
+		return err
+	}
+	t, err := bot()
+	if err != nil {
+		return err
+	}
+	fileIds, err := parseObjectIds(values)
+	if err != nil {
+		return err
+	}
+	if *archiveFile != "" {
+		return c.downloadArchive(ctx, t, *archiveFile, fileIds)
+	}
+	files, err := c.client.ListFiles(ctx, t.projectId, t.botId, api.FileFilter{Recursive: true})
+	if err != nil {
+		return err
+	}
+	wanted := make(map[primitive.ObjectID]bool, len(fileIds))
+	for _, fileId := range fileIds {
+		wanted[fileId] = true
+	}
+	rows := make([][]string, 0)
+	downloaded := make([]api.FileMetadata, 0)
+	for _, file := range files {
+		if len(wanted) > 0 && !wanted[file.FileId] {
+			continue
+		}
+		delete(wanted, file.FileId)
+		if file.Quarantined {
+			continue
+		}
+		localPath, err := localPathOf(*dir, file)
+		if err != nil {
+			return err
+		}
+		_, err = c.downloadFile(ctx, t, file.FileId, localPath)
+		if err != nil {
+			return fmt.Errorf("%s: %w", file.FileId.Hex(), err)
+		}
+		rows = append(rows, []string{file.FileId.Hex(), localPath, strconv.FormatInt(file.Size, 10)})
+		downloaded = append(downloaded, file)
+	}
+	for fileId := range wanted {
+		return fmt.Errorf("file %s: %w", fileId.Hex(), client.ErrNotFound)
+	}
+	return c.out.table(downloaded, []string{"FILE ID", "PATH", "SIZE"}, rows)
+}
+
+// localPathOf is where a file of a bot goes below dir, refusing names the
+// service should never have stored rather than writing outside of dir.
+func localPathOf(dir string, file api.FileMetadata) (string, error) {
+	rel := filepath.Join(filepath.FromSlash(file.Folder), file.FileName)
+	if !filepath.IsLocal(rel) {
+		return "", fmt.Errorf("file %s has an invalid path %q", file.FileId.Hex(), rel)
+	}
+	return filepath.Join(dir, rel), nil
+}
+
+func (c *cli) downloadArchive(ctx context.Context, t target, archiveFile string, fileIds []primitive.ObjectID) error {
+	format := "zip"
+	if strings.HasSuffix(archiveFile, ".tar.gz") || strings.HasSuffix(archiveFile, ".tgz") {
+		format = "tar.gz"
+	}
+	archive, err := c.client.DownloadArchive(ctx, t.projectId, t.botId, format, fileIds)
+	if err != nil {
+		return err
+	}
+	defer archive.Close()
+	out, err := os.Create(archiveFile)
+	if err != nil {
+		return err
+	}
+	size, err := io.Copy(out, archive)
+	closeErr := out.Close()
+	if err == nil {
+		err = closeErr
+	}
+	if err != nil {
+		_ = os.Remove(archiveFile)
+		return err
+	}
+	summary := map[string]any{"path": archiveFile, "format": format, "size": size}
+	return c.out.table(summary, []string{"PATH", "FORMAT", "SIZE"}, [][]string{{archiveFile, format, strconv.FormatInt(size, 10)}})
+}
+
+func deleteFilesCommand(ctx context.Context, c *cli, args []string) error {
+	flags := flag.NewFlagSet("files delete", flag.ContinueOnError)
+	bot := botFlags(flags)
+	values, err := parseFlags(flags, args)
+	if err != nil {
+		return err
+	}
+	t, err := bot()
+	if err != nil {
+		return err
+	}
+	fileIds, err := parseObjectIds(values)
+	if err != nil {
+		return err
+	}
+	if len(fileIds) == 0 {
+		return usageError("files delete needs at least one file id")
+	}
+	deleted := make([]primitive.ObjectID, 0, len(fileIds))
+	rows := make([][]string, 0, len(fileIds))
+	for _, fileId := range fileIds {
+		err = c.client.DeleteFile(ctx, t.projectId, t.botId, fileId)
+		if err != nil {
+			_ = c.out.table(deleted, []string{"DELETED"}, rows)
+			return fmt.Errorf("%s: %w", fileId.Hex(), err)
+		}
+		deleted = append(deleted, fileId)
+		rows = append(rows, []string{fileId.Hex()})
+	}
+	return c.out.table(deleted, []string{"DELETED"}, rows)
+}
@@ -0,0 +1,193 @@
+// Command pulsectl runs the common jobs on training data over the pulse API:
+// uploading, listing, downloading and deleting files, showing and resetting
+// training data, exporting and importing bots and syncing a local folder to
+// a bot.
+package main
+
+import (
+	"context"
+	"errors"
+	"flag"
+	"fmt"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"os"
+	"os/signal"
+	"pulse/client"
+	"syscall"
+	"time"
+)
+
+const usage = `usage: pulsectl [-url url] [-token token] [-output table|json] [-timeout duration] <command> [flags] [args]
+
+commands:
+  upload -project id -bot id [-folder folder] [-extract] <path>...
+  files list -project id -bot id [-folder folder] [-recursive] [-tag tag]...
+  files download -project id -bot id [-dir dir] [-archive file] [fileId]...
+  files delete -project id -bot id <fileId>...
+  trainingdata show -project id -bot id
+  trainingdata reset -project id -bot id (-version n | -force)
+  export -project id -bot id -dir dir
+  import -project id -bot id -dir dir
+  sync -project id -bot id [-folder folder] [-delete] [-dry-run] <dir>
+
+The url and token default to PULSE_URL and PULSE_TOKEN.`
+
+// errUsage reports a command line that cannot be run, it exits with 2.
+var errUsage = errors.New("invalid usage")
+
+func usageError(format string, args ...any) error {
+	return fmt.Errorf("%w: %s", errUsage, fmt.Sprintf(format, args...))
+}
+
+type cli struct {
+	client client.IClient
+	out    *printer
+}
+
+// command runs with the arguments left after its name.
+type command func(ctx context.Context, c *cli, args []string) error
+
+var commands = map[string]command{
+	"upload":             uploadCommand,
+	"files list":         listFilesCommand,
+	"files download":     downloadFilesCommand,
+	"files delete":       deleteFilesCommand,
+	"trainingdata show":  showTrainingDataCommand,
+	"trainingdata reset": resetTrainingDataCommand,
+	"export":             exportCommand,
+	"import":             importCommand,
+	"sync":               syncCommand,
+}
+
+// lookup finds the command named by the first one or two arguments.
+func lookup(args []string) (command, []string, bool) {
+	if len(args) >= 2 {
+		if run, ok := commands[args[0]+" "+args[1]]; ok {
+			return run, args[2:], true
+		}
+	}
+	if len(args) >= 1 {
+		if run, ok := commands[args[0]]; ok {
+			return run, args[1:], true
+		}
+	}
+	return nil, nil, false
+}
+
+func main() {
+	os.Exit(run(os.Args[1:]))
+}
+
+func run(args []string) int {
+	flags := flag.NewFlagSet("pulsectl", flag.ContinueOnError)
+	flags.Usage = func() {
+		fmt.Fprintln(os.Stderr, usage)
+	}
+	baseUrl := flags.String("url", envOr("PULSE_URL", "http://localhost:8080"), "base url of the service")
+	token := flags.String("token", os.Getenv("PULSE_TOKEN"), "token sent in the Authorization header")
+	output := flags.String("output", outputTable, "output format, table or json")
+	timeout := flags.Duration("timeout", 0, "time limit of the whole command, none when 0")
+	err := flags.Parse(args)
+	if err != nil {
+		return 2
+	}
+	if *output != outputTable && *output != outputJSON {
+		fmt.Fprintln(os.Stderr, "output must be table or json")
+		return 2
+	}
+	cmd, rest, ok := lookup(flags.Args())
+	if !ok {
+		flags.Usage()
+		return 2
+	}
+	apiClient, err := client.NewClient(*baseUrl, client.Options{Token: *token, UserAgent: "pulsectl"})
+	if err != nil {
+		fmt.Fprintln(os.Stderr, err.Error())
+		return 2
+	}
+	ctx, stop := signal.NotifyContext(context.Background(), syscall.SIGINT, syscall.SIGTERM)
+	defer stop()
+	if *timeout > 0 {
+		var cancel context.CancelFunc
+		ctx, cancel = context.WithTimeout(ctx, *timeout)
+		defer cancel()
+	}
+	err = cmd(ctx, &cli{client: apiClient, out: newPrinter(os.Stdout, *output)}, rest)
+	if errors.Is(err, errUsage) {
+		fmt.Fprintln(os.Stderr, err.Error())
+		return 2
+	} else if err != nil {
+		fmt.Fprintln(os.Stderr, "pulsectl: "+err.Error())
+		return 1
+	}
+	return 0
+}
+
+func envOr(name string, fallback string) string {
+	if value := os.Getenv(name); value != "" {
+		return value
+	}
+	return fallback
+}
+
+// target is the bot a command works on.
+type target struct {
+	projectId primitive.ObjectID
+	botId     primitive.ObjectID
+}
+
+// botFlags adds the -project and -bot flags, the returned function parses
+// them once the flags are parsed.
+func botFlags(flags *flag.FlagSet) func() (target, error) {
+	project := flags.String("project", "", "project id")
+	bot := flags.String("bot", "", "bot id")
+	return func() (target, error) {
+		projectId, err := primitive.ObjectIDFromHex(*project)
+		if err != nil {
+			return target{}, usageError("-project must be a valid id")
+		}
+		botId, err := primitive.ObjectIDFromHex(*bot)
+		if err != nil {
+			return target{}, usageError("-bot must be a valid id")
+		}
+		return target{projectId: projectId, botId: botId}, nil
+	}
+}
+
+// parseFlags parses the flags of a command, which may come before or
+// after its positional arguments.
+func parseFlags(flags *flag.FlagSet, args []string) ([]string, error) {
+	flags.SetOutput(os.Stderr)
+	var positional []string
+	for {
+		err := flags.Parse(args)
+		if err != nil {
+			return nil, fmt.Errorf("%w: %s", errUsage, err.Error())
+		}
+		args = flags.Args()
+		if len(args) == 0 {
+			return positional, nil
+		}
+		positional = append(positional, args[0])
+		args = args[1:]
+	}
+}
+
+func parseObjectIds(values []string) ([]primitive.ObjectID, error) {
+	ids := make([]primitive.ObjectID, 0, len(values))
+	for _, value := range values {
+		id, err := primitive.ObjectIDFromHex(value)
+		if err != nil {
+			return nil, usageError("%q is not a valid file id", value)
+		}
+		ids = append(ids, id)
+	}
+	return ids, nil
+}
+
+func formatTime(t time.Time) string {
+	if t.IsZero() {
+		return ""
+	}
+	return t.Local().Format(time.DateTime)
+}
@@ -0,0 +1,234 @@
+package main
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"flag"
+	"fmt"
+	"io"
+	"os"
+	"path"
+	"pulse/api"
+	"pulse/client"
+	"pulse/naming"
+	"sort"
+	"strings"
+)
+
+const (
+	syncUpload  = "upload"
+	syncReplace = "replace"
+	syncDelete  = "delete"
+	syncSkip    = "skip"
+)
+
+// syncAction is a change sync makes to the bot, Error is set when it failed
+// or was skipped.
+type syncAction struct {
+	Action string `json:"action"`
+	Path   string `json:"path"`
+	FileId string `json:"fileId,omitempty"`
+	Error  string `json:"error,omitempty"`
+
+	local  localFile
+	remote api.FileMetadata
+}
+
+// remotePath is where a local file ends up below the synced folder, with
+// its folders and name normalized the way the service stores them.
+func remotePath(file localFile) (string, error) {
+	dir := path.Dir(file.Path)
+	if dir == "." {
+		dir = ""
+	}
+	folder, err := naming.NormalizeFolder(dir)
+	if err != nil {
+		return "", err
+	}
+	name, err := naming.NormalizeFileName(path.Base(file.Path))
+	if err != nil {
+		return "", err
+	}
+	return path.Join(folder, name), nil
+}
+
+func fileChecksum(fullPath string) (string, error) {
+	file, err := os.Open(fullPath)
+	if err != nil {
+		return "", err
+	}
+	defer file.Close()
+	checksum := sha256.New()
+	_, err = io.Copy(checksum, file)
+	if err != nil {
+		return "", err
+	}
+	return hex.EncodeToString(checksum.Sum(nil)), nil
+}
+
+// planSync compares a local folder with the files of the bot below folder.
+func (c *cli) planSync(ctx context.Context, t target, folder string, dir string, deleteRemoved bool) ([]*syncAction, error) {
+	files, err := c.client.ListFiles(ctx, t.projectId, t.botId, api.FileFilter{Folder: folder, Recursive: true})
+	if err != nil {
+		return nil, err
+	}
+	remote := make(map[string]api.FileMetadata, len(files))
+	for _, file := range files {
+		rel := strings.TrimPrefix(path.Join(file.Folder, file.FileName), folder+"/")
+		if _, ok := remote[rel]; !ok {
+			remote[rel] = file
+		}
+	}
+	local, err := walkFiles(dir)
+	if err != nil {
+		return nil, err
+	}
+	actions := make([]*syncAction, 0)
+	seen := make(map[string]bool, len(local))
+	for _, file := range local {
+		rel, err := remotePath(file)
+		if err != nil {
+			actions = append(actions, &syncAction{Action: syncSkip, Path: file.Path, Error: err.Error()})
+			continue
+		}
+		seen[rel] = true
+		existing, ok := remote[rel]
+		if !ok {
+			actions = append(actions, &syncAction{Action: syncUpload, Path: rel, local: file})
+			continue
+		}
+		checksum, err := fileChecksum(file.FullPath)
+		if err != nil {
+			return nil, err
+		}
+		if checksum != existing.Checksum {
+			actions = append(actions, &syncAction{Action: syncReplace, Path: rel, FileId: existing.FileId.Hex(), local: file, remote: existing})
+		}
+	}
+	if deleteRemoved {
+		for rel, file := range remote {
+			if !seen[rel] {
+				actions = append(actions, &syncAction{Action: syncDelete, Path: rel, FileId: file.FileId.Hex(), remote: file})
+			}
+		}
+	}
+	sort.Slice(actions, func(i, j int) bool {
+		return actions[i].Path < actions[j].Path
+	})
+	return actions, nil
+}
+
+// applySync runs the planned actions, recording the failures on them.
+func (c *cli) applySync(ctx context.Context, t target, folder string, actions []*syncAction) {
+	uploads := make(map[string][]*syncAction)
+	for _, action := range actions {
+		switch action.Action {
+		case syncUpload:
+			dir := path.Join(folder, path.Dir(action.Path))
+			if dir == "." {
+				dir = ""
+			}
+			uploads[dir] = append(uploads[dir], action)
+		case syncReplace:
+			action.fail(c.replace(ctx, t, action))
+		case syncDelete:
+			action.fail(c.client.DeleteFile(ctx, t.projectId, t.botId, action.remote.FileId))
+		}
+	}
+	for dir, pending := range uploads {
+		for len(pending) > 0 {
+			batch := pending[:min(uploadBatch, len(pending))]
+			pending = pending[len(batch):]
+			c.uploadSyncBatch(ctx, t, dir, batch)
+		}
+	}
+}
+
+func (a *syncAction) fail(err error) {
+	if err != nil {
+		a.Error = err.Error()
+	}
+}
+
+// replace stores the local content under the remote file, unless the file
+// was changed by someone else since it was listed.
+func (c *cli) replace(ctx context.Context, t target, action *syncAction) error {
+	file, err := os.Open(action.local.FullPath)
+	if err != nil {
+		return err
+	}
+	defer file.Close()
+	upload := client.UploadFile{Name: path.Base(action.Path), Content: file}
+	_, err = c.client.ReplaceFile(ctx, t.projectId, t.botId, action.remote.FileId, upload, action.remote.Checksum)
+	return err
+}
+
+func (c *cli) uploadSyncBatch(ctx context.Context, t target, dir string, batch []*syncAction) {
+	files := make([]localFile, 0, len(batch))
+	byName := make(map[string]*syncAction, len(batch))
+	for _, action := range batch {
+		files = append(files, localFile{Path: path.Base(action.Path), FullPath: action.local.FullPath})
+		byName[path.Base(action.Path)] = action
+	}
+	result, err := c.uploadBatch(ctx, t, dir, files, false)
+	if err != nil {
+		for _, action := range batch {
+			action.fail(err)
+		}
+		return
+	}
+	for _, stored := range result.Files {
+		if action, ok := byName[stored.FileName]; ok {
+			action.FileId = stored.FileId.Hex()
+		}
+	}
+	for _, rejection := range result.Rejected {
+		if action, ok := byName[rejection.FileName]; ok {
+			action.Error = rejection.Reason
+		}
+	}
+}
+
+func syncCommand(ctx context.Context, c *cli, args []string) error {
+	flags := flag.NewFlagSet("sync", flag.ContinueOnError)
+	bot := botFlags(flags)
+	folder := flags.String("folder", "", "folder of the bot to sync the local folder to, the root by default")
+	deleteRemoved := flags.Bool("delete", false, "delete the files of the bot missing from the local folder")
+	dryRun := flags.Bool("dry-run", false, "only show the changes")
+	dirs, err := parseFlags(flags, args)
+	if err != nil {
+		return err
+	}
+	t, err := bot()
+	if err != nil {
+		return err
+	}
+	if len(dirs) != 1 {
+		return usageError("sync needs exactly one local folder")
+	}
+	remoteFolder, err := naming.NormalizeFolder(*folder)
+	if err != nil {
+		return usageError("-folder: %s", err.Error())
+	}
+	actions, err := c.planSync(ctx, t, remoteFolder, dirs[0], *deleteRemoved)
+	if err != nil {
+		return err
+	}
+	if !*dryRun {
+		c.applySync(ctx, t, remoteFolder, actions)
+	}
+	failed := 0
+	rows := make([][]string, 0, len(actions))
+	for _, action := range actions {
+		if action.Error != "" {
+			failed++
+		}
+		rows = append(rows, []string{action.Action, action.Path, action.FileId, action.Error})
+	}
+	err = c.out.table(actions, []string{"ACTION", "PATH", "FILE ID", "ERROR"}, rows)
+	if err == nil && failed > 0 {
+		err = fmt.Errorf("%d of %d changes failed", failed, len(actions))
+	}
+	return err
+}
@@ -0,0 +1,70 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"flag"
+	"pulse/api"
+	"pulse/client"
+	"strconv"
+)
+
+func (c *cli) printTrainingData(trainingData *api.VersionedTrainingData) error {
+	rows := [][]string{
+		{"id", trainingData.ID.Hex()},
+		{"project", trainingData.ProjectId.Hex()},
+		{"bot", trainingData.BotId.Hex()},
+		{"version", strconv.FormatInt(trainingData.Version, 10)},
+		{"description", trainingData.Description},
+		{"greeting", trainingData.Greeting},
+		{"persona", trainingData.Persona},
+		{"files", strconv.Itoa(len(trainingData.Files))},
+		{"questions", strconv.Itoa(len(trainingData.QA))},
+	}
+	return c.out.table(trainingData, []string{"FIELD", "VALUE"}, rows)
+}
+
+func showTrainingDataCommand(ctx context.Context, c *cli, args []string) error {
+	flags := flag.NewFlagSet("trainingdata show", flag.ContinueOnError)
+	bot := botFlags(flags)
+	_, err := parseFlags(flags, args)
+	if err != nil {
+		return err
+	}
+	t, err := bot()
+	if err != nil {
+		return err
+	}
+	trainingData, err := c.client.GetTrainingData(ctx, t.projectId, t.botId)
+	if err != nil {
+		return err
+	}
+	return c.printTrainingData(trainingData)
+}
+
+func resetTrainingDataCommand(ctx context.Context, c *cli, args []string) error {
+	flags := flag.NewFlagSet("trainingdata reset", flag.ContinueOnError)
+	bot := botFlags(flags)
+	version := flags.Int64("version", 0, "version the training data must still be at, as shown by trainingdata show")
+	force := flags.Bool("force", false, "reset whatever the version is")
+	_, err := parseFlags(flags, args)
+	if err != nil {
+		return err
+	}
+	t, err := bot()
+	if err != nil {
+		return err
+	}
+	if *force {
+		*version = client.AnyVersion
+	} else if *version < 1 {
+		return usageError("trainingdata reset needs -version or -force")
+	}
+	trainingData, err := c.client.ResetTrainingData(ctx, t.projectId, t.botId, *version)
+	if errors.Is(err, client.ErrPreconditionFailed) {
+		return errors.New("the training data was changed since that version, show it again and retry")
+	} else if err != nil {
+		return err
+	}
+	return c.printTrainingData(trainingData)
+}
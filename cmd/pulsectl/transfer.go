@@ -0,0 +1,149 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"flag"
+	"github.com/draco121/horizon/models"
+	"os"
+	"path/filepath"
+	"pulse/api"
+	"pulse/client"
+	"strconv"
+)
+
+// An export is a folder holding the training data in trainingDataFile and
+// the files of the bot below filesDir, in their folders.
+const (
+	trainingDataFile = "trainingdata.json"
+	filesDir         = "files"
+)
+
+// exportedTrainingData is the part of the training data that moves between
+// bots, the ids and the file list belong to the bot it was taken from.
+type exportedTrainingData struct {
+	Description string        `json:"description"`
+	Greeting    string        `json:"greeting"`
+	Persona     string        `json:"persona"`
+	QA          []models.FAQS `json:"qa"`
+}
+
+func exportCommand(ctx context.Context, c *cli, args []string) error {
+	flags := flag.NewFlagSet("export", flag.ContinueOnError)
+	bot := botFlags(flags)
+	dir := flags.String("dir", "", "folder to export into, created when missing")
+	_, err := parseFlags(flags, args)
+	if err != nil {
+		return err
+	}
+	t, err := bot()
+	if err != nil {
+		return err
+	}
+	if *dir == "" {
+		return usageError("export needs -dir")
+	}
+	trainingData, err := c.client.GetTrainingData(ctx, t.projectId, t.botId)
+	if err != nil {
+		return err
+	}
+	err = os.MkdirAll(*dir, 0755)
+	if err != nil {
+		return err
+	}
+	data, err := json.MarshalIndent(exportedTrainingData{
+		Description: trainingData.Description,
+		Greeting:    trainingData.Greeting,
+		Persona:     trainingData.Persona,
+		QA:          trainingData.QA,
+	}, "", "  ")
+	if err != nil {
+		return err
+	}
+	err = os.WriteFile(filepath.Join(*dir, trainingDataFile), data, 0644)
+	if err != nil {
+		return err
+	}
+	files, err := c.client.ListFiles(ctx, t.projectId, t.botId, api.FileFilter{Recursive: true})
+	if err != nil {
+		return err
+	}
+	exported := make([]api.FileMetadata, 0, len(files))
+	rows := make([][]string, 0, len(files))
+	for _, file := range files {
+		if file.Quarantined {
+			continue
+		}
+		localPath, err := localPathOf(filepath.Join(*dir, filesDir), file)
+		if err != nil {
+			return err
+		}
+		_, err = c.downloadFile(ctx, t, file.FileId, localPath)
+		if err != nil {
+			return err
+		}
+		exported = append(exported, file)
+		rows = append(rows, []string{file.FileId.Hex(), localPath, strconv.FormatInt(file.Size, 10)})
+	}
+	return c.out.table(exported, []string{"FILE ID", "PATH", "SIZE"}, rows)
+}
+
+func importCommand(ctx context.Context, c *cli, args []string) error {
+	flags := flag.NewFlagSet("import", flag.ContinueOnError)
+	bot := botFlags(flags)
+	dir := flags.String("dir", "", "folder written by export")
+	_, err := parseFlags(flags, args)
+	if err != nil {
+		return err
+	}
+	t, err := bot()
+	if err != nil {
+		return err
+	}
+	if *dir == "" {
+		return usageError("import needs -dir")
+	}
+	data, err := os.ReadFile(filepath.Join(*dir, trainingDataFile))
+	if err != nil {
+		return err
+	}
+	var imported exportedTrainingData
+	err = json.Unmarshal(data, &imported)
+	if err != nil {
+		return err
+	}
+	err = c.importTrainingData(ctx, t, imported)
+	if err != nil {
+		return err
+	}
+	result := &api.UploadResult{}
+	files, err := walkFiles(filepath.Join(*dir, filesDir))
+	if err == nil {
+		result, err = c.uploadFiles(ctx, t, "", files, false)
+	} else if errors.Is(err, os.ErrNotExist) {
+		err = nil
+	}
+	printErr := c.printUpload(result)
+	if err != nil {
+		return err
+	}
+	return printErr
+}
+
+// importTrainingData creates the training data of the bot, or overwrites
+// the imported fields when it has some already.
+func (c *cli) importTrainingData(ctx context.Context, t target, imported exportedTrainingData) error {
+	_, err := c.client.AddTrainingData(ctx, &models.TrainingData{
+		ProjectId:   t.projectId,
+		BotId:       t.botId,
+		Description: imported.Description,
+		Greeting:    imported.Greeting,
+		Persona:     imported.Persona,
+		QA:          imported.QA,
+	})
+	if errors.Is(err, client.ErrConflict) {
+		_, err = c.client.MergeTrainingData(ctx, t.projectId, t.botId, client.AnyVersion, imported)
+	}
+	return err
+}
@@ -0,0 +1,167 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"flag"
+	"fmt"
+	"github.com/draco121/horizon/utils"
+	"github.com/gin-gonic/gin"
+	"os"
+	"pulse/config"
+	"pulse/controllers"
+	"pulse/migrations"
+	"pulse/openapi"
+	"pulse/routes"
+	"text/tabwriter"
+	"time"
+)
+
+// configCommand runs `pulse config print`, which shows the effective
+// configuration with its secrets redacted.
+func configCommand(args []string) int {
+	if len(args) == 0 || args[0] != "print" {
+		fmt.Fprintln(os.Stderr, "usage: pulse config print [-config file.yaml]")
+		return 2
+	}
+	flags := flag.NewFlagSet("pulse config print", flag.ExitOnError)
+	file := flags.String("config", "", "YAML configuration file, "+config.FileEnv+" when not set")
+	_ = flags.Parse(args[1:])
+	cfg, err := config.Load(*file)
+	if err != nil {
+		fmt.Fprintln(os.Stderr, err.Error())
+		return 2
+	}
+	err = cfg.Print(os.Stdout)
+	if err != nil {
+		fmt.Fprintln(os.Stderr, err.Error())
+		return 1
+	}
+	err = cfg.Validate()
+	if err != nil {
+		fmt.Fprintln(os.Stderr, err.Error())
+		return 2
+	}
+	return 0
+}
+
+// migrateCommand runs `pulse migrate status|up|down`. up applies every
+// pending migration and down reverts the last one unless -to sets the
+// version to stop at.
+func migrateCommand(args []string) int {
+	usage := "usage: pulse migrate status|up|down [-to version] [-config file.yaml]"
+	if len(args) == 0 {
+		fmt.Fprintln(os.Stderr, usage)
+		return 2
+	}
+	action := args[0]
+	flags := flag.NewFlagSet("pulse migrate "+action, flag.ExitOnError)
+	file := flags.String("config", "", "YAML configuration file, "+config.FileEnv+" when not set")
+	to := flags.Int("to", -1, "version to migrate up or down to")
+	_ = flags.Parse(args[1:])
+	cfg := loadConfig(*file)
+	client := newMongoClient(cfg.Mongo)
+	defer func() {
+		_ = client.Disconnect(context.Background())
+	}()
+	migrator := migrations.NewMigrator(client.Database(cfg.Mongo.Database), migrations.All)
+	ctx, cancel := context.WithTimeout(context.Background(), cfg.Mongo.MigrationTimeout)
+	defer cancel()
+
+	var done []migrations.Migration
+	var err error
+	switch action {
+	case "status":
+		return printMigrations(ctx, migrator)
+	case "up":
+		target := migrations.Latest
+		if *to >= 0 {
+			target = *to
+		}
+		done, err = migrator.Up(ctx, target)
+	case "down":
+		target := *to
+		if target < 0 {
+			last, err := lastApplied(ctx, migrator)
+			if err != nil {
+				fmt.Fprintln(os.Stderr, err.Error())
+				return 1
+			}
+			target = max(last-1, 0)
+		}
+		done, err = migrator.Down(ctx, target)
+	default:
+		fmt.Fprintln(os.Stderr, usage)
+		return 2
+	}
+	for _, migration := range done {
+		fmt.Printf("%s %d: %s\n", action, migration.Version, migration.Description)
+	}
+	if err != nil {
+		fmt.Fprintln(os.Stderr, err.Error())
+		return 1
+	}
+	if len(done) == 0 {
+		fmt.Println("nothing to migrate")
+	}
+	return 0
+}
+
+// openapiCommand runs `pulse openapi`, which writes the OpenAPI document
+// once the routes are checked against it, for clients generated from it.
+func openapiCommand(args []string) int {
+	flags := flag.NewFlagSet("pulse openapi", flag.ExitOnError)
+	_ = flags.Parse(args)
+	// keep stdout for the document
+	utils.Logger.SetOutput(os.Stderr)
+	gin.SetMode(gin.ReleaseMode)
+	router := gin.New()
+	routes.RegisterRoutes(controllers.Controllers{}, router)
+	err := openapi.Check(router.Routes())
+	if err != nil {
+		fmt.Fprintln(os.Stderr, err.Error())
+		return 1
+	}
+	encoder := json.NewEncoder(os.Stdout)
+	encoder.SetIndent("", "  ")
+	err = encoder.Encode(openapi.Spec())
+	if err != nil {
+		fmt.Fprintln(os.Stderr, err.Error())
+		return 1
+	}
+	return 0
+}
+
+func printMigrations(ctx context.Context, migrator migrations.IMigrator) int {
+	statuses, err := migrator.Status(ctx)
+	if err != nil {
+		fmt.Fprintln(os.Stderr, err.Error())
+		return 1
+	}
+	writer := tabwriter.NewWriter(os.Stdout, 0, 4, 2, ' ', 0)
+	fmt.Fprintln(writer, "VERSION\tAPPLIED\tDESCRIPTION")
+	for _, status := range statuses {
+		applied := "pending"
+		if status.AppliedAt != nil {
+			applied = status.AppliedAt.Format(time.RFC3339)
+		}
+		fmt.Fprintf(writer, "%d\t%s\t%s\n", status.Version, applied, status.Description)
+	}
+	_ = writer.Flush()
+	return 0
+}
+
+// lastApplied returns the newest applied version, 0 when none is.
+func lastApplied(ctx context.Context, migrator migrations.IMigrator) (int, error) {
+	statuses, err := migrator.Status(ctx)
+	if err != nil {
+		return 0, err
+	}
+	last := 0
+	for _, status := range statuses {
+		if status.AppliedAt != nil {
+			last = status.Version
+		}
+	}
+	return last, nil
+}
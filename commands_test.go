@@ -0,0 +1,36 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"pulse/migrations"
+	"testing"
+	"time"
+)
+
+type statusMigrator struct {
+	migrations.IMigrator
+	statuses []migrations.Status
+	err      error
+}
+
+func (m statusMigrator) Status(ctx context.Context) ([]migrations.Status, error) {
+	return m.statuses, m.err
+}
+
+func TestLastApplied(t *testing.T) {
+	appliedAt := time.Now()
+	last, err := lastApplied(context.Background(), statusMigrator{statuses: []migrations.Status{
+		{Version: 1, AppliedAt: &appliedAt},
+		{Version: 2, AppliedAt: &appliedAt},
+		{Version: 3},
+	}})
+	if err != nil || last != 2 {
+		t.Fatalf("lastApplied = %d, %v, want 2", last, err)
+	}
+	failure := errors.New("server selection timeout")
+	_, err = lastApplied(context.Background(), statusMigrator{err: failure})
+	if !errors.Is(err, failure) {
+		t.Fatalf("err = %v, want the error of Status", err)
+	}
+}
@@ -0,0 +1,323 @@
+package config
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"gopkg.in/yaml.v3"
+	"io"
+	"net/url"
+	"os"
+	"reflect"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// FileEnv names the YAML configuration file when none is given on the command
+// line.
+const FileEnv = "PULSE_CONFIG"
+
+const redacted = "[redacted]"
+
+// Config holds every tunable of the service. Each field is read, in order of
+// precedence, from the environment variable in its env tag, the .env file,
+// the YAML file and finally Defaults. Durations set through the environment
+// take a Go duration such as 90s, or a bare number in the unit of the unit
+// tag for the variables that always took one.
+type Config struct {
+	Server      Server      `yaml:"server"`
+	Mongo       Mongo       `yaml:"mongo"`
+	Storage     Storage     `yaml:"storage"`
+	Limits      Limits      `yaml:"limits"`
+	Upload      Upload      `yaml:"upload"`
+	Quota       Quota       `yaml:"quota"`
+	Archive     Archive     `yaml:"archive"`
+	Scanner     Scanner     `yaml:"scanner"`
+	Encryption  Encryption  `yaml:"encryption"`
+	Idempotency Idempotency `yaml:"idempotency"`
+	Tracing     Tracing     `yaml:"tracing"`
+}
+
+type Server struct {
+	Port              int           `yaml:"port" env:"PORT"`
+	ReadHeaderTimeout time.Duration `yaml:"readHeaderTimeout" env:"SERVER_READ_HEADER_TIMEOUT"`
+	IdleTimeout       time.Duration `yaml:"idleTimeout" env:"SERVER_IDLE_TIMEOUT"`
+	// ShutdownDelay is how long readiness fails before the listener closes,
+	// ShutdownTimeout how long requests and jobs then get to finish.
+	ShutdownDelay   time.Duration `yaml:"shutdownDelay" env:"SHUTDOWN_DELAY_SECONDS" unit:"s"`
+	ShutdownTimeout time.Duration `yaml:"shutdownTimeout" env:"SHUTDOWN_TIMEOUT_SECONDS" unit:"s"`
+	TLS             TLS           `yaml:"tls"`
+}
+
+// TLS serves HTTPS when both files are set.
+type TLS struct {
+	CertFile   string `yaml:"certFile" env:"TLS_CERT_FILE"`
+	KeyFile    string `yaml:"keyFile" env:"TLS_KEY_FILE"`
+	MinVersion string `yaml:"minVersion" env:"TLS_MIN_VERSION"`
+}
+
+func (t TLS) Enabled() bool {
+	return t.CertFile != "" || t.KeyFile != ""
+}
+
+type Mongo struct {
+	URI            string        `yaml:"uri" env:"MONGODB_URI" secret:"true"`
+	Database       string        `yaml:"database" env:"MONGODB_DATABASE"`
+	ConnectTimeout time.Duration `yaml:"connectTimeout" env:"MONGODB_CONNECT_TIMEOUT"`
+	// AutoMigrate applies the pending migrations on startup, waiting up to
+	// MigrationTimeout for other replicas doing the same.
+	AutoMigrate      bool          `yaml:"autoMigrate" env:"MONGODB_AUTO_MIGRATE"`
+	MigrationTimeout time.Duration `yaml:"migrationTimeout" env:"MONGODB_MIGRATION_TIMEOUT"`
+}
+
+type Storage struct {
+	Backend string `yaml:"backend" env:"STORAGE_BACKEND"`
+	// MinFreeBytes is the free disk space below which the service is not
+	// ready, 0 disables the check.
+	MinFreeBytes uint64 `yaml:"minFreeBytes" env:"READY_MIN_FREE_BYTES"`
+}
+
+// Limits bound how much of a request body is held in memory.
+type Limits struct {
+	MaxMultipartMemory int64 `yaml:"maxMultipartMemory" env:"MAX_MULTIPART_MEMORY"`
+	MaxJSONBodySize    int64 `yaml:"maxJsonBodySize" env:"MAX_JSON_BODY_SIZE"`
+}
+
+// Upload is the upload policy of projects without one of their own, 0 means
+// unlimited.
+type Upload struct {
+	AllowedMimeTypes []string `yaml:"allowedMimeTypes" env:"UPLOAD_ALLOWED_MIME_TYPES"`
+	MaxFileSize      int64    `yaml:"maxFileSize" env:"UPLOAD_MAX_FILE_SIZE"`
+	MaxRequestSize   int64    `yaml:"maxRequestSize" env:"UPLOAD_MAX_REQUEST_SIZE"`
+	MaxFilesPerBot   int64    `yaml:"maxFilesPerBot" env:"UPLOAD_MAX_FILES_PER_BOT"`
+}
+
+// Quota holds the storage quotas, 0 means unlimited.
+type Quota struct {
+	ProjectMaxBytes     int64 `yaml:"projectMaxBytes" env:"QUOTA_PROJECT_MAX_BYTES"`
+	ProjectMaxFiles     int64 `yaml:"projectMaxFiles" env:"QUOTA_PROJECT_MAX_FILES"`
+	ProjectSoftMaxBytes int64 `yaml:"projectSoftMaxBytes" env:"QUOTA_PROJECT_SOFT_MAX_BYTES"`
+	ProjectSoftMaxFiles int64 `yaml:"projectSoftMaxFiles" env:"QUOTA_PROJECT_SOFT_MAX_FILES"`
+	BotMaxBytes         int64 `yaml:"botMaxBytes" env:"QUOTA_BOT_MAX_BYTES"`
+	BotMaxFiles         int64 `yaml:"botMaxFiles" env:"QUOTA_BOT_MAX_FILES"`
+	BotSoftMaxBytes     int64 `yaml:"botSoftMaxBytes" env:"QUOTA_BOT_SOFT_MAX_BYTES"`
+	BotSoftMaxFiles     int64 `yaml:"botSoftMaxFiles" env:"QUOTA_BOT_SOFT_MAX_FILES"`
+}
+
+// Archive bounds the archives extracted by uploads. The number of entries is
+// also capped at 1000 by the multipart reader unless GODEBUG raises
+// multipartmaxparts.
+type Archive struct {
+	MaxEntries   int   `yaml:"maxEntries" env:"ARCHIVE_MAX_ENTRIES"`
+	MaxTotalSize int64 `yaml:"maxTotalSize" env:"ARCHIVE_MAX_TOTAL_SIZE"`
+	MaxRatio     int64 `yaml:"maxRatio" env:"ARCHIVE_MAX_RATIO"`
+}
+
+// Scanner scans uploads with clamd when ClamdAddress is set, either
+// tcp://host:port or unix:///path/to/clamd.sock.
+type Scanner struct {
+	ClamdAddress string        `yaml:"clamdAddress" env:"CLAMD_ADDRESS"`
+	Timeout      time.Duration `yaml:"timeout" env:"CLAMD_TIMEOUT_SECONDS" unit:"s"`
+}
+
+// Encryption encrypts stored files when KeyFile names a master key file.
+type Encryption struct {
+	KeyFile string `yaml:"keyFile" env:"ENCRYPTION_KEY_FILE"`
+}
+
+type Idempotency struct {
+	TTL time.Duration `yaml:"ttl" env:"IDEMPOTENCY_TTL_HOURS" unit:"h"`
+}
+
+type Tracing struct {
+	Exporter string `yaml:"exporter" env:"OTEL_TRACES_EXPORTER"`
+}
+
+func Defaults() Config {
+	return Config{
+		Server: Server{
+			Port:              8080,
+			ReadHeaderTimeout: 10 * time.Second,
+			IdleTimeout:       2 * time.Minute,
+			ShutdownDelay:     5 * time.Second,
+			ShutdownTimeout:   30 * time.Second,
+			TLS:               TLS{MinVersion: "1.2"},
+		},
+		Mongo: Mongo{
+			Database:         "training-service",
+			ConnectTimeout:   10 * time.Second,
+			AutoMigrate:      true,
+			MigrationTimeout: 5 * time.Minute,
+		},
+		Storage: Storage{Backend: BackendFilesystem},
+		Limits: Limits{
+			MaxMultipartMemory: 30 << 20,
+			MaxJSONBodySize:    1 << 20,
+		},
+		Archive: Archive{
+			MaxEntries:   1000,
+			MaxTotalSize: 1 << 30,
+			MaxRatio:     100,
+		},
+		Scanner:     Scanner{Timeout: time.Minute},
+		Idempotency: Idempotency{TTL: 24 * time.Hour},
+		Tracing:     Tracing{Exporter: "none"},
+	}
+}
+
+// Load builds the configuration from the defaults, the YAML file and the
+// environment. file falls back to the PULSE_CONFIG variable and may be empty.
+// The .env file is expected to be loaded into the environment already. Load
+// only reports values it cannot parse, Validate checks the result.
+func Load(file string) (*Config, error) {
+	config := Defaults()
+	if file == "" {
+		file = os.Getenv(FileEnv)
+	}
+	if file != "" {
+		content, err := os.ReadFile(file)
+		if err != nil {
+			return nil, fmt.Errorf("config file: %w", err)
+		}
+		decoder := yaml.NewDecoder(bytes.NewReader(content))
+		decoder.KnownFields(true)
+		err = decoder.Decode(&config)
+		if err != nil && !errors.Is(err, io.EOF) {
+			return nil, fmt.Errorf("config file %s: %w", file, err)
+		}
+	}
+	var problems []string
+	walk(reflect.ValueOf(&config).Elem(), "", func(field reflect.Value, tag reflect.StructTag, path string) {
+		key := tag.Get("env")
+		value := strings.TrimSpace(os.Getenv(key))
+		if key == "" || value == "" {
+			return
+		}
+		err := setFromEnv(field, value, tag.Get("unit"))
+		if err != nil {
+			problems = append(problems, fmt.Sprintf("%s (%s): %s", key, path, err.Error()))
+		}
+	})
+	if len(problems) > 0 {
+		return nil, &Error{Problems: problems}
+	}
+	config.Tracing.Exporter = strings.ToLower(config.Tracing.Exporter)
+	if config.Tracing.Exporter == "console" {
+		config.Tracing.Exporter = "stdout"
+	}
+	return &config, nil
+}
+
+// walk calls visit with every leaf field of a struct along with its dotted
+// YAML path.
+func walk(value reflect.Value, prefix string, visit func(field reflect.Value, tag reflect.StructTag, path string)) {
+	for i := 0; i < value.NumField(); i++ {
+		field := value.Type().Field(i)
+		path := strings.Split(field.Tag.Get("yaml"), ",")[0]
+		if prefix != "" {
+			path = prefix + "." + path
+		}
+		if field.Type.Kind() == reflect.Struct {
+			walk(value.Field(i), path, visit)
+			continue
+		}
+		visit(value.Field(i), field.Tag, path)
+	}
+}
+
+func setFromEnv(field reflect.Value, value string, unit string) error {
+	switch {
+	case field.Type() == reflect.TypeOf(time.Duration(0)):
+		duration, err := parseDuration(value, unit)
+		if err != nil {
+			return err
+		}
+		field.SetInt(int64(duration))
+	case field.Kind() == reflect.String:
+		field.SetString(value)
+	case field.Kind() == reflect.Bool:
+		flag, err := strconv.ParseBool(value)
+		if err != nil {
+			return fmt.Errorf("%q is not a boolean", value)
+		}
+		field.SetBool(flag)
+	case field.Kind() == reflect.Int || field.Kind() == reflect.Int64:
+		number, err := strconv.ParseInt(value, 10, 64)
+		if err != nil {
+			return fmt.Errorf("%q is not an integer", value)
+		}
+		field.SetInt(number)
+	case field.Kind() == reflect.Uint64:
+		number, err := strconv.ParseUint(value, 10, 64)
+		if err != nil {
+			return fmt.Errorf("%q is not a non-negative integer", value)
+		}
+		field.SetUint(number)
+	case field.Kind() == reflect.Slice && field.Type().Elem().Kind() == reflect.String:
+		var values []string
+		for _, item := range strings.Split(value, ",") {
+			if item = strings.TrimSpace(item); item != "" {
+				values = append(values, item)
+			}
+		}
+		field.Set(reflect.ValueOf(values))
+	default:
+		return fmt.Errorf("unsupported field type %s", field.Type())
+	}
+	return nil
+}
+
+var units = map[string]time.Duration{"": time.Second, "s": time.Second, "h": time.Hour}
+
+func parseDuration(value string, unit string) (time.Duration, error) {
+	if number, err := strconv.ParseInt(value, 10, 64); err == nil {
+		return time.Duration(number) * units[unit], nil
+	}
+	duration, err := time.ParseDuration(value)
+	if err != nil {
+		return 0, fmt.Errorf("%q is not a duration", value)
+	}
+	return duration, nil
+}
+
+// Redacted returns a copy of the configuration with the secret fields masked.
+// Passwords are cut out of URLs so that the host stays visible.
+func (c Config) Redacted() Config {
+	walk(reflect.ValueOf(&c).Elem(), "", func(field reflect.Value, tag reflect.StructTag, path string) {
+		if tag.Get("secret") != "true" || field.String() == "" {
+			return
+		}
+		if u, err := url.Parse(field.String()); err == nil && u.Host != "" {
+			field.SetString(u.Redacted())
+			return
+		}
+		field.SetString(redacted)
+	})
+	return c
+}
+
+// Summary returns the redacted configuration in the shape Print writes it,
+// for reports encoded in other formats.
+func (c Config) Summary() map[string]any {
+	summary := map[string]any{}
+	content, err := yaml.Marshal(c.Redacted())
+	if err == nil {
+		err = yaml.Unmarshal(content, &summary)
+	}
+	if err != nil {
+		return map[string]any{"error": err.Error()}
+	}
+	return summary
+}
+
+// Print writes the configuration as YAML with its secrets redacted.
+func (c Config) Print(w io.Writer) error {
+	encoder := yaml.NewEncoder(w)
+	encoder.SetIndent(2)
+	err := encoder.Encode(c.Redacted())
+	if err != nil {
+		return err
+	}
+	return encoder.Close()
+}
@@ -0,0 +1,172 @@
+package config
+
+import (
+	"fmt"
+	"net/url"
+	"os"
+	"pulse/tracing"
+	"slices"
+	"strings"
+)
+
+const BackendFilesystem = "filesystem"
+
+// Backends are the storage backends the service can run with.
+var Backends = []string{BackendFilesystem}
+
+var tlsVersions = []string{"1.2", "1.3"}
+
+var exporters = []string{tracing.ExporterNone, tracing.ExporterOTLP, tracing.ExporterStdout, tracing.ExporterMemory}
+
+// Error lists every problem found in a configuration at once.
+type Error struct {
+	Problems []string
+}
+
+func (e *Error) Error() string {
+	return "invalid configuration:\n  - " + strings.Join(e.Problems, "\n  - ")
+}
+
+// Validate checks the configuration as a whole and returns an *Error naming
+// every invalid field by its YAML path.
+func (c *Config) Validate() error {
+	var problems []string
+	fail := func(path string, format string, args ...any) {
+		problems = append(problems, path+" "+fmt.Sprintf(format, args...))
+	}
+
+	if c.Server.Port < 1 || c.Server.Port > 65535 {
+		fail("server.port", "must be between 1 and 65535, got %d", c.Server.Port)
+	}
+	if c.Server.ReadHeaderTimeout < 0 {
+		fail("server.readHeaderTimeout", "must not be negative")
+	}
+	if c.Server.IdleTimeout < 0 {
+		fail("server.idleTimeout", "must not be negative")
+	}
+	if c.Server.ShutdownDelay < 0 {
+		fail("server.shutdownDelay", "must not be negative")
+	}
+	if c.Server.ShutdownTimeout <= 0 {
+		fail("server.shutdownTimeout", "must be positive")
+	}
+	if tls := c.Server.TLS; tls.Enabled() {
+		if tls.CertFile == "" || tls.KeyFile == "" {
+			fail("server.tls", "needs both certFile and keyFile")
+		}
+		checkFile(fail, "server.tls.certFile", tls.CertFile)
+		checkFile(fail, "server.tls.keyFile", tls.KeyFile)
+		if !slices.Contains(tlsVersions, tls.MinVersion) {
+			fail("server.tls.minVersion", "must be one of %s, got %q", strings.Join(tlsVersions, ", "), tls.MinVersion)
+		}
+	}
+
+	if c.Mongo.URI == "" {
+		fail("mongo.uri", "is required (MONGODB_URI)")
+	} else if !strings.HasPrefix(c.Mongo.URI, "mongodb://") && !strings.HasPrefix(c.Mongo.URI, "mongodb+srv://") {
+		fail("mongo.uri", "must start with mongodb:// or mongodb+srv://")
+	}
+	if c.Mongo.Database == "" {
+		fail("mongo.database", "is required")
+	}
+	if c.Mongo.ConnectTimeout <= 0 {
+		fail("mongo.connectTimeout", "must be positive")
+	}
+	if c.Mongo.MigrationTimeout <= 0 {
+		fail("mongo.migrationTimeout", "must be positive")
+	}
+
+	if !slices.Contains(Backends, c.Storage.Backend) {
+		fail("storage.backend", "must be one of %s, got %q", strings.Join(Backends, ", "), c.Storage.Backend)
+	}
+
+	if c.Limits.MaxMultipartMemory <= 0 {
+		fail("limits.maxMultipartMemory", "must be positive")
+	}
+	if c.Limits.MaxJSONBodySize <= 0 {
+		fail("limits.maxJsonBodySize", "must be positive")
+	}
+
+	for _, limit := range []struct {
+		path  string
+		value int64
+	}{
+		{"upload.maxFileSize", c.Upload.MaxFileSize},
+		{"upload.maxRequestSize", c.Upload.MaxRequestSize},
+		{"upload.maxFilesPerBot", c.Upload.MaxFilesPerBot},
+		{"quota.projectMaxBytes", c.Quota.ProjectMaxBytes},
+		{"quota.projectMaxFiles", c.Quota.ProjectMaxFiles},
+		{"quota.projectSoftMaxBytes", c.Quota.ProjectSoftMaxBytes},
+		{"quota.projectSoftMaxFiles", c.Quota.ProjectSoftMaxFiles},
+		{"quota.botMaxBytes", c.Quota.BotMaxBytes},
+		{"quota.botMaxFiles", c.Quota.BotMaxFiles},
+		{"quota.botSoftMaxBytes", c.Quota.BotSoftMaxBytes},
+		{"quota.botSoftMaxFiles", c.Quota.BotSoftMaxFiles},
+	} {
+		if limit.value < 0 {
+			fail(limit.path, "must not be negative, use 0 for no limit")
+		}
+	}
+	checkSoftLimit(fail, "quota.projectSoftMaxBytes", c.Quota.ProjectSoftMaxBytes, c.Quota.ProjectMaxBytes)
+	checkSoftLimit(fail, "quota.projectSoftMaxFiles", c.Quota.ProjectSoftMaxFiles, c.Quota.ProjectMaxFiles)
+	checkSoftLimit(fail, "quota.botSoftMaxBytes", c.Quota.BotSoftMaxBytes, c.Quota.BotMaxBytes)
+	checkSoftLimit(fail, "quota.botSoftMaxFiles", c.Quota.BotSoftMaxFiles, c.Quota.BotMaxFiles)
+	if c.Upload.MaxFileSize > 0 && c.Upload.MaxRequestSize > 0 && c.Upload.MaxFileSize > c.Upload.MaxRequestSize {
+		fail("upload.maxFileSize", "must not exceed upload.maxRequestSize")
+	}
+
+	if c.Archive.MaxEntries <= 0 {
+		fail("archive.maxEntries", "must be positive")
+	}
+	if c.Archive.MaxTotalSize <= 0 {
+		fail("archive.maxTotalSize", "must be positive")
+	}
+	if c.Archive.MaxRatio <= 0 {
+		fail("archive.maxRatio", "must be positive")
+	}
+
+	if c.Scanner.ClamdAddress != "" {
+		u, err := url.Parse(c.Scanner.ClamdAddress)
+		if err != nil || (u.Scheme != "tcp" && u.Scheme != "unix") {
+			fail("scanner.clamdAddress", "must be tcp://host:port or unix:///path/to/clamd.sock")
+		}
+	}
+	if c.Scanner.Timeout <= 0 {
+		fail("scanner.timeout", "must be positive")
+	}
+
+	if c.Encryption.KeyFile != "" {
+		checkFile(fail, "encryption.keyFile", c.Encryption.KeyFile)
+	}
+
+	if c.Idempotency.TTL <= 0 {
+		fail("idempotency.ttl", "must be positive")
+	}
+
+	if !slices.Contains(exporters, c.Tracing.Exporter) {
+		fail("tracing.exporter", "must be one of %s, got %q", strings.Join(exporters, ", "), c.Tracing.Exporter)
+	}
+
+	if len(problems) > 0 {
+		return &Error{Problems: problems}
+	}
+	return nil
+}
+
+func checkFile(fail func(path string, format string, args ...any), path string, file string) {
+	if file == "" {
+		return
+	}
+	info, err := os.Stat(file)
+	if err != nil {
+		fail(path, "cannot be read: %s", err.Error())
+	} else if info.IsDir() {
+		fail(path, "is a directory")
+	}
+}
+
+func checkSoftLimit(fail func(path string, format string, args ...any), path string, soft int64, hard int64) {
+	if soft > 0 && hard > 0 && soft > hard {
+		fail(path, "must not exceed the hard limit")
+	}
+}
@@ -1,75 +1,108 @@
 package controllers
 
 import (
-	"fmt"
 	"github.com/draco121/horizon/models"
+	"github.com/draco121/horizon/utils"
 	"github.com/gin-gonic/gin"
 	"go.mongodb.org/mongo-driver/bson/primitive"
+	"io"
 	"net/http"
-	"os"
+	"pulse/api"
+	"pulse/archive"
 	"pulse/core"
+	"pulse/naming"
+	"pulse/repository"
+	"strconv"
+	"strings"
 )
 
+// Limits bound how much of a request body is held in memory.
+type Limits struct {
+	// MaxMultipartMemory is the part of an upload kept in memory, the rest
+	// spills to disk.
+	MaxMultipartMemory int64
+	// MaxJSONBodySize bounds the JSON bodies that are read whole.
+	MaxJSONBodySize int64
+}
+
 type Controllers struct {
-	service core.ITrainingService
+	service     core.ITrainingService
+	usage       core.IUsageService
+	policy      core.IPolicyService
+	keys        core.IKeyService
+	idempotency core.IIdempotencyService
+	health      core.IHealthService
+	limits      Limits
 }
 
-func NewControllers(service core.ITrainingService) Controllers {
+func NewControllers(service core.ITrainingService, usage core.IUsageService, policy core.IPolicyService, keys core.IKeyService, idempotency core.IIdempotencyService, health core.IHealthService, limits Limits) Controllers {
 	c := Controllers{
-		service: service,
+		service:     service,
+		usage:       usage,
+		policy:      policy,
+		keys:        keys,
+		idempotency: idempotency,
+		health:      health,
+		limits:      limits,
 	}
 	return c
 }
 
 func (s Controllers) UploadTrainingData(c *gin.Context) {
-	projectId := c.Param("projectId")
-	if projectId == "" {
-		c.JSON(http.StatusBadRequest, fmt.Errorf("projectId is required"))
+	projectId, err := objectIdParam(c, "projectId")
+	if err != nil {
+		respondError(c, err)
 		return
 	}
-	botId := c.Param("botId")
-	if botId == "" {
-		c.JSON(http.StatusBadRequest, fmt.Errorf("botId is required"))
+	botId, err := objectIdParam(c, "botId")
+	if err != nil {
+		respondError(c, err)
 		return
 	}
-	err := c.Request.ParseMultipartForm(30 << 20) // 10 MB limit
+	err = s.parseMultipart(c)
 	if err != nil {
-		c.JSON(http.StatusBadRequest, err)
+		respondError(c, core.Validation("invalid multipart form: "+err.Error()))
 		return
 	}
 	files := c.Request.MultipartForm.File["files"]
-	err = s.service.UploadTrainingFiles(c, projectId, botId, files)
+	if len(files) == 0 {
+		respondError(c, core.InvalidField("files", "at least one file is required"))
+		return
+	}
+	extract, err := strconv.ParseBool(c.DefaultQuery("extract", "false"))
 	if err != nil {
-		c.JSON(http.StatusInternalServerError, err)
+		respondError(c, core.InvalidField("extract", "must be true or false"))
+		return
+	}
+	result, err := s.service.UploadTrainingFiles(c, botId.Hex(), projectId.Hex(), files, core.UploadOptions{ExtractArchives: extract, Folder: c.Query("folder")})
+	if err != nil {
+		respondError(c, err)
+	} else if len(result.Files) == 0 && len(result.Rejected) > 0 {
+		c.JSON(http.StatusUnprocessableEntity, result)
 	} else {
-		c.Status(http.StatusCreated)
+		c.JSON(http.StatusCreated, result)
 	}
 }
 
 func (s Controllers) DeleteFile(c *gin.Context) {
-	projectId := c.Param("projectId")
-	if projectId == "" {
-		c.JSON(http.StatusBadRequest, fmt.Errorf("projectId is required"))
-		return
-	}
-	botId := c.Param("botId")
-	if botId == "" {
-		c.JSON(http.StatusBadRequest, fmt.Errorf("botId is required"))
+	projectId, err := objectIdParam(c, "projectId")
+	if err != nil {
+		respondError(c, err)
 		return
 	}
-	fId := c.Param("fileId")
-	if fId == "" {
-		c.JSON(http.StatusBadRequest, fmt.Errorf("fileId is required"))
+	botId, err := objectIdParam(c, "botId")
+	if err != nil {
+		respondError(c, err)
 		return
 	}
-	fileId, err := primitive.ObjectIDFromHex(fId)
+	fileId, err := objectIdParam(c, "fileId")
 	if err != nil {
-		c.JSON(http.StatusBadRequest, err)
+		respondError(c, err)
 		return
 	}
-	err = s.service.DeleteFile(c, botId, projectId, fileId)
+	err = s.service.DeleteFile(c, botId.Hex(), projectId.Hex(), fileId)
 	if err != nil {
-		c.JSON(http.StatusInternalServerError, err)
+		respondError(c, err)
 		return
 	} else {
 		c.Status(http.StatusNoContent)
@@ -78,131 +111,469 @@ func (s Controllers) DeleteFile(c *gin.Context) {
 }
 
 func (s Controllers) GetFile(c *gin.Context) {
-	projectId := c.Param("projectId")
-	if projectId == "" {
-		c.JSON(http.StatusBadRequest, fmt.Errorf("projectId is required"))
+	projectId, err := objectIdParam(c, "projectId")
+	if err != nil {
+		respondError(c, err)
+		return
+	}
+	botId, err := objectIdParam(c, "botId")
+	if err != nil {
+		respondError(c, err)
+		return
+	}
+	fileId, err := objectIdParam(c, "fileId")
+	if err != nil {
+		respondError(c, err)
+		return
+	}
+	file, err := s.service.GetFile(c, botId.Hex(), projectId.Hex(), fileId)
+	if err != nil {
+		respondError(c, err)
+		return
+	}
+	serveFile(c, file)
+}
+
+// serveFile writes an opened file and closes it. ServeContent answers Range,
+// If-Range and the other conditional headers against the headers set here, on
+// any seekable content whatever it is stored in.
+func serveFile(c *gin.Context, file *core.FileContent) {
+	defer file.Content.Close()
+	contentType := file.MimeType
+	if contentType == "" {
+		contentType = "application/octet-stream"
+	}
+	c.Header("ETag", checksumETag(file.Checksum))
+	c.Header("Content-Type", contentType)
+	c.Header("Content-Disposition", naming.ContentDisposition(file.FileName))
+	c.Header("Cache-Control", "private, no-cache")
+	http.ServeContent(c.Writer, c.Request, "", file.ModTime, file.Content)
+}
+
+func (s Controllers) ReplaceFile(c *gin.Context) {
+	projectId, err := objectIdParam(c, "projectId")
+	if err != nil {
+		respondError(c, err)
+		return
+	}
+	botId, err := objectIdParam(c, "botId")
+	if err != nil {
+		respondError(c, err)
+		return
+	}
+	fileId, err := objectIdParam(c, "fileId")
+	if err != nil {
+		respondError(c, err)
+		return
+	}
+	checksum, err := ifMatchChecksum(c)
+	if err != nil {
+		respondError(c, err)
+		return
+	}
+	err = s.parseMultipart(c)
+	if err != nil {
+		respondError(c, core.Validation("invalid multipart form: "+err.Error()))
+		return
+	}
+	files := c.Request.MultipartForm.File["file"]
+	if len(files) != 1 {
+		respondError(c, core.InvalidField("file", "exactly one file is required"))
+		return
+	}
+	metadata, err := s.service.ReplaceFile(c, botId, projectId, fileId, files[0], checksum)
+	if err != nil {
+		respondError(c, err)
+		return
+	}
+	c.Header("ETag", checksumETag(metadata.Checksum))
+	c.JSON(http.StatusOK, metadata)
+}
+
+func (s Controllers) ListRevisions(c *gin.Context) {
+	projectId, err := objectIdParam(c, "projectId")
+	if err != nil {
+		respondError(c, err)
+		return
+	}
+	botId, err := objectIdParam(c, "botId")
+	if err != nil {
+		respondError(c, err)
+		return
+	}
+	fileId, err := objectIdParam(c, "fileId")
+	if err != nil {
+		respondError(c, err)
+		return
+	}
+	revisions, err := s.service.ListRevisions(c, botId, projectId, fileId)
+	if err != nil {
+		respondError(c, err)
+		return
+	}
+	c.JSON(http.StatusOK, revisions)
+}
+
+func (s Controllers) GetRevision(c *gin.Context) {
+	projectId, err := objectIdParam(c, "projectId")
+	if err != nil {
+		respondError(c, err)
+		return
+	}
+	botId, err := objectIdParam(c, "botId")
+	if err != nil {
+		respondError(c, err)
+		return
+	}
+	fileId, err := objectIdParam(c, "fileId")
+	if err != nil {
+		respondError(c, err)
+		return
+	}
+	revision, err := strconv.ParseInt(c.Param("revision"), 10, 64)
+	if err != nil || revision < 1 {
+		respondError(c, core.InvalidField("revision", "must be a positive number"))
+		return
+	}
+	file, err := s.service.GetRevision(c, botId, projectId, fileId, revision)
+	if err != nil {
+		respondError(c, err)
+		return
+	}
+	serveFile(c, file)
+}
+
+func (s Controllers) DownloadArchive(c *gin.Context) {
+	projectId, err := objectIdParam(c, "projectId")
+	if err != nil {
+		respondError(c, err)
+		return
+	}
+	botId, err := objectIdParam(c, "botId")
+	if err != nil {
+		respondError(c, err)
+		return
+	}
+	format := c.DefaultQuery("format", archive.FormatZip)
+	if format != archive.FormatZip && format != archive.FormatTarGz {
+		respondError(c, core.InvalidField("format", "must be zip or tar.gz"))
+		return
+	}
+	fileIds := make([]primitive.ObjectID, 0)
+	for _, value := range c.QueryArray("fileId") {
+		fileId, err := parseObjectId("fileId", value)
+		if err != nil {
+			respondError(c, err)
+			return
+		}
+		fileIds = append(fileIds, fileId)
+	}
+	entries, err := s.service.ArchiveFiles(c, botId.Hex(), projectId.Hex(), fileIds)
+	if err != nil {
+		respondError(c, err)
+		return
+	}
+	c.Header("Content-Type", archive.ContentType(format))
+	c.Header("Content-Disposition", naming.ContentDisposition(botId.Hex()+"."+format))
+	c.Header("Cache-Control", "no-store")
+	c.Status(http.StatusOK)
+	err = archive.Write(c.Writer, format, entries)
+	if err != nil {
+		// the status is sent already, the unfinished archive tells the client
+		utils.Logger.Error("request ", c.GetString("RequestId"), " archive download failed: ", err.Error())
+	}
+}
+
+func (s Controllers) ListFiles(c *gin.Context) {
+	projectId, err := objectIdParam(c, "projectId")
+	if err != nil {
+		respondError(c, err)
+		return
+	}
+	botId, err := objectIdParam(c, "botId")
+	if err != nil {
+		respondError(c, err)
 		return
 	}
-	botId := c.Param("botId")
-	if botId == "" {
-		c.JSON(http.StatusBadRequest, fmt.Errorf("botId is required"))
+	folder, inFolder := c.GetQuery("folder")
+	// without a folder the whole tree is listed, within one only its files
+	// unless recursive is set
+	recursive, err := strconv.ParseBool(c.DefaultQuery("recursive", strconv.FormatBool(!inFolder)))
+	if err != nil {
+		respondError(c, core.InvalidField("recursive", "must be true or false"))
+		return
+	}
+	filter := repository.FileFilter{Folder: folder, Recursive: recursive, Labels: make(map[string]string)}
+	for _, tags := range c.QueryArray("tag") {
+		filter.Tags = append(filter.Tags, strings.Split(tags, ",")...)
+	}
+	for _, label := range c.QueryArray("label") {
+		key, value, ok := strings.Cut(label, ":")
+		if !ok {
+			respondError(c, core.InvalidField("label", "must be key:value"))
+			return
+		}
+		filter.Labels[key] = value
+	}
+	files, err := s.service.ListFiles(c, botId, projectId, filter)
+	if err != nil {
+		respondError(c, err)
+		return
+	}
+	c.JSON(http.StatusOK, files)
+}
+
+type MoveFilesRequest = api.MoveFilesRequest
+
+func (s Controllers) MoveFiles(c *gin.Context) {
+	projectId, err := objectIdParam(c, "projectId")
+	if err != nil {
+		respondError(c, err)
+		return
+	}
+	botId, err := objectIdParam(c, "botId")
+	if err != nil {
+		respondError(c, err)
+		return
+	}
+	var request MoveFilesRequest
+	if err := c.ShouldBindJSON(&request); err != nil {
+		respondError(c, core.Validation("invalid move request: "+err.Error()))
+		return
+	}
+	files, err := s.service.MoveFiles(c, botId, projectId, request.FileIds, request.Folder)
+	if err != nil {
+		respondError(c, err)
+		return
+	}
+	c.JSON(http.StatusOK, files)
+}
+
+type TagFilesRequest = api.TagFilesRequest
+
+func (s Controllers) TagFiles(c *gin.Context) {
+	projectId, err := objectIdParam(c, "projectId")
+	if err != nil {
+		respondError(c, err)
+		return
+	}
+	botId, err := objectIdParam(c, "botId")
+	if err != nil {
+		respondError(c, err)
 		return
 	}
-	fId := c.Param("fileId")
-	if fId == "" {
-		c.JSON(http.StatusBadRequest, fmt.Errorf("fileId is required"))
+	var request TagFilesRequest
+	if err := c.ShouldBindJSON(&request); err != nil {
+		respondError(c, core.Validation("invalid tag request: "+err.Error()))
 		return
 	}
-	fileId, err := primitive.ObjectIDFromHex(fId)
+	files, err := s.service.TagFiles(c, botId, projectId, request.FileIds, request.TagChange)
 	if err != nil {
-		c.JSON(http.StatusBadRequest, err)
+		respondError(c, err)
 		return
 	}
-	fileName, filePath, err := s.service.GetFile(c, botId, projectId, fileId)
+	c.JSON(http.StatusOK, files)
+}
+
+type BatchRequest = api.BatchRequest
+
+type BatchItemResult = api.BatchItemResult
+
+type BatchResponse = api.BatchResponse
+
+func (s Controllers) RunBatch(c *gin.Context) {
+	projectId, err := objectIdParam(c, "projectId")
+	if err != nil {
+		respondError(c, err)
+		return
+	}
+	botId, err := objectIdParam(c, "botId")
 	if err != nil {
-		c.JSON(http.StatusInternalServerError, err)
+		respondError(c, err)
+		return
+	}
+	var request BatchRequest
+	c.Request.Body = http.MaxBytesReader(c.Writer, c.Request.Body, s.limits.MaxJSONBodySize)
+	if err := c.ShouldBindJSON(&request); err != nil {
+		respondError(c, core.Validation("invalid batch request: "+err.Error()))
 		return
 	}
-	_, err = os.Stat(filePath)
-	if os.IsNotExist(err) {
-		c.JSON(http.StatusNotFound, err)
+	items, err := s.service.RunBatch(c, botId, projectId, request.Operations, request.Atomic)
+	if err != nil {
+		respondError(c, err)
 		return
 	}
-	c.Header("Content-Description", "File Transfer")
-	c.Header("Content-Disposition", "attachment; filename="+fileName)
-	c.Header("Content-Type", "application/octet-stream")
-	c.Header("Content-Transfer-Encoding", "binary")
-	c.Header("Expires", "0")
-	c.Header("Cache-Control", "must-revalidate")
-	c.Header("Pragma", "public")
-	c.File(filePath)
+	response := BatchResponse{Atomic: request.Atomic, Results: make([]BatchItemResult, 0, len(items))}
+	for _, item := range items {
+		result := BatchItemResult{Operation: item.Operation, Op: item.Op, FileId: item.FileId, Status: http.StatusOK}
+		if item.Err != nil {
+			problem := problemFor(c, item.Err)
+			result.Status, result.Error = problem.Status, &problem
+			response.Failed++
+		} else {
+			response.Applied++
+		}
+		response.Results = append(response.Results, result)
+	}
+	// 207 tells clients to look at the results of a partially applied batch
+	switch {
+	case response.Failed == 0:
+		c.JSON(http.StatusOK, response)
+	case response.Applied == 0:
+		c.JSON(http.StatusUnprocessableEntity, response)
+	default:
+		c.JSON(http.StatusMultiStatus, response)
+	}
 }
 
 func (s Controllers) AddTrainingData(c *gin.Context) {
 	var trainingData *models.TrainingData
 	if err := c.ShouldBind(&trainingData); err != nil {
-		c.JSON(400, err.Error())
+		respondError(c, core.Validation("invalid training data: "+err.Error()))
 	} else {
 		res, err := s.service.AddTrainingData(c, trainingData)
 		if err != nil {
-			c.JSON(http.StatusInternalServerError, err)
+			respondError(c, err)
 		} else {
+			c.Header("ETag", versionETag(res.Version))
 			c.JSON(http.StatusCreated, res)
 		}
 	}
 }
 
 func (s Controllers) GetTrainingData(c *gin.Context) {
-	bId := c.Query("botId")
-	if bId == "" {
-		c.JSON(http.StatusBadRequest, fmt.Errorf("botId is required"))
+	botId, err := objectIdQuery(c, "botId")
+	if err != nil {
+		respondError(c, err)
+		return
+	}
+	projectId, err := objectIdQuery(c, "projectId")
+	if err != nil {
+		respondError(c, err)
+		return
+	}
+	trainingData, err := s.service.GetTrainingData(c, botId, projectId)
+	if err != nil {
+		respondError(c, err)
+		return
+	}
+	etag := versionETag(trainingData.Version)
+	if notModified(c, etag) {
 		return
 	}
-	botId, err := primitive.ObjectIDFromHex(bId)
+	c.Header("ETag", etag)
+	c.JSON(http.StatusOK, trainingData)
+	return
+}
+
+func (s Controllers) UpdateTrainingData(c *gin.Context) {
+	botId, err := objectIdQuery(c, "botId")
 	if err != nil {
-		c.JSON(http.StatusBadRequest, err)
+		respondError(c, err)
 		return
 	}
-	pId := c.Query("projectId")
-	if pId == "" {
-		c.JSON(http.StatusBadRequest, fmt.Errorf("botId is required"))
+	projectId, err := objectIdQuery(c, "projectId")
+	if err != nil {
+		respondError(c, err)
 		return
 	}
-	projectId, err := primitive.ObjectIDFromHex(pId)
+	version, err := ifMatchVersion(c)
 	if err != nil {
-		c.JSON(http.StatusBadRequest, err)
+		respondError(c, err)
 		return
 	}
-	trainingData, err := s.service.GetTrainingData(c, botId, projectId)
+	body, err := io.ReadAll(http.MaxBytesReader(c.Writer, c.Request.Body, s.limits.MaxJSONBodySize))
+	if err != nil {
+		respondError(c, err)
+		return
+	}
+	res, err := s.service.PatchTrainingData(c, botId, projectId, version, c.ContentType(), body)
+	if err != nil {
+		respondError(c, err)
+		return
+	}
+	c.Header("ETag", versionETag(res.Version))
+	c.JSON(http.StatusOK, res)
+}
+
+func (s Controllers) DeleteTrainingData(c *gin.Context) {
+	botId, err := objectIdQuery(c, "botId")
+	if err != nil {
+		respondError(c, err)
+		return
+	}
+	projectId, err := objectIdQuery(c, "projectId")
+	if err != nil {
+		respondError(c, err)
+		return
+	}
+	version, err := ifMatchVersion(c)
+	if err != nil {
+		respondError(c, err)
+		return
+	}
+	trainingData, err := s.service.ResetTrainingData(c, botId, projectId, version)
 	if err != nil {
-		c.JSON(http.StatusInternalServerError, err)
+		respondError(c, err)
 		return
 	}
 	c.JSON(http.StatusOK, trainingData)
 	return
 }
 
-func (s Controllers) UpdateTrainingData(c *gin.Context) {
-	var trainingData *models.TrainingData
-	if err := c.ShouldBind(&trainingData); err != nil {
-		c.JSON(http.StatusBadRequest, err)
-
-	} else {
-		res, err := s.service.UpdateTrainingData(c, trainingData)
-		if err != nil {
-			c.JSON(http.StatusInternalServerError, err)
-		} else {
-			c.JSON(http.StatusOK, res)
-		}
+func (s Controllers) GetUsage(c *gin.Context) {
+	projectId, err := objectIdParam(c, "projectId")
+	if err != nil {
+		respondError(c, err)
+		return
+	}
+	usage, err := s.usage.GetUsage(c, projectId)
+	if err != nil {
+		respondError(c, err)
+		return
 	}
+	c.JSON(http.StatusOK, usage)
 }
 
-func (s Controllers) DeleteTrainingData(c *gin.Context) {
-	bId := c.Query("botId")
-	if bId == "" {
-		c.JSON(http.StatusBadRequest, fmt.Errorf("botId is required"))
+func (s Controllers) GetUploadPolicy(c *gin.Context) {
+	projectId, err := objectIdParam(c, "projectId")
+	if err != nil {
+		respondError(c, err)
 		return
 	}
-	botId, err := primitive.ObjectIDFromHex(bId)
+	policy, err := s.policy.GetPolicy(c, projectId)
 	if err != nil {
-		c.JSON(http.StatusBadRequest, err)
+		respondError(c, err)
 		return
 	}
-	pId := c.Query("projectId")
-	if pId == "" {
-		c.JSON(http.StatusBadRequest, fmt.Errorf("botId is required"))
+	c.JSON(http.StatusOK, policy)
+}
+
+func (s Controllers) SetUploadPolicy(c *gin.Context) {
+	projectId, err := objectIdParam(c, "projectId")
+	if err != nil {
+		respondError(c, err)
 		return
 	}
-	projectId, err := primitive.ObjectIDFromHex(pId)
+	var policy repository.UploadPolicy
+	if err := c.ShouldBindJSON(&policy); err != nil {
+		respondError(c, core.Validation("invalid upload policy: "+err.Error()))
+		return
+	}
+	policy.ProjectId = projectId
+	res, err := s.policy.SetPolicy(c, &policy)
 	if err != nil {
-		c.JSON(http.StatusBadRequest, err)
+		respondError(c, err)
 		return
 	}
-	trainingData, err := s.service.ResetTrainingData(c, botId, projectId)
+	c.JSON(http.StatusOK, res)
+}
+
+func (s Controllers) RotateKeys(c *gin.Context) {
+	rotated, err := s.keys.RotateKeys(c)
 	if err != nil {
-		c.JSON(http.StatusInternalServerError, err)
+		respondError(c, err)
 		return
 	}
-	c.JSON(http.StatusOK, trainingData)
-	return
+	c.JSON(http.StatusOK, gin.H{"rotated": rotated})
 }
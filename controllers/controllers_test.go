@@ -0,0 +1,67 @@
+package controllers
+
+import (
+	"context"
+	"encoding/json"
+	"github.com/gin-gonic/gin"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"net/http"
+	"net/http/httptest"
+	"pulse/core"
+	"pulse/repository"
+	"strings"
+	"testing"
+)
+
+type storedPolicies struct {
+	repository.IPolicyRepository
+	stored []repository.UploadPolicy
+}
+
+func (r *storedPolicies) Upsert(ctx context.Context, policy *repository.UploadPolicy) (*repository.UploadPolicy, error) {
+	r.stored = append(r.stored, *policy)
+	return policy, nil
+}
+
+func TestSetUploadPolicy(t *testing.T) {
+	gin.SetMode(gin.TestMode)
+	tests := []struct {
+		name   string
+		body   string
+		status int
+	}{
+		{"policy", `{"maxFileSize": 1024, "allowedMimeTypes": ["text/plain"]}`, http.StatusOK},
+		{"null", `null`, http.StatusOK},
+		{"not JSON", `{`, http.StatusBadRequest},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			repo := &storedPolicies{}
+			s := Controllers{policy: core.NewPolicyService(repo, repository.UploadPolicy{})}
+			router := gin.New()
+			router.PUT("/projects/:projectId/policy", s.SetUploadPolicy)
+			projectId := primitive.NewObjectID()
+			request := httptest.NewRequest(http.MethodPut, "/projects/"+projectId.Hex()+"/policy", strings.NewReader(test.body))
+			request.Header.Set("Content-Type", "application/json")
+			response := httptest.NewRecorder()
+			router.ServeHTTP(response, request)
+			if response.Code != test.status {
+				t.Fatalf("status = %d, want %d: %s", response.Code, test.status, response.Body)
+			}
+			if test.status != http.StatusOK {
+				if len(repo.stored) != 0 {
+					t.Fatal("an invalid policy was stored")
+				}
+				return
+			}
+			var policy repository.UploadPolicy
+			err := json.NewDecoder(response.Body).Decode(&policy)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if len(repo.stored) != 1 || repo.stored[0].ProjectId != projectId || policy.ProjectId != projectId {
+				t.Fatalf("stored %+v, answered %+v, want the policy of project %s", repo.stored, policy, projectId.Hex())
+			}
+		})
+	}
+}
@@ -0,0 +1,93 @@
+// Package controllertest serves the routes of the service over the memory
+// repositories, so that the controllers and their clients can be tested
+// without a database, a disk or an authorization service:
+//
+//	server := controllertest.NewServer(t, controllertest.Options{})
+//	request.Header.Set("Authorization", server.Token)
+package controllertest
+
+import (
+	"encoding/json"
+	"github.com/draco121/horizon/constants"
+	"github.com/draco121/horizon/models"
+	"github.com/gin-gonic/gin"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"net/http"
+	"net/http/httptest"
+	"pulse/archive"
+	"pulse/controllers"
+	"pulse/core"
+	"pulse/encryption"
+	"pulse/repository"
+	"pulse/routes"
+	"pulse/scanner"
+	"testing"
+	"time"
+)
+
+// Options configure the services behind a Server, their zero values are
+// those of a service without limits, encryption or malware scanning.
+type Options struct {
+	Quota   core.QuotaPolicy
+	Upload  repository.UploadPolicy
+	Keys    encryption.IKeyProvider
+	Scanner scanner.IScanner
+	Archive archive.Limits
+	Limits  controllers.Limits
+}
+
+// Server is a running service. Requests carrying Token are authorized as
+// Owner, any other token is rejected.
+type Server struct {
+	*httptest.Server
+	Token string
+	Owner primitive.ObjectID
+	// Repo holds the training data and the stored files
+	Repo repository.ITrainingRepository
+}
+
+// NewServer starts a service and the authorization service its routes ask,
+// both closed with the test. It sets AUTHORIZATION_SERVICE_BASEURL, so tests
+// using it cannot run in parallel.
+func NewServer(t testing.TB, options Options) *Server {
+	t.Helper()
+	gin.SetMode(gin.TestMode)
+	owner := primitive.NewObjectID()
+	token := "Bearer " + primitive.NewObjectID().Hex()
+	authorizer := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		var input models.AuthorizationInput
+		_ = json.NewDecoder(r.Body).Decode(&input)
+		output := models.AuthorizationOutput{Grant: constants.Rejected}
+		if input.Token == token {
+			output = models.AuthorizationOutput{Grant: constants.Allowed, UserId: owner}
+		}
+		w.Header().Set("Content-Type", "application/json")
+		_ = json.NewEncoder(w).Encode(output)
+	}))
+	t.Cleanup(authorizer.Close)
+	t.Setenv("AUTHORIZATION_SERVICE_BASEURL", authorizer.URL)
+
+	if options.Scanner == nil {
+		options.Scanner = scanner.NewNoopScanner()
+	}
+	if options.Limits.MaxMultipartMemory == 0 {
+		options.Limits.MaxMultipartMemory = 30 << 20
+	}
+	if options.Limits.MaxJSONBodySize == 0 {
+		options.Limits.MaxJSONBodySize = 1 << 20
+	}
+	repo := repository.NewMemoryTrainingRepository()
+	usage := core.NewUsageService(repository.NewMemoryUsageRepository(), options.Quota)
+	policy := core.NewPolicyService(repository.NewMemoryPolicyRepository(), options.Upload)
+	keys := core.NewKeyService(repository.NewMemoryDataKeyRepository(), options.Keys)
+	service := core.NewTrainingService(repository.NewMemoryTransactions(), repo, repository.NewMemoryFileRepository(), repository.NewMemoryRevisionRepository(), usage, policy, options.Scanner, keys, options.Archive)
+	idempotency := core.NewIdempotencyService(repository.NewMemoryIdempotencyRepository(), time.Hour)
+	controller := controllers.NewControllers(service, usage, policy, keys, idempotency, nil, options.Limits)
+	router := gin.New()
+	router.ContextWithFallback = true
+	router.Use(controllers.RequestId())
+	routes.RegisterRoutes(controller, router)
+	server := httptest.NewServer(router)
+	t.Cleanup(server.Close)
+	return &Server{Server: server, Token: token, Owner: owner, Repo: repo}
+}
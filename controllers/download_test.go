@@ -0,0 +1,146 @@
+package controllers_test
+
+import (
+	"bytes"
+	"encoding/base64"
+	"encoding/json"
+	"fmt"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"io"
+	"mime"
+	"mime/multipart"
+	"net/http"
+	"os"
+	"path"
+	"pulse/api"
+	"pulse/controllers/controllertest"
+	"pulse/encryption"
+	"strconv"
+	"strings"
+	"testing"
+)
+
+func localKeys(t *testing.T) encryption.IKeyProvider {
+	t.Helper()
+	keyFile := path.Join(t.TempDir(), "keys.json")
+	content, _ := json.Marshal(map[string]any{
+		"active": "1",
+		"keys":   map[string]string{"1": base64.StdEncoding.EncodeToString(bytes.Repeat([]byte{7}, 32))},
+	})
+	if err := os.WriteFile(keyFile, content, 0600); err != nil {
+		t.Fatal(err)
+	}
+	provider, err := encryption.NewLocalKeyProvider(keyFile)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return provider
+}
+
+// numberedLines is text of more than two encryption chunks, where every range
+// reads differently.
+func numberedLines() string {
+	var content strings.Builder
+	for i := 0; content.Len() < 2*encryption.ChunkSize+encryption.ChunkSize/2; i++ {
+		fmt.Fprintf(&content, "line %06d\n", i)
+	}
+	return content.String()
+}
+
+func TestDownloadRanges(t *testing.T) {
+	content := numberedLines()
+	size := len(content)
+	boundary := encryption.ChunkSize
+	for _, encrypted := range []bool{false, true} {
+		t.Run("encrypted="+strconv.FormatBool(encrypted), func(t *testing.T) {
+			options := controllertest.Options{}
+			if encrypted {
+				options.Keys = localKeys(t)
+			}
+			server := controllertest.NewServer(t, options)
+			projectId, botId := primitive.NewObjectID(), primitive.NewObjectID()
+			var result api.UploadResult
+			decode(t, uploadFiles(t, server, projectId, botId, map[string]string{"lines.txt": content}), http.StatusCreated, &result)
+			var files []api.FileMetadata
+			decode(t, send(t, server, http.MethodGet, "/v1/files/"+projectId.Hex()+"/"+botId.Hex(), nil, nil), http.StatusOK, &files)
+			if len(files) != 1 || files[0].Encrypted != encrypted {
+				t.Fatalf("stored %+v, want encrypted %v", files, encrypted)
+			}
+			filePath := "/v1/download/" + projectId.Hex() + "/" + botId.Hex() + "/" + result.Files[0].FileId.Hex()
+
+			full := send(t, server, http.MethodGet, filePath, nil, nil)
+			body, _ := io.ReadAll(full.Body)
+			_ = full.Body.Close()
+			etag := full.Header.Get("ETag")
+			if full.StatusCode != http.StatusOK || string(body) != content || full.Header.Get("Accept-Ranges") != "bytes" {
+				t.Fatalf("download answered %d with %d bytes, Accept-Ranges %q", full.StatusCode, len(body), full.Header.Get("Accept-Ranges"))
+			}
+
+			ranges := []struct {
+				header string
+				start  int
+				end    int
+			}{
+				{"bytes=0-9", 0, 9},
+				{fmt.Sprintf("bytes=%d-%d", boundary-6, boundary+5), boundary - 6, boundary + 5},
+				{fmt.Sprintf("bytes=%d-%d", boundary, 2*boundary), boundary, 2 * boundary},
+				{"bytes=-10", size - 10, size - 1},
+				{fmt.Sprintf("bytes=%d-", size-5), size - 5, size - 1},
+				{fmt.Sprintf("bytes=%d-%d", size-3, size+100), size - 3, size - 1},
+			}
+			for _, r := range ranges {
+				response := send(t, server, http.MethodGet, filePath, http.Header{"Range": {r.header}}, nil)
+				body, _ := io.ReadAll(response.Body)
+				_ = response.Body.Close()
+				contentRange := fmt.Sprintf("bytes %d-%d/%d", r.start, r.end, size)
+				if response.StatusCode != http.StatusPartialContent || response.Header.Get("Content-Range") != contentRange {
+					t.Fatalf("%s answered %d with Content-Range %q, want 206 with %q", r.header, response.StatusCode, response.Header.Get("Content-Range"), contentRange)
+				}
+				if string(body) != content[r.start:r.end+1] {
+					t.Fatalf("%s answered %q, want %q", r.header, body, content[r.start:r.end+1])
+				}
+			}
+
+			// several ranges are answered as parts of a multipart body
+			multi := send(t, server, http.MethodGet, filePath, http.Header{"Range": {fmt.Sprintf("bytes=0-4,%d-%d", boundary-2, boundary+2)}}, nil)
+			defer multi.Body.Close()
+			mediaType, params, err := mime.ParseMediaType(multi.Header.Get("Content-Type"))
+			if multi.StatusCode != http.StatusPartialContent || err != nil || mediaType != "multipart/byteranges" {
+				t.Fatalf("ranges answered %d with %q", multi.StatusCode, multi.Header.Get("Content-Type"))
+			}
+			reader := multipart.NewReader(multi.Body, params["boundary"])
+			for _, want := range []string{content[:5], content[boundary-2 : boundary+3]} {
+				part, err := reader.NextPart()
+				if err != nil {
+					t.Fatal(err)
+				}
+				got, _ := io.ReadAll(part)
+				if string(got) != want {
+					t.Fatalf("part %q, want %q", got, want)
+				}
+			}
+
+			for _, header := range []string{fmt.Sprintf("bytes=%d-", size), fmt.Sprintf("bytes=%d-%d", size+10, size+20)} {
+				response := send(t, server, http.MethodGet, filePath, http.Header{"Range": {header}}, nil)
+				_ = response.Body.Close()
+				if response.StatusCode != http.StatusRequestedRangeNotSatisfiable || response.Header.Get("Content-Range") != fmt.Sprintf("bytes */%d", size) {
+					t.Fatalf("%s answered %d with Content-Range %q, want 416", header, response.StatusCode, response.Header.Get("Content-Range"))
+				}
+			}
+
+			// If-Range only narrows to the range while the file is unchanged
+			current := send(t, server, http.MethodGet, filePath, http.Header{"Range": {"bytes=10-19"}, "If-Range": {etag}}, nil)
+			body, _ = io.ReadAll(current.Body)
+			_ = current.Body.Close()
+			if current.StatusCode != http.StatusPartialContent || string(body) != content[10:20] {
+				t.Fatalf("If-Range with the ETag answered %d with %q", current.StatusCode, body)
+			}
+			stale := send(t, server, http.MethodGet, filePath, http.Header{"Range": {"bytes=10-19"}, "If-Range": {`"stale"`}}, nil)
+			body, _ = io.ReadAll(stale.Body)
+			_ = stale.Body.Close()
+			if stale.StatusCode != http.StatusOK || string(body) != content {
+				t.Fatalf("If-Range with another ETag answered %d with %d bytes, want the whole file", stale.StatusCode, len(body))
+			}
+		})
+	}
+}
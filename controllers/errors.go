@@ -0,0 +1,102 @@
+package controllers
+
+import (
+	"crypto/rand"
+	"encoding/hex"
+	"errors"
+	"github.com/draco121/horizon/utils"
+	"github.com/gin-gonic/gin"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"net/http"
+	"pulse/api"
+	"pulse/core"
+)
+
+const RequestIdHeader = api.RequestIdHeader
+
+type Problem = api.Problem
+
+var statusByKind = map[core.ErrorKind]int{
+	core.KindNotFound:             http.StatusNotFound,
+	core.KindConflict:             http.StatusConflict,
+	core.KindValidation:           http.StatusBadRequest,
+	core.KindForbidden:            http.StatusForbidden,
+	core.KindQuota:                http.StatusInsufficientStorage,
+	core.KindTooLarge:             http.StatusRequestEntityTooLarge,
+	core.KindUnsupportedMediaType: http.StatusUnsupportedMediaType,
+	core.KindUnprocessable:        http.StatusUnprocessableEntity,
+	core.KindPrecondition:         http.StatusPreconditionFailed,
+	core.KindPreconditionRequired: http.StatusPreconditionRequired,
+	core.KindFailedDependency:     http.StatusFailedDependency,
+	core.KindInternal:             http.StatusInternalServerError,
+}
+
+// RequestId tags every request with an id, reusing the one sent by the
+// client or a proxy when present, and echoes it in the response.
+func RequestId() gin.HandlerFunc {
+	return func(c *gin.Context) {
+		requestId := c.GetHeader(RequestIdHeader)
+		if requestId == "" || len(requestId) > 128 {
+			buf := make([]byte, 16)
+			_, _ = rand.Read(buf)
+			requestId = hex.EncodeToString(buf)
+		}
+		c.Set("RequestId", requestId)
+		c.Header(RequestIdHeader, requestId)
+		c.Next()
+	}
+}
+
+// respondError writes err as an application/problem+json response and aborts
+// the request.
+func respondError(c *gin.Context, err error) {
+	problem := problemFor(c, err)
+	c.Header("Content-Type", "application/problem+json")
+	c.AbortWithStatusJSON(problem.Status, problem)
+}
+
+// problemFor describes err as an RFC 7807 problem, logging server errors.
+func problemFor(c *gin.Context, err error) Problem {
+	typed := core.AsError(err)
+	var maxBytes *http.MaxBytesError
+	if errors.As(err, &maxBytes) {
+		typed = &core.Error{Kind: core.KindTooLarge, Message: "request body too large"}
+	}
+	status, ok := statusByKind[typed.Kind]
+	if !ok {
+		status = http.StatusInternalServerError
+	}
+	if status >= http.StatusInternalServerError {
+		utils.Logger.Error("request ", c.GetString("RequestId"), " failed: ", err.Error())
+	}
+	return Problem{
+		Type:      "urn:pulse:problem:" + string(typed.Kind),
+		Title:     http.StatusText(status),
+		Status:    status,
+		Detail:    typed.Message,
+		Code:      typed.Kind,
+		Errors:    typed.Fields,
+		RequestId: c.GetString("RequestId"),
+	}
+}
+
+// objectIdParam reads a required ObjectID from the path.
+func objectIdParam(c *gin.Context, name string) (primitive.ObjectID, error) {
+	return parseObjectId(name, c.Param(name))
+}
+
+// objectIdQuery reads a required ObjectID from the query string.
+func objectIdQuery(c *gin.Context, name string) (primitive.ObjectID, error) {
+	return parseObjectId(name, c.Query(name))
+}
+
+func parseObjectId(name string, value string) (primitive.ObjectID, error) {
+	if value == "" {
+		return primitive.NilObjectID, core.InvalidField(name, "is required")
+	}
+	id, err := primitive.ObjectIDFromHex(value)
+	if err != nil {
+		return primitive.NilObjectID, core.InvalidField(name, "must be a valid id")
+	}
+	return id, nil
+}
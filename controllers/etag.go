@@ -0,0 +1,85 @@
+package controllers
+
+import (
+	"github.com/gin-gonic/gin"
+	"net/http"
+	"pulse/core"
+	"pulse/repository"
+	"strconv"
+	"strings"
+)
+
+// versionETag is the strong entity tag of a versioned document.
+func versionETag(version int64) string {
+	return `"` + strconv.FormatInt(version, 10) + `"`
+}
+
+// checksumETag is the strong entity tag of a stored file.
+func checksumETag(checksum string) string {
+	return `"` + checksum + `"`
+}
+
+// etagList splits an If-Match or If-None-Match header into its entity tags.
+func etagList(header string) []string {
+	tags := make([]string, 0)
+	for _, tag := range strings.Split(header, ",") {
+		tag = strings.TrimSpace(tag)
+		if tag != "" {
+			tags = append(tags, tag)
+		}
+	}
+	return tags
+}
+
+// ifMatchVersion reads the version a write is conditioned on from If-Match.
+// The header is required; "*" matches any version.
+func ifMatchVersion(c *gin.Context) (int64, error) {
+	header := c.GetHeader("If-Match")
+	if header == "" {
+		return 0, &core.Error{Kind: core.KindPreconditionRequired, Message: "If-Match header is required, send the ETag of the last read"}
+	}
+	tags := etagList(header)
+	if len(tags) == 1 && tags[0] == "*" {
+		return repository.AnyVersion, nil
+	}
+	if len(tags) != 1 || !strings.HasPrefix(tags[0], `"`) || !strings.HasSuffix(tags[0], `"`) {
+		// weak tags never match for If-Match
+		return 0, &core.Error{Kind: core.KindPrecondition, Message: "If-Match must hold a single strong ETag"}
+	}
+	version, err := strconv.ParseInt(strings.Trim(tags[0], `"`), 10, 64)
+	if err != nil || version < 0 {
+		return 0, &core.Error{Kind: core.KindPrecondition, Message: "If-Match does not match the current ETag"}
+	}
+	return version, nil
+}
+
+// notModified answers a conditional GET with 304 when If-None-Match matches
+// the current entity tag, using the weak comparison of RFC 9110.
+func notModified(c *gin.Context, etag string) bool {
+	header := c.GetHeader("If-None-Match")
+	if header == "" {
+		return false
+	}
+	for _, tag := range etagList(header) {
+		if tag == "*" || strings.TrimPrefix(tag, "W/") == etag {
+			c.Header("ETag", etag)
+			c.Status(http.StatusNotModified)
+			return true
+		}
+	}
+	return false
+}
+
+// ifMatchChecksum reads the checksum a file write is conditioned on from
+// If-Match. The header is optional; without it, or with "*", any content is
+// replaced and "" is returned.
+func ifMatchChecksum(c *gin.Context) (string, error) {
+	tags := etagList(c.GetHeader("If-Match"))
+	if len(tags) == 0 || (len(tags) == 1 && tags[0] == "*") {
+		return "", nil
+	}
+	if len(tags) != 1 || len(tags[0]) < 3 || !strings.HasPrefix(tags[0], `"`) || !strings.HasSuffix(tags[0], `"`) {
+		return "", &core.Error{Kind: core.KindPrecondition, Message: "If-Match must hold a single strong ETag"}
+	}
+	return strings.Trim(tags[0], `"`), nil
+}
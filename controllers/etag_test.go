@@ -0,0 +1,117 @@
+package controllers
+
+import (
+	"github.com/gin-gonic/gin"
+	"net/http"
+	"net/http/httptest"
+	"pulse/repository"
+	"testing"
+)
+
+// conditional returns the context of a request with the given header, and
+// the recorder of its response.
+func conditional(name string, value string) (*gin.Context, *httptest.ResponseRecorder) {
+	gin.SetMode(gin.TestMode)
+	recorder := httptest.NewRecorder()
+	c, _ := gin.CreateTestContext(recorder)
+	c.Request = httptest.NewRequest(http.MethodGet, "/", nil)
+	if value != "" {
+		c.Request.Header.Set(name, value)
+	}
+	return c, recorder
+}
+
+// status is the status err is answered with, 0 for no error.
+func status(t *testing.T, err error) int {
+	t.Helper()
+	if err == nil {
+		return 0
+	}
+	c, recorder := conditional("", "")
+	respondError(c, err)
+	return recorder.Code
+}
+
+func TestIfMatchVersion(t *testing.T) {
+	tests := []struct {
+		header  string
+		version int64
+		status  int
+	}{
+		{"", 0, http.StatusPreconditionRequired},
+		{`"3"`, 3, 0},
+		{` "3" `, 3, 0},
+		{"*", repository.AnyVersion, 0},
+		{`W/"3"`, 0, http.StatusPreconditionFailed},
+		{`3`, 0, http.StatusPreconditionFailed},
+		{`"3", "4"`, 0, http.StatusPreconditionFailed},
+		{`"*"`, 0, http.StatusPreconditionFailed},
+		{`"-1"`, 0, http.StatusPreconditionFailed},
+		{`"abc"`, 0, http.StatusPreconditionFailed},
+	}
+	for _, test := range tests {
+		t.Run(test.header, func(t *testing.T) {
+			c, _ := conditional("If-Match", test.header)
+			version, err := ifMatchVersion(c)
+			if got := status(t, err); got != test.status || (err == nil && version != test.version) {
+				t.Fatalf("ifMatchVersion(%q) = %d answered %d, want %d answered %d", test.header, version, got, test.version, test.status)
+			}
+		})
+	}
+}
+
+func TestIfMatchChecksum(t *testing.T) {
+	tests := []struct {
+		header   string
+		checksum string
+		status   int
+	}{
+		{"", "", 0},
+		{"*", "", 0},
+		{`"abc"`, "abc", 0},
+		{`W/"abc"`, "", http.StatusPreconditionFailed},
+		{`abc`, "", http.StatusPreconditionFailed},
+		{`""`, "", http.StatusPreconditionFailed},
+		{`"abc", "def"`, "", http.StatusPreconditionFailed},
+	}
+	for _, test := range tests {
+		t.Run(test.header, func(t *testing.T) {
+			c, _ := conditional("If-Match", test.header)
+			checksum, err := ifMatchChecksum(c)
+			if got := status(t, err); got != test.status || checksum != test.checksum {
+				t.Fatalf("ifMatchChecksum(%q) = %q answered %d, want %q answered %d", test.header, checksum, got, test.checksum, test.status)
+			}
+		})
+	}
+}
+
+func TestNotModified(t *testing.T) {
+	etag := versionETag(2)
+	tests := []struct {
+		header      string
+		notModified bool
+	}{
+		{"", false},
+		{`"2"`, true},
+		{`W/"2"`, true},
+		{`"1", "2"`, true},
+		{"*", true},
+		{`"1"`, false},
+		{`"22"`, false},
+	}
+	for _, test := range tests {
+		t.Run(test.header, func(t *testing.T) {
+			c, recorder := conditional("If-None-Match", test.header)
+			if notModified(c, etag) != test.notModified {
+				t.Fatalf("notModified(%q) = %v", test.header, !test.notModified)
+			}
+			c.Writer.WriteHeaderNow()
+			if test.notModified && (recorder.Code != http.StatusNotModified || recorder.Header().Get("ETag") != etag) {
+				t.Fatalf("answered %d with ETag %q, want 304 with %s", recorder.Code, recorder.Header().Get("ETag"), etag)
+			}
+			if !test.notModified && recorder.Code == http.StatusNotModified {
+				t.Fatal("answered 304 for an entity tag that does not match")
+			}
+		})
+	}
+}
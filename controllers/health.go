@@ -0,0 +1,29 @@
+package controllers
+
+import (
+	"github.com/gin-gonic/gin"
+	"net/http"
+)
+
+// Healthz only tells that the process serves requests, it checks no
+// dependency so that a database outage does not get every replica restarted.
+func (s Controllers) Healthz(c *gin.Context) {
+	c.JSON(http.StatusOK, gin.H{"status": "ok"})
+}
+
+// Readyz fails while a dependency is unavailable or the service is shutting
+// down, taking the replica out of the load balancer.
+func (s Controllers) Readyz(c *gin.Context) {
+	readiness := s.health.Ready(c)
+	status := http.StatusOK
+	if !readiness.Ready {
+		status = http.StatusServiceUnavailable
+	}
+	c.Header("Cache-Control", "no-store")
+	c.JSON(status, readiness)
+}
+
+func (s Controllers) DebugStatus(c *gin.Context) {
+	c.Header("Cache-Control", "no-store")
+	c.JSON(http.StatusOK, s.health.Status(c))
+}
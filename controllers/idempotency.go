@@ -0,0 +1,158 @@
+package controllers
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"github.com/draco121/horizon/utils"
+	"github.com/gin-gonic/gin"
+	"io"
+	"net/http"
+	"pulse/api"
+	"pulse/core"
+	"sort"
+)
+
+const IdempotencyKeyHeader = api.IdempotencyKeyHeader
+
+// replayedHeaders are stored with an idempotent response and sent again when
+// it is replayed.
+var replayedHeaders = []string{"Content-Type", "ETag", "Location"}
+
+// responseRecorder keeps a copy of the response body while it is written.
+type responseRecorder struct {
+	gin.ResponseWriter
+	body bytes.Buffer
+}
+
+func (w *responseRecorder) Write(data []byte) (int, error) {
+	w.body.Write(data)
+	return w.ResponseWriter.Write(data)
+}
+
+func (w *responseRecorder) WriteString(data string) (int, error) {
+	w.body.WriteString(data)
+	return w.ResponseWriter.WriteString(data)
+}
+
+// Idempotent makes a route safe to retry with an Idempotency-Key header. The
+// first request with a key runs and its response is stored, repeats of the
+// same request get the stored response and a different request reusing the
+// key is refused. Requests without the header are not affected.
+func (s Controllers) Idempotent() gin.HandlerFunc {
+	return func(c *gin.Context) {
+		key := c.GetHeader(IdempotencyKeyHeader)
+		if key == "" {
+			c.Next()
+			return
+		}
+		if !validIdempotencyKey(key) {
+			respondError(c, core.InvalidField(IdempotencyKeyHeader, "must be 1 to 255 printable ASCII characters"))
+			return
+		}
+		fingerprint, err := s.requestFingerprint(c)
+		if err != nil {
+			respondError(c, err)
+			return
+		}
+		record, err := s.idempotency.Begin(c, key, fingerprint)
+		if err != nil {
+			respondError(c, err)
+			return
+		}
+		if record != nil {
+			for name, value := range record.Headers {
+				c.Header(name, value)
+			}
+			c.Header(api.IdempotentReplayedHeader, "true")
+			c.Status(record.Status)
+			_, _ = c.Writer.Write(record.Body)
+			c.Abort()
+			return
+		}
+		recorder := &responseRecorder{ResponseWriter: c.Writer}
+		c.Writer = recorder
+		c.Next()
+		status := c.Writer.Status()
+		if status >= http.StatusInternalServerError || status == http.StatusConflict {
+			// a retry may succeed, let it run again
+			err = s.idempotency.Release(c, key)
+			if err != nil {
+				utils.Logger.Error("failed to release idempotency key", "error: ", err.Error())
+			}
+			return
+		}
+		headers := make(map[string]string)
+		for _, name := range replayedHeaders {
+			if value := c.Writer.Header().Get(name); value != "" {
+				headers[name] = value
+			}
+		}
+		err = s.idempotency.Complete(c, key, status, headers, recorder.body.Bytes())
+		if err != nil {
+			utils.Logger.Error("failed to store idempotent response", "error: ", err.Error())
+		}
+	}
+}
+
+func validIdempotencyKey(key string) bool {
+	if len(key) > 255 {
+		return false
+	}
+	for i := 0; i < len(key); i++ {
+		if key[i] < 0x20 || key[i] > 0x7e {
+			return false
+		}
+	}
+	return true
+}
+
+// requestFingerprint hashes what makes a request unique. Multipart bodies are
+// hashed from their parsed parts, as clients pick a new boundary every time.
+func (s Controllers) requestFingerprint(c *gin.Context) (string, error) {
+	hash := sha256.New()
+	_, _ = fmt.Fprintf(hash, "%s %s\n", c.Request.Method, c.Request.URL.RequestURI())
+	if c.ContentType() != "multipart/form-data" {
+		body, err := io.ReadAll(http.MaxBytesReader(c.Writer, c.Request.Body, s.limits.MaxJSONBodySize))
+		if err != nil {
+			return "", err
+		}
+		c.Request.Body = io.NopCloser(bytes.NewReader(body))
+		_, _ = fmt.Fprintf(hash, "%q %d\n", c.ContentType(), len(body))
+		hash.Write(body)
+		return hex.EncodeToString(hash.Sum(nil)), nil
+	}
+	err := s.parseMultipart(c)
+	if err != nil {
+		return "", core.Validation("invalid multipart form: " + err.Error())
+	}
+	form := c.Request.MultipartForm
+	for _, name := range sortedKeys(form.Value) {
+		_, _ = fmt.Fprintf(hash, "value %q %q\n", name, form.Value[name])
+	}
+	for _, name := range sortedKeys(form.File) {
+		for _, file := range form.File[name] {
+			_, _ = fmt.Fprintf(hash, "file %q %q %d\n", name, file.Filename, file.Size)
+			reader, err := file.Open()
+			if err != nil {
+				return "", err
+			}
+			_, err = io.Copy(hash, reader)
+			_ = reader.Close()
+			if err != nil {
+				return "", err
+			}
+		}
+	}
+	return hex.EncodeToString(hash.Sum(nil)), nil
+}
+
+func sortedKeys[V any](values map[string]V) []string {
+	keys := make([]string, 0, len(values))
+	for key := range values {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+	return keys
+}
@@ -0,0 +1,129 @@
+package controllers_test
+
+import (
+	"context"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"io"
+	"net/http"
+	"pulse/api"
+	"pulse/controllers/controllertest"
+	"pulse/scanner"
+	"strings"
+	"sync"
+	"testing"
+)
+
+// blockingScanner holds every scan until release is closed, telling started
+// of the first one.
+type blockingScanner struct {
+	scanner.IScanner
+	once    sync.Once
+	started chan struct{}
+	release chan struct{}
+}
+
+func (s *blockingScanner) Scan(ctx context.Context, reader io.Reader) (*scanner.ScanResult, error) {
+	s.once.Do(func() { close(s.started) })
+	<-s.release
+	_, _ = io.Copy(io.Discard, reader)
+	return &scanner.ScanResult{Status: scanner.StatusClean}, nil
+}
+
+func TestIdempotentTrainingData(t *testing.T) {
+	server := controllertest.NewServer(t, controllertest.Options{})
+	projectId, botId := primitive.NewObjectID(), primitive.NewObjectID()
+	header := http.Header{"Content-Type": {"application/json"}, api.IdempotencyKeyHeader: {"add-1"}}
+	body := `{"botId": "` + botId.Hex() + `", "projectId": "` + projectId.Hex() + `", "description": "first"}`
+
+	first := send(t, server, http.MethodPost, "/v1/trainingdata", header, strings.NewReader(body))
+	firstBody, _ := io.ReadAll(first.Body)
+	_ = first.Body.Close()
+	if first.StatusCode != http.StatusCreated || first.Header.Get(api.IdempotentReplayedHeader) != "" {
+		t.Fatalf("first request answered %d: %s", first.StatusCode, firstBody)
+	}
+
+	// adding the training data again would conflict, the repeat is answered
+	// with the stored response instead
+	repeat := send(t, server, http.MethodPost, "/v1/trainingdata", header, strings.NewReader(body))
+	repeatBody, _ := io.ReadAll(repeat.Body)
+	_ = repeat.Body.Close()
+	if repeat.StatusCode != http.StatusCreated || repeat.Header.Get(api.IdempotentReplayedHeader) != "true" || string(repeatBody) != string(firstBody) {
+		t.Fatalf("repeat answered %d, replayed %q: %s", repeat.StatusCode, repeat.Header.Get(api.IdempotentReplayedHeader), repeatBody)
+	}
+	if repeat.Header.Get("ETag") != first.Header.Get("ETag") || repeat.Header.Get("Content-Type") != first.Header.Get("Content-Type") {
+		t.Fatalf("repeat headers %v, want those of %v", repeat.Header, first.Header)
+	}
+
+	changed := strings.Replace(body, "first", "second", 1)
+	decode(t, send(t, server, http.MethodPost, "/v1/trainingdata", header, strings.NewReader(changed)), http.StatusUnprocessableEntity, nil)
+	// without a key the conflict is reported
+	decode(t, send(t, server, http.MethodPost, "/v1/trainingdata", http.Header{"Content-Type": {"application/json"}}, strings.NewReader(body)), http.StatusConflict, nil)
+	header.Set(api.IdempotencyKeyHeader, strings.Repeat("k", 256))
+	decode(t, send(t, server, http.MethodPost, "/v1/trainingdata", header, strings.NewReader(body)), http.StatusBadRequest, nil)
+}
+
+func TestIdempotentUploadInFlight(t *testing.T) {
+	scan := &blockingScanner{started: make(chan struct{}), release: make(chan struct{})}
+	server := controllertest.NewServer(t, controllertest.Options{Scanner: scan})
+	// the held upload must end before the server is closed, even when the
+	// test fails
+	var released sync.Once
+	release := func() { released.Do(func() { close(scan.release) }) }
+	t.Cleanup(release)
+	projectId, botId := primitive.NewObjectID(), primitive.NewObjectID()
+	path := "/v1/upload/" + projectId.Hex() + "/" + botId.Hex()
+	upload := func(content string) (http.Header, io.Reader) {
+		header, body := multipartForm(t, map[string]string{"notes.txt": content})
+		header.Set(api.IdempotencyKeyHeader, "upload-1")
+		return header, body
+	}
+
+	type answer struct {
+		status int
+		body   string
+		err    error
+	}
+	answers := make(chan answer, 1)
+	header, body := upload("hello")
+	request, err := http.NewRequest(http.MethodPost, server.URL+path, body)
+	if err != nil {
+		t.Fatal(err)
+	}
+	request.Header = header
+	request.Header.Set("Authorization", server.Token)
+	go func() {
+		response, err := server.Client().Do(request)
+		if err != nil {
+			answers <- answer{err: err}
+			return
+		}
+		defer response.Body.Close()
+		content, _ := io.ReadAll(response.Body)
+		answers <- answer{status: response.StatusCode, body: string(content)}
+	}()
+	<-scan.started
+
+	// the same upload sent again while the first still runs
+	header, body = upload("hello")
+	decode(t, send(t, server, http.MethodPost, path, header, body), http.StatusConflict, nil)
+	header, body = upload("another content")
+	decode(t, send(t, server, http.MethodPost, path, header, body), http.StatusUnprocessableEntity, nil)
+
+	release()
+	first := <-answers
+	if first.err != nil || first.status != http.StatusCreated {
+		t.Fatalf("first upload answered %d, %v: %s", first.status, first.err, first.body)
+	}
+	header, body = upload("hello")
+	replay := send(t, server, http.MethodPost, path, header, body)
+	replayBody, _ := io.ReadAll(replay.Body)
+	_ = replay.Body.Close()
+	if replay.StatusCode != http.StatusCreated || replay.Header.Get(api.IdempotentReplayedHeader) != "true" || string(replayBody) != first.body {
+		t.Fatalf("replay answered %d: %s, want the response of the first upload", replay.StatusCode, replayBody)
+	}
+	var files []api.FileMetadata
+	decode(t, send(t, server, http.MethodGet, "/v1/files/"+projectId.Hex()+"/"+botId.Hex(), nil, nil), http.StatusOK, &files)
+	if len(files) != 1 {
+		t.Fatalf("stored %d files, want the upload stored once", len(files))
+	}
+}
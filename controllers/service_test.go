@@ -0,0 +1,241 @@
+package controllers_test
+
+import (
+	"archive/zip"
+	"bytes"
+	"encoding/json"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"io"
+	"mime/multipart"
+	"net/http"
+	"pulse/api"
+	"pulse/archive"
+	"pulse/controllers/controllertest"
+	"pulse/core"
+	"strings"
+	"testing"
+)
+
+// send sends a request authorized as the owner of server, the caller closes
+// the response body.
+func send(t *testing.T, server *controllertest.Server, method string, path string, header http.Header, body io.Reader) *http.Response {
+	t.Helper()
+	request, err := http.NewRequest(method, server.URL+path, body)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for key, values := range header {
+		request.Header[key] = values
+	}
+	if request.Header.Get("Authorization") == "" {
+		request.Header.Set("Authorization", server.Token)
+	}
+	response, err := server.Client().Do(request)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return response
+}
+
+// decode reads a JSON response with the wanted status into value.
+func decode(t *testing.T, response *http.Response, status int, value any) {
+	t.Helper()
+	defer response.Body.Close()
+	if response.StatusCode != status {
+		body, _ := io.ReadAll(response.Body)
+		t.Fatalf("status = %d, want %d: %s", response.StatusCode, status, body)
+	}
+	if value == nil {
+		return
+	}
+	err := json.NewDecoder(response.Body).Decode(value)
+	if err != nil {
+		t.Fatal(err)
+	}
+}
+
+// uploadFiles uploads files, given as their content by name, to a bot.
+func uploadFiles(t *testing.T, server *controllertest.Server, projectId primitive.ObjectID, botId primitive.ObjectID, files map[string]string) *http.Response {
+	t.Helper()
+	header, body := multipartForm(t, files)
+	return send(t, server, http.MethodPost, "/v1/upload/"+projectId.Hex()+"/"+botId.Hex(), header, body)
+}
+
+// multipartForm encodes files as the form of an upload.
+func multipartForm(t *testing.T, files map[string]string) (http.Header, io.Reader) {
+	t.Helper()
+	var body bytes.Buffer
+	writer := multipart.NewWriter(&body)
+	for name, content := range files {
+		part, err := writer.CreateFormFile("files", name)
+		if err != nil {
+			t.Fatal(err)
+		}
+		_, _ = part.Write([]byte(content))
+	}
+	_ = writer.Close()
+	return http.Header{"Content-Type": {writer.FormDataContentType()}}, &body
+}
+
+// multipartFile encodes a single file as the form field field.
+func multipartFile(t *testing.T, field string, name string, content string) (http.Header, io.Reader) {
+	t.Helper()
+	var body bytes.Buffer
+	writer := multipart.NewWriter(&body)
+	part, err := writer.CreateFormFile(field, name)
+	if err != nil {
+		t.Fatal(err)
+	}
+	_, _ = part.Write([]byte(content))
+	_ = writer.Close()
+	return http.Header{"Content-Type": {writer.FormDataContentType()}}, &body
+}
+
+// zipOf returns a zip archive of files, given as their content by name.
+func zipOf(t *testing.T, files map[string]string) string {
+	t.Helper()
+	var buf bytes.Buffer
+	writer := zip.NewWriter(&buf)
+	for name, content := range files {
+		w, err := writer.Create(name)
+		if err != nil {
+			t.Fatal(err)
+		}
+		_, _ = w.Write([]byte(content))
+	}
+	if err := writer.Close(); err != nil {
+		t.Fatal(err)
+	}
+	return buf.String()
+}
+
+func TestUploadDownloadDelete(t *testing.T) {
+	server := controllertest.NewServer(t, controllertest.Options{})
+	projectId, botId := primitive.NewObjectID(), primitive.NewObjectID()
+	var result api.UploadResult
+	decode(t, uploadFiles(t, server, projectId, botId, map[string]string{"notes.txt": "hello world"}), http.StatusCreated, &result)
+	if len(result.Files) != 1 || result.Files[0].FileName != "notes.txt" {
+		t.Fatalf("uploaded %+v", result)
+	}
+	fileId := result.Files[0].FileId
+	path := "/v1/download/" + projectId.Hex() + "/" + botId.Hex() + "/" + fileId.Hex()
+
+	response := send(t, server, http.MethodGet, path, nil, nil)
+	content, _ := io.ReadAll(response.Body)
+	_ = response.Body.Close()
+	if response.StatusCode != http.StatusOK || string(content) != "hello world" {
+		t.Fatalf("download answered %d %q", response.StatusCode, content)
+	}
+	if response.Header.Get("ETag") == "" {
+		t.Fatal("download without an ETag")
+	}
+
+	response = send(t, server, http.MethodDelete, "/v1/delete/"+projectId.Hex()+"/"+botId.Hex()+"/"+fileId.Hex(), nil, nil)
+	decode(t, response, http.StatusNoContent, nil)
+	decode(t, send(t, server, http.MethodGet, path, nil, nil), http.StatusNotFound, nil)
+	decode(t, send(t, server, http.MethodDelete, "/v1/delete/"+projectId.Hex()+"/"+botId.Hex()+"/"+fileId.Hex(), nil, nil), http.StatusNotFound, nil)
+}
+
+func TestUploadRejections(t *testing.T) {
+	server := controllertest.NewServer(t, controllertest.Options{
+		Upload: api.UploadPolicy{AllowedMimeTypes: []string{"text/plain"}},
+	})
+	projectId, botId := primitive.NewObjectID(), primitive.NewObjectID()
+	var result api.UploadResult
+	decode(t, uploadFiles(t, server, projectId, botId, map[string]string{"page.html": "<html><body>hi</body></html>"}), http.StatusUnprocessableEntity, &result)
+	if len(result.Files) != 0 || len(result.Rejected) != 1 {
+		t.Fatalf("upload answered %+v, want the file rejected", result)
+	}
+	response := send(t, server, http.MethodPost, "/v1/upload/"+projectId.Hex()+"/"+botId.Hex(), http.Header{"Content-Type": {"multipart/form-data; boundary=x"}}, strings.NewReader("--x--\r\n"))
+	decode(t, response, http.StatusBadRequest, nil)
+	decode(t, send(t, server, http.MethodPost, "/v1/upload/not-an-id/"+botId.Hex(), nil, nil), http.StatusBadRequest, nil)
+}
+
+func TestArchiveLimitsSpanTheUpload(t *testing.T) {
+	server := controllertest.NewServer(t, controllertest.Options{Archive: archive.Limits{MaxEntries: 3}})
+	projectId, botId := primitive.NewObjectID(), primitive.NewObjectID()
+	header, body := multipartForm(t, map[string]string{
+		"first.zip":  zipOf(t, map[string]string{"a.txt": "a", "b.txt": "b"}),
+		"second.zip": zipOf(t, map[string]string{"c.txt": "c", "d.txt": "d"}),
+	})
+	var result api.UploadResult
+	response := send(t, server, http.MethodPost, "/v1/upload/"+projectId.Hex()+"/"+botId.Hex()+"?extract=true", header, body)
+	decode(t, response, http.StatusCreated, &result)
+	// each archive is within the limit, the second is refused as the two
+	// together are not
+	if len(result.Files) != 2 || len(result.Rejected) != 1 || result.Rejected[0].Code != core.RejectionArchiveTooLarge {
+		t.Fatalf("upload answered %+v, want one archive extracted and the other rejected", result)
+	}
+}
+
+func TestUnauthorizedRequests(t *testing.T) {
+	server := controllertest.NewServer(t, controllertest.Options{})
+	header := http.Header{"Authorization": {"Bearer someone else"}}
+	response := send(t, server, http.MethodGet, "/v1/trainingdata?botId="+primitive.NewObjectID().Hex()+"&projectId="+primitive.NewObjectID().Hex(), header, nil)
+	decode(t, response, http.StatusUnauthorized, nil)
+}
+
+func TestTrainingData(t *testing.T) {
+	server := controllertest.NewServer(t, controllertest.Options{})
+	projectId, botId := primitive.NewObjectID(), primitive.NewObjectID()
+	query := "/v1/trainingdata?botId=" + botId.Hex() + "&projectId=" + projectId.Hex()
+	decode(t, send(t, server, http.MethodGet, query, nil, nil), http.StatusNotFound, nil)
+
+	body := `{"botId": "` + botId.Hex() + `", "projectId": "` + projectId.Hex() + `", "description": "first"}`
+	var created api.VersionedTrainingData
+	response := send(t, server, http.MethodPost, "/v1/trainingdata", http.Header{"Content-Type": {"application/json"}}, strings.NewReader(body))
+	etag := response.Header.Get("ETag")
+	decode(t, response, http.StatusCreated, &created)
+	if created.Description != "first" || created.Owner != server.Owner || etag != `"1"` {
+		t.Fatalf("created %+v with ETag %s", created, etag)
+	}
+
+	var read api.VersionedTrainingData
+	decode(t, send(t, server, http.MethodGet, query, nil, nil), http.StatusOK, &read)
+	if read.ID != created.ID || read.Version != 1 {
+		t.Fatalf("read %+v, want %+v", read, created)
+	}
+	decode(t, send(t, server, http.MethodGet, query, http.Header{"If-None-Match": {etag}}, nil), http.StatusNotModified, nil)
+
+	update := `{"description": "second"}`
+	header := http.Header{"Content-Type": {"application/merge-patch+json"}, "If-Match": {`"7"`}}
+	decode(t, send(t, server, http.MethodPatch, query, header, strings.NewReader(update)), http.StatusPreconditionFailed, nil)
+	header.Set("If-Match", etag)
+	var updated api.VersionedTrainingData
+	decode(t, send(t, server, http.MethodPatch, query, header, strings.NewReader(update)), http.StatusOK, &updated)
+	if updated.Description != "second" || updated.Version != 2 {
+		t.Fatalf("updated %+v", updated)
+	}
+
+	decode(t, send(t, server, http.MethodDelete, query, nil, nil), http.StatusPreconditionRequired, nil)
+	decode(t, send(t, server, http.MethodDelete, query, http.Header{"If-Match": {`"2"`}}, nil), http.StatusOK, nil)
+	decode(t, send(t, server, http.MethodGet, query, nil, nil), http.StatusNotFound, nil)
+}
+
+func TestReplaceFileIfMatch(t *testing.T) {
+	server := controllertest.NewServer(t, controllertest.Options{})
+	projectId, botId := primitive.NewObjectID(), primitive.NewObjectID()
+	var result api.UploadResult
+	decode(t, uploadFiles(t, server, projectId, botId, map[string]string{"notes.txt": "first"}), http.StatusCreated, &result)
+	filePath := "/v1/files/" + projectId.Hex() + "/" + botId.Hex() + "/" + result.Files[0].FileId.Hex()
+	download := send(t, server, http.MethodGet, "/v1/download/"+projectId.Hex()+"/"+botId.Hex()+"/"+result.Files[0].FileId.Hex(), nil, nil)
+	_ = download.Body.Close()
+	etag := download.Header.Get("ETag")
+
+	replace := func(ifMatch string) *http.Response {
+		header, body := multipartFile(t, "file", "notes.txt", "second")
+		if ifMatch != "" {
+			header.Set("If-Match", ifMatch)
+		}
+		return send(t, server, http.MethodPut, filePath, header, body)
+	}
+	decode(t, replace(`"`+strings.Repeat("0", 64)+`"`), http.StatusPreconditionFailed, nil)
+	decode(t, replace(`W/`+etag), http.StatusPreconditionFailed, nil)
+	var replaced api.FileMetadata
+	decode(t, replace(etag), http.StatusOK, &replaced)
+	if replaced.Revision != 2 {
+		t.Fatalf("replaced %+v, want revision 2", replaced)
+	}
+	// the ETag read before the replace is stale now
+	decode(t, replace(etag), http.StatusPreconditionFailed, nil)
+}
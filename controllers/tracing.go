@@ -0,0 +1,52 @@
+package controllers
+
+import (
+	"github.com/gin-gonic/gin"
+	"go.opentelemetry.io/otel"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/codes"
+	"go.opentelemetry.io/otel/propagation"
+	"net/http"
+	"pulse/tracing"
+	"strings"
+)
+
+// Tracing starts the server span of every request, continuing the trace of
+// the caller when it sends a W3C traceparent header. The span is put on the
+// request context, which gin only falls back to when ContextWithFallback is
+// set on the engine.
+func Tracing() gin.HandlerFunc {
+	return func(c *gin.Context) {
+		ctx := otel.GetTextMapPropagator().Extract(c.Request.Context(), propagation.HeaderCarrier(c.Request.Header))
+		route := c.FullPath()
+		if route == "" {
+			route = "unmatched"
+		}
+		ctx, span := tracing.StartServer(ctx, c.Request.Method+" "+route,
+			attribute.String("http.request.method", c.Request.Method),
+			attribute.String("http.route", route),
+			attribute.String("url.path", c.Request.URL.Path),
+			attribute.String("pulse.request_id", c.GetString("RequestId")),
+		)
+		defer span.End()
+		c.Request = c.Request.WithContext(ctx)
+		c.Next()
+		status := c.Writer.Status()
+		span.SetAttributes(
+			attribute.Int("http.response.status_code", status),
+			attribute.String("pulse.controller", strings.TrimPrefix(c.HandlerName(), "pulse/controllers.")),
+		)
+		if status >= http.StatusInternalServerError {
+			span.SetStatus(codes.Error, http.StatusText(status))
+		}
+	}
+}
+
+// parseMultipart parses the multipart body of the request in its own span as
+// it spools the uploaded files to disk.
+func (s Controllers) parseMultipart(c *gin.Context) error {
+	_, span := tracing.Start(c.Request.Context(), "multipart.parse")
+	err := c.Request.ParseMultipartForm(s.limits.MaxMultipartMemory)
+	tracing.End(span, err)
+	return err
+}
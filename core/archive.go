@@ -0,0 +1,149 @@
+package core
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"github.com/draco121/horizon/utils"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"io"
+	"pulse/archive"
+	"pulse/naming"
+	"pulse/repository"
+	"time"
+)
+
+// ManifestName is the archive entry describing the other entries.
+const ManifestName = "manifest.json"
+
+// ArchiveManifest is written into every bulk download.
+type ArchiveManifest struct {
+	ProjectId primitive.ObjectID    `json:"projectId"`
+	BotId     primitive.ObjectID    `json:"botId"`
+	CreatedAt time.Time             `json:"createdAt"`
+	Files     []ArchiveManifestFile `json:"files"`
+}
+
+// ArchiveManifestFile maps an archive entry back to the stored file. Path
+// is the file name under the folder of the file, made unique when several
+// files share it.
+type ArchiveManifestFile struct {
+	FileId   primitive.ObjectID `json:"fileId"`
+	FileName string             `json:"fileName"`
+	Path     string             `json:"path"`
+	Size     int64              `json:"size,omitempty"`
+	Checksum string             `json:"checksum,omitempty"`
+	MimeType string             `json:"mimeType,omitempty"`
+	Tags     []string           `json:"tags,omitempty"`
+	Labels   map[string]string  `json:"labels,omitempty"`
+}
+
+func (s *trainingService) ArchiveFiles(ctx context.Context, botId string, projectId string, fileIds []primitive.ObjectID) ([]archive.Entry, error) {
+	ctx, tx, err := s.transactions.Begin(ctx)
+	if err != nil {
+		utils.Logger.Error("failed to start transaction", "error: ", err.Error())
+		return nil, err
+	}
+	defer tx.End(ctx)
+	bid, err := primitive.ObjectIDFromHex(botId)
+	if err != nil {
+		return nil, InvalidField("botId", "must be a valid id")
+	}
+	pid, err := primitive.ObjectIDFromHex(projectId)
+	if err != nil {
+		return nil, InvalidField("projectId", "must be a valid id")
+	}
+	trainingData, err := s.repo.FindOneByBotId(ctx, bid, pid)
+	if err != nil {
+		utils.Logger.Error("no training data found error ", err.Error())
+		return nil, err
+	}
+	stored, err := s.files.FindByBotId(ctx, bid, pid, nil)
+	if err != nil {
+		utils.Logger.Error("failed to fetch file metadata", "error: ", err.Error())
+		return nil, err
+	}
+	metadata := make(map[primitive.ObjectID]repository.FileMetadata, len(stored))
+	for _, m := range stored {
+		metadata[m.FileId] = m
+	}
+	selected := make(map[primitive.ObjectID]bool, len(fileIds))
+	for _, fileId := range fileIds {
+		selected[fileId] = true
+	}
+	dataKey, err := s.keys.DataKey(ctx, pid, false)
+	if err != nil {
+		return nil, err
+	}
+	manifest := ArchiveManifest{ProjectId: pid, BotId: bid, CreatedAt: time.Now().UTC(), Files: make([]ArchiveManifestFile, 0)}
+	taken := map[string]bool{ManifestName: true}
+	entries := make([]archive.Entry, 0, len(trainingData.Files)+1)
+	for _, file := range trainingData.Files {
+		if len(selected) > 0 && !selected[file.FileId] {
+			continue
+		}
+		delete(selected, file.FileId)
+		m, known := metadata[file.FileId]
+		if known && m.Quarantined {
+			if len(fileIds) > 0 {
+				return nil, ErrFileQuarantined
+			}
+			continue
+		}
+		name, err := naming.NormalizeFileName(file.FileName)
+		if err != nil {
+			// names stored before they were validated
+			name = naming.StorageKey(file.FileId, file.Extension)
+		}
+		if known && m.Folder != "" {
+			name = m.Folder + "/" + name
+		}
+		name = naming.UniqueName(name, taken)
+		modTime := file.FileId.Timestamp()
+		if known && !m.UpdatedAt.IsZero() {
+			modTime = m.UpdatedAt
+		} else if known && !m.CreatedAt.IsZero() {
+			modTime = m.CreatedAt
+		}
+		manifest.Files = append(manifest.Files, ArchiveManifestFile{
+			FileId:   file.FileId,
+			FileName: file.FileName,
+			Path:     name,
+			Size:     m.Size,
+			Checksum: m.Checksum,
+			MimeType: m.MimeType,
+			Tags:     m.Tags,
+			Labels:   m.Labels,
+		})
+		key := naming.StorageKey(file.FileId, file.Extension)
+		entries = append(entries, archive.Entry{
+			Name:    name,
+			ModTime: modTime,
+			Open: func() (io.ReadCloser, int64, error) {
+				return s.repo.OpenFile(ctx, botId, projectId, key, dataKey)
+			},
+		})
+	}
+	for fileId := range selected {
+		return nil, NotFound("file %s not found", fileId.Hex())
+	}
+	data, err := json.MarshalIndent(manifest, "", "  ")
+	if err != nil {
+		return nil, err
+	}
+	// the manifest goes first so that streaming readers see it before the files
+	entries = append([]archive.Entry{{
+		Name:    ManifestName,
+		ModTime: manifest.CreatedAt,
+		Open: func() (io.ReadCloser, int64, error) {
+			return io.NopCloser(bytes.NewReader(data)), int64(len(data)), nil
+		},
+	}}, entries...)
+	err = tx.Commit(ctx)
+	if err != nil {
+		utils.Logger.Error("failed to commit transaction", "error: ", err.Error())
+		return nil, err
+	}
+	utils.Logger.Info("successfully listed ", len(manifest.Files), " files for download")
+	return entries, nil
+}
@@ -0,0 +1,405 @@
+package core
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"github.com/draco121/horizon/models"
+	"github.com/draco121/horizon/utils"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"pulse/api"
+	"pulse/naming"
+	"pulse/repository"
+	"slices"
+	"time"
+)
+
+const (
+	BatchDelete  = "delete"
+	BatchMove    = "move"
+	BatchTag     = "tag"
+	BatchRestore = "restore"
+	// MaxBatchOperations bounds the operations of one batch, the files they
+	// cover are bounded by MaxBulkFiles.
+	MaxBatchOperations = 100
+)
+
+var ErrBatchAborted = errors.New("not applied as another operation of the atomic batch failed")
+
+type BatchOperation = api.BatchOperation
+
+// BatchItem is the outcome of an operation of a batch on one file, Err is nil
+// when it was applied.
+type BatchItem struct {
+	Operation int
+	Op        string
+	FileId    primitive.ObjectID
+	Err       error
+}
+
+// batchFile is a file as it stands after the operations planned so far.
+type batchFile struct {
+	metadata repository.FileMetadata
+	history  []repository.FileRevision
+	deleted  bool
+	restored bool
+}
+
+func validateBatch(operations []BatchOperation) error {
+	if len(operations) == 0 {
+		return InvalidField("operations", "at least one operation is required")
+	} else if len(operations) > MaxBatchOperations {
+		return InvalidField("operations", fmt.Sprintf("at most %d operations can be run at once", MaxBatchOperations))
+	}
+	files := 0
+	for i := range operations {
+		err := validateBatchOperation(&operations[i])
+		if err != nil {
+			return inOperation(i, err)
+		}
+		files += len(operations[i].FileIds)
+	}
+	if files > MaxBulkFiles {
+		return InvalidField("operations", fmt.Sprintf("at most %d files can be changed at once", MaxBulkFiles))
+	}
+	return nil
+}
+
+func validateBatchOperation(operation *BatchOperation) error {
+	var err error
+	operation.FileIds, err = validateBulkFiles(operation.FileIds)
+	if err != nil {
+		return err
+	}
+	switch operation.Op {
+	case BatchDelete:
+	case BatchMove:
+		operation.Folder, err = naming.NormalizeFolder(operation.Folder)
+		if err != nil {
+			return InvalidField("folder", err.Error())
+		}
+	case BatchTag:
+		return validateTagChange(&operation.TagChange)
+	case BatchRestore:
+		if operation.Revision < 1 {
+			return InvalidField("revision", "must be a positive number")
+		}
+	default:
+		return InvalidField("op", "must be one of delete, move, tag or restore")
+	}
+	return nil
+}
+
+// inOperation points a validation error at an operation of the batch.
+func inOperation(i int, err error) error {
+	var typed *Error
+	if !errors.As(err, &typed) {
+		return err
+	}
+	prefix := fmt.Sprintf("operations[%d].", i)
+	fields := make([]FieldError, len(typed.Fields))
+	for j, field := range typed.Fields {
+		fields[j] = FieldError{Field: prefix + field.Field, Message: field.Message}
+	}
+	return &Error{Kind: typed.Kind, Message: prefix + typed.Message, Fields: fields, Err: typed.Err}
+}
+
+// RunBatch checks every operation against the files as the previous
+// operations leave them before writing anything. In an atomic batch a single
+// failed check leaves everything untouched; otherwise the failed operations
+// are skipped. The writes of the database are made in one transaction: when
+// it does not commit, restored files are moved back and nothing is removed
+// from disk, as deleted files are only removed once it committed.
+func (s *trainingService) RunBatch(ctx context.Context, botId primitive.ObjectID, projectId primitive.ObjectID, operations []BatchOperation, atomic bool) ([]BatchItem, error) {
+	err := validateBatch(operations)
+	if err != nil {
+		return nil, err
+	}
+	ctx, tx, err := s.transactions.Begin(ctx)
+	if err != nil {
+		utils.Logger.Error("failed to start transaction", "error: ", err.Error())
+		return nil, err
+	}
+	defer tx.End(ctx)
+	trainingData, err := s.repo.FindOneByBotId(ctx, botId, projectId)
+	if err != nil {
+		utils.Logger.Error("no training data found error ", err.Error())
+		return nil, err
+	}
+	known := make(map[primitive.ObjectID]bool, len(trainingData.Files))
+	for _, file := range trainingData.Files {
+		known[file.FileId] = true
+	}
+	present := make([]primitive.ObjectID, 0)
+	for _, operation := range operations {
+		for _, fileId := range operation.FileIds {
+			if known[fileId] && !slices.Contains(present, fileId) {
+				present = append(present, fileId)
+			}
+		}
+	}
+	err = s.ensureMetadata(ctx, botId, projectId, present)
+	if err != nil {
+		return nil, err
+	}
+	stored, err := s.files.FindMany(ctx, botId, projectId, present)
+	if err != nil {
+		return nil, err
+	}
+	state := make(map[primitive.ObjectID]*batchFile, len(stored))
+	for _, metadata := range stored {
+		state[metadata.FileId] = &batchFile{metadata: metadata}
+	}
+	items := make([]BatchItem, 0)
+	var restoreSize int64
+	for i := range operations {
+		for _, fileId := range operations[i].FileIds {
+			err = s.planBatchItem(ctx, fileId, state[fileId], &operations[i], &restoreSize)
+			items = append(items, BatchItem{Operation: i, Op: operations[i].Op, FileId: fileId, Err: err})
+		}
+	}
+	if atomic && slices.ContainsFunc(items, func(item BatchItem) bool { return item.Err != nil }) {
+		for i := range items {
+			if items[i].Err == nil {
+				items[i].Err = ErrBatchAborted
+			}
+		}
+		utils.Logger.Info("aborted atomic batch of ", len(operations), " operations")
+		return items, nil
+	}
+	changed := false
+	files := make([]models.Files, 0, len(trainingData.Files))
+	for _, file := range trainingData.Files {
+		planned, ok := state[file.FileId]
+		if ok && planned.deleted {
+			changed = true
+			continue
+		} else if ok && planned.restored {
+			file.FileName, file.Extension = planned.metadata.FileName, planned.metadata.Extension
+			changed = true
+		}
+		files = append(files, file)
+	}
+	if changed {
+		trainingData.Files = files
+		_, err = s.repo.UpdateOne(ctx, &trainingData.TrainingData, trainingData.Version)
+		if errors.Is(err, repository.ErrVersionMismatch) {
+			return nil, Conflict("training data of the bot changed during the batch, retry the batch")
+		} else if err != nil {
+			utils.Logger.Error("failed to update training data for batch, error ", err.Error())
+			return nil, err
+		}
+	}
+	undo := make([]func(), 0)
+	for i := range operations {
+		undo = append(undo, s.applyBatchOperation(ctx, botId, projectId, i, &operations[i], items)...)
+	}
+	deleted := s.applyBatchDeletes(ctx, botId, projectId, items)
+	err = tx.Commit(ctx)
+	if err != nil {
+		utils.Logger.Error("failed to commit batch, error ", err.Error())
+		for _, restored := range undo {
+			restored()
+		}
+		return nil, err
+	}
+	for _, file := range deleted {
+		s.removeContent(ctx, botId.Hex(), projectId.Hex(), file)
+	}
+	utils.Logger.Info("ran batch of ", len(operations), " operations over ", len(items), " files")
+	return items, nil
+}
+
+// planBatchItem checks an operation on one file and records its effect on the
+// planned state of the file when it passes.
+func (s *trainingService) planBatchItem(ctx context.Context, fileId primitive.ObjectID, file *batchFile, operation *BatchOperation, restoreSize *int64) error {
+	if file == nil || file.deleted {
+		return NotFound("file %s not found", fileId.Hex())
+	}
+	switch operation.Op {
+	case BatchDelete:
+		file.deleted = true
+	case BatchMove:
+		file.metadata.Folder = operation.Folder
+	case BatchTag:
+		tags, labels := applyTagChange(file.metadata, &operation.TagChange)
+		if len(tags) > MaxTagsPerFile || len(labels) > MaxLabelsPerFile {
+			return InvalidField("fileIds", fmt.Sprintf("file %s would have more than %d tags or %d labels", fileId.Hex(), MaxTagsPerFile, MaxLabelsPerFile))
+		}
+		file.metadata.Tags, file.metadata.Labels = tags, labels
+	case BatchRestore:
+		if file.metadata.Quarantined {
+			return ErrFileQuarantined
+		} else if operation.Revision == currentRevision(&file.metadata) {
+			return nil
+		}
+		if file.history == nil {
+			history, err := s.revisions.FindByFileId(ctx, fileId)
+			if err != nil {
+				return err
+			}
+			file.history = history
+		}
+		i := slices.IndexFunc(file.history, func(r repository.FileRevision) bool { return r.Revision == operation.Revision })
+		if i < 0 {
+			return NotFound("revision %d of file %s not found", operation.Revision, fileId.Hex())
+		}
+		target := file.history[i]
+		// the restored copy takes space like any upload
+		err := s.usage.CheckUpload(ctx, file.metadata.ProjectId, file.metadata.BotId, *restoreSize+target.Size, 0)
+		if err != nil {
+			return err
+		}
+		*restoreSize += target.Size
+		file.history = append(file.history, *historyOf(&file.metadata, time.Time{}))
+		file.metadata.FileName = target.FileName
+		file.metadata.Extension = target.Extension
+		file.metadata.Size = target.Size
+		file.metadata.Revision = currentRevision(&file.metadata) + 1
+		file.restored = true
+	}
+	return nil
+}
+
+// applyBatchOperation writes the planned moves, tags and restores of an
+// operation, deletes are written last by applyBatchDeletes. It returns what
+// undoes the restores on disk.
+func (s *trainingService) applyBatchOperation(ctx context.Context, botId primitive.ObjectID, projectId primitive.ObjectID, i int, operation *BatchOperation, items []BatchItem) []func() {
+	planned := make([]*BatchItem, 0)
+	fileIds := make([]primitive.ObjectID, 0)
+	for j := range items {
+		if items[j].Operation == i && items[j].Err == nil {
+			planned = append(planned, &items[j])
+			fileIds = append(fileIds, items[j].FileId)
+		}
+	}
+	if len(planned) == 0 {
+		return nil
+	}
+	var err error
+	switch operation.Op {
+	case BatchMove:
+		err = s.files.SetFolder(ctx, botId, projectId, fileIds, operation.Folder)
+	case BatchTag:
+		err = s.files.UpdateTags(ctx, botId, projectId, fileIds, &operation.TagChange)
+	case BatchRestore:
+		undo := make([]func(), 0, len(planned))
+		for _, item := range planned {
+			var restored func()
+			restored, item.Err = s.applyBatchRestore(ctx, item.FileId, operation.Revision)
+			if item.Err != nil {
+				utils.Logger.Error("failed to restore revision ", operation.Revision, " of file ", item.FileId.Hex(), ": ", item.Err.Error())
+			} else if restored != nil {
+				undo = append(undo, restored)
+			}
+		}
+		return undo
+	}
+	if err != nil {
+		utils.Logger.Error("failed to apply batch operation ", i, ": ", err.Error())
+		for _, item := range planned {
+			item.Err = err
+		}
+	}
+	return nil
+}
+
+func (s *trainingService) applyBatchRestore(ctx context.Context, fileId primitive.ObjectID, revision int64) (func(), error) {
+	current, err := s.files.FindOne(ctx, fileId)
+	if err != nil {
+		return nil, err
+	} else if currentRevision(current) == revision {
+		return nil, nil
+	}
+	target, err := s.revisions.FindOne(ctx, fileId, revision)
+	if err != nil {
+		return nil, err
+	}
+	// the restored copy is counted before it is written, like an upload
+	err = s.usage.RecordUpload(ctx, current.ProjectId, current.BotId, target.Size, 0)
+	if err != nil {
+		return nil, err
+	}
+	_, undo, err := s.restoreRevision(ctx, current, target)
+	if err != nil {
+		s.releaseUsage(ctx, current.ProjectId, current.BotId, target.Size, 0)
+		return nil, err
+	}
+	return undo, nil
+}
+
+// applyBatchDeletes deletes the metadata and the revision records of the
+// files the training data no longer lists and returns them, their content is
+// removed from disk once the batch committed.
+func (s *trainingService) applyBatchDeletes(ctx context.Context, botId primitive.ObjectID, projectId primitive.ObjectID, items []BatchItem) []deletedFile {
+	deleted := make([]deletedFile, 0)
+	var size int64
+	for i := range items {
+		if items[i].Op != BatchDelete || items[i].Err != nil {
+			continue
+		}
+		file, err := s.forgetFile(ctx, items[i].FileId)
+		if err != nil {
+			utils.Logger.Error("failed to delete metadata of file ", items[i].FileId.Hex(), ": ", err.Error())
+			items[i].Err = err
+			continue
+		}
+		deleted = append(deleted, file)
+		size += file.size()
+	}
+	if len(deleted) == 0 {
+		return deleted
+	}
+	err := s.usage.RecordDelete(ctx, projectId, botId, size, int64(len(deleted)))
+	if err != nil {
+		utils.Logger.Error("failed to record deleted files: ", err.Error())
+	}
+	return deleted
+}
+
+// deletedFile is a file whose records are deleted, along with what is needed
+// to remove its content.
+type deletedFile struct {
+	metadata  *repository.FileMetadata
+	revisions []repository.FileRevision
+}
+
+// size is the storage the file took with its revisions.
+func (f deletedFile) size() int64 {
+	size := f.metadata.Size
+	for _, revision := range f.revisions {
+		size += revision.Size
+	}
+	return size
+}
+
+// forgetFile deletes the metadata and the revision records of a file the
+// training data no longer lists, leaving its content to removeContent.
+func (s *trainingService) forgetFile(ctx context.Context, fileId primitive.ObjectID) (deletedFile, error) {
+	metadata, err := s.files.DeleteOne(ctx, fileId)
+	if err != nil {
+		return deletedFile{}, err
+	}
+	revisions, err := s.revisions.DeleteByFileId(ctx, fileId)
+	if err != nil {
+		return deletedFile{}, err
+	}
+	return deletedFile{metadata: metadata, revisions: revisions}, nil
+}
+
+// removeContent removes the stored content and revisions of a forgotten file.
+// Files left on disk by a failed removal are only logged, the training data
+// already forgot them.
+func (s *trainingService) removeContent(ctx context.Context, botId string, projectId string, file deletedFile) {
+	fileId := file.metadata.FileId
+	err := s.repo.RemoveFile(ctx, botId, projectId, naming.StorageKey(fileId, file.metadata.Extension))
+	if err != nil {
+		utils.Logger.Warn("failed to remove deleted file ", fileId.Hex(), ": ", err.Error())
+	}
+	for _, revision := range file.revisions {
+		err = s.repo.DeleteRevision(ctx, botId, projectId, naming.RevisionKey(fileId, revision.Revision, revision.Extension))
+		if err != nil {
+			utils.Logger.Warn("failed to delete file revision ", revision.Revision, " of ", fileId.Hex(), ": ", err.Error())
+		}
+	}
+}
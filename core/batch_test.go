@@ -0,0 +1,187 @@
+package core
+
+import (
+	"context"
+	"errors"
+	"github.com/draco121/horizon/models"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"io"
+	"os"
+	"pulse/naming"
+	"pulse/repository"
+	"pulse/repository/repotest"
+	"testing"
+)
+
+type deletableFiles struct {
+	repository.IFileRepository
+	metadata map[primitive.ObjectID]repository.FileMetadata
+}
+
+func (r *deletableFiles) DeleteOne(ctx context.Context, fileId primitive.ObjectID) (*repository.FileMetadata, error) {
+	metadata, ok := r.metadata[fileId]
+	if !ok {
+		return nil, errors.New("no metadata")
+	}
+	delete(r.metadata, fileId)
+	return &metadata, nil
+}
+
+type noRevisions struct {
+	repository.IRevisionRepository
+}
+
+func (noRevisions) DeleteByFileId(ctx context.Context, fileId primitive.ObjectID) ([]repository.FileRevision, error) {
+	return nil, nil
+}
+
+// Deleted files stay on disk until the batch commits, so that a batch that
+// does not commit loses nothing.
+func TestBatchDeletesKeepContentUntilCommit(t *testing.T) {
+	ctx := context.WithValue(context.Background(), "UserId", primitive.NewObjectID())
+	botId, projectId := primitive.NewObjectID(), primitive.NewObjectID()
+	repo := repository.NewMemoryTrainingRepository()
+	files := &deletableFiles{metadata: make(map[primitive.ObjectID]repository.FileMetadata)}
+	usage := &limitedUsage{}
+	s := &trainingService{repo: repo, files: files, revisions: noRevisions{}, usage: NewUsageService(usage, QuotaPolicy{})}
+	fileId, missing := primitive.NewObjectID(), primitive.NewObjectID()
+	key := naming.StorageKey(fileId, ".txt")
+	_, err := repo.SaveFile(ctx, botId.Hex(), projectId.Hex(), key, repotest.FileHeader(t, "a.txt", []byte("hello")), nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	files.metadata[fileId] = repository.FileMetadata{FileId: fileId, Extension: ".txt", Size: 5}
+	usage.bytes, usage.files = 5, 1
+
+	items := []BatchItem{{Op: BatchDelete, FileId: fileId}, {Op: BatchDelete, FileId: missing}}
+	deleted := s.applyBatchDeletes(ctx, botId, projectId, items)
+	if len(deleted) != 1 || items[0].Err != nil || items[1].Err == nil {
+		t.Fatalf("deleted %d files, items %+v", len(deleted), items)
+	}
+	if usage.bytes != 0 || usage.files != 0 {
+		t.Fatalf("usage is %d bytes in %d files after the delete, want none", usage.bytes, usage.files)
+	}
+	content, _, err := repo.OpenFile(ctx, botId.Hex(), projectId.Hex(), key, nil)
+	if err != nil {
+		t.Fatalf("content removed before the batch committed: %v", err)
+	}
+	_, _ = io.ReadAll(content)
+	_ = content.Close()
+
+	s.removeContent(ctx, botId.Hex(), projectId.Hex(), deleted[0])
+	_, _, err = repo.OpenFile(ctx, botId.Hex(), projectId.Hex(), key, nil)
+	if !errors.Is(err, os.ErrNotExist) {
+		t.Fatalf("err = %v, want ErrNotExist once the content is removed", err)
+	}
+}
+
+// conflictingRepository fails every versioned update of the training data.
+type conflictingRepository struct {
+	repository.ITrainingRepository
+}
+
+func (r conflictingRepository) UpdateOne(ctx context.Context, trainingData *models.TrainingData, version int64) (*repository.VersionedTrainingData, error) {
+	return nil, repository.ErrVersionMismatch
+}
+
+// A delete that loses the race for the training data keeps the content and
+// the records of the file.
+func TestDeleteFileKeepsContentOnConflict(t *testing.T) {
+	ctx := context.WithValue(context.Background(), "UserId", primitive.NewObjectID())
+	botId, projectId := primitive.NewObjectID(), primitive.NewObjectID()
+	repo := repository.NewMemoryTrainingRepository()
+	files := &deletableFiles{metadata: make(map[primitive.ObjectID]repository.FileMetadata)}
+	usage := &limitedUsage{}
+	s := &trainingService{
+		transactions: repository.NewMemoryTransactions(),
+		repo:         repo,
+		files:        files,
+		revisions:    noRevisions{},
+		usage:        NewUsageService(usage, QuotaPolicy{}),
+	}
+	fileId := primitive.NewObjectID()
+	key := naming.StorageKey(fileId, ".txt")
+	_, err := repo.SaveFile(ctx, botId.Hex(), projectId.Hex(), key, repotest.FileHeader(t, "a.txt", []byte("hello")), nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	_, err = repo.InsertOne(ctx, &models.TrainingData{BotId: botId, ProjectId: projectId, Files: []models.Files{{FileId: fileId, FileName: "a.txt", Extension: ".txt"}}})
+	if err != nil {
+		t.Fatal(err)
+	}
+	files.metadata[fileId] = repository.FileMetadata{FileId: fileId, Extension: ".txt", Size: 5}
+	usage.bytes, usage.files = 5, 1
+
+	s.repo = conflictingRepository{repo}
+	err = s.DeleteFile(ctx, botId.Hex(), projectId.Hex(), fileId)
+	if AsError(err).Kind != KindConflict {
+		t.Fatalf("err = %v, want a conflict", err)
+	}
+	_, _, err = repo.OpenFile(ctx, botId.Hex(), projectId.Hex(), key, nil)
+	if err != nil {
+		t.Fatalf("content removed by a failed delete: %v", err)
+	}
+	if _, ok := files.metadata[fileId]; !ok || usage.bytes != 5 {
+		t.Fatalf("records changed by a failed delete, usage %d bytes", usage.bytes)
+	}
+
+	s.repo = repo
+	err = s.DeleteFile(ctx, botId.Hex(), projectId.Hex(), fileId)
+	if err != nil {
+		t.Fatal(err)
+	}
+	_, _, err = repo.OpenFile(ctx, botId.Hex(), projectId.Hex(), key, nil)
+	if !errors.Is(err, os.ErrNotExist) {
+		t.Fatalf("err = %v, want the content removed", err)
+	}
+	if usage.bytes != 0 || usage.files != 0 {
+		t.Fatalf("usage is %d bytes in %d files after the delete, want none", usage.bytes, usage.files)
+	}
+}
+
+type failingDeletes struct {
+	IUsageService
+}
+
+func (failingDeletes) RecordDelete(ctx context.Context, projectId primitive.ObjectID, botId primitive.ObjectID, bytes int64, files int64) error {
+	return errors.New("usage unavailable")
+}
+
+// A reset that cannot give the usage back resets nothing.
+func TestResetTrainingDataCountsInItsTransaction(t *testing.T) {
+	ctx := context.WithValue(context.Background(), "UserId", primitive.NewObjectID())
+	botId, projectId := primitive.NewObjectID(), primitive.NewObjectID()
+	repo := repository.NewMemoryTrainingRepository()
+	files := &deletableFiles{metadata: make(map[primitive.ObjectID]repository.FileMetadata)}
+	s := &trainingService{
+		transactions: repository.NewMemoryTransactions(),
+		repo:         repo,
+		files:        files,
+		revisions:    noRevisions{},
+		usage:        failingDeletes{},
+	}
+	fileId := primitive.NewObjectID()
+	key := naming.StorageKey(fileId, ".txt")
+	_, err := repo.SaveFile(ctx, botId.Hex(), projectId.Hex(), key, repotest.FileHeader(t, "a.txt", []byte("hello")), nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	_, err = repo.InsertOne(ctx, &models.TrainingData{BotId: botId, ProjectId: projectId, Files: []models.Files{{FileId: fileId, FileName: "a.txt", Extension: ".txt"}}})
+	if err != nil {
+		t.Fatal(err)
+	}
+	files.metadata[fileId] = repository.FileMetadata{FileId: fileId, Extension: ".txt", Size: 5}
+
+	_, err = s.ResetTrainingData(ctx, botId, projectId, repository.AnyVersion)
+	if err == nil {
+		t.Fatal("reset succeeded without recording the usage")
+	}
+	_, err = repo.FindOneByBotId(ctx, botId, projectId)
+	if err != nil {
+		t.Fatalf("training data lost by a failed reset: %v", err)
+	}
+	_, _, err = repo.OpenFile(ctx, botId.Hex(), projectId.Hex(), key, nil)
+	if err != nil {
+		t.Fatalf("content removed by a failed reset: %v", err)
+	}
+}
@@ -2,63 +2,130 @@ package core
 
 import (
 	"context"
-	"fmt"
+	"errors"
 	"github.com/draco121/horizon/models"
 	"github.com/draco121/horizon/utils"
 	"go.mongodb.org/mongo-driver/bson/primitive"
 	"go.mongodb.org/mongo-driver/mongo"
+	"io"
 	"mime/multipart"
+	"os"
 	"path"
+	"pulse/api"
+	"pulse/archive"
+	"pulse/metrics"
+	"pulse/naming"
 	"pulse/repository"
+	"pulse/scanner"
 	"slices"
+	"time"
 )
 
 type ITrainingService interface {
-	UploadTrainingFiles(ctx context.Context, botId string, projectId string, files []*multipart.FileHeader) error
+	UploadTrainingFiles(ctx context.Context, botId string, projectId string, files []*multipart.FileHeader, options UploadOptions) (*UploadResult, error)
 	DeleteFile(ctx context.Context, botId string, projectId string, fileId primitive.ObjectID) error
-	GetFile(ctx context.Context, botId string, projectId string, fileId primitive.ObjectID) (string, string, error)
-	AddTrainingData(ctx context.Context, trainingData *models.TrainingData) (*models.TrainingData, error)
-	GetTrainingData(ctx context.Context, botId primitive.ObjectID, projectId primitive.ObjectID) (*models.TrainingData, error)
-	UpdateTrainingData(ctx context.Context, trainingData *models.TrainingData) (*models.TrainingData, error)
-	ResetTrainingData(ctx context.Context, botId primitive.ObjectID, projectId primitive.ObjectID) (*models.TrainingData, error)
+	GetFile(ctx context.Context, botId string, projectId string, fileId primitive.ObjectID) (*FileContent, error)
+	// ListFiles lists the metadata of the files of a bot matching filter.
+	ListFiles(ctx context.Context, botId primitive.ObjectID, projectId primitive.ObjectID, filter repository.FileFilter) ([]repository.FileMetadata, error)
+	// MoveFiles and TagFiles change the folder, tags and labels of several
+	// files of a bot at once.
+	MoveFiles(ctx context.Context, botId primitive.ObjectID, projectId primitive.ObjectID, fileIds []primitive.ObjectID, folder string) ([]repository.FileMetadata, error)
+	TagFiles(ctx context.Context, botId primitive.ObjectID, projectId primitive.ObjectID, fileIds []primitive.ObjectID, change repository.TagChange) ([]repository.FileMetadata, error)
+	// ArchiveFiles lists the files of a bot, or only fileIds when given, as
+	// archive entries headed by a manifest.
+	ArchiveFiles(ctx context.Context, botId string, projectId string, fileIds []primitive.ObjectID) ([]archive.Entry, error)
+	// ReplaceFile stores new content under an existing file id, keeping the
+	// previous content as a revision. A non empty checksum must match the
+	// current content.
+	ReplaceFile(ctx context.Context, botId primitive.ObjectID, projectId primitive.ObjectID, fileId primitive.ObjectID, file *multipart.FileHeader, checksum string) (*repository.FileMetadata, error)
+	// ListRevisions lists the current revision of a file followed by its
+	// history, newest first.
+	ListRevisions(ctx context.Context, botId primitive.ObjectID, projectId primitive.ObjectID, fileId primitive.ObjectID) ([]repository.FileRevision, error)
+	GetRevision(ctx context.Context, botId primitive.ObjectID, projectId primitive.ObjectID, fileId primitive.ObjectID, revision int64) (*FileContent, error)
+	// RunBatch runs delete, move, tag and restore operations over files of a
+	// bot, all or nothing when atomic, and reports the outcome per file.
+	RunBatch(ctx context.Context, botId primitive.ObjectID, projectId primitive.ObjectID, operations []BatchOperation, atomic bool) ([]BatchItem, error)
+	AddTrainingData(ctx context.Context, trainingData *models.TrainingData) (*repository.VersionedTrainingData, error)
+	GetTrainingData(ctx context.Context, botId primitive.ObjectID, projectId primitive.ObjectID) (*repository.VersionedTrainingData, error)
+	// UpdateTrainingData and ResetTrainingData only apply when the stored
+	// version still equals version, or always with repository.AnyVersion.
+	UpdateTrainingData(ctx context.Context, trainingData *models.TrainingData, version int64) (*repository.VersionedTrainingData, error)
+	ResetTrainingData(ctx context.Context, botId primitive.ObjectID, projectId primitive.ObjectID, version int64) (*repository.VersionedTrainingData, error)
+	// PatchTrainingData applies a JSON Merge Patch or JSON Patch, named by its
+	// media type, under the same version check.
+	PatchTrainingData(ctx context.Context, botId primitive.ObjectID, projectId primitive.ObjectID, version int64, patchType string, patch []byte) (*repository.VersionedTrainingData, error)
+}
+
+type UploadResult = api.UploadResult
+
+// FileContent is a stored file opened for download. Content must be closed
+// by the caller.
+type FileContent struct {
+	FileName string
+	MimeType string
+	Size     int64
+	Checksum string
+	ModTime  time.Time
+	Content  io.ReadSeekCloser
 }
 
 type trainingService struct {
-	client *mongo.Client
-	repo   repository.ITrainingRepository
+	// transactions hold the writes of each method together
+	transactions repository.ITransactions
+	repo         repository.ITrainingRepository
+	files        repository.IFileRepository
+	// revisions records the replaced contents of files
+	revisions repository.IRevisionRepository
+	usage     IUsageService
+	policy    IPolicyService
+	scanner   scanner.IScanner
+	keys      IKeyService
+	// archiveLimits bound the archives expanded by uploads
+	archiveLimits archive.Limits
 }
 
-func NewTrainingService(client *mongo.Client, repo repository.ITrainingRepository) ITrainingService {
+func NewTrainingService(transactions repository.ITransactions, repo repository.ITrainingRepository, files repository.IFileRepository, revisions repository.IRevisionRepository, usage IUsageService, policy IPolicyService, scanner scanner.IScanner, keys IKeyService, archiveLimits archive.Limits) ITrainingService {
 	return &trainingService{
-		client: client,
-		repo:   repo,
+		transactions:  transactions,
+		repo:          repo,
+		files:         files,
+		revisions:     revisions,
+		usage:         usage,
+		policy:        policy,
+		scanner:       scanner,
+		keys:          keys,
+		archiveLimits: archiveLimits,
 	}
 }
 
-func (s *trainingService) UploadTrainingFiles(ctx context.Context, botId string, projectId string, files []*multipart.FileHeader) error {
-	mongoSession, err := s.client.StartSession()
-	if err != nil {
-		utils.Logger.Error("failed to start mongo mongoSession", "error: ", err.Error())
-		return err
-	}
-	defer mongoSession.EndSession(ctx)
-	err = mongoSession.StartTransaction()
+func (s *trainingService) UploadTrainingFiles(ctx context.Context, botId string, projectId string, files []*multipart.FileHeader, options UploadOptions) (*UploadResult, error) {
+	metrics.UploadsInFlight.Inc()
+	defer metrics.UploadsInFlight.Dec()
+	// quarantined files are kept when the transaction is discarded
+	requestCtx := ctx
+	ctx, tx, err := s.transactions.Begin(ctx)
 	if err != nil {
-		utils.Logger.Error("failed to start mongo transaction", "error: ", err.Error())
-		return err
+		utils.Logger.Error("failed to start transaction", "error: ", err.Error())
+		return nil, err
 	}
+	defer tx.End(ctx)
 	bid, err := primitive.ObjectIDFromHex(botId)
 	if err != nil {
 		utils.Logger.Error("unable to fetch bot training data wrong bot id error: ", err.Error())
-		return err
+		return nil, InvalidField("botId", "must be a valid id")
 	}
 	pid, err := primitive.ObjectIDFromHex(projectId)
 	if err != nil {
 		utils.Logger.Error("unable to fetch bot training data wrong project id error: ", err.Error())
-		return err
+		return nil, InvalidField("projectId", "must be a valid id")
 	}
-	trainingData, err := s.repo.FindOneByBotId(ctx, bid, pid)
-	if err != nil {
+	var trainingData *models.TrainingData
+	version := int64(0)
+	stored, err := s.repo.FindOneByBotId(ctx, bid, pid)
+	exists := err == nil
+	if exists {
+		trainingData, version = &stored.TrainingData, stored.Version
+	} else {
 		utils.Logger.Info("failed to find training data by bot id error: ", err.Error())
 		utils.Logger.Info("creating new training data by bot id")
 		trainingData = &models.TrainingData{
@@ -66,254 +133,397 @@ func (s *trainingService) UploadTrainingFiles(ctx context.Context, botId string,
 			ProjectId: pid,
 			ID:        primitive.NewObjectID(),
 		}
-		var filesData []models.Files
-		for _, file := range files {
-			f := models.Files{
-				FileId:    primitive.NewObjectID(),
-				FileName:  file.Filename,
-				Extension: path.Ext(file.Filename),
-			}
-			file.Filename = f.FileId.Hex() + f.Extension
-			err = s.repo.SaveFile(ctx, botId, projectId, file)
-			if err != nil {
-				utils.Logger.Error("could not save file error ", err.Error())
-				return err
-			}
-			filesData = append(filesData, f)
+	}
+	folder, err := naming.NormalizeFolder(options.Folder)
+	if err != nil {
+		return nil, InvalidField("folder", err.Error())
+	}
+	expanded := &expansion{files: files}
+	if options.ExtractArchives {
+		expanded = s.expandArchives(files)
+		defer expanded.Close()
+	}
+	accepted, rejected, err := s.policy.Evaluate(ctx, pid, len(trainingData.Files), expanded.files)
+	if err != nil {
+		return nil, err
+	}
+	accepted, infected, failed := s.screenFiles(ctx, accepted)
+	result := &UploadResult{
+		Files:    make([]models.Files, 0, len(accepted)),
+		Rejected: append(append(expanded.rejected, rejected...), failed...),
+		Skipped:  expanded.skipped,
+	}
+	// infected files are quarantined however the upload ends, outside of its
+	// transaction so that a failed upload does not discard them
+	defer func() {
+		result.Rejected = append(result.Rejected, s.quarantineFiles(requestCtx, bid, pid, infected)...)
+	}()
+	if len(accepted) == 0 {
+		metrics.RecordUpload(0, 0, len(result.Rejected)+len(infected), len(result.Skipped))
+		return result, nil
+	}
+	var uploadSize int64
+	for _, file := range accepted {
+		uploadSize += file.Header.Size
+	}
+	err = s.usage.CheckUpload(ctx, pid, bid, uploadSize, int64(len(accepted)))
+	if err != nil {
+		return nil, err
+	}
+	dataKey, err := s.keys.DataKey(ctx, pid, true)
+	if err != nil {
+		return nil, err
+	}
+	var saved []models.Files
+	for _, file := range accepted {
+		f := models.Files{
+			FileId:    primitive.NewObjectID(),
+			FileName:  file.FileName,
+			Extension: file.Extension,
 		}
-		trainingData.Files = filesData
-		_, err = s.repo.InsertOne(ctx, trainingData)
+		checksum, err := s.repo.SaveFile(ctx, botId, projectId, naming.StorageKey(f.FileId, f.Extension), file.Header, dataKey)
 		if err != nil {
-			utils.Logger.Error("failed to create training data error ", err.Error())
-			return err
-		} else {
-			utils.Logger.Info("created training data successfully")
-			_ = mongoSession.CommitTransaction(ctx)
-			return nil
+			utils.Logger.Error("could not save file error ", err.Error())
+			s.discardSaved(ctx, botId, projectId, saved)
+			return nil, err
 		}
-	} else {
-		var filesData []models.Files
-		for _, file := range files {
-			f := models.Files{
-				FileId:    primitive.NewObjectID(),
-				FileName:  file.Filename,
-				Extension: path.Ext(file.Filename),
-			}
-			file.Filename = f.FileId.Hex() + f.Extension
-			err = s.repo.SaveFile(ctx, botId, projectId, file)
-			if err != nil {
-				utils.Logger.Error("could not save file error ", err.Error())
-				return err
-			}
-			filesData = append(filesData, f)
+		saved = append(saved, f)
+		_, err = s.files.InsertOne(ctx, &repository.FileMetadata{
+			FileId:        f.FileId,
+			ProjectId:     pid,
+			BotId:         bid,
+			FileName:      f.FileName,
+			Extension:     f.Extension,
+			Folder:        path.Join(folder, expanded.folders[file.Header]),
+			MimeType:      file.MimeType,
+			Size:          file.Header.Size,
+			Checksum:      checksum,
+			ScanStatus:    file.Scan.Status,
+			ScanSignature: file.Scan.Signature,
+			ScannedAt:     file.Scan.ScannedAt,
+			Encrypted:     dataKey != nil,
+			Revision:      1,
+		})
+		if err != nil {
+			utils.Logger.Error("could not save file metadata error ", err.Error())
+			s.discardSaved(ctx, botId, projectId, saved)
+			return nil, err
 		}
-		trainingData.Files = filesData
+		trainingData.Files = append(trainingData.Files, f)
+		result.Files = append(result.Files, f)
+	}
+	// counted before the training data lists the files, so that an upload
+	// crossing the quota leaves nothing behind
+	err = s.usage.RecordUpload(ctx, pid, bid, uploadSize, int64(len(accepted)))
+	if err != nil {
+		s.discardSaved(ctx, botId, projectId, saved)
+		return nil, err
+	}
+	if exists {
+		_, err = s.repo.UpdateOne(ctx, trainingData, version)
+	} else {
 		_, err = s.repo.InsertOne(ctx, trainingData)
-		if err != nil {
-			utils.Logger.Error("failed to create training data error ", err.Error())
-			return err
-		} else {
-			utils.Logger.Info("created training data successfully")
-			_ = mongoSession.CommitTransaction(ctx)
-			return nil
+	}
+	if errors.Is(err, repository.ErrVersionMismatch) || errors.Is(err, repository.ErrRecordExists) {
+		utils.Logger.Warn("training data changed during upload")
+		s.discardSaved(ctx, botId, projectId, saved)
+		return nil, Conflict("training data of the bot changed during the upload, retry the upload")
+	} else if err != nil {
+		utils.Logger.Error("failed to save training data error ", err.Error())
+		s.discardSaved(ctx, botId, projectId, saved)
+		return nil, err
+	}
+	err = tx.Commit(ctx)
+	if err != nil {
+		utils.Logger.Error("failed to commit upload, error ", err.Error())
+		s.discardSaved(ctx, botId, projectId, saved)
+		return nil, err
+	}
+	metrics.RecordUpload(uploadSize, len(accepted), len(result.Rejected)+len(infected), len(result.Skipped))
+	utils.Logger.Info("saved training data successfully")
+	return result, nil
+}
+
+// discardSaved removes the files an upload stored before it failed, their
+// metadata and usage are discarded with its transaction. It does not use the
+// cancellation of ctx, since an upload aborted by a client or by shutdown is
+// the usual way to get here.
+func (s *trainingService) discardSaved(ctx context.Context, botId string, projectId string, saved []models.Files) {
+	ctx = context.WithoutCancel(ctx)
+	for _, f := range saved {
+		err := s.repo.RemoveFile(ctx, botId, projectId, naming.StorageKey(f.FileId, f.Extension))
+		if err != nil && !errors.Is(err, os.ErrNotExist) {
+			utils.Logger.Error("could not remove file of failed upload error ", err.Error())
 		}
 	}
 }
 
-func (s *trainingService) DeleteFile(ctx context.Context, botId string, projectId string, fileId primitive.ObjectID) error {
-	mongoSession, err := s.client.StartSession()
+// releaseUsage gives back the usage counted for files that were discarded
+// after all while the transaction goes on. Like discardSaved it does not use
+// the cancellation of ctx.
+func (s *trainingService) releaseUsage(ctx context.Context, projectId primitive.ObjectID, botId primitive.ObjectID, bytes int64, files int64) {
+	err := s.usage.RecordDelete(context.WithoutCancel(ctx), projectId, botId, bytes, files)
 	if err != nil {
-		utils.Logger.Error("failed to start mongo mongoSession", "error: ", err.Error())
-		return err
+		utils.Logger.Error("could not release usage of discarded files error ", err.Error())
 	}
-	defer mongoSession.EndSession(ctx)
-	err = mongoSession.StartTransaction()
+}
+
+func (s *trainingService) DeleteFile(ctx context.Context, botId string, projectId string, fileId primitive.ObjectID) error {
+	ctx, tx, err := s.transactions.Begin(ctx)
 	if err != nil {
-		utils.Logger.Error("failed to start mongo transaction", "error: ", err.Error())
+		utils.Logger.Error("failed to start transaction", "error: ", err.Error())
 		return err
 	}
+	defer tx.End(ctx)
 	bid, err := primitive.ObjectIDFromHex(botId)
 	if err != nil {
 		utils.Logger.Error("unable to fetch bot training data wrong bot id error: ", err.Error())
-		return err
+		return InvalidField("botId", "must be a valid id")
 	}
 	pid, err := primitive.ObjectIDFromHex(projectId)
 	if err != nil {
 		utils.Logger.Error("unable to fetch bot training data wrong project id error: ", err.Error())
-		return err
+		return InvalidField("projectId", "must be a valid id")
 	}
 	trainingData, err := s.repo.FindOneByBotId(ctx, bid, pid)
 	if err != nil {
 		utils.Logger.Error("no training data found error ", err.Error())
 		return err
+	}
+	if len(trainingData.Files) <= 0 {
+		return NotFound("no files are uploaded yet")
+	}
+	i := slices.IndexFunc(trainingData.Files, func(f models.Files) bool { return f.FileId == fileId })
+	if i < 0 {
+		utils.Logger.Debug("file not found")
+		return NotFound("file %s not found", fileId.Hex())
+	}
+	file := trainingData.Files[i]
+	trainingData.Files = slices.Delete(trainingData.Files, i, i+1)
+	_, err = s.repo.UpdateOne(ctx, &trainingData.TrainingData, trainingData.Version)
+	if errors.Is(err, repository.ErrVersionMismatch) {
+		return Conflict("training data of the bot changed while deleting the file, retry the delete")
+	} else if err != nil {
+		utils.Logger.Error("failed to update training data after deleting file, error ", err.Error())
+		return err
+	}
+	deleted, err := s.forgetFile(ctx, fileId)
+	if errors.Is(err, mongo.ErrNoDocuments) {
+		// files stored before metadata was recorded were never counted
+		deleted = deletedFile{metadata: &repository.FileMetadata{FileId: fileId, Extension: file.Extension}}
+	} else if err != nil {
+		utils.Logger.Error("failed to delete records of file ", fileId.Hex(), ": ", err.Error())
+		return err
 	} else {
-		if len(trainingData.Files) <= 0 {
-			return fmt.Errorf("no files are uploaded yet")
-		} else {
-			for i, file := range trainingData.Files {
-				if file.FileId == fileId {
-					err = s.repo.DeleteFile(ctx, botId, projectId, fileId)
-					if err != nil {
-						utils.Logger.Error("failed to delete file error ", err.Error())
-						return err
-					} else {
-						utils.Logger.Info("file deleted successfully")
-						trainingData.Files = slices.Delete(trainingData.Files, i, i+1)
-						_, err = s.repo.UpdateOne(ctx, trainingData)
-						if err != nil {
-							utils.Logger.Error("failed to update training data after deleting file, error ", err.Error())
-						} else {
-							_ = mongoSession.CommitTransaction(ctx)
-							utils.Logger.Info("file deleted successfully")
-							return nil
-						}
-					}
-				}
-			}
+		err = s.usage.RecordDelete(ctx, pid, bid, deleted.size(), 1)
+		if err != nil {
+			return err
 		}
-		utils.Logger.Debug("file not found")
-		return fmt.Errorf("file not found with given Id")
 	}
-}
-
-func (s *trainingService) GetFile(ctx context.Context, botId string, projectId string, fileId primitive.ObjectID) (string, string, error) {
-	mongoSession, err := s.client.StartSession()
+	err = tx.Commit(ctx)
 	if err != nil {
-		utils.Logger.Error("failed to start mongo mongoSession", "error: ", err.Error())
-		return "", "", err
+		utils.Logger.Error("failed to commit file delete, error ", err.Error())
+		return err
 	}
-	defer mongoSession.EndSession(ctx)
-	err = mongoSession.StartTransaction()
+	// the content goes once nothing refers to it, a delete that does not
+	// commit loses nothing
+	s.removeContent(context.WithoutCancel(ctx), botId, projectId, deleted)
+	utils.Logger.Info("file deleted successfully")
+	return nil
+}
+
+func (s *trainingService) GetFile(ctx context.Context, botId string, projectId string, fileId primitive.ObjectID) (*FileContent, error) {
+	ctx, tx, err := s.transactions.Begin(ctx)
 	if err != nil {
-		utils.Logger.Error("failed to start mongo transaction", "error: ", err.Error())
-		return "", "", err
+		utils.Logger.Error("failed to start transaction", "error: ", err.Error())
+		return nil, err
 	}
+	defer tx.End(ctx)
 	bid, err := primitive.ObjectIDFromHex(botId)
 	if err != nil {
 		utils.Logger.Error("unable to fetch bot training data wrong bot id error: ", err.Error())
-		return "", "", err
+		return nil, InvalidField("botId", "must be a valid id")
 	}
 	pid, err := primitive.ObjectIDFromHex(projectId)
 	if err != nil {
 		utils.Logger.Error("unable to fetch bot training data wrong project id error: ", err.Error())
-		return "", "", err
+		return nil, InvalidField("projectId", "must be a valid id")
+	}
+	metadata, err := s.files.FindOne(ctx, fileId)
+	if err == nil && metadata.Quarantined {
+		utils.Logger.Warn("refusing to serve quarantined file ", fileId.Hex())
+		return nil, ErrFileQuarantined
+	}
+	// files uploaded before checksums were recorded never change, their id
+	// identifies the content and holds its creation time
+	checksum, mimeType, modTime := fileId.Hex(), "", fileId.Timestamp()
+	if err == nil {
+		mimeType = metadata.MimeType
+		if metadata.Checksum != "" {
+			checksum = metadata.Checksum
+		}
+		if !metadata.UpdatedAt.IsZero() {
+			modTime = metadata.UpdatedAt
+		} else if !metadata.CreatedAt.IsZero() {
+			modTime = metadata.CreatedAt
+		}
 	}
 	trainingData, err := s.repo.FindOneByBotId(ctx, bid, pid)
 	if err != nil {
 		utils.Logger.Error("no training data found error ", err.Error())
-		return "", "", err
+		return nil, err
 	} else {
 		if len(trainingData.Files) <= 0 {
-			return "", "", fmt.Errorf("no files are uploaded yet")
+			return nil, NotFound("no files are uploaded yet")
 		} else {
 			for _, file := range trainingData.Files {
 				if file.FileId == fileId {
-					botSpace, err := utils.CreateBotSpace(botId, projectId)
+					dataKey, err := s.keys.DataKey(ctx, pid, false)
 					if err != nil {
-						utils.Logger.Error("unable to access bot storage space")
-						return "", "", err
+						return nil, err
+					}
+					content, size, err := s.repo.OpenFile(ctx, botId, projectId, naming.StorageKey(file.FileId, file.Extension), dataKey)
+					if err != nil {
+						utils.Logger.Error("unable to open file from bot storage space")
+						return nil, err
 					} else {
-						filePath := path.Join(botSpace, file.FileId.Hex()+file.Extension)
-						_ = mongoSession.CommitTransaction(ctx)
+						err = tx.Commit(ctx)
+						if err != nil {
+							utils.Logger.Error("failed to commit transaction", "error: ", err.Error())
+							return nil, err
+						}
 						utils.Logger.Info("successfully fetched the file details")
-						return file.FileName, filePath, nil
+						return &FileContent{FileName: file.FileName, MimeType: mimeType, Size: size, Checksum: checksum, ModTime: modTime, Content: content}, nil
 					}
 				}
 			}
 		}
 		utils.Logger.Debug("file not found")
-		return "", "", fmt.Errorf("file not found with given Id")
+		return nil, NotFound("file %s not found", fileId.Hex())
 	}
 }
 
-func (s *trainingService) AddTrainingData(ctx context.Context, trainingData *models.TrainingData) (*models.TrainingData, error) {
-	mongoSession, err := s.client.StartSession()
+func (s *trainingService) AddTrainingData(ctx context.Context, trainingData *models.TrainingData) (*repository.VersionedTrainingData, error) {
+	ctx, tx, err := s.transactions.Begin(ctx)
 	if err != nil {
-		utils.Logger.Error("failed to start mongo mongoSession", "error: ", err.Error())
-		return nil, err
-	}
-	defer mongoSession.EndSession(ctx)
-	err = mongoSession.StartTransaction()
-	if err != nil {
-		utils.Logger.Error("failed to start mongo transaction", "error: ", err.Error())
+		utils.Logger.Error("failed to start transaction", "error: ", err.Error())
 		return nil, err
 	}
+	defer tx.End(ctx)
 	td, err := s.repo.InsertOne(ctx, trainingData)
 	if err != nil {
 		utils.Logger.Error("failed to insert training data into db", "error: ", err.Error())
 		return nil, err
 	} else {
-		_ = mongoSession.CommitTransaction(ctx)
+		err = tx.Commit(ctx)
+		if err != nil {
+			utils.Logger.Error("failed to commit transaction", "error: ", err.Error())
+			return nil, err
+		}
 		utils.Logger.Info("successfully inserted training data into db")
 		return td, nil
 	}
 }
 
-func (s *trainingService) GetTrainingData(ctx context.Context, botId primitive.ObjectID, projectId primitive.ObjectID) (*models.TrainingData, error) {
-	mongoSession, err := s.client.StartSession()
-	if err != nil {
-		utils.Logger.Error("failed to start mongo mongoSession", "error: ", err.Error())
-		return nil, err
-	}
-	defer mongoSession.EndSession(ctx)
-	err = mongoSession.StartTransaction()
+func (s *trainingService) GetTrainingData(ctx context.Context, botId primitive.ObjectID, projectId primitive.ObjectID) (*repository.VersionedTrainingData, error) {
+	ctx, tx, err := s.transactions.Begin(ctx)
 	if err != nil {
-		utils.Logger.Error("failed to start mongo transaction", "error: ", err.Error())
+		utils.Logger.Error("failed to start transaction", "error: ", err.Error())
 		return nil, err
 	}
+	defer tx.End(ctx)
 	td, err := s.repo.FindOneByBotId(ctx, botId, projectId)
 	if err != nil {
 		utils.Logger.Error("failed to fetch training data from db", "error: ", err.Error())
 		return nil, err
 	} else {
-		_ = mongoSession.CommitTransaction(ctx)
+		err = tx.Commit(ctx)
+		if err != nil {
+			utils.Logger.Error("failed to commit transaction", "error: ", err.Error())
+			return nil, err
+		}
 		utils.Logger.Info("successfully inserted training data into db")
 		return td, nil
 	}
 }
 
-func (s *trainingService) UpdateTrainingData(ctx context.Context, trainingData *models.TrainingData) (*models.TrainingData, error) {
-	mongoSession, err := s.client.StartSession()
-	if err != nil {
-		utils.Logger.Error("failed to start mongo mongoSession", "error: ", err.Error())
-		return nil, err
-	}
-	defer mongoSession.EndSession(ctx)
-	err = mongoSession.StartTransaction()
+func (s *trainingService) UpdateTrainingData(ctx context.Context, trainingData *models.TrainingData, version int64) (*repository.VersionedTrainingData, error) {
+	ctx, tx, err := s.transactions.Begin(ctx)
 	if err != nil {
-		utils.Logger.Error("failed to start mongo transaction", "error: ", err.Error())
+		utils.Logger.Error("failed to start transaction", "error: ", err.Error())
 		return nil, err
 	}
-	td, err := s.repo.UpdateOne(ctx, trainingData)
+	defer tx.End(ctx)
+	td, err := s.repo.UpdateOne(ctx, trainingData, version)
 	if err != nil {
 		utils.Logger.Error("failed to update training data from db", "error: ", err.Error())
 		return nil, err
 	} else {
-		_ = mongoSession.CommitTransaction(ctx)
+		err = tx.Commit(ctx)
+		if err != nil {
+			utils.Logger.Error("failed to commit transaction", "error: ", err.Error())
+			return nil, err
+		}
 		utils.Logger.Info("successfully updated training data into db")
 		return td, nil
 	}
 }
 
-func (s *trainingService) ResetTrainingData(ctx context.Context, botId primitive.ObjectID, projectId primitive.ObjectID) (*models.TrainingData, error) {
-	mongoSession, err := s.client.StartSession()
+func (s *trainingService) ResetTrainingData(ctx context.Context, botId primitive.ObjectID, projectId primitive.ObjectID, version int64) (*repository.VersionedTrainingData, error) {
+	ctx, tx, err := s.transactions.Begin(ctx)
 	if err != nil {
-		utils.Logger.Error("failed to start mongo mongoSession", "error: ", err.Error())
+		utils.Logger.Error("failed to start transaction", "error: ", err.Error())
 		return nil, err
 	}
-	defer mongoSession.EndSession(ctx)
-	err = mongoSession.StartTransaction()
+	defer tx.End(ctx)
+	td, err := s.repo.DeleteOneByBotId(ctx, botId, projectId, version)
 	if err != nil {
-		utils.Logger.Error("failed to start mongo transaction", "error: ", err.Error())
+		utils.Logger.Error("failed to delete training data from db", "error: ", err.Error())
 		return nil, err
 	}
-	td, err := s.repo.DeleteOneByBotId(ctx, botId, projectId)
+	deleted, err := s.forgetResetFiles(ctx, botId, projectId, td.Files)
 	if err != nil {
-		utils.Logger.Error("failed to delete training data from db", "error: ", err.Error())
 		return nil, err
-	} else {
-		_ = mongoSession.CommitTransaction(ctx)
-		utils.Logger.Info("successfully deleted training data into db")
-		return td, nil
 	}
+	err = tx.Commit(ctx)
+	if err != nil {
+		utils.Logger.Error("failed to commit transaction", "error: ", err.Error())
+		return nil, err
+	}
+	utils.Logger.Info("successfully deleted training data into db")
+	ctx = context.WithoutCancel(ctx)
+	for _, file := range deleted {
+		s.removeContent(ctx, botId.Hex(), projectId.Hex(), file)
+	}
+	return td, nil
+}
+
+// forgetResetFiles deletes the records of the files of reset training data
+// and gives their usage back, leaving their content to removeContent. Files
+// stored before metadata was recorded were never counted.
+func (s *trainingService) forgetResetFiles(ctx context.Context, botId primitive.ObjectID, projectId primitive.ObjectID, files []models.Files) ([]deletedFile, error) {
+	deleted := make([]deletedFile, 0, len(files))
+	var size, count int64
+	for _, file := range files {
+		forgotten, err := s.forgetFile(ctx, file.FileId)
+		if errors.Is(err, mongo.ErrNoDocuments) {
+			deleted = append(deleted, deletedFile{metadata: &repository.FileMetadata{FileId: file.FileId, Extension: file.Extension}})
+			continue
+		} else if err != nil {
+			utils.Logger.Error("failed to delete records of reset file ", file.FileId.Hex(), ": ", err.Error())
+			return nil, err
+		}
+		deleted = append(deleted, forgotten)
+		size += forgotten.size()
+		count++
+	}
+	if count == 0 {
+		return deleted, nil
+	}
+	err := s.usage.RecordDelete(ctx, projectId, botId, size, count)
+	if err != nil {
+		utils.Logger.Error("failed to record reset files: ", err.Error())
+		return nil, err
+	}
+	return deleted, nil
 }
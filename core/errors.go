@@ -0,0 +1,116 @@
+package core
+
+import (
+	"errors"
+	"fmt"
+	"go.mongodb.org/mongo-driver/mongo"
+	"os"
+	"pulse/api"
+	"pulse/naming"
+	"pulse/repository"
+)
+
+type ErrorKind = api.ErrorKind
+
+const (
+	KindNotFound             = api.KindNotFound
+	KindConflict             = api.KindConflict
+	KindValidation           = api.KindValidation
+	KindForbidden            = api.KindForbidden
+	KindQuota                = api.KindQuota
+	KindTooLarge             = api.KindTooLarge
+	KindUnsupportedMediaType = api.KindUnsupportedMediaType
+	KindUnprocessable        = api.KindUnprocessable
+	KindPrecondition         = api.KindPrecondition
+	KindPreconditionRequired = api.KindPreconditionRequired
+	KindFailedDependency     = api.KindFailedDependency
+	KindInternal             = api.KindInternal
+)
+
+type FieldError = api.FieldError
+
+// Error is the typed error returned by the services. Message is safe to show
+// to clients, the wrapped error is only logged.
+type Error struct {
+	Kind    ErrorKind
+	Message string
+	Fields  []FieldError
+	Err     error
+}
+
+func (e *Error) Error() string {
+	if e.Err != nil {
+		return e.Message + ": " + e.Err.Error()
+	}
+	return e.Message
+}
+
+func (e *Error) Unwrap() error {
+	return e.Err
+}
+
+func newError(kind ErrorKind, err error, format string, args ...any) *Error {
+	return &Error{Kind: kind, Message: fmt.Sprintf(format, args...), Err: err}
+}
+
+func NotFound(format string, args ...any) *Error {
+	return newError(KindNotFound, nil, format, args...)
+}
+
+func Conflict(format string, args ...any) *Error {
+	return newError(KindConflict, nil, format, args...)
+}
+
+func Forbidden(format string, args ...any) *Error {
+	return newError(KindForbidden, nil, format, args...)
+}
+
+func Internal(err error) *Error {
+	return newError(KindInternal, err, "internal server error")
+}
+
+// Validation reports invalid input, optionally attributing it to fields.
+func Validation(message string, fields ...FieldError) *Error {
+	return &Error{Kind: KindValidation, Message: message, Fields: fields}
+}
+
+// InvalidField is a validation error on a single field.
+func InvalidField(field string, message string) *Error {
+	return Validation(field+" "+message, FieldError{Field: field, Message: message})
+}
+
+// AsError classifies any error returned by the services, repositories or the
+// packages they use into a typed Error.
+func AsError(err error) *Error {
+	var typed *Error
+	switch {
+	case err == nil:
+		return nil
+	case errors.As(err, &typed):
+		return typed
+	case errors.Is(err, mongo.ErrNoDocuments), errors.Is(err, os.ErrNotExist):
+		return newError(KindNotFound, err, "resource not found")
+	case errors.Is(err, repository.ErrVersionMismatch):
+		return newError(KindPrecondition, nil, "the resource was modified since it was read")
+	case errors.Is(err, repository.ErrRecordExists), mongo.IsDuplicateKeyError(err):
+		return newError(KindConflict, err, "resource already exists")
+	case repository.IsTransient(err):
+		return newError(KindConflict, err, "the resource was changed by a concurrent request, retry the request")
+	case errors.Is(err, ErrQuotaExceeded):
+		return newError(KindQuota, nil, "%s", err.Error())
+	case errors.Is(err, ErrRequestTooLarge):
+		return newError(KindTooLarge, nil, "%s", err.Error())
+	case errors.Is(err, ErrFileQuarantined):
+		return newError(KindForbidden, nil, "%s", err.Error())
+	case errors.Is(err, ErrIdempotencyKeyReused):
+		return newError(KindUnprocessable, nil, "%s", err.Error())
+	case errors.Is(err, ErrIdempotencyKeyInFlight):
+		return newError(KindConflict, nil, "%s", err.Error())
+	case errors.Is(err, ErrBatchAborted):
+		return newError(KindFailedDependency, nil, "%s", err.Error())
+	case errors.Is(err, naming.ErrInvalidFileName):
+		return newError(KindValidation, nil, "%s", err.Error())
+	default:
+		return Internal(err)
+	}
+}
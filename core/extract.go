@@ -0,0 +1,87 @@
+package core
+
+import (
+	"errors"
+	"github.com/draco121/horizon/utils"
+	"mime/multipart"
+	"pulse/archive"
+)
+
+const (
+	RejectionArchiveTooLarge = "archive_too_large"
+	RejectionArchiveInvalid  = "invalid_archive"
+)
+
+// extractMemory is the part of the expanded archives kept in memory, the rest
+// spills to temporary files.
+const extractMemory = 30 << 20
+
+// UploadOptions change how an upload is processed.
+type UploadOptions struct {
+	// ExtractArchives replaces uploaded zip and tar(.gz) archives by the
+	// files they contain.
+	ExtractArchives bool
+	// Folder is where the files are put, extracted files keep their
+	// folders from the archive below it.
+	Folder string
+}
+
+// expansion is an upload with its archives replaced by their entries.
+type expansion struct {
+	files       []*multipart.FileHeader
+	folders     map[*multipart.FileHeader]string
+	skipped     []FileRejection
+	rejected    []FileRejection
+	extractions []*archive.Extraction
+}
+
+func (e *expansion) Close() {
+	for _, extraction := range e.extractions {
+		err := extraction.Close()
+		if err != nil {
+			utils.Logger.Warn("failed to remove extracted files: ", err.Error())
+		}
+	}
+}
+
+// expandArchives extracts the archives of an upload. Files that are not
+// archives are kept as they are; archives that cannot be read or expand
+// beyond what the archives before them left of the limits are rejected as a
+// whole.
+func (s *trainingService) expandArchives(files []*multipart.FileHeader) *expansion {
+	result := &expansion{
+		files:   make([]*multipart.FileHeader, 0, len(files)),
+		folders: make(map[*multipart.FileHeader]string),
+	}
+	budget := archive.NewBudget(s.archiveLimits)
+	for _, file := range files {
+		format, err := archive.Detect(file)
+		if err != nil || format == "" {
+			// unreadable files are reported by the policy like any other
+			result.files = append(result.files, file)
+			continue
+		}
+		extraction, err := archive.Extract(file, format, budget, extractMemory)
+		if errors.Is(err, archive.ErrArchiveTooLarge) {
+			result.rejected = append(result.rejected, FileRejection{FileName: file.Filename, Code: RejectionArchiveTooLarge, Reason: err.Error()})
+			continue
+		} else if err != nil {
+			result.rejected = append(result.rejected, FileRejection{FileName: file.Filename, Code: RejectionArchiveInvalid, Reason: err.Error()})
+			continue
+		}
+		result.extractions = append(result.extractions, extraction)
+		for _, extracted := range extraction.Files {
+			result.files = append(result.files, extracted.Header)
+			result.folders[extracted.Header] = extracted.Folder
+		}
+		for _, skipped := range extraction.Skipped {
+			result.skipped = append(result.skipped, FileRejection{
+				FileName: skipped.Path,
+				Archive:  file.Filename,
+				Code:     skipped.Code,
+				Reason:   skipped.Reason,
+			})
+		}
+	}
+	return result
+}
@@ -0,0 +1,185 @@
+package core
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/readpref"
+	"pulse/api"
+	"pulse/metrics"
+	"pulse/repository"
+	"sort"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+const (
+	CheckOK      = "ok"
+	CheckFailing = "failing"
+)
+
+const (
+	WorkerRunning  = "running"
+	WorkerFinished = "finished"
+	WorkerFailed   = "failed"
+)
+
+// checkTimeout bounds every dependency check so that a hung dependency fails
+// the probe instead of blocking it.
+const checkTimeout = 2 * time.Second
+
+type DependencyCheck = api.DependencyCheck
+
+type Readiness = api.Readiness
+
+type WorkerState = api.WorkerState
+
+type BuildInfo = api.BuildInfo
+
+type StatusReport = api.StatusReport
+
+type HealthOptions struct {
+	// MinFreeBytes is the free space the storage disk must keep for the
+	// service to be ready, 0 disables the check.
+	MinFreeBytes uint64
+	Build        BuildInfo
+	// Config is the effective configuration with its secrets redacted.
+	Config map[string]any
+}
+
+type IHealthService interface {
+	// Ready probes the dependencies the service needs to take requests.
+	Ready(ctx context.Context) *Readiness
+	Status(ctx context.Context) *StatusReport
+	// BeginShutdown makes the service report not ready from then on.
+	BeginShutdown()
+	// StartWorker records a background job as running and returns the
+	// function to call when it ends.
+	StartWorker(name string) func(err error)
+}
+
+type healthService struct {
+	client       *mongo.Client
+	options      HealthOptions
+	startedAt    time.Time
+	shuttingDown atomic.Bool
+	mutex        sync.Mutex
+	workers      map[string]*WorkerState
+}
+
+func NewHealthService(client *mongo.Client, options HealthOptions) IHealthService {
+	return &healthService{
+		client:    client,
+		options:   options,
+		startedAt: time.Now(),
+		workers:   map[string]*WorkerState{},
+	}
+}
+
+func (s *healthService) Ready(ctx context.Context) *Readiness {
+	readiness := &Readiness{
+		Ready:        !s.shuttingDown.Load(),
+		ShuttingDown: s.shuttingDown.Load(),
+		Checks: []DependencyCheck{
+			runCheck(ctx, "mongodb", s.checkMongo),
+			runCheck(ctx, "storage", s.checkStorage),
+			runCheck(ctx, "disk", s.checkDisk),
+		},
+	}
+	for _, check := range readiness.Checks {
+		if check.Status != CheckOK {
+			readiness.Ready = false
+		}
+	}
+	return readiness
+}
+
+func (s *healthService) Status(ctx context.Context) *StatusReport {
+	return &StatusReport{
+		Build:     s.options.Build,
+		StartedAt: s.startedAt,
+		Uptime:    time.Since(s.startedAt).Round(time.Second).String(),
+		Readiness: *s.Ready(ctx),
+		Config:    s.options.Config,
+		Workers:   s.workerStates(),
+	}
+}
+
+func (s *healthService) BeginShutdown() {
+	s.shuttingDown.Store(true)
+}
+
+func (s *healthService) StartWorker(name string) func(err error) {
+	state := &WorkerState{Name: name, State: WorkerRunning, StartedAt: time.Now()}
+	s.mutex.Lock()
+	s.workers[name] = state
+	s.mutex.Unlock()
+	return func(err error) {
+		s.mutex.Lock()
+		defer s.mutex.Unlock()
+		finishedAt := time.Now()
+		state.FinishedAt = &finishedAt
+		state.State = WorkerFinished
+		if err != nil {
+			state.State = WorkerFailed
+			state.Error = err.Error()
+		}
+	}
+}
+
+func (s *healthService) workerStates() []WorkerState {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	states := make([]WorkerState, 0, len(s.workers))
+	for _, state := range s.workers {
+		states = append(states, *state)
+	}
+	sort.Slice(states, func(i, j int) bool {
+		return states[i].Name < states[j].Name
+	})
+	return states
+}
+
+// runCheck times check and turns its result into a DependencyCheck.
+func runCheck(ctx context.Context, name string, check func(ctx context.Context) (map[string]any, error)) DependencyCheck {
+	ctx, cancel := context.WithTimeout(ctx, checkTimeout)
+	defer cancel()
+	start := time.Now()
+	details, err := check(ctx)
+	result := DependencyCheck{
+		Name:      name,
+		Status:    CheckOK,
+		LatencyMs: float64(time.Since(start).Microseconds()) / 1000,
+		Details:   details,
+	}
+	if err != nil {
+		result.Status = CheckFailing
+		result.Error = err.Error()
+	}
+	return result
+}
+
+func (s *healthService) checkMongo(ctx context.Context) (map[string]any, error) {
+	return nil, s.client.Ping(ctx, readpref.Primary())
+}
+
+func (s *healthService) checkStorage(ctx context.Context) (map[string]any, error) {
+	return map[string]any{"backend": metrics.BackendFilesystem}, repository.ProbeStorage()
+}
+
+func (s *healthService) checkDisk(ctx context.Context) (map[string]any, error) {
+	free, err := repository.StorageFreeBytes()
+	if errors.Is(err, repository.ErrFreeSpaceUnsupported) && s.options.MinFreeBytes == 0 {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	details := map[string]any{"freeBytes": free, "minFreeBytes": s.options.MinFreeBytes}
+	if free < s.options.MinFreeBytes {
+		return details, fmt.Errorf("%d bytes free, below the %d bytes threshold", free, s.options.MinFreeBytes)
+	}
+	return details, nil
+}
@@ -0,0 +1,94 @@
+package core
+
+import (
+	"context"
+	"errors"
+	"github.com/draco121/horizon/utils"
+	"go.mongodb.org/mongo-driver/mongo"
+	"pulse/repository"
+	"time"
+)
+
+var (
+	ErrIdempotencyKeyReused   = errors.New("idempotency key was already used for a different request")
+	ErrIdempotencyKeyInFlight = errors.New("a request with this idempotency key is still being processed")
+)
+
+// idempotencyLockTimeout is how long a pending key blocks retries. A request
+// that has not completed by then is assumed to have died with its server.
+const idempotencyLockTimeout = 10 * time.Minute
+
+type IIdempotencyService interface {
+	// Begin reserves key for the request identified by fingerprint. It returns
+	// the stored record when the same request already completed, and nil when
+	// the request should run.
+	Begin(ctx context.Context, key string, fingerprint string) (*repository.IdempotencyRecord, error)
+	// Complete stores the response to replay for key.
+	Complete(ctx context.Context, key string, status int, headers map[string]string, body []byte) error
+	// Release frees key so that a failed request can be retried.
+	Release(ctx context.Context, key string) error
+}
+
+type idempotencyService struct {
+	repo repository.IIdempotencyRepository
+	ttl  time.Duration
+}
+
+// NewIdempotencyService keeps the responses of requests sent with an
+// Idempotency-Key for ttl.
+func NewIdempotencyService(repo repository.IIdempotencyRepository, ttl time.Duration) IIdempotencyService {
+	return &idempotencyService{
+		repo: repo,
+		ttl:  ttl,
+	}
+}
+
+func (s *idempotencyService) Begin(ctx context.Context, key string, fingerprint string) (*repository.IdempotencyRecord, error) {
+	record, err := s.repo.FindOne(ctx, key)
+	if err != nil && !errors.Is(err, mongo.ErrNoDocuments) {
+		utils.Logger.Error("failed to fetch idempotency key", "error: ", err.Error())
+		return nil, err
+	}
+	if record != nil {
+		if record.Fingerprint != fingerprint {
+			return nil, ErrIdempotencyKeyReused
+		} else if record.Completed {
+			return record, nil
+		} else if time.Since(record.CreatedAt) < idempotencyLockTimeout {
+			return nil, ErrIdempotencyKeyInFlight
+		}
+		utils.Logger.Warn("taking over abandoned idempotency key ", key)
+		err = s.repo.DeleteOne(ctx, key)
+		if err != nil {
+			return nil, err
+		}
+	}
+	now := time.Now().UTC()
+	err = s.repo.InsertOne(ctx, &repository.IdempotencyRecord{
+		Key:         key,
+		Fingerprint: fingerprint,
+		CreatedAt:   now,
+		ExpiresAt:   now.Add(s.ttl),
+	})
+	if errors.Is(err, repository.ErrRecordExists) {
+		// lost the race against the same key sent concurrently
+		return nil, ErrIdempotencyKeyInFlight
+	} else if err != nil {
+		utils.Logger.Error("failed to reserve idempotency key", "error: ", err.Error())
+		return nil, err
+	}
+	return nil, nil
+}
+
+func (s *idempotencyService) Complete(ctx context.Context, key string, status int, headers map[string]string, body []byte) error {
+	return s.repo.Complete(ctx, &repository.IdempotencyRecord{
+		Key:     key,
+		Status:  status,
+		Headers: headers,
+		Body:    body,
+	})
+}
+
+func (s *idempotencyService) Release(ctx context.Context, key string) error {
+	return s.repo.DeleteOne(ctx, key)
+}
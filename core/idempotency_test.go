@@ -0,0 +1,71 @@
+package core
+
+import (
+	"context"
+	"errors"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"pulse/repository"
+	"testing"
+	"time"
+)
+
+func TestIdempotencyKeys(t *testing.T) {
+	ctx := context.WithValue(context.Background(), "UserId", primitive.NewObjectID())
+	service := NewIdempotencyService(repository.NewMemoryIdempotencyRepository(), time.Hour)
+
+	record, err := service.Begin(ctx, "key", "request")
+	if err != nil || record != nil {
+		t.Fatalf("first Begin = %+v, %v, want the request to run", record, err)
+	}
+	_, err = service.Begin(ctx, "key", "request")
+	if !errors.Is(err, ErrIdempotencyKeyInFlight) {
+		t.Fatalf("Begin while running: err = %v, want ErrIdempotencyKeyInFlight", err)
+	}
+	_, err = service.Begin(ctx, "key", "other request")
+	if !errors.Is(err, ErrIdempotencyKeyReused) {
+		t.Fatalf("Begin of another request: err = %v, want ErrIdempotencyKeyReused", err)
+	}
+
+	err = service.Complete(ctx, "key", 201, map[string]string{"ETag": `"1"`}, []byte("created"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	record, err = service.Begin(ctx, "key", "request")
+	if err != nil || record == nil || record.Status != 201 || string(record.Body) != "created" || record.Headers["ETag"] != `"1"` {
+		t.Fatalf("Begin after Complete = %+v, %v, want the stored response", record, err)
+	}
+	_, err = service.Begin(ctx, "key", "other request")
+	if !errors.Is(err, ErrIdempotencyKeyReused) {
+		t.Fatalf("Begin of another request after Complete: err = %v, want ErrIdempotencyKeyReused", err)
+	}
+
+	// keys are per owner
+	other := context.WithValue(context.Background(), "UserId", primitive.NewObjectID())
+	record, err = service.Begin(other, "key", "other request")
+	if err != nil || record != nil {
+		t.Fatalf("Begin of another owner = %+v, %v, want the request to run", record, err)
+	}
+	// a released key runs again
+	err = service.Release(other, "key")
+	if err != nil {
+		t.Fatal(err)
+	}
+	record, err = service.Begin(other, "key", "request")
+	if err != nil || record != nil {
+		t.Fatalf("Begin after Release = %+v, %v, want the request to run", record, err)
+	}
+}
+
+func TestAbandonedIdempotencyKey(t *testing.T) {
+	ctx := context.WithValue(context.Background(), "UserId", primitive.NewObjectID())
+	repo := repository.NewMemoryIdempotencyRepository()
+	created := time.Now().UTC().Add(-idempotencyLockTimeout - time.Minute)
+	err := repo.InsertOne(ctx, &repository.IdempotencyRecord{Key: "key", Fingerprint: "request", CreatedAt: created, ExpiresAt: created.Add(time.Hour)})
+	if err != nil {
+		t.Fatal(err)
+	}
+	record, err := NewIdempotencyService(repo, time.Hour).Begin(ctx, "key", "request")
+	if err != nil || record != nil {
+		t.Fatalf("Begin = %+v, %v, want the abandoned key taken over", record, err)
+	}
+}
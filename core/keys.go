@@ -0,0 +1,144 @@
+package core
+
+import (
+	"context"
+	"errors"
+	"github.com/draco121/horizon/utils"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"go.mongodb.org/mongo-driver/mongo"
+	"pulse/encryption"
+	"pulse/repository"
+	"sync"
+)
+
+type IKeyService interface {
+	Enabled() bool
+	// DataKey returns the plaintext data key of a project. When create is set
+	// a missing key is generated, otherwise nil is returned for it.
+	DataKey(ctx context.Context, projectId primitive.ObjectID, create bool) ([]byte, error)
+	// RotateKeys re-wraps the caller's data keys with the active master key.
+	RotateKeys(ctx context.Context) (int, error)
+	// RewrapAll re-wraps the data keys of every owner with the active master key.
+	RewrapAll(ctx context.Context) (int, error)
+}
+
+type keyService struct {
+	repo     repository.IDataKeyRepository
+	provider encryption.IKeyProvider
+	// cache holds the plaintext data keys by cacheKey
+	cache sync.Map
+}
+
+// cacheKey identifies a data key, as every owner of a project has their own.
+type cacheKey struct {
+	owner     primitive.ObjectID
+	projectId primitive.ObjectID
+}
+
+// NewKeyService returns the envelope encryption key service. A nil provider
+// disables encryption and files are stored as they are uploaded.
+func NewKeyService(repo repository.IDataKeyRepository, provider encryption.IKeyProvider) IKeyService {
+	return &keyService{
+		repo:     repo,
+		provider: provider,
+	}
+}
+
+func (s *keyService) Enabled() bool {
+	return s.provider != nil
+}
+
+func (s *keyService) DataKey(ctx context.Context, projectId primitive.ObjectID, create bool) ([]byte, error) {
+	if !s.Enabled() {
+		return nil, nil
+	}
+	ownerId := ctx.Value("UserId").(primitive.ObjectID)
+	cached := cacheKey{owner: ownerId, projectId: projectId}
+	if key, ok := s.cache.Load(cached); ok {
+		return key.([]byte), nil
+	}
+	record, err := s.repo.FindByProjectId(ctx, projectId)
+	if errors.Is(err, mongo.ErrNoDocuments) {
+		if !create {
+			return nil, nil
+		}
+		record, err = s.createDataKey(ctx, projectId)
+	}
+	if err != nil {
+		utils.Logger.Error("failed to fetch project data key", "error: ", err.Error())
+		return nil, err
+	}
+	key, err := s.provider.UnwrapKey(ctx, record.WrappedKey, record.MasterKeyId)
+	if err != nil {
+		utils.Logger.Error("failed to unwrap project data key", "error: ", err.Error())
+		return nil, err
+	}
+	s.cache.Store(cached, key)
+	return key, nil
+}
+
+func (s *keyService) createDataKey(ctx context.Context, projectId primitive.ObjectID) (*repository.DataKey, error) {
+	key, err := encryption.NewDataKey()
+	if err != nil {
+		return nil, err
+	}
+	wrapped, keyId, err := s.provider.WrapKey(ctx, key)
+	if err != nil {
+		return nil, err
+	}
+	record, err := s.repo.InsertOne(ctx, &repository.DataKey{
+		ProjectId:   projectId,
+		WrappedKey:  wrapped,
+		MasterKeyId: keyId,
+	})
+	if mongo.IsDuplicateKeyError(err) {
+		// another upload created the key first
+		return s.repo.FindByProjectId(ctx, projectId)
+	} else if err != nil {
+		return nil, err
+	}
+	utils.Logger.Info("created data key for project ", projectId.Hex())
+	return record, nil
+}
+
+func (s *keyService) RotateKeys(ctx context.Context) (int, error) {
+	ownerId := ctx.Value("UserId").(primitive.ObjectID)
+	return s.rewrap(ctx, ownerId)
+}
+
+func (s *keyService) RewrapAll(ctx context.Context) (int, error) {
+	return s.rewrap(ctx, primitive.NilObjectID)
+}
+
+func (s *keyService) rewrap(ctx context.Context, owner primitive.ObjectID) (int, error) {
+	if !s.Enabled() {
+		return 0, nil
+	}
+	active := s.provider.ActiveKeyId()
+	records, err := s.repo.FindNotWrappedWith(ctx, owner, active)
+	if err != nil {
+		utils.Logger.Error("failed to list data keys to rotate", "error: ", err.Error())
+		return 0, err
+	}
+	rotated := 0
+	for _, record := range records {
+		key, err := s.provider.UnwrapKey(ctx, record.WrappedKey, record.MasterKeyId)
+		if err != nil {
+			utils.Logger.Error("failed to unwrap data key of project ", record.ProjectId.Hex(), " error: ", err.Error())
+			return rotated, err
+		}
+		previous := record.MasterKeyId
+		record.WrappedKey, record.MasterKeyId, err = s.provider.WrapKey(ctx, key)
+		if err != nil {
+			return rotated, err
+		}
+		err = s.repo.UpdateWrapping(ctx, &record, previous)
+		if err != nil {
+			utils.Logger.Error("failed to store re-wrapped data key", "error: ", err.Error())
+			return rotated, err
+		}
+		rotated++
+	}
+	utils.Logger.Info("re-wrapped ", rotated, " data keys with master key ", active)
+	return rotated, nil
+}
@@ -0,0 +1,96 @@
+package core
+
+import (
+	"bytes"
+	"context"
+	"encoding/base64"
+	"encoding/json"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"go.mongodb.org/mongo-driver/mongo"
+	"os"
+	"path"
+	"pulse/encryption"
+	"pulse/repository"
+	"sync"
+	"testing"
+)
+
+// memoryDataKeys keeps data keys by owner and project the way the Mongo
+// repository does.
+type memoryDataKeys struct {
+	repository.IDataKeyRepository
+	mu   sync.Mutex
+	keys map[cacheKey]repository.DataKey
+}
+
+func (m *memoryDataKeys) FindByProjectId(ctx context.Context, projectId primitive.ObjectID) (*repository.DataKey, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	record, ok := m.keys[cacheKey{owner: ctx.Value("UserId").(primitive.ObjectID), projectId: projectId}]
+	if !ok {
+		return nil, mongo.ErrNoDocuments
+	}
+	return &record, nil
+}
+
+func (m *memoryDataKeys) InsertOne(ctx context.Context, dataKey *repository.DataKey) (*repository.DataKey, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	dataKey.Owner = ctx.Value("UserId").(primitive.ObjectID)
+	key := cacheKey{owner: dataKey.Owner, projectId: dataKey.ProjectId}
+	if _, ok := m.keys[key]; ok {
+		return nil, mongo.WriteException{WriteErrors: mongo.WriteErrors{{Code: 11000}}}
+	}
+	m.keys[key] = *dataKey
+	return dataKey, nil
+}
+
+func newLocalKeyProvider(t *testing.T) encryption.IKeyProvider {
+	t.Helper()
+	keyFile := path.Join(t.TempDir(), "keys.json")
+	content, _ := json.Marshal(map[string]any{
+		"active": "1",
+		"keys":   map[string]string{"1": base64.StdEncoding.EncodeToString(bytes.Repeat([]byte{1}, 32))},
+	})
+	err := os.WriteFile(keyFile, content, 0600)
+	if err != nil {
+		t.Fatal(err)
+	}
+	provider, err := encryption.NewLocalKeyProvider(keyFile)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return provider
+}
+
+func TestDataKeysPerOwner(t *testing.T) {
+	repo := &memoryDataKeys{keys: make(map[cacheKey]repository.DataKey)}
+	service := NewKeyService(repo, newLocalKeyProvider(t))
+	projectId := primitive.NewObjectID()
+	first := context.WithValue(context.Background(), "UserId", primitive.NewObjectID())
+	second := context.WithValue(context.Background(), "UserId", primitive.NewObjectID())
+
+	firstKey, err := service.DataKey(first, projectId, true)
+	if err != nil {
+		t.Fatal(err)
+	}
+	// the key of the first owner is cached now, the second owner must not get it
+	missing, err := service.DataKey(second, projectId, false)
+	if err != nil || missing != nil {
+		t.Fatalf("second owner got %x, %v before having a key", missing, err)
+	}
+	secondKey, err := service.DataKey(second, projectId, true)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if bytes.Equal(firstKey, secondKey) {
+		t.Fatal("both owners share a data key")
+	}
+	if len(repo.keys) != 2 {
+		t.Fatalf("stored %d data keys, want 2", len(repo.keys))
+	}
+	again, err := service.DataKey(first, projectId, false)
+	if err != nil || !bytes.Equal(again, firstKey) {
+		t.Fatalf("first owner got %x, %v, want its own key", again, err)
+	}
+}
@@ -0,0 +1,318 @@
+package core
+
+import (
+	"cmp"
+	"context"
+	"fmt"
+	"github.com/draco121/horizon/models"
+	"github.com/draco121/horizon/utils"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"golang.org/x/text/unicode/norm"
+	"pulse/naming"
+	"pulse/repository"
+	"pulse/scanner"
+	"regexp"
+	"slices"
+	"strings"
+	"unicode"
+)
+
+const (
+	MaxTagsPerFile      = 50
+	MaxLabelsPerFile    = 50
+	MaxTagLength        = 64
+	MaxLabelValueLength = 256
+	// MaxBulkFiles bounds the files changed by one bulk request.
+	MaxBulkFiles = 1000
+)
+
+// labelKeyPattern keeps label keys usable as MongoDB field names.
+var labelKeyPattern = regexp.MustCompile(`^[a-z0-9][a-z0-9_-]{0,62}$`)
+
+// normalizeTags trims, NFC normalizes and deduplicates tags. Commas are
+// refused as listings take comma separated tags.
+func normalizeTags(field string, tags []string) ([]string, error) {
+	result := make([]string, 0, len(tags))
+	for _, tag := range tags {
+		tag = norm.NFC.String(strings.TrimSpace(tag))
+		if tag == "" || len(tag) > MaxTagLength {
+			return nil, InvalidField(field, fmt.Sprintf("tags must be 1 to %d bytes long", MaxTagLength))
+		}
+		if strings.ContainsFunc(tag, func(r rune) bool { return r == ',' || unicode.IsControl(r) }) {
+			return nil, InvalidField(field, "tags may not contain commas or control characters")
+		}
+		if !slices.Contains(result, tag) {
+			result = append(result, tag)
+		}
+	}
+	return result, nil
+}
+
+func validateLabelKeys(field string, keys []string) error {
+	for _, key := range keys {
+		if !labelKeyPattern.MatchString(key) {
+			return InvalidField(field, fmt.Sprintf("label key %q must be lowercase letters, digits, - or _ and at most 63 characters", key))
+		}
+	}
+	return nil
+}
+
+func validateLabels(field string, labels map[string]string) error {
+	for key, value := range labels {
+		err := validateLabelKeys(field, []string{key})
+		if err != nil {
+			return err
+		}
+		if len(value) > MaxLabelValueLength || strings.ContainsFunc(value, unicode.IsControl) {
+			return InvalidField(field, fmt.Sprintf("label %q must be at most %d bytes without control characters", key, MaxLabelValueLength))
+		}
+	}
+	return nil
+}
+
+func validateBulkFiles(fileIds []primitive.ObjectID) ([]primitive.ObjectID, error) {
+	if len(fileIds) == 0 {
+		return nil, InvalidField("fileIds", "at least one file is required")
+	} else if len(fileIds) > MaxBulkFiles {
+		return nil, InvalidField("fileIds", fmt.Sprintf("at most %d files can be changed at once", MaxBulkFiles))
+	}
+	unique := slices.Clone(fileIds)
+	slices.SortFunc(unique, func(a, b primitive.ObjectID) int { return strings.Compare(a.Hex(), b.Hex()) })
+	return slices.Compact(unique), nil
+}
+
+func (s *trainingService) ListFiles(ctx context.Context, botId primitive.ObjectID, projectId primitive.ObjectID, filter repository.FileFilter) ([]repository.FileMetadata, error) {
+	folder, err := naming.NormalizeFolder(filter.Folder)
+	if err != nil {
+		return nil, InvalidField("folder", err.Error())
+	}
+	filter.Folder = folder
+	filter.Tags, err = normalizeTags("tag", filter.Tags)
+	if err != nil {
+		return nil, err
+	}
+	err = validateLabels("label", filter.Labels)
+	if err != nil {
+		return nil, err
+	}
+	ctx, tx, err := s.transactions.Begin(ctx)
+	if err != nil {
+		utils.Logger.Error("failed to start transaction", "error: ", err.Error())
+		return nil, err
+	}
+	defer tx.End(ctx)
+	trainingData, err := s.repo.FindOneByBotId(ctx, botId, projectId)
+	if err != nil {
+		utils.Logger.Error("no training data found error ", err.Error())
+		return nil, err
+	}
+	files, err := s.files.FindByBotId(ctx, botId, projectId, &filter)
+	if err != nil {
+		utils.Logger.Error("failed to fetch file metadata", "error: ", err.Error())
+		return nil, err
+	}
+	// files uploaded before metadata was recorded sit untagged at the root
+	if filter.Folder == "" && len(filter.Tags) == 0 && len(filter.Labels) == 0 {
+		all, err := s.files.FindByBotId(ctx, botId, projectId, nil)
+		if err != nil {
+			return nil, err
+		}
+		for _, file := range trainingData.Files {
+			if !slices.ContainsFunc(all, func(m repository.FileMetadata) bool { return m.FileId == file.FileId }) {
+				files = append(files, legacyMetadata(botId, projectId, file.FileId, file.FileName, file.Extension))
+			}
+		}
+	}
+	slices.SortFunc(files, func(a, b repository.FileMetadata) int {
+		return cmp.Or(strings.Compare(a.Folder, b.Folder), strings.Compare(a.FileName, b.FileName))
+	})
+	err = tx.Commit(ctx)
+	if err != nil {
+		utils.Logger.Error("failed to commit transaction", "error: ", err.Error())
+		return nil, err
+	}
+	return files, nil
+}
+
+func legacyMetadata(botId primitive.ObjectID, projectId primitive.ObjectID, fileId primitive.ObjectID, fileName string, extension string) repository.FileMetadata {
+	return repository.FileMetadata{
+		FileId:     fileId,
+		ProjectId:  projectId,
+		BotId:      botId,
+		FileName:   fileName,
+		Extension:  extension,
+		ScanStatus: scanner.StatusSkipped,
+		Tags:       make([]string, 0),
+		Labels:     make(map[string]string),
+		CreatedAt:  fileId.Timestamp(),
+	}
+}
+
+// ensureMetadata checks that every file belongs to the bot and records
+// metadata for files uploaded before it existed, so that they can be
+// organized like the others.
+func (s *trainingService) ensureMetadata(ctx context.Context, botId primitive.ObjectID, projectId primitive.ObjectID, fileIds []primitive.ObjectID) error {
+	trainingData, err := s.repo.FindOneByBotId(ctx, botId, projectId)
+	if err != nil {
+		utils.Logger.Error("no training data found error ", err.Error())
+		return err
+	}
+	known, err := s.files.FindMany(ctx, botId, projectId, fileIds)
+	if err != nil {
+		return err
+	}
+	for _, fileId := range fileIds {
+		if slices.ContainsFunc(known, func(m repository.FileMetadata) bool { return m.FileId == fileId }) {
+			continue
+		}
+		i := slices.IndexFunc(trainingData.Files, func(f models.Files) bool { return f.FileId == fileId })
+		if i < 0 {
+			return NotFound("file %s not found", fileId.Hex())
+		}
+		metadata := legacyMetadata(botId, projectId, fileId, trainingData.Files[i].FileName, trainingData.Files[i].Extension)
+		_, err = s.files.InsertOne(ctx, &metadata)
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func (s *trainingService) MoveFiles(ctx context.Context, botId primitive.ObjectID, projectId primitive.ObjectID, fileIds []primitive.ObjectID, folder string) ([]repository.FileMetadata, error) {
+	fileIds, err := validateBulkFiles(fileIds)
+	if err != nil {
+		return nil, err
+	}
+	folder, err = naming.NormalizeFolder(folder)
+	if err != nil {
+		return nil, InvalidField("folder", err.Error())
+	}
+	ctx, tx, err := s.transactions.Begin(ctx)
+	if err != nil {
+		utils.Logger.Error("failed to start transaction", "error: ", err.Error())
+		return nil, err
+	}
+	defer tx.End(ctx)
+	err = s.ensureMetadata(ctx, botId, projectId, fileIds)
+	if err != nil {
+		return nil, err
+	}
+	err = s.files.SetFolder(ctx, botId, projectId, fileIds, folder)
+	if err != nil {
+		utils.Logger.Error("failed to move files", "error: ", err.Error())
+		return nil, err
+	}
+	files, err := s.files.FindMany(ctx, botId, projectId, fileIds)
+	if err != nil {
+		return nil, err
+	}
+	err = tx.Commit(ctx)
+	if err != nil {
+		utils.Logger.Error("failed to commit transaction", "error: ", err.Error())
+		return nil, err
+	}
+	utils.Logger.Info("moved ", len(files), " files to folder ", folder)
+	return files, nil
+}
+
+func (s *trainingService) TagFiles(ctx context.Context, botId primitive.ObjectID, projectId primitive.ObjectID, fileIds []primitive.ObjectID, change repository.TagChange) ([]repository.FileMetadata, error) {
+	fileIds, err := validateBulkFiles(fileIds)
+	if err != nil {
+		return nil, err
+	}
+	err = validateTagChange(&change)
+	if err != nil {
+		return nil, err
+	}
+	ctx, tx, err := s.transactions.Begin(ctx)
+	if err != nil {
+		utils.Logger.Error("failed to start transaction", "error: ", err.Error())
+		return nil, err
+	}
+	defer tx.End(ctx)
+	err = s.ensureMetadata(ctx, botId, projectId, fileIds)
+	if err != nil {
+		return nil, err
+	}
+	current, err := s.files.FindMany(ctx, botId, projectId, fileIds)
+	if err != nil {
+		return nil, err
+	}
+	for _, file := range current {
+		tags, labels := applyTagChange(file, &change)
+		if len(tags) > MaxTagsPerFile || len(labels) > MaxLabelsPerFile {
+			return nil, InvalidField("fileIds", fmt.Sprintf("file %s would have more than %d tags or %d labels", file.FileId.Hex(), MaxTagsPerFile, MaxLabelsPerFile))
+		}
+	}
+	err = s.files.UpdateTags(ctx, botId, projectId, fileIds, &change)
+	if err != nil {
+		utils.Logger.Error("failed to tag files", "error: ", err.Error())
+		return nil, err
+	}
+	files, err := s.files.FindMany(ctx, botId, projectId, fileIds)
+	if err != nil {
+		return nil, err
+	}
+	err = tx.Commit(ctx)
+	if err != nil {
+		utils.Logger.Error("failed to commit transaction", "error: ", err.Error())
+		return nil, err
+	}
+	utils.Logger.Info("retagged ", len(files), " files")
+	return files, nil
+}
+
+// validateTagChange normalizes the tags of a change and checks that it is
+// consistent.
+func validateTagChange(change *repository.TagChange) error {
+	var err error
+	change.AddTags, err = normalizeTags("addTags", change.AddTags)
+	if err != nil {
+		return err
+	}
+	change.RemoveTags, err = normalizeTags("removeTags", change.RemoveTags)
+	if err != nil {
+		return err
+	}
+	for _, tag := range change.AddTags {
+		if slices.Contains(change.RemoveTags, tag) {
+			return InvalidField("removeTags", fmt.Sprintf("tag %q is both added and removed", tag))
+		}
+	}
+	err = validateLabels("setLabels", change.SetLabels)
+	if err != nil {
+		return err
+	}
+	err = validateLabelKeys("removeLabels", change.RemoveLabels)
+	if err != nil {
+		return err
+	}
+	for _, key := range change.RemoveLabels {
+		if _, ok := change.SetLabels[key]; ok {
+			return InvalidField("removeLabels", fmt.Sprintf("label %q is both set and removed", key))
+		}
+	}
+	return nil
+}
+
+// applyTagChange returns the tags and labels a file ends up with.
+func applyTagChange(file repository.FileMetadata, change *repository.TagChange) ([]string, map[string]string) {
+	tags := slices.Clone(file.Tags)
+	for _, tag := range change.AddTags {
+		if !slices.Contains(tags, tag) {
+			tags = append(tags, tag)
+		}
+	}
+	tags = slices.DeleteFunc(tags, func(tag string) bool { return slices.Contains(change.RemoveTags, tag) })
+	labels := make(map[string]string, len(file.Labels)+len(change.SetLabels))
+	for key, value := range file.Labels {
+		labels[key] = value
+	}
+	for key, value := range change.SetLabels {
+		labels[key] = value
+	}
+	for _, key := range change.RemoveLabels {
+		delete(labels, key)
+	}
+	return tags, labels
+}
@@ -0,0 +1,104 @@
+package core
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"github.com/draco121/horizon/models"
+	"github.com/draco121/horizon/utils"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"pulse/patch"
+	"pulse/repository"
+	"slices"
+)
+
+// protectedFields are set by the server and cannot be changed by a patch.
+// Files are managed through the upload and delete routes.
+var protectedFields = []string{"id", "owner", "botId", "projectId", "files"}
+
+func (s *trainingService) PatchTrainingData(ctx context.Context, botId primitive.ObjectID, projectId primitive.ObjectID, version int64, patchType string, body []byte) (*repository.VersionedTrainingData, error) {
+	var apply func(document []byte, patch []byte) ([]byte, error)
+	switch patchType {
+	case patch.MergePatchType, "application/json":
+		apply = patch.ApplyMergePatch
+	case patch.JSONPatchType:
+		apply = patch.ApplyJSONPatch
+	default:
+		return nil, &Error{Kind: KindUnsupportedMediaType, Message: "patch must be sent as " + patch.MergePatchType + " or " + patch.JSONPatchType}
+	}
+	ctx, tx, err := s.transactions.Begin(ctx)
+	if err != nil {
+		utils.Logger.Error("failed to start transaction", "error: ", err.Error())
+		return nil, err
+	}
+	defer tx.End(ctx)
+	td, err := s.repo.PatchOne(ctx, botId, projectId, version, func(trainingData *models.TrainingData) error {
+		return patchTrainingData(trainingData, apply, body)
+	})
+	if err != nil {
+		utils.Logger.Error("failed to patch training data in db", "error: ", err.Error())
+		return nil, err
+	} else {
+		err = tx.Commit(ctx)
+		if err != nil {
+			utils.Logger.Error("failed to commit transaction", "error: ", err.Error())
+			return nil, err
+		}
+		utils.Logger.Info("successfully patched training data in db")
+		return td, nil
+	}
+}
+
+// patchTrainingData applies a patch to the JSON form of the document and
+// decodes the result back into it, strictly, so that the patched document is
+// still a valid training data model.
+func patchTrainingData(trainingData *models.TrainingData, apply func(document []byte, patch []byte) ([]byte, error), body []byte) error {
+	document, err := json.Marshal(trainingData)
+	if err != nil {
+		return err
+	}
+	patched, err := apply(document, body)
+	if errors.Is(err, patch.ErrTestFailed) {
+		return Conflict("%s", err.Error())
+	} else if err != nil {
+		return Validation("invalid patch: " + err.Error())
+	}
+	decoder := json.NewDecoder(bytes.NewReader(patched))
+	decoder.DisallowUnknownFields()
+	result := models.TrainingData{}
+	err = decoder.Decode(&result)
+	var typeErr *json.UnmarshalTypeError
+	if errors.As(err, &typeErr) {
+		return InvalidField(typeErr.Field, "must be of type "+typeErr.Type.String())
+	} else if err != nil {
+		return Validation("patched training data is invalid: " + err.Error())
+	}
+	fields := make([]FieldError, 0)
+	for _, field := range protectedFields {
+		if changed(trainingData, &result, field) {
+			fields = append(fields, FieldError{Field: field, Message: "cannot be changed"})
+		}
+	}
+	if len(fields) > 0 {
+		return Validation("patch changes fields it may not change", fields...)
+	}
+	*trainingData = result
+	return nil
+}
+
+func changed(before *models.TrainingData, after *models.TrainingData, field string) bool {
+	switch field {
+	case "id":
+		return before.ID != after.ID
+	case "owner":
+		return before.Owner != after.Owner
+	case "botId":
+		return before.BotId != after.BotId
+	case "projectId":
+		return before.ProjectId != after.ProjectId
+	case "files":
+		return !slices.Equal(before.Files, after.Files)
+	}
+	return false
+}
@@ -0,0 +1,317 @@
+package core
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"github.com/draco121/horizon/utils"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"go.mongodb.org/mongo-driver/mongo"
+	"io"
+	"mime"
+	"mime/multipart"
+	"net/http"
+	"path"
+	"pulse/api"
+	"pulse/naming"
+	"pulse/repository"
+	"pulse/scanner"
+	"slices"
+	"strings"
+)
+
+var ErrRequestTooLarge = errors.New("upload request too large")
+
+const (
+	RejectionMimeType  = "mime_type_not_allowed"
+	RejectionMismatch  = "content_mismatch"
+	RejectionFileSize  = "file_too_large"
+	RejectionFileCount = "bot_file_limit_reached"
+	RejectionUnread    = "unreadable"
+	RejectionFileName  = "invalid_file_name"
+)
+
+type FileRejection = api.FileRejection
+
+// AcceptedFile is an uploaded file that passed the policy, with its normalized
+// name, the type detected from its content and the extension matching that type.
+type AcceptedFile struct {
+	Header    *multipart.FileHeader
+	FileName  string
+	MimeType  string
+	Extension string
+	Scan      *scanner.ScanResult
+}
+
+type IPolicyService interface {
+	GetPolicy(ctx context.Context, projectId primitive.ObjectID) (*repository.UploadPolicy, error)
+	SetPolicy(ctx context.Context, policy *repository.UploadPolicy) (*repository.UploadPolicy, error)
+	Evaluate(ctx context.Context, projectId primitive.ObjectID, existingFiles int, files []*multipart.FileHeader) ([]AcceptedFile, []FileRejection, error)
+}
+
+type policyService struct {
+	repo     repository.IPolicyRepository
+	defaults repository.UploadPolicy
+}
+
+func NewPolicyService(repo repository.IPolicyRepository, defaults repository.UploadPolicy) IPolicyService {
+	return &policyService{
+		repo:     repo,
+		defaults: defaults,
+	}
+}
+
+func (s *policyService) GetPolicy(ctx context.Context, projectId primitive.ObjectID) (*repository.UploadPolicy, error) {
+	policy, err := s.repo.FindByProjectId(ctx, projectId)
+	if errors.Is(err, mongo.ErrNoDocuments) {
+		defaults := s.defaults
+		defaults.ProjectId = projectId
+		return &defaults, nil
+	} else if err != nil {
+		utils.Logger.Error("failed to fetch upload policy", "error: ", err.Error())
+		return nil, err
+	}
+	return policy, nil
+}
+
+func (s *policyService) SetPolicy(ctx context.Context, policy *repository.UploadPolicy) (*repository.UploadPolicy, error) {
+	for i, mimeType := range policy.AllowedMimeTypes {
+		mediaType, _, err := mime.ParseMediaType(mimeType)
+		if err != nil {
+			return nil, InvalidField("allowedMimeTypes", fmt.Sprintf("contains invalid mime type %q", mimeType))
+		}
+		policy.AllowedMimeTypes[i] = mediaType
+	}
+	if policy.MaxFileSize < 0 || policy.MaxRequestSize < 0 || policy.MaxFilesPerBot < 0 {
+		return nil, Validation("policy limits must not be negative")
+	}
+	res, err := s.repo.Upsert(ctx, policy)
+	if err != nil {
+		utils.Logger.Error("failed to save upload policy", "error: ", err.Error())
+		return nil, err
+	}
+	utils.Logger.Info("successfully saved upload policy")
+	return res, nil
+}
+
+func (s *policyService) Evaluate(ctx context.Context, projectId primitive.ObjectID, existingFiles int, files []*multipart.FileHeader) ([]AcceptedFile, []FileRejection, error) {
+	policy, err := s.GetPolicy(ctx, projectId)
+	if err != nil {
+		return nil, nil, err
+	}
+	var requestSize int64
+	for _, file := range files {
+		requestSize += file.Size
+	}
+	if policy.MaxRequestSize > 0 && requestSize > policy.MaxRequestSize {
+		return nil, nil, fmt.Errorf("%w: %d bytes sent, limit is %d bytes", ErrRequestTooLarge, requestSize, policy.MaxRequestSize)
+	}
+	accepted := make([]AcceptedFile, 0, len(files))
+	rejected := make([]FileRejection, 0)
+	for _, file := range files {
+		reject := func(code string, reason string) {
+			rejected = append(rejected, FileRejection{FileName: file.Filename, Code: code, Reason: reason})
+		}
+		fileName, err := naming.NormalizeFileName(file.Filename)
+		if err != nil {
+			reject(RejectionFileName, err.Error())
+			continue
+		}
+		if policy.MaxFileSize > 0 && file.Size > policy.MaxFileSize {
+			reject(RejectionFileSize, fmt.Sprintf("file is %d bytes, limit is %d bytes", file.Size, policy.MaxFileSize))
+			continue
+		}
+		if policy.MaxFilesPerBot > 0 && int64(existingFiles+len(accepted)) >= policy.MaxFilesPerBot {
+			reject(RejectionFileCount, fmt.Sprintf("bot already holds the maximum of %d files", policy.MaxFilesPerBot))
+			continue
+		}
+		mimeType, err := sniffContentType(file)
+		if err != nil {
+			reject(RejectionUnread, err.Error())
+			continue
+		}
+		if isExecutable(mimeType) {
+			reject(RejectionMimeType, fmt.Sprintf("executable content (%s) is not allowed", mimeType))
+			continue
+		}
+		if len(policy.AllowedMimeTypes) > 0 && !slices.Contains(policy.AllowedMimeTypes, mimeType) {
+			reject(RejectionMimeType, fmt.Sprintf("content type %s is not allowed", mimeType))
+			continue
+		}
+		extension, ok := extensionForType(fileName, mimeType)
+		if !ok {
+			reject(RejectionMismatch, fmt.Sprintf("extension %q does not match content type %s", path.Ext(fileName), mimeType))
+			continue
+		}
+		accepted = append(accepted, AcceptedFile{
+			Header:    file,
+			FileName:  fileName,
+			MimeType:  mimeType,
+			Extension: naming.SanitizeExtension(extension),
+		})
+	}
+	return accepted, rejected, nil
+}
+
+// executableSignatures are magic bytes of binaries that must never be accepted
+// as training data, regardless of the name they were uploaded under.
+var executableSignatures = []struct {
+	magic    []byte
+	mimeType string
+}{
+	{[]byte("MZ"), "application/x-msdownload"},
+	{[]byte("\x7fELF"), "application/x-executable"},
+	{[]byte("\xfe\xed\xfa\xce"), "application/x-mach-binary"},
+	{[]byte("\xfe\xed\xfa\xcf"), "application/x-mach-binary"},
+	{[]byte("\xce\xfa\xed\xfe"), "application/x-mach-binary"},
+	{[]byte("\xcf\xfa\xed\xfe"), "application/x-mach-binary"},
+	{[]byte("\xca\xfe\xba\xbe"), "application/x-mach-binary"},
+	{[]byte("#!"), "text/x-shellscript"},
+}
+
+// mimeTypes maps the extensions stored files are checked against to their
+// media type. It is fixed rather than read from the host, whose mime.types
+// would make the same upload accepted on one server and refused on another.
+// The first extension of a type is the one given to files sent without any.
+// Extensions missing here are kept whatever the content.
+var mimeTypes = []struct {
+	extension string
+	mimeType  string
+}{
+	{".txt", "text/plain"},
+	{".text", "text/plain"},
+	{".log", "text/plain"},
+	{".md", "text/markdown"},
+	{".markdown", "text/markdown"},
+	{".csv", "text/csv"},
+	{".tsv", "text/tab-separated-values"},
+	{".html", "text/html"},
+	{".htm", "text/html"},
+	{".xml", "text/xml"},
+	{".css", "text/css"},
+	{".js", "text/javascript"},
+	{".mjs", "text/javascript"},
+	{".yaml", "text/yaml"},
+	{".yml", "text/yaml"},
+	{".rtf", "text/rtf"},
+	{".sh", "text/x-shellscript"},
+	{".json", "application/json"},
+	{".pdf", "application/pdf"},
+	{".ps", "application/postscript"},
+	{".zip", "application/zip"},
+	{".gz", "application/x-gzip"},
+	{".tgz", "application/x-gzip"},
+	{".rar", "application/x-rar-compressed"},
+	{".wasm", "application/wasm"},
+	{".exe", "application/x-msdownload"},
+	{".dll", "application/x-msdownload"},
+	{".docx", "application/vnd.openxmlformats-officedocument.wordprocessingml.document"},
+	{".xlsx", "application/vnd.openxmlformats-officedocument.spreadsheetml.sheet"},
+	{".pptx", "application/vnd.openxmlformats-officedocument.presentationml.presentation"},
+	{".odt", "application/vnd.oasis.opendocument.text"},
+	{".ods", "application/vnd.oasis.opendocument.spreadsheet"},
+	{".odp", "application/vnd.oasis.opendocument.presentation"},
+	{".png", "image/png"},
+	{".jpg", "image/jpeg"},
+	{".jpeg", "image/jpeg"},
+	{".gif", "image/gif"},
+	{".bmp", "image/bmp"},
+	{".webp", "image/webp"},
+	{".avif", "image/avif"},
+	{".ico", "image/x-icon"},
+	{".svg", "image/svg+xml"},
+	{".mp3", "audio/mpeg"},
+	{".wav", "audio/wave"},
+	{".aiff", "audio/aiff"},
+	{".mid", "audio/midi"},
+	{".ogg", "application/ogg"},
+	{".mp4", "video/mp4"},
+	{".webm", "video/webm"},
+	{".avi", "video/avi"},
+	{".ttf", "font/ttf"},
+	{".otf", "font/otf"},
+	{".woff", "font/woff"},
+	{".woff2", "font/woff2"},
+}
+
+// typeByExtension returns the media type of a lower case extension, or "".
+func typeByExtension(extension string) string {
+	for _, known := range mimeTypes {
+		if known.extension == extension {
+			return known.mimeType
+		}
+	}
+	return ""
+}
+
+// extensionByType returns the canonical extension of a media type, or "".
+func extensionByType(mimeType string) string {
+	for _, known := range mimeTypes {
+		if known.mimeType == mimeType {
+			return known.extension
+		}
+	}
+	return ""
+}
+
+func isExecutable(mimeType string) bool {
+	for _, signature := range executableSignatures {
+		if signature.mimeType == mimeType {
+			return true
+		}
+	}
+	return false
+}
+
+// sniffContentType detects the media type of an uploaded file from its first
+// bytes. Generic text is refined using the extension so that csv, markdown and
+// json files keep a useful type.
+func sniffContentType(file *multipart.FileHeader) (string, error) {
+	reader, err := file.Open()
+	if err != nil {
+		return "", err
+	}
+	defer reader.Close()
+	head := make([]byte, 512)
+	n, err := io.ReadFull(reader, head)
+	if err != nil && !errors.Is(err, io.ErrUnexpectedEOF) && !errors.Is(err, io.EOF) {
+		return "", err
+	}
+	head = head[:n]
+	for _, signature := range executableSignatures {
+		if bytes.HasPrefix(head, signature.magic) {
+			return signature.mimeType, nil
+		}
+	}
+	mediaType, _, _ := mime.ParseMediaType(http.DetectContentType(head))
+	if mediaType == "text/plain" {
+		byExtension := typeByExtension(strings.ToLower(path.Ext(file.Filename)))
+		if strings.HasPrefix(byExtension, "text/") || byExtension == "application/json" {
+			return byExtension, nil
+		}
+	}
+	return mediaType, nil
+}
+
+// extensionForType returns the extension to store a file under. The client
+// extension is kept when it agrees with the detected type, a file without an
+// extension gets the canonical one, and a conflicting extension is refused.
+func extensionForType(fileName string, mimeType string) (string, bool) {
+	extension := strings.ToLower(path.Ext(fileName))
+	if extension == "" {
+		return extensionByType(mimeType), true
+	}
+	byExtension := typeByExtension(extension)
+	if byExtension == "" || byExtension == mimeType {
+		return extension, true
+	}
+	// office documents are zip containers and plain text has many extensions
+	if mimeType == "application/zip" && strings.HasPrefix(byExtension, "application/vnd.") {
+		return extension, true
+	}
+	if mimeType == "text/plain" && strings.HasPrefix(byExtension, "text/") {
+		return extension, true
+	}
+	return "", false
+}
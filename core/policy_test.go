@@ -0,0 +1,96 @@
+package core
+
+import (
+	"pulse/repository/repotest"
+	"strings"
+	"testing"
+)
+
+func TestSniffContentType(t *testing.T) {
+	tests := []struct {
+		name     string
+		content  string
+		mimeType string
+	}{
+		{"notes.txt", "plain words", "text/plain"},
+		{"README.MD", "# title", "text/markdown"},
+		{"table.csv", "a,b\n1,2\n", "text/csv"},
+		{"data.json", `{"a": 1}`, "application/json"},
+		{"config.yml", "a: 1\n", "text/yaml"},
+		{"noext", "plain words", "text/plain"},
+		{"unknown.abc", "plain words", "text/plain"},
+		// the extension only refines generic text
+		{"fake.pdf", "plain words", "text/plain"},
+		{"doc.txt", "%PDF-1.7\n", "application/pdf"},
+		{"page.txt", "<html><body>hi</body></html>", "text/html"},
+		{"run.txt", "#!/bin/sh\necho hi\n", "text/x-shellscript"},
+		{"setup.pdf", "MZ\x90\x00", "application/x-msdownload"},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			mimeType, err := sniffContentType(repotest.FileHeader(t, test.name, []byte(test.content)))
+			if err != nil {
+				t.Fatal(err)
+			}
+			if mimeType != test.mimeType {
+				t.Fatalf("sniffContentType(%s) = %q, want %q", test.name, mimeType, test.mimeType)
+			}
+		})
+	}
+}
+
+func TestExtensionForType(t *testing.T) {
+	tests := []struct {
+		fileName  string
+		mimeType  string
+		extension string
+		ok        bool
+	}{
+		{"notes.txt", "text/plain", ".txt", true},
+		{"NOTES.TXT", "text/plain", ".txt", true},
+		{"notes", "text/plain", ".txt", true},
+		{"photo", "image/jpeg", ".jpg", true},
+		{"report", "application/pdf", ".pdf", true},
+		{"data", "application/octet-stream", "", true},
+		{"photo.jpeg", "image/jpeg", ".jpeg", true},
+		{"table.csv", "text/csv", ".csv", true},
+		// plain text under any text extension
+		{"page.html", "text/plain", ".html", true},
+		// office documents are zip containers
+		{"sheet.xlsx", "application/zip", ".xlsx", true},
+		{"notes.odt", "application/zip", ".odt", true},
+		// extensions missing from the table are kept
+		{"model.bin", "application/octet-stream", ".bin", true},
+		{"notes.abc", "text/plain", ".abc", true},
+		// a known extension must agree with the content
+		{"report.pdf", "text/plain", "", false},
+		{"photo.png", "image/jpeg", "", false},
+		{"notes.txt", "application/pdf", "", false},
+		{"data.json", "application/zip", "", false},
+	}
+	for _, test := range tests {
+		t.Run(test.fileName+" "+test.mimeType, func(t *testing.T) {
+			extension, ok := extensionForType(test.fileName, test.mimeType)
+			if extension != test.extension || ok != test.ok {
+				t.Fatalf("extensionForType(%q, %q) = %q, %v, want %q, %v", test.fileName, test.mimeType, extension, ok, test.extension, test.ok)
+			}
+		})
+	}
+}
+
+func TestMimeTypesTable(t *testing.T) {
+	seen := make(map[string]bool)
+	for _, known := range mimeTypes {
+		if seen[known.extension] {
+			t.Fatalf("extension %s is listed twice", known.extension)
+		}
+		seen[known.extension] = true
+		if typeByExtension(known.extension) != known.mimeType {
+			t.Fatalf("typeByExtension(%s) = %q", known.extension, typeByExtension(known.extension))
+		}
+		// extensions are looked up lower cased
+		if !strings.HasPrefix(known.extension, ".") || strings.ToLower(known.extension) != known.extension {
+			t.Fatalf("extension %q is not a lower case extension", known.extension)
+		}
+	}
+}
@@ -0,0 +1,331 @@
+package core
+
+import (
+	"context"
+	"errors"
+	"github.com/draco121/horizon/models"
+	"github.com/draco121/horizon/utils"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"mime/multipart"
+	"os"
+	"pulse/metrics"
+	"pulse/naming"
+	"pulse/repository"
+	"slices"
+	"time"
+)
+
+// rejectionError turns the rejection of the only file of a request into an
+// error.
+func rejectionError(rejection FileRejection) *Error {
+	return &Error{
+		Kind:    KindUnprocessable,
+		Message: rejection.Reason,
+		Fields:  []FieldError{{Field: "file", Message: rejection.Code}},
+	}
+}
+
+// currentRevision is the revision of a file's metadata, counting files stored
+// before revisions as revision 1.
+func currentRevision(metadata *repository.FileMetadata) int64 {
+	return max(metadata.Revision, 1)
+}
+
+// contentETag identifies the content of a file. Files stored before checksums
+// were recorded never change, their id identifies the content.
+func contentETag(metadata *repository.FileMetadata) string {
+	if metadata.Checksum != "" {
+		return metadata.Checksum
+	}
+	return metadata.FileId.Hex()
+}
+
+func (s *trainingService) ReplaceFile(ctx context.Context, botId primitive.ObjectID, projectId primitive.ObjectID, fileId primitive.ObjectID, file *multipart.FileHeader, checksum string) (*repository.FileMetadata, error) {
+	// quarantined files are kept when the transaction is discarded
+	requestCtx := ctx
+	ctx, tx, err := s.transactions.Begin(ctx)
+	if err != nil {
+		utils.Logger.Error("failed to start transaction", "error: ", err.Error())
+		return nil, err
+	}
+	defer tx.End(ctx)
+	err = s.ensureMetadata(ctx, botId, projectId, []primitive.ObjectID{fileId})
+	if err != nil {
+		return nil, err
+	}
+	trainingData, err := s.repo.FindOneByBotId(ctx, botId, projectId)
+	if err != nil {
+		return nil, err
+	}
+	i := slices.IndexFunc(trainingData.Files, func(f models.Files) bool { return f.FileId == fileId })
+	if i < 0 {
+		return nil, NotFound("file %s not found", fileId.Hex())
+	}
+	current, err := s.files.FindOne(ctx, fileId)
+	if err != nil {
+		return nil, err
+	}
+	if current.Quarantined {
+		return nil, ErrFileQuarantined
+	}
+	if checksum != "" && checksum != contentETag(current) {
+		return nil, repository.ErrVersionMismatch
+	}
+	accepted, rejected, err := s.policy.Evaluate(ctx, projectId, len(trainingData.Files)-1, []*multipart.FileHeader{file})
+	if err != nil {
+		return nil, err
+	} else if len(rejected) > 0 {
+		return nil, rejectionError(rejected[0])
+	}
+	accepted, infected, failed := s.screenFiles(ctx, accepted)
+	if len(failed) > 0 {
+		return nil, rejectionError(failed[0])
+	} else if len(infected) > 0 {
+		// an infected replacement is quarantined like an infected upload
+		return nil, rejectionError(s.quarantineFiles(requestCtx, botId, projectId, infected)[0])
+	}
+	replacement := accepted[0]
+	// previous revisions stay on disk, the new content is counted in full
+	err = s.usage.CheckUpload(ctx, projectId, botId, replacement.Header.Size, 0)
+	if err != nil {
+		return nil, err
+	}
+	dataKey, err := s.keys.DataKey(ctx, projectId, true)
+	if err != nil {
+		return nil, err
+	}
+	revision := currentRevision(current)
+	key := naming.StorageKey(fileId, current.Extension)
+	revisionKey := naming.RevisionKey(fileId, revision, current.Extension)
+	err = s.repo.ArchiveRevision(ctx, botId.Hex(), projectId.Hex(), key, revisionKey)
+	if err != nil {
+		utils.Logger.Error("could not move file into its history error ", err.Error())
+		return nil, err
+	}
+	savedKey := naming.StorageKey(fileId, replacement.Extension)
+	newChecksum, err := s.repo.SaveFile(ctx, botId.Hex(), projectId.Hex(), savedKey, replacement.Header, dataKey)
+	if err != nil {
+		utils.Logger.Error("could not save replacement file error ", err.Error())
+		s.undoReplace(ctx, botId.Hex(), projectId.Hex(), savedKey, key, revisionKey)
+		return nil, err
+	}
+	// the history, the metadata and the training data are written together,
+	// a retry after a conflict must not find the replacement archived
+	now := time.Now().UTC()
+	_, err = s.revisions.InsertOne(ctx, historyOf(current, now))
+	if err != nil {
+		utils.Logger.Error("could not save file revision error ", err.Error())
+		s.undoReplace(ctx, botId.Hex(), projectId.Hex(), savedKey, key, revisionKey)
+		return nil, err
+	}
+	current.FileName = replacement.FileName
+	current.Extension = replacement.Extension
+	current.MimeType = replacement.MimeType
+	current.Size = replacement.Header.Size
+	current.Checksum = newChecksum
+	current.ScanStatus = replacement.Scan.Status
+	current.ScanSignature = replacement.Scan.Signature
+	current.ScannedAt = replacement.Scan.ScannedAt
+	current.Encrypted = dataKey != nil
+	current.Revision = revision + 1
+	current.UpdatedAt = now
+	current, err = s.files.ReplaceOne(ctx, current)
+	if err != nil {
+		utils.Logger.Error("could not update file metadata error ", err.Error())
+		s.undoReplace(ctx, botId.Hex(), projectId.Hex(), savedKey, key, revisionKey)
+		return nil, err
+	}
+	trainingData.Files[i].FileName = replacement.FileName
+	trainingData.Files[i].Extension = replacement.Extension
+	_, err = s.repo.UpdateOne(ctx, &trainingData.TrainingData, trainingData.Version)
+	if errors.Is(err, repository.ErrVersionMismatch) {
+		s.undoReplace(ctx, botId.Hex(), projectId.Hex(), savedKey, key, revisionKey)
+		return nil, Conflict("training data of the bot changed while replacing the file, retry the replace")
+	} else if err != nil {
+		utils.Logger.Error("failed to update training data after replacing file, error ", err.Error())
+		s.undoReplace(ctx, botId.Hex(), projectId.Hex(), savedKey, key, revisionKey)
+		return nil, err
+	}
+	err = s.usage.RecordUpload(ctx, projectId, botId, replacement.Header.Size, 0)
+	if err != nil {
+		s.undoReplace(ctx, botId.Hex(), projectId.Hex(), savedKey, key, revisionKey)
+		return nil, err
+	}
+	err = tx.Commit(ctx)
+	if err != nil {
+		utils.Logger.Error("failed to commit file replacement, error ", err.Error())
+		s.undoReplace(ctx, botId.Hex(), projectId.Hex(), savedKey, key, revisionKey)
+		return nil, err
+	}
+	metrics.RecordUpload(replacement.Header.Size, 1, 0, 0)
+	utils.Logger.Info("replaced file ", fileId.Hex(), " with revision ", current.Revision)
+	return current, nil
+}
+
+// undoReplace removes the content a failed replace saved under savedKey and
+// moves the archived content back to key. Like discardSaved it does not use
+// the cancellation of ctx.
+func (s *trainingService) undoReplace(ctx context.Context, botId string, projectId string, savedKey string, key string, revisionKey string) {
+	ctx = context.WithoutCancel(ctx)
+	err := s.repo.RemoveFile(ctx, botId, projectId, savedKey)
+	if err != nil && !errors.Is(err, os.ErrNotExist) {
+		utils.Logger.Error("could not remove replacement file error ", err.Error())
+	}
+	err = s.repo.RestoreRevision(ctx, botId, projectId, revisionKey, key)
+	if err != nil {
+		utils.Logger.Error("could not restore replaced file error ", err.Error())
+	}
+}
+
+// historyOf is the revision record of content replaced at replacedAt.
+func historyOf(metadata *repository.FileMetadata, replacedAt time.Time) *repository.FileRevision {
+	createdAt := metadata.CreatedAt
+	if !metadata.UpdatedAt.IsZero() {
+		createdAt = metadata.UpdatedAt
+	}
+	return &repository.FileRevision{
+		FileId:     metadata.FileId,
+		ProjectId:  metadata.ProjectId,
+		BotId:      metadata.BotId,
+		Revision:   currentRevision(metadata),
+		FileName:   metadata.FileName,
+		Extension:  metadata.Extension,
+		MimeType:   metadata.MimeType,
+		Size:       metadata.Size,
+		Checksum:   metadata.Checksum,
+		Encrypted:  metadata.Encrypted,
+		CreatedAt:  createdAt,
+		ReplacedAt: replacedAt,
+	}
+}
+
+// restoreRevision makes a copy of a previous revision the current content of
+// a file, keeping the content it replaces in the history like ReplaceFile.
+// The caller updates the training data with the restored name. The returned
+// func moves the replaced content back, for a transaction that does not
+// commit.
+func (s *trainingService) restoreRevision(ctx context.Context, current *repository.FileMetadata, target *repository.FileRevision) (*repository.FileMetadata, func(), error) {
+	botId, projectId, fileId := current.BotId.Hex(), current.ProjectId.Hex(), current.FileId
+	revision := currentRevision(current)
+	key := naming.StorageKey(fileId, current.Extension)
+	revisionKey := naming.RevisionKey(fileId, revision, current.Extension)
+	err := s.repo.ArchiveRevision(ctx, botId, projectId, key, revisionKey)
+	if err != nil {
+		utils.Logger.Error("could not move file into its history error ", err.Error())
+		return nil, nil, err
+	}
+	restoredKey := naming.StorageKey(fileId, target.Extension)
+	undo := func() {
+		s.undoReplace(ctx, botId, projectId, restoredKey, key, revisionKey)
+	}
+	err = s.repo.CopyRevision(ctx, botId, projectId, naming.RevisionKey(fileId, target.Revision, target.Extension), restoredKey)
+	if err != nil {
+		utils.Logger.Error("could not restore file revision error ", err.Error())
+		undo()
+		return nil, nil, err
+	}
+	now := time.Now().UTC()
+	_, err = s.revisions.InsertOne(ctx, historyOf(current, now))
+	if err != nil {
+		utils.Logger.Error("could not save file revision error ", err.Error())
+		undo()
+		return nil, nil, err
+	}
+	current.FileName = target.FileName
+	current.Extension = target.Extension
+	current.MimeType = target.MimeType
+	current.Size = target.Size
+	current.Checksum = target.Checksum
+	current.Encrypted = target.Encrypted
+	current.Revision = revision + 1
+	current.UpdatedAt = now
+	current, err = s.files.ReplaceOne(ctx, current)
+	if err != nil {
+		utils.Logger.Error("could not update file metadata error ", err.Error())
+		undo()
+		return nil, nil, err
+	}
+	return current, undo, nil
+}
+
+func (s *trainingService) ListRevisions(ctx context.Context, botId primitive.ObjectID, projectId primitive.ObjectID, fileId primitive.ObjectID) ([]repository.FileRevision, error) {
+	ctx, tx, err := s.transactions.Begin(ctx)
+	if err != nil {
+		utils.Logger.Error("failed to start transaction", "error: ", err.Error())
+		return nil, err
+	}
+	defer tx.End(ctx)
+	err = s.ensureMetadata(ctx, botId, projectId, []primitive.ObjectID{fileId})
+	if err != nil {
+		return nil, err
+	}
+	current, err := s.files.FindOne(ctx, fileId)
+	if err != nil {
+		return nil, err
+	}
+	previous, err := s.revisions.FindByFileId(ctx, fileId)
+	if err != nil {
+		utils.Logger.Error("failed to fetch file revisions", "error: ", err.Error())
+		return nil, err
+	}
+	createdAt := current.CreatedAt
+	if !current.UpdatedAt.IsZero() {
+		createdAt = current.UpdatedAt
+	}
+	revisions := append([]repository.FileRevision{{
+		FileId:    fileId,
+		ProjectId: projectId,
+		BotId:     botId,
+		Owner:     current.Owner,
+		Revision:  currentRevision(current),
+		FileName:  current.FileName,
+		Extension: current.Extension,
+		MimeType:  current.MimeType,
+		Size:      current.Size,
+		Checksum:  contentETag(current),
+		Encrypted: current.Encrypted,
+		CreatedAt: createdAt,
+		Current:   true,
+	}}, previous...)
+	err = tx.Commit(ctx)
+	if err != nil {
+		utils.Logger.Error("failed to commit transaction", "error: ", err.Error())
+		return nil, err
+	}
+	return revisions, nil
+}
+
+func (s *trainingService) GetRevision(ctx context.Context, botId primitive.ObjectID, projectId primitive.ObjectID, fileId primitive.ObjectID, revision int64) (*FileContent, error) {
+	revisions, err := s.ListRevisions(ctx, botId, projectId, fileId)
+	if err != nil {
+		return nil, err
+	}
+	i := slices.IndexFunc(revisions, func(r repository.FileRevision) bool { return r.Revision == revision })
+	if i < 0 {
+		return nil, NotFound("revision %d of file %s not found", revision, fileId.Hex())
+	} else if revisions[i].Current {
+		return s.GetFile(ctx, botId.Hex(), projectId.Hex(), fileId)
+	}
+	found := revisions[i]
+	dataKey, err := s.keys.DataKey(ctx, projectId, false)
+	if err != nil {
+		return nil, err
+	}
+	content, size, err := s.repo.OpenRevision(ctx, botId.Hex(), projectId.Hex(), naming.RevisionKey(fileId, found.Revision, found.Extension), dataKey)
+	if err != nil {
+		utils.Logger.Error("unable to open file revision error ", err.Error())
+		return nil, err
+	}
+	checksum := found.Checksum
+	if checksum == "" {
+		checksum = fileId.Hex()
+	}
+	return &FileContent{
+		FileName: found.FileName,
+		MimeType: found.MimeType,
+		Size:     size,
+		Checksum: checksum,
+		ModTime:  found.CreatedAt,
+		Content:  content,
+	}, nil
+}
@@ -0,0 +1,97 @@
+package core
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"github.com/draco121/horizon/utils"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"pulse/naming"
+	"pulse/repository"
+	"pulse/scanner"
+)
+
+var ErrFileQuarantined = errors.New("file is quarantined")
+
+const (
+	RejectionInfected   = "infected"
+	RejectionScanFailed = "scan_failed"
+)
+
+// screenFiles runs every accepted file through the malware scanner. Infected
+// files are returned apart to be quarantined once the request completes,
+// files that could not be scanned are rejected. Nothing is written, so that
+// an aborted request leaves nothing behind.
+func (s *trainingService) screenFiles(ctx context.Context, accepted []AcceptedFile) ([]AcceptedFile, []AcceptedFile, []FileRejection) {
+	clean := make([]AcceptedFile, 0, len(accepted))
+	infected := make([]AcceptedFile, 0)
+	rejected := make([]FileRejection, 0)
+	for _, file := range accepted {
+		reader, err := file.Header.Open()
+		if err != nil {
+			rejected = append(rejected, FileRejection{FileName: file.FileName, Code: RejectionScanFailed, Reason: err.Error()})
+			continue
+		}
+		result, err := s.scanner.Scan(ctx, reader)
+		_ = reader.Close()
+		if err != nil {
+			utils.Logger.Error("failed to scan file", "error: ", err.Error())
+			rejected = append(rejected, FileRejection{FileName: file.FileName, Code: RejectionScanFailed, Reason: err.Error()})
+			continue
+		}
+		file.Scan = result
+		if result.Status == scanner.StatusInfected {
+			infected = append(infected, file)
+		} else {
+			clean = append(clean, file)
+		}
+	}
+	return clean, infected, rejected
+}
+
+// quarantineFiles moves infected files to quarantine, records them in the
+// file metadata and reports them as rejected. A file that could not be
+// quarantined is only reported. Like discardSaved it does not use the
+// cancellation of ctx, the request it belongs to is complete.
+func (s *trainingService) quarantineFiles(ctx context.Context, botId primitive.ObjectID, projectId primitive.ObjectID, infected []AcceptedFile) []FileRejection {
+	ctx = context.WithoutCancel(ctx)
+	rejected := make([]FileRejection, 0, len(infected))
+	for _, file := range infected {
+		utils.Logger.Warn("quarantining infected file ", file.FileName, " signature ", file.Scan.Signature)
+		rejection := FileRejection{
+			FileName: file.FileName,
+			Code:     RejectionInfected,
+			Reason:   fmt.Sprintf("malware detected (%s)", file.Scan.Signature),
+		}
+		fileId, err := s.quarantineFile(ctx, botId, projectId, file)
+		if err != nil {
+			utils.Logger.Error("could not quarantine file error ", err.Error())
+		} else {
+			rejection.Reason = fmt.Sprintf("malware detected (%s), file %s quarantined", file.Scan.Signature, fileId.Hex())
+		}
+		rejected = append(rejected, rejection)
+	}
+	return rejected
+}
+
+func (s *trainingService) quarantineFile(ctx context.Context, botId primitive.ObjectID, projectId primitive.ObjectID, file AcceptedFile) (primitive.ObjectID, error) {
+	fileId := primitive.NewObjectID()
+	err := s.repo.QuarantineFile(ctx, botId.Hex(), projectId.Hex(), naming.StorageKey(fileId, file.Extension), file.Header)
+	if err != nil {
+		return fileId, err
+	}
+	_, err = s.files.InsertOne(ctx, &repository.FileMetadata{
+		FileId:        fileId,
+		ProjectId:     projectId,
+		BotId:         botId,
+		FileName:      file.FileName,
+		Extension:     file.Extension,
+		MimeType:      file.MimeType,
+		Size:          file.Header.Size,
+		ScanStatus:    file.Scan.Status,
+		ScanSignature: file.Scan.Signature,
+		ScannedAt:     file.Scan.ScannedAt,
+		Quarantined:   true,
+	})
+	return fileId, err
+}
@@ -0,0 +1,117 @@
+package core
+
+import (
+	"context"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"io"
+	"mime/multipart"
+	"pulse/archive"
+	"pulse/repository"
+	"pulse/repository/repotest"
+	"pulse/scanner"
+	"strings"
+	"testing"
+)
+
+// contentScanner finds malware in content holding "virus" and fails on
+// content holding "broken".
+type contentScanner struct {
+	scanner.IScanner
+}
+
+func (contentScanner) Scan(ctx context.Context, reader io.Reader) (*scanner.ScanResult, error) {
+	content, err := io.ReadAll(reader)
+	if err != nil {
+		return nil, err
+	}
+	switch {
+	case strings.Contains(string(content), "broken"):
+		return nil, scanner.ErrScanFailed
+	case strings.Contains(string(content), "virus"):
+		return &scanner.ScanResult{Status: scanner.StatusInfected, Signature: "Test.Virus"}, nil
+	}
+	return &scanner.ScanResult{Status: scanner.StatusClean}, nil
+}
+
+type insertedFiles struct {
+	repository.IFileRepository
+	inserted []repository.FileMetadata
+}
+
+func (r *insertedFiles) InsertOne(ctx context.Context, metadata *repository.FileMetadata) (*repository.FileMetadata, error) {
+	r.inserted = append(r.inserted, *metadata)
+	return metadata, nil
+}
+
+func TestScreenFilesWritesNothing(t *testing.T) {
+	// without repositories any write panics
+	s := &trainingService{scanner: contentScanner{}}
+	accepted := []AcceptedFile{
+		{Header: repotest.FileHeader(t, "clean.txt", []byte("hello")), FileName: "clean.txt"},
+		{Header: repotest.FileHeader(t, "infected.txt", []byte("a virus")), FileName: "infected.txt"},
+		{Header: repotest.FileHeader(t, "unscanned.txt", []byte("broken")), FileName: "unscanned.txt"},
+	}
+	clean, infected, failed := s.screenFiles(context.Background(), accepted)
+	if len(clean) != 1 || clean[0].FileName != "clean.txt" || clean[0].Scan.Status != scanner.StatusClean {
+		t.Fatalf("clean = %+v", clean)
+	}
+	if len(infected) != 1 || infected[0].FileName != "infected.txt" || infected[0].Scan.Signature != "Test.Virus" {
+		t.Fatalf("infected = %+v", infected)
+	}
+	if len(failed) != 1 || failed[0].FileName != "unscanned.txt" || failed[0].Code != RejectionScanFailed {
+		t.Fatalf("failed = %+v", failed)
+	}
+}
+
+func TestQuarantineFiles(t *testing.T) {
+	files := &insertedFiles{}
+	repo := repository.NewMemoryTrainingRepository()
+	s := &trainingService{scanner: contentScanner{}, repo: repo, files: files}
+	ctx := context.WithValue(context.Background(), "UserId", primitive.NewObjectID())
+	botId, projectId := primitive.NewObjectID(), primitive.NewObjectID()
+	_, infected, _ := s.screenFiles(ctx, []AcceptedFile{
+		{Header: repotest.FileHeader(t, "infected.txt", []byte("a virus")), FileName: "infected.txt", Extension: ".txt"},
+	})
+	// a cancelled request still quarantines what it was sent
+	cancelled, cancel := context.WithCancel(ctx)
+	cancel()
+	rejected := s.quarantineFiles(cancelled, botId, projectId, infected)
+	if len(rejected) != 1 || rejected[0].Code != RejectionInfected {
+		t.Fatalf("rejected = %+v", rejected)
+	}
+	if len(files.inserted) != 1 {
+		t.Fatalf("inserted %d metadata records, want 1", len(files.inserted))
+	}
+	metadata := files.inserted[0]
+	if !metadata.Quarantined || metadata.ScanSignature != "Test.Virus" || metadata.BotId != botId {
+		t.Fatalf("metadata = %+v", metadata)
+	}
+	if !strings.Contains(rejected[0].Reason, metadata.FileId.Hex()+" quarantined") {
+		t.Fatalf("reason %q does not name the quarantined file", rejected[0].Reason)
+	}
+	// quarantined files are kept out of the bot space
+	_, _, err := repo.OpenFile(ctx, botId.Hex(), projectId.Hex(), metadata.FileId.Hex()+".txt", nil)
+	if err == nil {
+		t.Fatalf("quarantined file opened from the bot space, err = %v", err)
+	}
+}
+
+func TestFailedUploadQuarantines(t *testing.T) {
+	ctx := context.WithValue(context.Background(), "UserId", primitive.NewObjectID())
+	files := &insertedFiles{}
+	usage := NewUsageService(repository.NewMemoryUsageRepository(), QuotaPolicy{ProjectHard: QuotaLimits{MaxBytes: 3}})
+	policy := NewPolicyService(repository.NewMemoryPolicyRepository(), repository.UploadPolicy{})
+	s := NewTrainingService(repository.NewMemoryTransactions(), repository.NewMemoryTrainingRepository(), files, nil, usage, policy, contentScanner{}, nil, archive.Limits{})
+	upload := []*multipart.FileHeader{
+		repotest.FileHeader(t, "clean.txt", []byte("hello world")),
+		repotest.FileHeader(t, "infected.txt", []byte("a virus")),
+	}
+	// the clean file is over the quota, the upload fails after screening
+	_, err := s.UploadTrainingFiles(ctx, primitive.NewObjectID().Hex(), primitive.NewObjectID().Hex(), upload, UploadOptions{})
+	if AsError(err).Kind != KindQuota {
+		t.Fatalf("err = %v, want the quota exceeded", err)
+	}
+	if len(files.inserted) != 1 || !files.inserted[0].Quarantined || files.inserted[0].FileName != "infected.txt" {
+		t.Fatalf("inserted %+v, want the infected file quarantined", files.inserted)
+	}
+}
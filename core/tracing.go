@@ -0,0 +1,134 @@
+package core
+
+import (
+	"context"
+	"github.com/draco121/horizon/models"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"mime/multipart"
+	"pulse/archive"
+	"pulse/repository"
+	"pulse/tracing"
+)
+
+// tracedTrainingService wraps every call to the training service in a span.
+type tracedTrainingService struct {
+	ITrainingService
+}
+
+func NewTracedTrainingService(service ITrainingService) ITrainingService {
+	return &tracedTrainingService{
+		ITrainingService: service,
+	}
+}
+
+func (s *tracedTrainingService) UploadTrainingFiles(ctx context.Context, botId string, projectId string, files []*multipart.FileHeader, options UploadOptions) (*UploadResult, error) {
+	ctx, span := tracing.Start(ctx, "TrainingService.UploadTrainingFiles", tracing.BotId(botId), tracing.ProjectId(projectId))
+	result, err := s.ITrainingService.UploadTrainingFiles(ctx, botId, projectId, files, options)
+	tracing.End(span, err)
+	return result, err
+}
+
+func (s *tracedTrainingService) DeleteFile(ctx context.Context, botId string, projectId string, fileId primitive.ObjectID) error {
+	ctx, span := tracing.Start(ctx, "TrainingService.DeleteFile", tracing.BotId(botId), tracing.ProjectId(projectId), tracing.FileId(fileId.Hex()))
+	err := s.ITrainingService.DeleteFile(ctx, botId, projectId, fileId)
+	tracing.End(span, err)
+	return err
+}
+
+func (s *tracedTrainingService) GetFile(ctx context.Context, botId string, projectId string, fileId primitive.ObjectID) (*FileContent, error) {
+	ctx, span := tracing.Start(ctx, "TrainingService.GetFile", tracing.BotId(botId), tracing.ProjectId(projectId), tracing.FileId(fileId.Hex()))
+	file, err := s.ITrainingService.GetFile(ctx, botId, projectId, fileId)
+	tracing.End(span, err)
+	return file, err
+}
+
+func (s *tracedTrainingService) ListFiles(ctx context.Context, botId primitive.ObjectID, projectId primitive.ObjectID, filter repository.FileFilter) ([]repository.FileMetadata, error) {
+	ctx, span := tracing.Start(ctx, "TrainingService.ListFiles", tracing.BotId(botId.Hex()), tracing.ProjectId(projectId.Hex()))
+	files, err := s.ITrainingService.ListFiles(ctx, botId, projectId, filter)
+	tracing.End(span, err)
+	return files, err
+}
+
+func (s *tracedTrainingService) MoveFiles(ctx context.Context, botId primitive.ObjectID, projectId primitive.ObjectID, fileIds []primitive.ObjectID, folder string) ([]repository.FileMetadata, error) {
+	ctx, span := tracing.Start(ctx, "TrainingService.MoveFiles", tracing.BotId(botId.Hex()), tracing.ProjectId(projectId.Hex()))
+	files, err := s.ITrainingService.MoveFiles(ctx, botId, projectId, fileIds, folder)
+	tracing.End(span, err)
+	return files, err
+}
+
+func (s *tracedTrainingService) TagFiles(ctx context.Context, botId primitive.ObjectID, projectId primitive.ObjectID, fileIds []primitive.ObjectID, change repository.TagChange) ([]repository.FileMetadata, error) {
+	ctx, span := tracing.Start(ctx, "TrainingService.TagFiles", tracing.BotId(botId.Hex()), tracing.ProjectId(projectId.Hex()))
+	files, err := s.ITrainingService.TagFiles(ctx, botId, projectId, fileIds, change)
+	tracing.End(span, err)
+	return files, err
+}
+
+func (s *tracedTrainingService) ArchiveFiles(ctx context.Context, botId string, projectId string, fileIds []primitive.ObjectID) ([]archive.Entry, error) {
+	ctx, span := tracing.Start(ctx, "TrainingService.ArchiveFiles", tracing.BotId(botId), tracing.ProjectId(projectId))
+	entries, err := s.ITrainingService.ArchiveFiles(ctx, botId, projectId, fileIds)
+	tracing.End(span, err)
+	return entries, err
+}
+
+func (s *tracedTrainingService) ReplaceFile(ctx context.Context, botId primitive.ObjectID, projectId primitive.ObjectID, fileId primitive.ObjectID, file *multipart.FileHeader, checksum string) (*repository.FileMetadata, error) {
+	ctx, span := tracing.Start(ctx, "TrainingService.ReplaceFile", tracing.BotId(botId.Hex()), tracing.ProjectId(projectId.Hex()), tracing.FileId(fileId.Hex()))
+	metadata, err := s.ITrainingService.ReplaceFile(ctx, botId, projectId, fileId, file, checksum)
+	tracing.End(span, err)
+	return metadata, err
+}
+
+func (s *tracedTrainingService) ListRevisions(ctx context.Context, botId primitive.ObjectID, projectId primitive.ObjectID, fileId primitive.ObjectID) ([]repository.FileRevision, error) {
+	ctx, span := tracing.Start(ctx, "TrainingService.ListRevisions", tracing.BotId(botId.Hex()), tracing.ProjectId(projectId.Hex()), tracing.FileId(fileId.Hex()))
+	revisions, err := s.ITrainingService.ListRevisions(ctx, botId, projectId, fileId)
+	tracing.End(span, err)
+	return revisions, err
+}
+
+func (s *tracedTrainingService) GetRevision(ctx context.Context, botId primitive.ObjectID, projectId primitive.ObjectID, fileId primitive.ObjectID, revision int64) (*FileContent, error) {
+	ctx, span := tracing.Start(ctx, "TrainingService.GetRevision", tracing.BotId(botId.Hex()), tracing.ProjectId(projectId.Hex()), tracing.FileId(fileId.Hex()))
+	file, err := s.ITrainingService.GetRevision(ctx, botId, projectId, fileId, revision)
+	tracing.End(span, err)
+	return file, err
+}
+
+func (s *tracedTrainingService) RunBatch(ctx context.Context, botId primitive.ObjectID, projectId primitive.ObjectID, operations []BatchOperation, atomic bool) ([]BatchItem, error) {
+	ctx, span := tracing.Start(ctx, "TrainingService.RunBatch", tracing.BotId(botId.Hex()), tracing.ProjectId(projectId.Hex()))
+	items, err := s.ITrainingService.RunBatch(ctx, botId, projectId, operations, atomic)
+	tracing.End(span, err)
+	return items, err
+}
+
+func (s *tracedTrainingService) AddTrainingData(ctx context.Context, trainingData *models.TrainingData) (*repository.VersionedTrainingData, error) {
+	ctx, span := tracing.Start(ctx, "TrainingService.AddTrainingData", tracing.BotId(trainingData.BotId.Hex()), tracing.ProjectId(trainingData.ProjectId.Hex()))
+	result, err := s.ITrainingService.AddTrainingData(ctx, trainingData)
+	tracing.End(span, err)
+	return result, err
+}
+
+func (s *tracedTrainingService) GetTrainingData(ctx context.Context, botId primitive.ObjectID, projectId primitive.ObjectID) (*repository.VersionedTrainingData, error) {
+	ctx, span := tracing.Start(ctx, "TrainingService.GetTrainingData", tracing.BotId(botId.Hex()), tracing.ProjectId(projectId.Hex()))
+	result, err := s.ITrainingService.GetTrainingData(ctx, botId, projectId)
+	tracing.End(span, err)
+	return result, err
+}
+
+func (s *tracedTrainingService) UpdateTrainingData(ctx context.Context, trainingData *models.TrainingData, version int64) (*repository.VersionedTrainingData, error) {
+	ctx, span := tracing.Start(ctx, "TrainingService.UpdateTrainingData", tracing.BotId(trainingData.BotId.Hex()), tracing.ProjectId(trainingData.ProjectId.Hex()))
+	result, err := s.ITrainingService.UpdateTrainingData(ctx, trainingData, version)
+	tracing.End(span, err)
+	return result, err
+}
+
+func (s *tracedTrainingService) ResetTrainingData(ctx context.Context, botId primitive.ObjectID, projectId primitive.ObjectID, version int64) (*repository.VersionedTrainingData, error) {
+	ctx, span := tracing.Start(ctx, "TrainingService.ResetTrainingData", tracing.BotId(botId.Hex()), tracing.ProjectId(projectId.Hex()))
+	result, err := s.ITrainingService.ResetTrainingData(ctx, botId, projectId, version)
+	tracing.End(span, err)
+	return result, err
+}
+
+func (s *tracedTrainingService) PatchTrainingData(ctx context.Context, botId primitive.ObjectID, projectId primitive.ObjectID, version int64, patchType string, patch []byte) (*repository.VersionedTrainingData, error) {
+	ctx, span := tracing.Start(ctx, "TrainingService.PatchTrainingData", tracing.BotId(botId.Hex()), tracing.ProjectId(projectId.Hex()))
+	result, err := s.ITrainingService.PatchTrainingData(ctx, botId, projectId, version, patchType, patch)
+	tracing.End(span, err)
+	return result, err
+}
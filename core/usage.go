@@ -0,0 +1,172 @@
+package core
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"github.com/draco121/horizon/utils"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"pulse/api"
+	"pulse/repository"
+)
+
+var ErrQuotaExceeded = errors.New("storage quota exceeded")
+
+type QuotaLimits = api.QuotaLimits
+
+type QuotaPolicy = api.QuotaPolicy
+
+type ProjectUsage = api.ProjectUsage
+
+type IUsageService interface {
+	// CheckUpload rejects an upload crossing a hard limit before it is
+	// stored, RecordUpload checks again as it counts the upload.
+	CheckUpload(ctx context.Context, projectId primitive.ObjectID, botId primitive.ObjectID, bytes int64, files int64) error
+	RecordUpload(ctx context.Context, projectId primitive.ObjectID, botId primitive.ObjectID, bytes int64, files int64) error
+	RecordDelete(ctx context.Context, projectId primitive.ObjectID, botId primitive.ObjectID, bytes int64, files int64) error
+	GetUsage(ctx context.Context, projectId primitive.ObjectID) (*ProjectUsage, error)
+}
+
+type usageService struct {
+	repo   repository.IUsageRepository
+	policy QuotaPolicy
+}
+
+func NewUsageService(repo repository.IUsageRepository, policy QuotaPolicy) IUsageService {
+	return &usageService{
+		repo:   repo,
+		policy: policy,
+	}
+}
+
+func exceeds(limits QuotaLimits, bytes int64, files int64) bool {
+	return (limits.MaxBytes > 0 && bytes > limits.MaxBytes) || (limits.MaxFiles > 0 && files > limits.MaxFiles)
+}
+
+func (s *usageService) CheckUpload(ctx context.Context, projectId primitive.ObjectID, botId primitive.ObjectID, bytes int64, files int64) error {
+	usages, err := s.repo.FindByProjectId(ctx, projectId)
+	if err != nil {
+		utils.Logger.Error("failed to fetch project usage", "error: ", err.Error())
+		return err
+	}
+	var projectBytes, projectFiles, botBytes, botFiles int64
+	for _, u := range usages {
+		projectBytes += u.Bytes
+		projectFiles += u.Files
+		if u.BotId == botId {
+			botBytes += u.Bytes
+			botFiles += u.Files
+		}
+	}
+	projectBytes, projectFiles = projectBytes+bytes, projectFiles+files
+	botBytes, botFiles = botBytes+bytes, botFiles+files
+
+	var reason string
+	if exceeds(s.policy.ProjectHard, projectBytes, projectFiles) {
+		reason = fmt.Sprintf("project quota exceeded: %d bytes in %d files requested, limit is %d bytes in %d files",
+			projectBytes, projectFiles, s.policy.ProjectHard.MaxBytes, s.policy.ProjectHard.MaxFiles)
+	} else if exceeds(s.policy.BotHard, botBytes, botFiles) {
+		reason = fmt.Sprintf("bot quota exceeded: %d bytes in %d files requested, limit is %d bytes in %d files",
+			botBytes, botFiles, s.policy.BotHard.MaxBytes, s.policy.BotHard.MaxFiles)
+	}
+	if reason != "" {
+		utils.Logger.Warn(reason)
+		_ = s.repo.InsertEvent(ctx, &repository.UsageEvent{
+			ProjectId: projectId,
+			BotId:     botId,
+			Kind:      repository.UsageEventHardQuota,
+			Bytes:     bytes,
+			Files:     files,
+			Message:   reason,
+		})
+		return fmt.Errorf("%w: %s", ErrQuotaExceeded, reason)
+	}
+	return nil
+}
+
+// RecordUpload counts an upload against the hard limits once more, as the
+// counters may have grown since CheckUpload. Uploads that cross them are not
+// counted and fail with ErrQuotaExceeded, the soft limits are checked against
+// the counters the upload left.
+func (s *usageService) RecordUpload(ctx context.Context, projectId primitive.ObjectID, botId primitive.ObjectID, bytes int64, files int64) error {
+	projectUsage, botUsage, err := s.repo.IncrementWithin(ctx, projectId, botId, bytes, files, s.policy.ProjectHard, s.policy.BotHard)
+	if errors.Is(err, repository.ErrLimitReached) {
+		reason := fmt.Sprintf("quota exceeded: %d bytes in %d files would cross a hard limit", bytes, files)
+		utils.Logger.Warn(reason)
+		_ = s.repo.InsertEvent(ctx, &repository.UsageEvent{
+			ProjectId: projectId,
+			BotId:     botId,
+			Kind:      repository.UsageEventHardQuota,
+			Bytes:     bytes,
+			Files:     files,
+			Message:   reason,
+		})
+		return fmt.Errorf("%w: %s", ErrQuotaExceeded, reason)
+	} else if err != nil {
+		utils.Logger.Error("failed to record upload usage", "error: ", err.Error())
+		return err
+	}
+	var warning string
+	if exceeds(s.policy.ProjectSoft, projectUsage.Bytes, projectUsage.Files) {
+		warning = fmt.Sprintf("project soft quota exceeded: %d bytes in %d files", projectUsage.Bytes, projectUsage.Files)
+	} else if exceeds(s.policy.BotSoft, botUsage.Bytes, botUsage.Files) {
+		warning = fmt.Sprintf("bot soft quota exceeded: %d bytes in %d files", botUsage.Bytes, botUsage.Files)
+	}
+	if warning != "" {
+		utils.Logger.Warn(warning)
+		_ = s.repo.InsertEvent(ctx, &repository.UsageEvent{
+			ProjectId: projectId,
+			BotId:     botId,
+			Kind:      repository.UsageEventSoftQuota,
+			Bytes:     bytes,
+			Files:     files,
+			Message:   warning,
+		})
+	}
+	return s.repo.InsertEvent(ctx, &repository.UsageEvent{
+		ProjectId: projectId,
+		BotId:     botId,
+		Kind:      repository.UsageEventUpload,
+		Bytes:     bytes,
+		Files:     files,
+	})
+}
+
+func (s *usageService) RecordDelete(ctx context.Context, projectId primitive.ObjectID, botId primitive.ObjectID, bytes int64, files int64) error {
+	err := s.repo.Increment(ctx, projectId, botId, -bytes, -files)
+	if err != nil {
+		utils.Logger.Error("failed to record delete usage", "error: ", err.Error())
+		return err
+	}
+	return s.repo.InsertEvent(ctx, &repository.UsageEvent{
+		ProjectId: projectId,
+		BotId:     botId,
+		Kind:      repository.UsageEventDelete,
+		Bytes:     -bytes,
+		Files:     -files,
+	})
+}
+
+func (s *usageService) GetUsage(ctx context.Context, projectId primitive.ObjectID) (*ProjectUsage, error) {
+	usages, err := s.repo.FindByProjectId(ctx, projectId)
+	if err != nil {
+		utils.Logger.Error("failed to fetch project usage", "error: ", err.Error())
+		return nil, err
+	}
+	history, err := s.repo.FindEvents(ctx, projectId, 0)
+	if err != nil {
+		utils.Logger.Error("failed to fetch project usage history", "error: ", err.Error())
+		return nil, err
+	}
+	result := &ProjectUsage{
+		ProjectId: projectId,
+		Policy:    s.policy,
+		Bots:      usages,
+		History:   history,
+	}
+	for _, u := range usages {
+		result.Bytes += u.Bytes
+		result.Files += u.Files
+	}
+	return result, nil
+}
@@ -0,0 +1,100 @@
+package core
+
+import (
+	"context"
+	"errors"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"pulse/api"
+	"pulse/repository"
+	"testing"
+)
+
+// limitedUsage counts within the limits it is given, like the Mongo
+// repository does in a single write.
+type limitedUsage struct {
+	repository.IUsageRepository
+	bytes, files int64
+	events       []repository.UsageEvent
+}
+
+func (r *limitedUsage) IncrementWithin(ctx context.Context, projectId primitive.ObjectID, botId primitive.ObjectID, bytes int64, files int64, project api.QuotaLimits, bot api.QuotaLimits) (*repository.Usage, *repository.Usage, error) {
+	for _, limits := range []api.QuotaLimits{project, bot} {
+		if (limits.MaxBytes > 0 && r.bytes+bytes > limits.MaxBytes) || (limits.MaxFiles > 0 && r.files+files > limits.MaxFiles) {
+			return nil, nil, repository.ErrLimitReached
+		}
+	}
+	r.bytes, r.files = r.bytes+bytes, r.files+files
+	usage := &repository.Usage{ProjectId: projectId, Bytes: r.bytes, Files: r.files}
+	return usage, usage, nil
+}
+
+func (r *limitedUsage) Increment(ctx context.Context, projectId primitive.ObjectID, botId primitive.ObjectID, bytes int64, files int64) error {
+	r.bytes, r.files = r.bytes+bytes, r.files+files
+	return nil
+}
+
+func (r *limitedUsage) FindByProjectId(ctx context.Context, projectId primitive.ObjectID) ([]repository.Usage, error) {
+	return []repository.Usage{{ProjectId: projectId, Bytes: r.bytes, Files: r.files}}, nil
+}
+
+func (r *limitedUsage) InsertEvent(ctx context.Context, event *repository.UsageEvent) error {
+	r.events = append(r.events, *event)
+	return nil
+}
+
+func TestRecordUploadWithinHardLimits(t *testing.T) {
+	repo := &limitedUsage{}
+	service := NewUsageService(repo, QuotaPolicy{ProjectHard: QuotaLimits{MaxBytes: 100}})
+	ctx := context.WithValue(context.Background(), "UserId", primitive.NewObjectID())
+	projectId, botId := primitive.NewObjectID(), primitive.NewObjectID()
+	err := service.RecordUpload(ctx, projectId, botId, 60, 1)
+	if err != nil {
+		t.Fatal(err)
+	}
+	// passed CheckUpload alongside the first upload, but no longer fits
+	err = service.RecordUpload(ctx, projectId, botId, 60, 1)
+	if !errors.Is(err, ErrQuotaExceeded) {
+		t.Fatalf("err = %v, want ErrQuotaExceeded", err)
+	}
+	if repo.bytes != 60 || repo.files != 1 {
+		t.Fatalf("counted %d bytes in %d files, want 60 bytes in 1 file", repo.bytes, repo.files)
+	}
+	last := repo.events[len(repo.events)-1]
+	if last.Kind != repository.UsageEventHardQuota {
+		t.Fatalf("last event %q, want %q", last.Kind, repository.UsageEventHardQuota)
+	}
+}
+
+func TestSoftQuotaWarnedOnceCounted(t *testing.T) {
+	repo := &limitedUsage{}
+	service := NewUsageService(repo, QuotaPolicy{
+		ProjectSoft: QuotaLimits{MaxBytes: 50},
+		ProjectHard: QuotaLimits{MaxBytes: 100},
+	})
+	ctx := context.WithValue(context.Background(), "UserId", primitive.NewObjectID())
+	projectId, botId := primitive.NewObjectID(), primitive.NewObjectID()
+	err := service.CheckUpload(ctx, projectId, botId, 60, 1)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(repo.events) != 0 {
+		t.Fatalf("CheckUpload wrote %v", repo.events)
+	}
+	err = service.RecordUpload(ctx, projectId, botId, 60, 1)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(repo.events) != 2 || repo.events[0].Kind != repository.UsageEventSoftQuota {
+		t.Fatalf("events %v, want a soft quota warning then the upload", repo.events)
+	}
+	// a rejected upload warns of nothing
+	err = service.RecordUpload(ctx, projectId, botId, 60, 1)
+	if !errors.Is(err, ErrQuotaExceeded) {
+		t.Fatalf("err = %v, want ErrQuotaExceeded", err)
+	}
+	for _, event := range repo.events[2:] {
+		if event.Kind == repository.UsageEventSoftQuota {
+			t.Fatalf("warned of a rejected upload: %v", event)
+		}
+	}
+}
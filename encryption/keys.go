@@ -0,0 +1,112 @@
+package encryption
+
+import (
+	"context"
+	"crypto/rand"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+)
+
+const DataKeySize = 32
+
+var ErrUnknownKey = errors.New("unknown master key")
+
+// IKeyProvider wraps and unwraps data keys with a master key that never
+// leaves the provider, in the same way a KMS does.
+type IKeyProvider interface {
+	ActiveKeyId() string
+	WrapKey(ctx context.Context, dataKey []byte) ([]byte, string, error)
+	UnwrapKey(ctx context.Context, wrapped []byte, keyId string) ([]byte, error)
+}
+
+// NewDataKey returns a fresh random AES-256 key.
+func NewDataKey() ([]byte, error) {
+	key := make([]byte, DataKeySize)
+	_, err := rand.Read(key)
+	if err != nil {
+		return nil, err
+	}
+	return key, nil
+}
+
+// localKeyFile is the format of the key file read by the local provider. Old
+// keys stay in the file after a rotation until every data key is re-wrapped.
+//
+//	{"active": "2024-06", "keys": {"2024-01": "<base64>", "2024-06": "<base64>"}}
+type localKeyFile struct {
+	Active string            `json:"active"`
+	Keys   map[string]string `json:"keys"`
+}
+
+type localKeyProvider struct {
+	IKeyProvider
+	active string
+	keys   map[string][]byte
+}
+
+// NewLocalKeyProvider loads the master keys from a JSON key file.
+func NewLocalKeyProvider(keyFile string) (IKeyProvider, error) {
+	content, err := os.ReadFile(keyFile)
+	if err != nil {
+		return nil, err
+	}
+	var file localKeyFile
+	err = json.Unmarshal(content, &file)
+	if err != nil {
+		return nil, fmt.Errorf("invalid key file %s: %w", keyFile, err)
+	}
+	provider := &localKeyProvider{
+		active: file.Active,
+		keys:   make(map[string][]byte, len(file.Keys)),
+	}
+	for id, encoded := range file.Keys {
+		key, err := base64.StdEncoding.DecodeString(encoded)
+		if err != nil {
+			return nil, fmt.Errorf("invalid master key %s: %w", id, err)
+		}
+		if len(key) != 32 {
+			return nil, fmt.Errorf("master key %s must be 32 bytes, got %d", id, len(key))
+		}
+		provider.keys[id] = key
+	}
+	if _, ok := provider.keys[provider.active]; !ok {
+		return nil, fmt.Errorf("%w: active key %q is not in %s", ErrUnknownKey, provider.active, keyFile)
+	}
+	return provider, nil
+}
+
+func (p *localKeyProvider) ActiveKeyId() string {
+	return p.active
+}
+
+func (p *localKeyProvider) WrapKey(ctx context.Context, dataKey []byte) ([]byte, string, error) {
+	aead, err := newAEAD(p.keys[p.active])
+	if err != nil {
+		return nil, "", err
+	}
+	nonce := make([]byte, aead.NonceSize())
+	_, err = rand.Read(nonce)
+	if err != nil {
+		return nil, "", err
+	}
+	return aead.Seal(nonce, nonce, dataKey, []byte(p.active)), p.active, nil
+}
+
+func (p *localKeyProvider) UnwrapKey(ctx context.Context, wrapped []byte, keyId string) ([]byte, error) {
+	key, ok := p.keys[keyId]
+	if !ok {
+		return nil, fmt.Errorf("%w: %s", ErrUnknownKey, keyId)
+	}
+	aead, err := newAEAD(key)
+	if err != nil {
+		return nil, err
+	}
+	if len(wrapped) < aead.NonceSize() {
+		return nil, ErrCorrupted
+	}
+	nonce, sealed := wrapped[:aead.NonceSize()], wrapped[aead.NonceSize():]
+	return aead.Open(nil, nonce, sealed, []byte(keyId))
+}
@@ -0,0 +1,232 @@
+package encryption
+
+import (
+	"bytes"
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/rand"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+)
+
+// Blobs are written as a header followed by independently sealed chunks so
+// that any byte range can be decrypted without reading the whole file.
+//
+//	header: magic (8 bytes) | nonce prefix (8 bytes)
+//	chunk:  AES-GCM(plaintext[i*ChunkSize:(i+1)*ChunkSize]) with
+//	        nonce = prefix | uint32(i) and the final chunk flagged in the
+//	        additional data, so truncation is detected.
+const (
+	ChunkSize  = 64 << 10
+	headerSize = 16
+	tagSize    = 16
+)
+
+var magic = []byte("PULSEv1\x00")
+
+var ErrCorrupted = errors.New("encrypted file is corrupted")
+
+// IsEncrypted reports whether the header belongs to an encrypted blob.
+func IsEncrypted(header []byte) bool {
+	return len(header) >= len(magic) && bytes.Equal(header[:len(magic)], magic)
+}
+
+func newAEAD(key []byte) (cipher.AEAD, error) {
+	block, err := aes.NewCipher(key)
+	if err != nil {
+		return nil, err
+	}
+	return cipher.NewGCM(block)
+}
+
+func chunkNonce(prefix []byte, index uint32) []byte {
+	nonce := make([]byte, 12)
+	copy(nonce, prefix)
+	binary.BigEndian.PutUint32(nonce[8:], index)
+	return nonce
+}
+
+func chunkAdditionalData(final bool) []byte {
+	if final {
+		return []byte{1}
+	}
+	return []byte{0}
+}
+
+type encryptWriter struct {
+	w      io.Writer
+	aead   cipher.AEAD
+	prefix []byte
+	buf    []byte
+	index  uint32
+	closed bool
+}
+
+// NewEncryptWriter returns a writer encrypting everything written to it into w
+// with the given data key. Close must be called to flush the final chunk.
+func NewEncryptWriter(w io.Writer, key []byte) (io.WriteCloser, error) {
+	aead, err := newAEAD(key)
+	if err != nil {
+		return nil, err
+	}
+	prefix := make([]byte, 8)
+	_, err = rand.Read(prefix)
+	if err != nil {
+		return nil, err
+	}
+	_, err = w.Write(append(append([]byte{}, magic...), prefix...))
+	if err != nil {
+		return nil, err
+	}
+	return &encryptWriter{
+		w:      w,
+		aead:   aead,
+		prefix: prefix,
+		buf:    make([]byte, 0, ChunkSize),
+	}, nil
+}
+
+func (e *encryptWriter) seal(final bool) error {
+	sealed := e.aead.Seal(nil, chunkNonce(e.prefix, e.index), e.buf, chunkAdditionalData(final))
+	_, err := e.w.Write(sealed)
+	e.buf = e.buf[:0]
+	e.index++
+	return err
+}
+
+func (e *encryptWriter) Write(p []byte) (int, error) {
+	if e.closed {
+		return 0, errors.New("write to closed encrypt writer")
+	}
+	written := 0
+	for len(p) > 0 {
+		// a full chunk is only sealed once more data arrives, so the last
+		// chunk is always sealed as final by Close
+		if len(e.buf) == ChunkSize {
+			if err := e.seal(false); err != nil {
+				return written, err
+			}
+		}
+		n := copy(e.buf[len(e.buf):ChunkSize], p)
+		e.buf = e.buf[:len(e.buf)+n]
+		p = p[n:]
+		written += n
+	}
+	return written, nil
+}
+
+func (e *encryptWriter) Close() error {
+	if e.closed {
+		return nil
+	}
+	e.closed = true
+	return e.seal(true)
+}
+
+type decryptReader struct {
+	r       io.ReaderAt
+	aead    cipher.AEAD
+	prefix  []byte
+	chunks  int64
+	size    int64
+	offset  int64
+	current int64
+	plain   []byte
+}
+
+// PlaintextSize returns the size of the decrypted content of an encrypted
+// blob of the given size.
+func PlaintextSize(size int64) (int64, error) {
+	body := size - headerSize
+	// every chunk, the last included, holds at least its tag
+	if body < tagSize || (body%(ChunkSize+tagSize) != 0 && body%(ChunkSize+tagSize) < tagSize) {
+		return 0, ErrCorrupted
+	}
+	chunks := (body + ChunkSize + tagSize - 1) / (ChunkSize + tagSize)
+	return body - chunks*tagSize, nil
+}
+
+// NewDecryptReader returns a seekable reader over the plaintext of the
+// encrypted blob r of the given size.
+func NewDecryptReader(r io.ReaderAt, size int64, key []byte) (io.ReadSeeker, error) {
+	aead, err := newAEAD(key)
+	if err != nil {
+		return nil, err
+	}
+	header := make([]byte, headerSize)
+	_, err = r.ReadAt(header, 0)
+	if err != nil {
+		return nil, err
+	}
+	if !IsEncrypted(header) {
+		return nil, ErrCorrupted
+	}
+	plainSize, err := PlaintextSize(size)
+	if err != nil {
+		return nil, err
+	}
+	return &decryptReader{
+		r:       r,
+		aead:    aead,
+		prefix:  header[len(magic):],
+		chunks:  (size - headerSize + ChunkSize + tagSize - 1) / (ChunkSize + tagSize),
+		size:    plainSize,
+		current: -1,
+	}, nil
+}
+
+func (d *decryptReader) load(index int64) error {
+	if d.current == index {
+		return nil
+	}
+	sealed := make([]byte, ChunkSize+tagSize)
+	n, err := d.r.ReadAt(sealed, headerSize+index*(ChunkSize+tagSize))
+	if err != nil && !errors.Is(err, io.EOF) {
+		return err
+	}
+	final := index == d.chunks-1
+	plain, err := d.aead.Open(sealed[:0], chunkNonce(d.prefix, uint32(index)), sealed[:n], chunkAdditionalData(final))
+	if err != nil {
+		return fmt.Errorf("%w: chunk %d: %s", ErrCorrupted, index, err.Error())
+	}
+	d.plain = plain
+	d.current = index
+	return nil
+}
+
+func (d *decryptReader) Read(p []byte) (int, error) {
+	if d.offset >= d.size {
+		// the final chunk is authenticated before the end is reported, a
+		// file cut down to an empty chunk would read as empty otherwise
+		if err := d.load(d.chunks - 1); err != nil {
+			return 0, err
+		}
+		return 0, io.EOF
+	}
+	index := d.offset / ChunkSize
+	if err := d.load(index); err != nil {
+		return 0, err
+	}
+	n := copy(p, d.plain[d.offset-index*ChunkSize:])
+	d.offset += int64(n)
+	return n, nil
+}
+
+func (d *decryptReader) Seek(offset int64, whence int) (int64, error) {
+	switch whence {
+	case io.SeekStart:
+	case io.SeekCurrent:
+		offset += d.offset
+	case io.SeekEnd:
+		offset += d.size
+	default:
+		return 0, errors.New("invalid whence")
+	}
+	if offset < 0 {
+		return 0, errors.New("negative position")
+	}
+	d.offset = offset
+	return offset, nil
+}
@@ -0,0 +1,192 @@
+package encryption
+
+import (
+	"bytes"
+	"crypto/rand"
+	"errors"
+	"io"
+	"testing"
+)
+
+func testKey(t *testing.T) []byte {
+	t.Helper()
+	key := make([]byte, 32)
+	if _, err := rand.Read(key); err != nil {
+		t.Fatal(err)
+	}
+	return key
+}
+
+func plaintext(t *testing.T, size int) []byte {
+	t.Helper()
+	content := make([]byte, size)
+	if _, err := rand.Read(content); err != nil {
+		t.Fatal(err)
+	}
+	return content
+}
+
+func encrypt(t *testing.T, key []byte, content []byte) []byte {
+	t.Helper()
+	var sealed bytes.Buffer
+	writer, err := NewEncryptWriter(&sealed, key)
+	if err != nil {
+		t.Fatal(err)
+	}
+	// uneven writes, so that chunks are filled across calls
+	for len(content) > 0 {
+		n := min(len(content), 10007)
+		if _, err := writer.Write(content[:n]); err != nil {
+			t.Fatal(err)
+		}
+		content = content[n:]
+	}
+	if err := writer.Close(); err != nil {
+		t.Fatal(err)
+	}
+	return sealed.Bytes()
+}
+
+// decrypt reads the whole plaintext of sealed.
+func decrypt(key []byte, sealed []byte) ([]byte, error) {
+	reader, err := NewDecryptReader(bytes.NewReader(sealed), int64(len(sealed)), key)
+	if err != nil {
+		return nil, err
+	}
+	return io.ReadAll(reader)
+}
+
+// sizes cover an empty file, a partial chunk, chunk boundaries and a partial
+// last chunk.
+var sizes = []int{0, 1, ChunkSize - 1, ChunkSize, ChunkSize + 1, 2 * ChunkSize, 2*ChunkSize + ChunkSize/2}
+
+func TestRoundTrip(t *testing.T) {
+	key := testKey(t)
+	for _, size := range sizes {
+		content := plaintext(t, size)
+		sealed := encrypt(t, key, content)
+		if !IsEncrypted(sealed) {
+			t.Fatalf("%d bytes: sealed content without the header", size)
+		}
+		plainSize, err := PlaintextSize(int64(len(sealed)))
+		if err != nil || plainSize != int64(size) {
+			t.Fatalf("%d bytes: PlaintextSize = %d, %v", size, plainSize, err)
+		}
+		got, err := decrypt(key, sealed)
+		if err != nil {
+			t.Fatalf("%d bytes: %v", size, err)
+		}
+		if !bytes.Equal(got, content) {
+			t.Fatalf("%d bytes: decrypted %d different bytes", size, len(got))
+		}
+	}
+}
+
+func TestWrongKey(t *testing.T) {
+	sealed := encrypt(t, testKey(t), plaintext(t, 100))
+	_, err := decrypt(testKey(t), sealed)
+	if !errors.Is(err, ErrCorrupted) {
+		t.Fatalf("err = %v, want ErrCorrupted", err)
+	}
+}
+
+func TestTruncation(t *testing.T) {
+	key := testKey(t)
+	sealed := encrypt(t, key, plaintext(t, 2*ChunkSize+ChunkSize/2))
+	cuts := []int{0, len(magic), headerSize - 1, headerSize, headerSize + 1, headerSize + tagSize, len(sealed) - 1, len(sealed) - tagSize}
+	for _, boundary := range []int{headerSize + ChunkSize + tagSize, headerSize + 2*(ChunkSize+tagSize)} {
+		for _, delta := range []int{-1, 0, 1, 5, tagSize - 1, tagSize, tagSize + 1} {
+			cuts = append(cuts, boundary+delta)
+		}
+	}
+	for _, cut := range cuts {
+		got, err := decrypt(key, sealed[:cut])
+		if err == nil {
+			t.Fatalf("truncated to %d of %d bytes: decrypted %d bytes without an error", cut, len(sealed), len(got))
+		}
+	}
+}
+
+func TestTampering(t *testing.T) {
+	key := testKey(t)
+	sealed := encrypt(t, key, plaintext(t, 2*ChunkSize+10))
+	// the nonce prefix, then a byte of every chunk and of the last tag
+	offsets := []int{len(magic), headerSize, headerSize + ChunkSize + tagSize + 7, len(sealed) - tagSize - 1, len(sealed) - 1}
+	for _, offset := range offsets {
+		tampered := bytes.Clone(sealed)
+		tampered[offset] ^= 0x01
+		_, err := decrypt(key, tampered)
+		if !errors.Is(err, ErrCorrupted) {
+			t.Fatalf("byte %d flipped: err = %v, want ErrCorrupted", offset, err)
+		}
+	}
+}
+
+func TestReorderedChunks(t *testing.T) {
+	key := testKey(t)
+	sealed := encrypt(t, key, plaintext(t, 3*ChunkSize))
+	chunk := func(i int) []byte {
+		start := headerSize + i*(ChunkSize+tagSize)
+		return sealed[start : start+ChunkSize+tagSize]
+	}
+	orders := [][]int{{1, 0, 2}, {0, 2, 1}, {0, 1, 1}, {0, 0, 2}}
+	for _, order := range orders {
+		reordered := bytes.Clone(sealed[:headerSize])
+		for _, i := range order {
+			reordered = append(reordered, chunk(i)...)
+		}
+		_, err := decrypt(key, reordered)
+		if !errors.Is(err, ErrCorrupted) {
+			t.Fatalf("chunks %v: err = %v, want ErrCorrupted", order, err)
+		}
+	}
+	// dropping the last chunk leaves a chunk that was not sealed as final
+	_, err := decrypt(key, append(bytes.Clone(sealed[:headerSize]), append(chunk(0), chunk(1)...)...))
+	if !errors.Is(err, ErrCorrupted) {
+		t.Fatalf("last chunk dropped: err = %v, want ErrCorrupted", err)
+	}
+}
+
+func TestSeekThenRead(t *testing.T) {
+	key := testKey(t)
+	content := plaintext(t, 2*ChunkSize+ChunkSize/2)
+	sealed := encrypt(t, key, content)
+	reader, err := NewDecryptReader(bytes.NewReader(sealed), int64(len(sealed)), key)
+	if err != nil {
+		t.Fatal(err)
+	}
+	offsets := []int{0, 1, ChunkSize - 1, ChunkSize, ChunkSize + 1, 2*ChunkSize - 1, 2 * ChunkSize, len(content) - 1, len(content), 5}
+	for _, offset := range offsets {
+		position, err := reader.Seek(int64(offset), io.SeekStart)
+		if err != nil || position != int64(offset) {
+			t.Fatalf("Seek(%d) = %d, %v", offset, position, err)
+		}
+		got, err := io.ReadAll(reader)
+		if err != nil {
+			t.Fatalf("read from %d: %v", offset, err)
+		}
+		if !bytes.Equal(got, content[offset:]) {
+			t.Fatalf("read %d bytes from %d, want the %d bytes after it", len(got), offset, len(content)-offset)
+		}
+	}
+
+	// a range spanning a chunk boundary, as served for a Range request
+	position, err := reader.Seek(-int64(len(content)-ChunkSize+10), io.SeekEnd)
+	if err != nil || position != ChunkSize-10 {
+		t.Fatalf("Seek from the end = %d, %v", position, err)
+	}
+	got := make([]byte, 20)
+	if _, err := io.ReadFull(reader, got); err != nil {
+		t.Fatal(err)
+	}
+	if !bytes.Equal(got, content[ChunkSize-10:ChunkSize+10]) {
+		t.Fatal("range across the chunk boundary differs")
+	}
+	position, err = reader.Seek(-20, io.SeekCurrent)
+	if err != nil || position != ChunkSize-10 {
+		t.Fatalf("Seek back = %d, %v", position, err)
+	}
+	if _, err := reader.Seek(-1, io.SeekStart); err == nil {
+		t.Fatal("Seek before the start succeeded")
+	}
+}
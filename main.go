@@ -1,36 +1,202 @@
 package main
 
 import (
-	"github.com/draco121/horizon/database"
+	"context"
+	"flag"
+	"fmt"
 	"github.com/draco121/horizon/utils"
 	"github.com/gin-gonic/gin"
 	"github.com/joho/godotenv"
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
 	"os"
+	"pulse/archive"
+	"pulse/config"
 	"pulse/controllers"
 	"pulse/core"
+	"pulse/encryption"
+	"pulse/metrics"
+	"pulse/migrations"
 	"pulse/repository"
 	"pulse/routes"
+	"pulse/scanner"
+	"pulse/tracing"
+	"runtime"
+	"runtime/debug"
 )
 
-func RunApp() {
+func RunApp(cfg *config.Config) {
 	utils.Logger.Info("starting trainingservice...")
-	client := database.NewMongoDatabase(os.Getenv("MONGODB_URI"))
+	shutdownTracing, err := tracing.Setup(context.Background(), cfg.Tracing.Exporter)
+	if err != nil {
+		utils.Logger.Fatal("failed to set up tracing: ", err.Error())
+	}
+	defer func() {
+		err := shutdownTracing(context.Background())
+		if err != nil {
+			utils.Logger.Error("failed to flush traces: ", err.Error())
+		}
+	}()
+	client := newMongoClient(cfg.Mongo)
 	utils.Logger.Debug(utils.BaseDir())
-	db := client.Database("training-service")
-	repo := repository.NewTrainingRepository(db)
-	service := core.NewTrainingService(client, repo)
-	controller := controllers.NewControllers(service)
+	db := client.Database(cfg.Mongo.Database)
+	if cfg.Mongo.AutoMigrate {
+		ctx, cancel := context.WithTimeout(context.Background(), cfg.Mongo.MigrationTimeout)
+		_, err = migrations.NewMigrator(db, migrations.All).Up(ctx, migrations.Latest)
+		cancel()
+		if err != nil {
+			utils.Logger.Fatal("failed to migrate the database: ", err.Error())
+		}
+	}
+	repo := repository.NewTracedTrainingRepository(repository.NewTrainingRepository(db))
+	fileRepo := repository.NewFileRepository(db)
+	revisionRepo := repository.NewRevisionRepository(db)
+	usageRepo := repository.NewUsageRepository(db)
+	usageService := core.NewUsageService(usageRepo, quotaPolicy(cfg.Quota))
+	policyRepo := repository.NewPolicyRepository(db)
+	policyService := core.NewPolicyService(policyRepo, uploadPolicy(cfg.Upload))
+	keyService := core.NewKeyService(repository.NewDataKeyRepository(db), keyProvider(cfg.Encryption))
+	healthService := core.NewHealthService(client, core.HealthOptions{
+		MinFreeBytes: cfg.Storage.MinFreeBytes,
+		Build:        buildInfo(),
+		Config:       cfg.Summary(),
+	})
+	service := core.NewTracedTrainingService(core.NewTrainingService(repository.NewTransactions(client), repo, fileRepo, revisionRepo, usageService, policyService, newScanner(cfg.Scanner), keyService, archiveLimits(cfg.Archive)))
+	idempotencyService := core.NewIdempotencyService(repository.NewIdempotencyRepository(db), cfg.Idempotency.TTL)
+	controller := controllers.NewControllers(service, usageService, policyService, keyService, idempotencyService, healthService, controllers.Limits{
+		MaxMultipartMemory: cfg.Limits.MaxMultipartMemory,
+		MaxJSONBodySize:    cfg.Limits.MaxJSONBodySize,
+	})
 	router := gin.New()
+	// services read the request span from the context they are handed
+	router.ContextWithFallback = true
 	router.Use(gin.LoggerWithWriter(utils.Logger.Out))
+	router.Use(controllers.RequestId())
+	router.Use(controllers.Metrics())
+	router.Use(controllers.Tracing())
 	routes.RegisterRoutes(controller, router)
+	srv := newServer(cfg.Server, router, healthService, client)
+	srv.Go("rewrap-data-keys", func(ctx context.Context) error {
+		_, err := keyService.RewrapAll(ctx)
+		if err != nil {
+			utils.Logger.Error("failed to re-wrap data keys with the active master key: ", err.Error())
+		}
+		return err
+	})
 	utils.Logger.Info("started trainingservice...")
-	err := router.Run()
+	err = srv.Run()
+	if err != nil {
+		utils.Logger.Fatal(err.Error())
+	}
+}
+
+// newMongoClient connects like database.NewMongoDatabase and also counts the
+// failed commands and traces every command.
+func newMongoClient(cfg config.Mongo) *mongo.Client {
+	utils.Logger.Info("initializing mongo db connection")
+	ctx, cancel := context.WithTimeout(context.Background(), cfg.ConnectTimeout)
+	defer cancel()
+	client, err := mongo.Connect(ctx, options.Client().ApplyURI(cfg.URI).SetMonitor(tracing.CommandMonitor(metrics.CommandMonitor())))
+	if err != nil {
+		utils.Logger.Fatal("failed to connect to mongo db: ", err.Error())
+	}
+	utils.Logger.Info("connected to mongo db")
+	return client
+}
+
+func quotaPolicy(cfg config.Quota) core.QuotaPolicy {
+	return core.QuotaPolicy{
+		ProjectHard: core.QuotaLimits{MaxBytes: cfg.ProjectMaxBytes, MaxFiles: cfg.ProjectMaxFiles},
+		ProjectSoft: core.QuotaLimits{MaxBytes: cfg.ProjectSoftMaxBytes, MaxFiles: cfg.ProjectSoftMaxFiles},
+		BotHard:     core.QuotaLimits{MaxBytes: cfg.BotMaxBytes, MaxFiles: cfg.BotMaxFiles},
+		BotSoft:     core.QuotaLimits{MaxBytes: cfg.BotSoftMaxBytes, MaxFiles: cfg.BotSoftMaxFiles},
+	}
+}
+
+func uploadPolicy(cfg config.Upload) repository.UploadPolicy {
+	return repository.UploadPolicy{
+		AllowedMimeTypes: cfg.AllowedMimeTypes,
+		MaxFileSize:      cfg.MaxFileSize,
+		MaxRequestSize:   cfg.MaxRequestSize,
+		MaxFilesPerBot:   cfg.MaxFilesPerBot,
+	}
+}
+
+func newScanner(cfg config.Scanner) scanner.IScanner {
+	if cfg.ClamdAddress == "" {
+		utils.Logger.Warn("CLAMD_ADDRESS is not set, uploads will not be scanned for malware")
+		return scanner.NewNoopScanner()
+	}
+	s, err := scanner.NewClamdScanner(cfg.ClamdAddress, cfg.Timeout)
 	if err != nil {
 		utils.Logger.Fatal(err.Error())
-		return
 	}
+	return s
 }
+
+func keyProvider(cfg config.Encryption) encryption.IKeyProvider {
+	if cfg.KeyFile == "" {
+		utils.Logger.Warn("ENCRYPTION_KEY_FILE is not set, training files will be stored unencrypted")
+		return nil
+	}
+	provider, err := encryption.NewLocalKeyProvider(cfg.KeyFile)
+	if err != nil {
+		utils.Logger.Fatal(err.Error())
+	}
+	return provider
+}
+
+func archiveLimits(cfg config.Archive) archive.Limits {
+	return archive.Limits{
+		MaxEntries:   cfg.MaxEntries,
+		MaxTotalSize: cfg.MaxTotalSize,
+		MaxRatio:     cfg.MaxRatio,
+	}
+}
+
+// version is set at build time with -ldflags "-X main.version=...".
+var version = "dev"
+
+func buildInfo() core.BuildInfo {
+	build := core.BuildInfo{Version: version, GoVersion: runtime.Version()}
+	if info, ok := debug.ReadBuildInfo(); ok {
+		for _, setting := range info.Settings {
+			if setting.Key == "vcs.revision" {
+				build.Revision = setting.Value
+			}
+		}
+	}
+	return build
+}
+
+// loadConfig loads and validates the configuration, exiting with the list of
+// problems when it is invalid.
+func loadConfig(file string) *config.Config {
+	cfg, err := config.Load(file)
+	if err == nil {
+		err = cfg.Validate()
+	}
+	if err != nil {
+		fmt.Fprintln(os.Stderr, err.Error())
+		os.Exit(2)
+	}
+	return cfg
+}
+
 func main() {
 	_ = godotenv.Load()
-	RunApp()
+	if len(os.Args) > 1 {
+		switch os.Args[1] {
+		case "config":
+			os.Exit(configCommand(os.Args[2:]))
+		case "migrate":
+			os.Exit(migrateCommand(os.Args[2:]))
+		case "openapi":
+			os.Exit(openapiCommand(os.Args[2:]))
+		}
+	}
+	flags := flag.NewFlagSet("pulse", flag.ExitOnError)
+	file := flags.String("config", "", "YAML configuration file, "+config.FileEnv+" when not set")
+	_ = flags.Parse(os.Args[1:])
+	RunApp(loadConfig(*file))
 }
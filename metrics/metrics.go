@@ -0,0 +1,142 @@
+package metrics
+
+import (
+	"context"
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/collectors"
+	"github.com/prometheus/client_golang/prometheus/promauto"
+	"github.com/prometheus/client_golang/prometheus/promhttp"
+	"go.mongodb.org/mongo-driver/event"
+	"net/http"
+	"strconv"
+	"time"
+)
+
+const namespace = "pulse"
+
+const (
+	// BackendFilesystem is the storage backend keeping files on the local disk.
+	BackendFilesystem = "filesystem"
+	// BackendMemory keeps files in memory, for tests.
+	BackendMemory = "memory"
+)
+
+const (
+	OutcomeStored   = "stored"
+	OutcomeRejected = "rejected"
+	OutcomeSkipped  = "skipped"
+)
+
+// durationBuckets reach a minute as uploads and archive downloads take far
+// longer than the other requests.
+var durationBuckets = []float64{.005, .01, .025, .05, .1, .25, .5, 1, 2.5, 5, 10, 30, 60}
+
+var registry = prometheus.NewRegistry()
+
+// Label values only come from fixed sets such as route patterns, storage
+// operations and MongoDB command names, never from ids or file names, so that
+// the number of series stays bounded.
+var (
+	requests = promauto.With(registry).NewCounterVec(prometheus.CounterOpts{
+		Namespace: namespace,
+		Subsystem: "http",
+		Name:      "requests_total",
+		Help:      "HTTP requests by method, route pattern and status code.",
+	}, []string{"method", "route", "status"})
+	requestDuration = promauto.With(registry).NewHistogramVec(prometheus.HistogramOpts{
+		Namespace: namespace,
+		Subsystem: "http",
+		Name:      "request_duration_seconds",
+		Help:      "HTTP request latency by method and route pattern.",
+		Buckets:   durationBuckets,
+	}, []string{"method", "route"})
+	uploadBytes = promauto.With(registry).NewCounter(prometheus.CounterOpts{
+		Namespace: namespace,
+		Subsystem: "upload",
+		Name:      "bytes_total",
+		Help:      "Bytes of training files stored by uploads.",
+	})
+	uploadFiles = promauto.With(registry).NewCounterVec(prometheus.CounterOpts{
+		Namespace: namespace,
+		Subsystem: "upload",
+		Name:      "files_total",
+		Help:      "Uploaded files by outcome: stored, rejected by the policy or scanner, or skipped archive entries.",
+	}, []string{"outcome"})
+	// UploadsInFlight counts the uploads being processed.
+	UploadsInFlight = promauto.With(registry).NewGauge(prometheus.GaugeOpts{
+		Namespace: namespace,
+		Subsystem: "upload",
+		Name:      "in_flight",
+		Help:      "Uploads being processed.",
+	})
+	storageDuration = promauto.With(registry).NewHistogramVec(prometheus.HistogramOpts{
+		Namespace: namespace,
+		Subsystem: "storage",
+		Name:      "operation_duration_seconds",
+		Help:      "Latency of file storage operations by backend and operation.",
+		Buckets:   durationBuckets,
+	}, []string{"backend", "operation"})
+	mongoErrors = promauto.With(registry).NewCounterVec(prometheus.CounterOpts{
+		Namespace: namespace,
+		Subsystem: "mongo",
+		Name:      "command_errors_total",
+		Help:      "Failed MongoDB commands by command name.",
+	}, []string{"command"})
+	// TransactionAborts counts the transactions ended without a commit.
+	TransactionAborts = promauto.With(registry).NewCounter(prometheus.CounterOpts{
+		Namespace: namespace,
+		Subsystem: "mongo",
+		Name:      "transaction_aborts_total",
+		Help:      "MongoDB transactions ended without being committed.",
+	})
+)
+
+func init() {
+	registry.MustRegister(collectors.NewGoCollector(), collectors.NewProcessCollector(collectors.ProcessCollectorOpts{}))
+}
+
+// Handler serves the metrics in the Prometheus text format.
+func Handler() http.Handler {
+	return promhttp.HandlerFor(registry, promhttp.HandlerOpts{})
+}
+
+// methods are the HTTP methods recorded as they are, any other is recorded
+// as OTHER.
+var methods = map[string]bool{
+	http.MethodGet: true, http.MethodHead: true, http.MethodPost: true, http.MethodPut: true,
+	http.MethodPatch: true, http.MethodDelete: true, http.MethodOptions: true,
+}
+
+// ObserveRequest records a served request. route is the pattern the request
+// matched, not its path.
+func ObserveRequest(method string, route string, status int, elapsed time.Duration) {
+	if !methods[method] {
+		method = "OTHER"
+	}
+	requests.WithLabelValues(method, route, strconv.Itoa(status)).Inc()
+	requestDuration.WithLabelValues(method, route).Observe(elapsed.Seconds())
+}
+
+// RecordUpload records the files of an upload by outcome and the bytes
+// stored.
+func RecordUpload(bytes int64, stored int, rejected int, skipped int) {
+	uploadBytes.Add(float64(bytes))
+	uploadFiles.WithLabelValues(OutcomeStored).Add(float64(stored))
+	uploadFiles.WithLabelValues(OutcomeRejected).Add(float64(rejected))
+	uploadFiles.WithLabelValues(OutcomeSkipped).Add(float64(skipped))
+}
+
+// ObserveStorage records a storage operation that began at start, meant to be
+// deferred at the top of the operation.
+func ObserveStorage(backend string, operation string, start time.Time) {
+	storageDuration.WithLabelValues(backend, operation).Observe(time.Since(start).Seconds())
+}
+
+// CommandMonitor counts the failed commands of a MongoDB client.
+func CommandMonitor() *event.CommandMonitor {
+	return &event.CommandMonitor{
+		Failed: func(ctx context.Context, failed *event.CommandFailedEvent) {
+			mongoErrors.WithLabelValues(failed.CommandName).Inc()
+		},
+	}
+}
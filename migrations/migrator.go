@@ -0,0 +1,279 @@
+package migrations
+
+import (
+	"context"
+	"crypto/rand"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"github.com/draco121/horizon/utils"
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/mongo"
+	"os"
+	"sort"
+	"time"
+)
+
+// Latest targets the newest migration.
+const Latest = int(^uint(0) >> 1)
+
+const (
+	appliedCollection = "migrations"
+	lockCollection    = "migration-lock"
+	lockId            = "migrations"
+	// lockLease is how long a lock outlives a process that died holding it,
+	// the holder renews it well before.
+	lockLease    = time.Minute
+	lockPollWait = 2 * time.Second
+)
+
+var ErrLocked = errors.New("migrations are locked by another process")
+
+// Migration changes the database from Version-1 to Version. Up and Down must
+// be safe to run again after failing halfway, as a failed migration is not
+// recorded.
+type Migration struct {
+	Version     int
+	Description string
+	Up          func(ctx context.Context, db *mongo.Database) error
+	// Down reverts Up, nil when the migration cannot be reverted.
+	Down func(ctx context.Context, db *mongo.Database) error
+}
+
+// Record marks an applied migration.
+type Record struct {
+	Version     int       `bson:"_id"`
+	Description string    `bson:"description"`
+	AppliedAt   time.Time `bson:"appliedAt"`
+}
+
+type Status struct {
+	Version     int        `json:"version"`
+	Description string     `json:"description"`
+	AppliedAt   *time.Time `json:"appliedAt,omitempty"`
+}
+
+type lockRecord struct {
+	Id        string    `bson:"_id"`
+	Owner     string    `bson:"owner"`
+	ExpiresAt time.Time `bson:"expiresAt"`
+}
+
+type IMigrator interface {
+	// Status lists every migration along with when it was applied.
+	Status(ctx context.Context) ([]Status, error)
+	// Up applies the pending migrations up to and including target and
+	// returns those it applied.
+	Up(ctx context.Context, target int) ([]Migration, error)
+	// Down reverts the applied migrations above target, newest first, and
+	// returns those it reverted.
+	Down(ctx context.Context, target int) ([]Migration, error)
+}
+
+type migrator struct {
+	IMigrator
+	db         *mongo.Database
+	migrations []Migration
+	owner      string
+}
+
+// NewMigrator runs migrations against db. Up and Down hold a lock in the
+// database while they run so that replicas starting together apply each
+// migration once, the others wait for the lock and find nothing left to do.
+func NewMigrator(db *mongo.Database, migrations []Migration) IMigrator {
+	sorted := append([]Migration(nil), migrations...)
+	sort.Slice(sorted, func(i, j int) bool {
+		return sorted[i].Version < sorted[j].Version
+	})
+	for i := 1; i < len(sorted); i++ {
+		if sorted[i].Version == sorted[i-1].Version {
+			panic(fmt.Sprintf("migration version %d is used twice", sorted[i].Version))
+		}
+	}
+	return &migrator{
+		db:         db,
+		migrations: sorted,
+		owner:      lockOwner(),
+	}
+}
+
+func lockOwner() string {
+	host, _ := os.Hostname()
+	suffix := make([]byte, 4)
+	_, _ = rand.Read(suffix)
+	return fmt.Sprintf("%s/%d/%s", host, os.Getpid(), hex.EncodeToString(suffix))
+}
+
+func (m *migrator) Status(ctx context.Context) ([]Status, error) {
+	applied, err := m.applied(ctx)
+	if err != nil {
+		return nil, err
+	}
+	statuses := make([]Status, 0, len(m.migrations))
+	for _, migration := range m.migrations {
+		status := Status{Version: migration.Version, Description: migration.Description}
+		if record, ok := applied[migration.Version]; ok {
+			status.AppliedAt = &record.AppliedAt
+		}
+		statuses = append(statuses, status)
+	}
+	return statuses, nil
+}
+
+func (m *migrator) Up(ctx context.Context, target int) ([]Migration, error) {
+	unlock, err := m.lock(ctx)
+	if err != nil {
+		return nil, err
+	}
+	defer unlock()
+	applied, err := m.applied(ctx)
+	if err != nil {
+		return nil, err
+	}
+	var done []Migration
+	for _, migration := range m.migrations {
+		if _, ok := applied[migration.Version]; ok || migration.Version > target {
+			continue
+		}
+		utils.Logger.Info("applying migration ", migration.Version, ": ", migration.Description)
+		err = migration.Up(ctx, m.db)
+		if err != nil {
+			return done, fmt.Errorf("migration %d (%s): %w", migration.Version, migration.Description, err)
+		}
+		_, err = m.db.Collection(appliedCollection).InsertOne(ctx, Record{
+			Version:     migration.Version,
+			Description: migration.Description,
+			AppliedAt:   time.Now().UTC(),
+		})
+		if err != nil {
+			return done, err
+		}
+		done = append(done, migration)
+	}
+	return done, nil
+}
+
+func (m *migrator) Down(ctx context.Context, target int) ([]Migration, error) {
+	unlock, err := m.lock(ctx)
+	if err != nil {
+		return nil, err
+	}
+	defer unlock()
+	applied, err := m.applied(ctx)
+	if err != nil {
+		return nil, err
+	}
+	var done []Migration
+	for i := len(m.migrations) - 1; i >= 0; i-- {
+		migration := m.migrations[i]
+		if _, ok := applied[migration.Version]; !ok || migration.Version <= target {
+			continue
+		}
+		if migration.Down == nil {
+			return done, fmt.Errorf("migration %d (%s) cannot be reverted", migration.Version, migration.Description)
+		}
+		utils.Logger.Info("reverting migration ", migration.Version, ": ", migration.Description)
+		err = migration.Down(ctx, m.db)
+		if err != nil {
+			return done, fmt.Errorf("migration %d (%s): %w", migration.Version, migration.Description, err)
+		}
+		_, err = m.db.Collection(appliedCollection).DeleteOne(ctx, bson.D{{Key: "_id", Value: migration.Version}})
+		if err != nil {
+			return done, err
+		}
+		done = append(done, migration)
+	}
+	return done, nil
+}
+
+func (m *migrator) applied(ctx context.Context) (map[int]Record, error) {
+	cursor, err := m.db.Collection(appliedCollection).Find(ctx, bson.D{})
+	if err != nil {
+		return nil, err
+	}
+	var records []Record
+	err = cursor.All(ctx, &records)
+	if err != nil {
+		return nil, err
+	}
+	applied := make(map[int]Record, len(records))
+	for _, record := range records {
+		applied[record.Version] = record
+	}
+	return applied, nil
+}
+
+// lock waits until it holds the migration lock or ctx is done. The lock is
+// renewed in the background until the returned function releases it.
+func (m *migrator) lock(ctx context.Context) (func(), error) {
+	for {
+		acquired, err := m.tryLock(ctx)
+		if err != nil {
+			return nil, err
+		}
+		if acquired {
+			break
+		}
+		utils.Logger.Info("waiting for another process to finish migrating")
+		select {
+		case <-ctx.Done():
+			return nil, fmt.Errorf("%w: %s", ErrLocked, ctx.Err().Error())
+		case <-time.After(lockPollWait):
+		}
+	}
+	stop := make(chan struct{})
+	stopped := make(chan struct{})
+	go func() {
+		defer close(stopped)
+		ticker := time.NewTicker(lockLease / 3)
+		defer ticker.Stop()
+		for {
+			select {
+			case <-stop:
+				return
+			case <-ticker.C:
+				err := m.renewLock(context.WithoutCancel(ctx))
+				if err != nil {
+					utils.Logger.Error("failed to renew the migration lock: ", err.Error())
+				}
+			}
+		}
+	}()
+	return func() {
+		close(stop)
+		<-stopped
+		filter := bson.D{{Key: "_id", Value: lockId}, {Key: "owner", Value: m.owner}}
+		_, err := m.db.Collection(lockCollection).DeleteOne(context.WithoutCancel(ctx), filter)
+		if err != nil {
+			utils.Logger.Error("failed to release the migration lock: ", err.Error())
+		}
+	}, nil
+}
+
+// tryLock takes the lock when it is free or its holder let it expire.
+func (m *migrator) tryLock(ctx context.Context) (bool, error) {
+	now := time.Now().UTC()
+	record := lockRecord{Id: lockId, Owner: m.owner, ExpiresAt: now.Add(lockLease)}
+	_, err := m.db.Collection(lockCollection).InsertOne(ctx, record)
+	if !mongo.IsDuplicateKeyError(err) {
+		return err == nil, err
+	}
+	filter := bson.D{{Key: "_id", Value: lockId}, {Key: "expiresAt", Value: bson.M{"$lte": now}}}
+	result, err := m.db.Collection(lockCollection).ReplaceOne(ctx, filter, record)
+	if err != nil {
+		return false, err
+	}
+	return result.MatchedCount > 0, nil
+}
+
+func (m *migrator) renewLock(ctx context.Context) error {
+	filter := bson.D{{Key: "_id", Value: lockId}, {Key: "owner", Value: m.owner}}
+	update := bson.M{"$set": bson.M{"expiresAt": time.Now().UTC().Add(lockLease)}}
+	result, err := m.db.Collection(lockCollection).UpdateOne(ctx, filter, update)
+	if err != nil {
+		return err
+	} else if result.MatchedCount == 0 {
+		return errors.New("the lock was taken over after it expired")
+	}
+	return nil
+}
@@ -0,0 +1,258 @@
+package migrations
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
+	"strings"
+)
+
+// All are the migrations of the service database, in order. Released
+// migrations must not change, add a new one instead.
+var All = []Migration{
+	{
+		Version:     1,
+		Description: "unique index on training-data owner, project and bot",
+		Up: func(ctx context.Context, db *mongo.Database) error {
+			return createUniqueIndex(ctx, db, "training-data", "owner_projectid_botid", bson.D{
+				{Key: "owner", Value: 1},
+				{Key: "projectid", Value: 1},
+				{Key: "botid", Value: 1},
+			})
+		},
+		Down: dropIndexes("training-data", "owner_projectid_botid"),
+	},
+	{
+		Version:     2,
+		Description: "indexes for listing file metadata and revisions",
+		Up: func(ctx context.Context, db *mongo.Database) error {
+			err := createIndexes(ctx, db, "file-metadata", mongo.IndexModel{
+				Keys: bson.D{
+					{Key: "owner", Value: 1},
+					{Key: "projectId", Value: 1},
+					{Key: "botId", Value: 1},
+					{Key: "folder", Value: 1},
+					{Key: "fileName", Value: 1},
+				},
+				Options: options.Index().SetName("owner_projectId_botId_folder_fileName"),
+			})
+			if err != nil {
+				return err
+			}
+			return createUniqueIndex(ctx, db, "file-revisions", "owner_fileId_revision", bson.D{
+				{Key: "owner", Value: 1},
+				{Key: "fileId", Value: 1},
+				{Key: "revision", Value: 1},
+			})
+		},
+		Down: func(ctx context.Context, db *mongo.Database) error {
+			err := dropIndexes("file-metadata", "owner_projectId_botId_folder_fileName")(ctx, db)
+			if err != nil {
+				return err
+			}
+			return dropIndexes("file-revisions", "owner_fileId_revision")(ctx, db)
+		},
+	},
+	{
+		Version:     3,
+		Description: "unique index on usage counters and index on usage events",
+		Up: func(ctx context.Context, db *mongo.Database) error {
+			err := createUniqueIndex(ctx, db, "usage", "owner_projectId_botId", bson.D{
+				{Key: "owner", Value: 1},
+				{Key: "projectId", Value: 1},
+				{Key: "botId", Value: 1},
+			})
+			if err != nil {
+				return err
+			}
+			return createIndexes(ctx, db, "usage-events", mongo.IndexModel{
+				Keys: bson.D{
+					{Key: "owner", Value: 1},
+					{Key: "projectId", Value: 1},
+					{Key: "createdAt", Value: -1},
+				},
+				Options: options.Index().SetName("owner_projectId_createdAt"),
+			})
+		},
+		Down: func(ctx context.Context, db *mongo.Database) error {
+			err := dropIndexes("usage", "owner_projectId_botId")(ctx, db)
+			if err != nil {
+				return err
+			}
+			return dropIndexes("usage-events", "owner_projectId_createdAt")(ctx, db)
+		},
+	},
+	{
+		Version:     4,
+		Description: "index data keys by master key and expire idempotency keys",
+		Up: func(ctx context.Context, db *mongo.Database) error {
+			err := createIndexes(ctx, db, "data-keys", mongo.IndexModel{
+				Keys:    bson.D{{Key: "masterKeyId", Value: 1}},
+				Options: options.Index().SetName("masterKeyId"),
+			})
+			if err != nil {
+				return err
+			}
+			// keeps the default name of the index the service used to create
+			// on every start, so that existing deployments are left as they are
+			return createIndexes(ctx, db, "idempotency-keys", mongo.IndexModel{
+				Keys:    bson.D{{Key: "expiresAt", Value: 1}},
+				Options: options.Index().SetName("expiresAt_1").SetExpireAfterSeconds(0),
+			})
+		},
+		Down: func(ctx context.Context, db *mongo.Database) error {
+			err := dropIndexes("data-keys", "masterKeyId")(ctx, db)
+			if err != nil {
+				return err
+			}
+			return dropIndexes("idempotency-keys", "expiresAt_1")(ctx, db)
+		},
+	},
+	{
+		Version:     5,
+		Description: "key data keys by owner and project",
+		// data keys used to be stored under the id of their project, keys
+		// created since cannot be stored that way again
+		Up: func(ctx context.Context, db *mongo.Database) error {
+			filter := bson.M{"projectId": bson.M{"$exists": false}}
+			update := mongo.Pipeline{{{Key: "$set", Value: bson.M{"projectId": "$_id"}}}}
+			_, err := db.Collection("data-keys").UpdateMany(ctx, filter, update)
+			if err != nil {
+				return err
+			}
+			return createUniqueIndex(ctx, db, "data-keys", "owner_projectId", bson.D{
+				{Key: "owner", Value: 1},
+				{Key: "projectId", Value: 1},
+			})
+		},
+	},
+	{
+		Version:     6,
+		Description: "key upload policies by owner and project",
+		// like data keys, policies used to be stored under the id of their
+		// project
+		Up: func(ctx context.Context, db *mongo.Database) error {
+			filter := bson.M{"projectId": bson.M{"$exists": false}}
+			update := mongo.Pipeline{{{Key: "$set", Value: bson.M{"projectId": "$_id"}}}}
+			_, err := db.Collection("upload-policies").UpdateMany(ctx, filter, update)
+			if err != nil {
+				return err
+			}
+			return createUniqueIndex(ctx, db, "upload-policies", "owner_projectId", bson.D{
+				{Key: "owner", Value: 1},
+				{Key: "projectId", Value: 1},
+			})
+		},
+	},
+	{
+		Version:     7,
+		Description: "count usage per project",
+		Up: func(ctx context.Context, db *mongo.Database) error {
+			err := createUniqueIndex(ctx, db, "project-usage", "owner_projectId", bson.D{
+				{Key: "owner", Value: 1},
+				{Key: "projectId", Value: 1},
+			})
+			if err != nil {
+				return err
+			}
+			// sums the counters of the bots, replacing the totals of a
+			// previous attempt
+			pipeline := mongo.Pipeline{
+				{{Key: "$group", Value: bson.M{
+					"_id":       bson.M{"owner": "$owner", "projectId": "$projectId"},
+					"bytes":     bson.M{"$sum": "$bytes"},
+					"files":     bson.M{"$sum": "$files"},
+					"updatedAt": bson.M{"$max": "$updatedAt"},
+				}}},
+				{{Key: "$project", Value: bson.M{
+					"_id":       0,
+					"owner":     "$_id.owner",
+					"projectId": "$_id.projectId",
+					"bytes":     1,
+					"files":     1,
+					"updatedAt": 1,
+				}}},
+				{{Key: "$merge", Value: bson.M{
+					"into":           "project-usage",
+					"on":             bson.A{"owner", "projectId"},
+					"whenMatched":    "replace",
+					"whenNotMatched": "insert",
+				}}},
+			}
+			cursor, err := db.Collection("usage").Aggregate(ctx, pipeline)
+			if err != nil {
+				return err
+			}
+			return cursor.Close(ctx)
+		},
+		Down: func(ctx context.Context, db *mongo.Database) error {
+			return db.Collection("project-usage").Drop(ctx)
+		},
+	},
+}
+
+func createIndexes(ctx context.Context, db *mongo.Database, collection string, models ...mongo.IndexModel) error {
+	_, err := db.Collection(collection).Indexes().CreateMany(ctx, models)
+	return err
+}
+
+// createUniqueIndex refuses to start building the index when documents
+// already share a key, naming a few of them so that they can be merged by
+// hand.
+func createUniqueIndex(ctx context.Context, db *mongo.Database, collection string, name string, keys bson.D) error {
+	group := bson.M{}
+	for _, key := range keys {
+		group[key.Key] = "$" + key.Key
+	}
+	pipeline := mongo.Pipeline{
+		{{Key: "$group", Value: bson.M{"_id": group, "count": bson.M{"$sum": 1}}}},
+		{{Key: "$match", Value: bson.M{"count": bson.M{"$gt": 1}}}},
+		{{Key: "$limit", Value: 5}},
+	}
+	cursor, err := db.Collection(collection).Aggregate(ctx, pipeline)
+	if err != nil {
+		return err
+	}
+	var duplicates []bson.M
+	err = cursor.All(ctx, &duplicates)
+	if err != nil {
+		return err
+	}
+	if len(duplicates) > 0 {
+		examples := make([]string, 0, len(duplicates))
+		for _, duplicate := range duplicates {
+			examples = append(examples, fmt.Sprintf("%v", duplicate["_id"]))
+		}
+		return fmt.Errorf("%s has documents sharing the key of index %s, remove the duplicates first: %s", collection, name, strings.Join(examples, "; "))
+	}
+	return createIndexes(ctx, db, collection, mongo.IndexModel{
+		Keys:    keys,
+		Options: options.Index().SetName(name).SetUnique(true),
+	})
+}
+
+// dropIndexes drops indexes by name, ignoring those that do not exist.
+func dropIndexes(collection string, names ...string) func(ctx context.Context, db *mongo.Database) error {
+	return func(ctx context.Context, db *mongo.Database) error {
+		for _, name := range names {
+			_, err := db.Collection(collection).Indexes().DropOne(ctx, name)
+			if err != nil && !isMissing(err) {
+				return err
+			}
+		}
+		return nil
+	}
+}
+
+// isMissing tells whether a command failed because its index or namespace
+// does not exist.
+func isMissing(err error) bool {
+	var commandErr mongo.CommandError
+	if errors.As(err, &commandErr) {
+		return commandErr.Code == 26 || commandErr.Code == 27
+	}
+	return false
+}
@@ -0,0 +1,207 @@
+package naming
+
+import (
+	"errors"
+	"fmt"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"golang.org/x/text/unicode/norm"
+	"path/filepath"
+	"regexp"
+	"strings"
+	"unicode"
+	"unicode/utf8"
+)
+
+// MaxFileNameLength is the longest accepted original file name in bytes, the
+// limit of most file systems.
+const MaxFileNameLength = 255
+
+var ErrInvalidFileName = errors.New("invalid file name")
+
+var extensionPattern = regexp.MustCompile(`^\.[a-z0-9]{1,16}$`)
+
+// reservedNames cannot be used as file names on Windows, where training files
+// are sometimes downloaded to.
+var reservedNames = map[string]bool{
+	"CON": true, "PRN": true, "AUX": true, "NUL": true,
+	"COM1": true, "COM2": true, "COM3": true, "COM4": true, "COM5": true,
+	"COM6": true, "COM7": true, "COM8": true, "COM9": true,
+	"LPT1": true, "LPT2": true, "LPT3": true, "LPT4": true, "LPT5": true,
+	"LPT6": true, "LPT7": true, "LPT8": true, "LPT9": true,
+}
+
+// NormalizeFileName turns a client supplied file name into a display name:
+// directory components are dropped, the name is NFC normalized and names that
+// are empty, too long, contain control characters or are reserved are refused.
+// The result is only ever stored as metadata, never used as a path.
+func NormalizeFileName(name string) (string, error) {
+	if !utf8.ValidString(name) {
+		return "", fmt.Errorf("%w: not valid UTF-8", ErrInvalidFileName)
+	}
+	// browsers on Windows send full paths, keep only the last element
+	if i := strings.LastIndexAny(name, `/\`); i >= 0 {
+		name = name[i+1:]
+	}
+	name = norm.NFC.String(name)
+	name = strings.TrimRight(strings.TrimSpace(name), ". ")
+	if name == "" {
+		return "", fmt.Errorf("%w: empty name", ErrInvalidFileName)
+	}
+	if len(name) > MaxFileNameLength {
+		return "", fmt.Errorf("%w: longer than %d bytes", ErrInvalidFileName, MaxFileNameLength)
+	}
+	for _, r := range name {
+		if unicode.IsControl(r) || r == unicode.ReplacementChar || unicode.Is(unicode.Bidi_Control, r) {
+			return "", fmt.Errorf("%w: contains control characters", ErrInvalidFileName)
+		}
+		if strings.ContainsRune(`<>:"|?*`, r) {
+			return "", fmt.Errorf("%w: contains reserved character %q", ErrInvalidFileName, r)
+		}
+	}
+	base := strings.ToUpper(name)
+	if i := strings.IndexByte(base, '.'); i >= 0 {
+		base = base[:i]
+	}
+	if reservedNames[strings.TrimSpace(base)] {
+		return "", fmt.Errorf("%w: %s is a reserved name", ErrInvalidFileName, name)
+	}
+	return name, nil
+}
+
+// SanitizeExtension returns the extension lower cased when it is short and
+// alphanumeric, and an empty string otherwise.
+func SanitizeExtension(extension string) string {
+	extension = strings.ToLower(extension)
+	if extensionPattern.MatchString(extension) {
+		return extension
+	}
+	return ""
+}
+
+// StorageKey is the name a file is stored under in the bot space. It is
+// generated from the file id only, never from the client supplied name.
+func StorageKey(fileId primitive.ObjectID, extension string) string {
+	return fileId.Hex() + SanitizeExtension(extension)
+}
+
+// IsLegacyKey reports whether name is how the file stored under key was
+// named before extensions were sanitized: its id followed by the extension of
+// the uploaded name as it was, such as ".PDF" or an over long one.
+func IsLegacyKey(key string, name string) bool {
+	idLength := len(primitive.NilObjectID.Hex())
+	if name == key || len(key) < idLength || len(name) < idLength || name[:idLength] != key[:idLength] {
+		return false
+	}
+	fileId, err := primitive.ObjectIDFromHex(key[:idLength])
+	if err != nil {
+		return false
+	}
+	return StorageKey(fileId, name[idLength:]) == key
+}
+
+// ResolveInBotSpace joins a storage key to the bot space and makes sure the
+// result cannot point outside of it.
+func ResolveInBotSpace(botSpace string, key string) (string, error) {
+	if key == "" || key != filepath.Base(key) || strings.ContainsAny(key, `/\`) || key == "." || key == ".." {
+		return "", fmt.Errorf("%w: storage key %q", ErrInvalidFileName, key)
+	}
+	root, err := filepath.Abs(botSpace)
+	if err != nil {
+		return "", err
+	}
+	resolved := filepath.Join(root, key)
+	rel, err := filepath.Rel(root, resolved)
+	if err != nil || rel != key {
+		return "", fmt.Errorf("%w: storage key %q escapes the bot space", ErrInvalidFileName, key)
+	}
+	return resolved, nil
+}
+
+// ContentDisposition builds an RFC 6266 attachment header carrying the name
+// both as a quoted ASCII fallback and as an RFC 5987 encoded UTF-8 value.
+func ContentDisposition(name string) string {
+	var fallback strings.Builder
+	for _, r := range name {
+		switch {
+		case r == '"' || r == '\\':
+			fallback.WriteByte('_')
+		case r < 0x20 || r == 0x7f:
+			continue
+		case r < utf8.RuneSelf:
+			fallback.WriteRune(r)
+		default:
+			fallback.WriteByte('_')
+		}
+	}
+	var encoded strings.Builder
+	for i := 0; i < len(name); i++ {
+		c := name[i]
+		if isAttrChar(c) {
+			encoded.WriteByte(c)
+		} else {
+			fmt.Fprintf(&encoded, "%%%02X", c)
+		}
+	}
+	return fmt.Sprintf(`attachment; filename="%s"; filename*=UTF-8''%s`, fallback.String(), encoded.String())
+}
+
+// isAttrChar reports whether c may appear unencoded in an RFC 5987 value.
+func isAttrChar(c byte) bool {
+	return ('a' <= c && c <= 'z') || ('A' <= c && c <= 'Z') || ('0' <= c && c <= '9') ||
+		strings.IndexByte("!#$&+-.^_`|~", c) >= 0
+}
+
+// UniqueName returns name, or name with a " (n)" suffix before its extension
+// when it is already in taken, and records the result. Names are compared
+// case-insensitively as archives are often extracted on such file systems.
+func UniqueName(name string, taken map[string]bool) string {
+	extension := filepath.Ext(name)
+	base := strings.TrimSuffix(name, extension)
+	candidate := name
+	for n := 1; taken[strings.ToLower(candidate)]; n++ {
+		candidate = fmt.Sprintf("%s (%d)%s", base, n, extension)
+	}
+	taken[strings.ToLower(candidate)] = true
+	return candidate
+}
+
+// MaxFolderDepth and MaxFolderLength bound virtual folder paths.
+const (
+	MaxFolderDepth  = 32
+	MaxFolderLength = 1024
+)
+
+// NormalizeFolder turns a slash separated virtual folder path into its
+// canonical form, without leading or trailing slashes. Every element must be a
+// valid file name and "." or ".." elements are refused. The root is "".
+func NormalizeFolder(folder string) (string, error) {
+	if strings.Contains(folder, `\`) {
+		return "", fmt.Errorf("%w: folders are separated by /", ErrInvalidFileName)
+	}
+	elements := make([]string, 0)
+	for _, element := range strings.Split(folder, "/") {
+		if element == "" {
+			continue
+		} else if element == "." || element == ".." {
+			return "", fmt.Errorf("%w: folder may not contain %q", ErrInvalidFileName, element)
+		}
+		normalized, err := NormalizeFileName(element)
+		if err != nil {
+			return "", err
+		}
+		elements = append(elements, normalized)
+	}
+	if len(elements) > MaxFolderDepth {
+		return "", fmt.Errorf("%w: folder deeper than %d levels", ErrInvalidFileName, MaxFolderDepth)
+	}
+	normalized := strings.Join(elements, "/")
+	if len(normalized) > MaxFolderLength {
+		return "", fmt.Errorf("%w: folder longer than %d bytes", ErrInvalidFileName, MaxFolderLength)
+	}
+	return normalized, nil
+}
+
+// RevisionKey is the name a previous revision of a file is stored under.
+func RevisionKey(fileId primitive.ObjectID, revision int64, extension string) string {
+	return fmt.Sprintf("%s.r%d%s", fileId.Hex(), revision, SanitizeExtension(extension))
+}
@@ -0,0 +1,127 @@
+package naming
+
+import (
+	"errors"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func TestNormalizeFileNameRejects(t *testing.T) {
+	names := map[string]string{
+		"dot dot":           "..",
+		"empty":             "",
+		"only a directory":  "../../",
+		"NUL byte":          "a\x00b.txt",
+		"newline":           "a\nb.txt",
+		"RTL override":      "evil\u202Etxt.exe",
+		"invalid UTF-8":     "\xff.txt",
+		"reserved CON":      "CON",
+		"reserved con.txt":  "con.txt",
+		"reserved LPT1":     "LPT1.pdf",
+		"reserved nul":      "nul",
+		"reserved char":     "a<b.txt",
+		"alternate stream":  "a.txt:stream",
+		"over 255 bytes":    strings.Repeat("a", MaxFileNameLength) + ".txt",
+		"windows traversal": `..\..\`,
+	}
+	for test, name := range names {
+		t.Run(test, func(t *testing.T) {
+			normalized, err := NormalizeFileName(name)
+			if !errors.Is(err, ErrInvalidFileName) {
+				t.Fatalf("NormalizeFileName(%q) = %q, %v, want ErrInvalidFileName", name, normalized, err)
+			}
+		})
+	}
+}
+
+func TestNormalizeFileName(t *testing.T) {
+	names := map[string]string{
+		"../../etc/passwd":      "passwd",
+		`..\..\boot.ini`:        "boot.ini",
+		`C:\Users\me\notes.txt`: "notes.txt",
+		"report.pdf...":         "report.pdf",
+		"  spaced.txt  ":        "spaced.txt",
+		"cafe\u0301.txt":        "caf\u00e9.txt",
+		"CONSOLE.txt":           "CONSOLE.txt",
+	}
+	for name, want := range names {
+		normalized, err := NormalizeFileName(name)
+		if err != nil || normalized != want {
+			t.Errorf("NormalizeFileName(%q) = %q, %v, want %q", name, normalized, err, want)
+		}
+	}
+}
+
+func TestSanitizeExtension(t *testing.T) {
+	extensions := map[string]string{
+		".pdf":               ".pdf",
+		".PDF":               ".pdf",
+		".tar~":              "",
+		"./../x":             "",
+		".a\x00b":            "",
+		".":                  "",
+		"":                   "",
+		".abcdefghijklmnop":  ".abcdefghijklmnop",
+		".abcdefghijklmnopq": "",
+	}
+	for extension, want := range extensions {
+		if sanitized := SanitizeExtension(extension); sanitized != want {
+			t.Errorf("SanitizeExtension(%q) = %q, want %q", extension, sanitized, want)
+		}
+	}
+}
+
+func TestResolveInBotSpace(t *testing.T) {
+	botSpace := t.TempDir()
+	for _, key := range []string{"", ".", "..", "../x", "a/b", `a\b`, "/etc/passwd"} {
+		resolved, err := ResolveInBotSpace(botSpace, key)
+		if !errors.Is(err, ErrInvalidFileName) {
+			t.Errorf("ResolveInBotSpace(%q) = %q, %v, want ErrInvalidFileName", key, resolved, err)
+		}
+	}
+	key := StorageKey(primitive.NewObjectID(), ".txt")
+	resolved, err := ResolveInBotSpace(botSpace, key)
+	if err != nil || resolved != filepath.Join(botSpace, key) {
+		t.Fatalf("ResolveInBotSpace(%q) = %q, %v", key, resolved, err)
+	}
+}
+
+func TestNormalizeFolder(t *testing.T) {
+	for _, folder := range []string{"..", "a/../b", "./a", `a\b`, "a/CON", "a/b\x00"} {
+		normalized, err := NormalizeFolder(folder)
+		if !errors.Is(err, ErrInvalidFileName) {
+			t.Errorf("NormalizeFolder(%q) = %q, %v, want ErrInvalidFileName", folder, normalized, err)
+		}
+	}
+	normalized, err := NormalizeFolder("/a//b/")
+	if err != nil || normalized != "a/b" {
+		t.Fatalf("NormalizeFolder = %q, %v, want a/b", normalized, err)
+	}
+}
+
+func TestIsLegacyKey(t *testing.T) {
+	fileId := primitive.NewObjectID()
+	other := primitive.NewObjectID()
+	tests := []struct {
+		extension string
+		name      string
+		want      bool
+	}{
+		{".PDF", fileId.Hex() + ".PDF", true},
+		{".extension-too-long", fileId.Hex() + ".extension-too-long", true},
+		{".tar~", fileId.Hex() + ".tar~", true},
+		// stored under the key itself, not a legacy name
+		{".pdf", fileId.Hex() + ".pdf", false},
+		{".PDF", fileId.Hex() + ".txt", false},
+		{".PDF", other.Hex() + ".PDF", false},
+		{".PDF", "short", false},
+	}
+	for _, test := range tests {
+		key := StorageKey(fileId, test.extension)
+		if got := IsLegacyKey(key, test.name); got != test.want {
+			t.Errorf("IsLegacyKey(%q, %q) = %v, want %v", key, test.name, got, test.want)
+		}
+	}
+}
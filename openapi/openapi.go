@@ -0,0 +1,175 @@
+// Package openapi describes the routes of the service in an OpenAPI 3.1
+// document, served at /openapi.json along with a page rendering it at /docs.
+package openapi
+
+import (
+	_ "embed"
+	"encoding/json"
+	"fmt"
+	"github.com/draco121/horizon/utils"
+	"github.com/gin-gonic/gin"
+	"net/http"
+	"sort"
+	"strings"
+	"sync"
+)
+
+const (
+	SpecPath = "/openapi.json"
+	UIPath   = "/docs"
+)
+
+// Schema is a JSON schema, kept as a map as schemas are only ever written.
+type Schema map[string]any
+
+type Document struct {
+	OpenAPI    string              `json:"openapi"`
+	Info       Info                `json:"info"`
+	Tags       []Tag               `json:"tags,omitempty"`
+	Paths      map[string]PathItem `json:"paths"`
+	Components Components          `json:"components"`
+}
+
+type Info struct {
+	Title       string `json:"title"`
+	Version     string `json:"version"`
+	Description string `json:"description,omitempty"`
+}
+
+type Tag struct {
+	Name        string `json:"name"`
+	Description string `json:"description,omitempty"`
+}
+
+// PathItem holds the operations of a path by their lower case method.
+type PathItem map[string]*Operation
+
+type Operation struct {
+	OperationId string                `json:"operationId"`
+	Summary     string                `json:"summary"`
+	Description string                `json:"description,omitempty"`
+	Tags        []string              `json:"tags,omitempty"`
+	Security    []map[string][]string `json:"security,omitempty"`
+	Parameters  []Parameter           `json:"parameters,omitempty"`
+	RequestBody *RequestBody          `json:"requestBody,omitempty"`
+	Responses   map[string]Response   `json:"responses"`
+}
+
+// Parameter is either a reference to a shared parameter or a parameter of
+// its own.
+type Parameter struct {
+	Ref         string `json:"$ref,omitempty"`
+	Name        string `json:"name,omitempty"`
+	In          string `json:"in,omitempty"`
+	Description string `json:"description,omitempty"`
+	Required    bool   `json:"required,omitempty"`
+	Schema      Schema `json:"schema,omitempty"`
+}
+
+type RequestBody struct {
+	Description string               `json:"description,omitempty"`
+	Required    bool                 `json:"required,omitempty"`
+	Content     map[string]MediaType `json:"content"`
+}
+
+// Response is either a reference to a shared response or a response of its
+// own.
+type Response struct {
+	Ref         string               `json:"$ref,omitempty"`
+	Description string               `json:"description,omitempty"`
+	Headers     map[string]Header    `json:"headers,omitempty"`
+	Content     map[string]MediaType `json:"content,omitempty"`
+}
+
+type Header struct {
+	Ref         string `json:"$ref,omitempty"`
+	Description string `json:"description,omitempty"`
+	Schema      Schema `json:"schema,omitempty"`
+}
+
+type MediaType struct {
+	Schema Schema `json:"schema"`
+}
+
+type SecurityScheme struct {
+	Type        string `json:"type"`
+	In          string `json:"in"`
+	Name        string `json:"name"`
+	Description string `json:"description,omitempty"`
+}
+
+type Components struct {
+	Schemas         map[string]Schema         `json:"schemas"`
+	Parameters      map[string]Parameter      `json:"parameters"`
+	Headers         map[string]Header         `json:"headers"`
+	Responses       map[string]Response       `json:"responses"`
+	SecuritySchemes map[string]SecurityScheme `json:"securitySchemes"`
+}
+
+//go:embed ui.html
+var ui []byte
+
+var encoded = sync.OnceValues(func() ([]byte, error) {
+	return json.Marshal(Spec())
+})
+
+// Serve answers with the document.
+func Serve(c *gin.Context) {
+	body, err := encoded()
+	if err != nil {
+		utils.Logger.Error("failed to encode the OpenAPI document: ", err.Error())
+		c.AbortWithStatus(http.StatusInternalServerError)
+		return
+	}
+	c.Header("Cache-Control", "no-cache")
+	c.Data(http.StatusOK, "application/json", body)
+}
+
+// ServeUI answers with a page rendering the document with Redoc.
+func ServeUI(c *gin.Context) {
+	c.Data(http.StatusOK, "text/html; charset=utf-8", ui)
+}
+
+// Check fails when the registered routes and the documented operations
+// differ, so that a route cannot be added without describing it.
+func Check(routes gin.RoutesInfo) error {
+	documented := make(map[string]bool)
+	for path, item := range Spec().Paths {
+		for method := range item {
+			documented[strings.ToUpper(method)+" "+path] = true
+		}
+	}
+	missing := make([]string, 0)
+	for _, route := range routes {
+		operation := route.Method + " " + specPath(route.Path)
+		if !documented[operation] {
+			missing = append(missing, operation)
+		}
+		delete(documented, operation)
+	}
+	stale := make([]string, 0, len(documented))
+	for operation := range documented {
+		stale = append(stale, operation)
+	}
+	sort.Strings(missing)
+	sort.Strings(stale)
+	switch {
+	case len(missing) > 0:
+		return fmt.Errorf("routes missing from the OpenAPI document: %s", strings.Join(missing, ", "))
+	case len(stale) > 0:
+		return fmt.Errorf("operations of the OpenAPI document without a route: %s", strings.Join(stale, ", "))
+	}
+	return nil
+}
+
+// specPath writes the parameters of a gin route the OpenAPI way, /a/:id
+// becomes /a/{id}.
+func specPath(route string) string {
+	segments := strings.Split(route, "/")
+	for i, segment := range segments {
+		if strings.HasPrefix(segment, ":") || strings.HasPrefix(segment, "*") {
+			segments[i] = "{" + segment[1:] + "}"
+		}
+	}
+	return strings.Join(segments, "/")
+}
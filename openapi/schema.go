@@ -0,0 +1,145 @@
+package openapi
+
+import (
+	"encoding/json"
+	"fmt"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"pulse/core"
+	"reflect"
+	"strings"
+	"time"
+)
+
+var (
+	objectIdType   = reflect.TypeOf(primitive.ObjectID{})
+	timeType       = reflect.TypeOf(time.Time{})
+	rawMessageType = reflect.TypeOf(json.RawMessage{})
+	errorKindType  = reflect.TypeOf(core.ErrorKind(""))
+)
+
+// errorKinds are the codes a problem may carry.
+var errorKinds = []core.ErrorKind{
+	core.KindNotFound,
+	core.KindConflict,
+	core.KindValidation,
+	core.KindForbidden,
+	core.KindQuota,
+	core.KindTooLarge,
+	core.KindUnsupportedMediaType,
+	core.KindUnprocessable,
+	core.KindPrecondition,
+	core.KindPreconditionRequired,
+	core.KindFailedDependency,
+	core.KindInternal,
+}
+
+// schemas derives the schemas of Go types from their json tags, the way
+// encoding/json writes them. Named structs go to the components under the
+// name of their type and are referenced from there.
+type schemas struct {
+	components map[string]Schema
+	types      map[string]reflect.Type
+}
+
+func newSchemas() *schemas {
+	return &schemas{components: make(map[string]Schema), types: make(map[string]reflect.Type)}
+}
+
+// ref is the schema of the type of value.
+func (s *schemas) ref(value any) Schema {
+	return s.of(reflect.TypeOf(value))
+}
+
+func (s *schemas) of(t reflect.Type) Schema {
+	switch t {
+	case objectIdType:
+		return Schema{"type": "string", "pattern": "^[0-9a-f]{24}$"}
+	case timeType:
+		return Schema{"type": "string", "format": "date-time"}
+	case rawMessageType:
+		return Schema{}
+	case errorKindType:
+		return Schema{"type": "string", "enum": errorKinds}
+	}
+	switch t.Kind() {
+	case reflect.Pointer:
+		return s.of(t.Elem())
+	case reflect.Bool:
+		return Schema{"type": "boolean"}
+	case reflect.Int, reflect.Int64, reflect.Uint, reflect.Uint64:
+		return Schema{"type": "integer", "format": "int64"}
+	case reflect.Int8, reflect.Int16, reflect.Int32, reflect.Uint8, reflect.Uint16, reflect.Uint32:
+		return Schema{"type": "integer", "format": "int32"}
+	case reflect.Float32, reflect.Float64:
+		return Schema{"type": "number"}
+	case reflect.String:
+		return Schema{"type": "string"}
+	case reflect.Interface:
+		return Schema{}
+	case reflect.Slice:
+		if t.Elem().Kind() == reflect.Uint8 {
+			return Schema{"type": "string", "contentEncoding": "base64"}
+		}
+		// nil slices are written as null
+		return Schema{"type": []string{"array", "null"}, "items": s.of(t.Elem())}
+	case reflect.Array:
+		return Schema{"type": "array", "items": s.of(t.Elem())}
+	case reflect.Map:
+		return Schema{"type": []string{"object", "null"}, "additionalProperties": s.of(t.Elem())}
+	case reflect.Struct:
+		if t.Name() == "" {
+			return s.object(t)
+		}
+		return s.component(t)
+	}
+	panic(fmt.Sprintf("openapi: no schema for %s", t))
+}
+
+// component adds a named struct to the components once and references it.
+func (s *schemas) component(t reflect.Type) Schema {
+	ref := Schema{"$ref": "#/components/schemas/" + t.Name()}
+	if known, ok := s.types[t.Name()]; ok {
+		if known != t {
+			panic(fmt.Sprintf("openapi: %s and %s have the same schema name", known, t))
+		}
+		return ref
+	}
+	s.types[t.Name()] = t
+	s.components[t.Name()] = s.object(t)
+	return ref
+}
+
+func (s *schemas) object(t reflect.Type) Schema {
+	properties := make(map[string]Schema)
+	s.fields(t, properties)
+	return Schema{"type": "object", "properties": properties}
+}
+
+// fields adds the fields of a struct to properties, inlining the embedded
+// structs without a json name. Fields are not marked required as decoding
+// never requires them.
+func (s *schemas) fields(t reflect.Type, properties map[string]Schema) {
+	for i := 0; i < t.NumField(); i++ {
+		field := t.Field(i)
+		tag := field.Tag.Get("json")
+		if tag == "-" {
+			continue
+		}
+		name, _, _ := strings.Cut(tag, ",")
+		embedded := field.Type
+		if embedded.Kind() == reflect.Pointer {
+			embedded = embedded.Elem()
+		}
+		if field.Anonymous && name == "" && embedded.Kind() == reflect.Struct {
+			s.fields(embedded, properties)
+			continue
+		}
+		if !field.IsExported() {
+			continue
+		}
+		if name == "" {
+			name = field.Name
+		}
+		properties[name] = s.of(field.Type)
+	}
+}
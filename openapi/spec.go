@@ -0,0 +1,609 @@
+package openapi
+
+import (
+	"github.com/draco121/horizon/models"
+	"net/http"
+	"pulse/archive"
+	"pulse/controllers"
+	"pulse/core"
+	"pulse/patch"
+	"pulse/repository"
+	"strconv"
+	"strings"
+)
+
+const (
+	tagFiles        = "files"
+	tagRevisions    = "revisions"
+	tagTrainingData = "trainingdata"
+	tagProjects     = "projects"
+	tagService      = "service"
+)
+
+// problemStatuses are the statuses answered with a problem, each has a
+// shared response named after its status text.
+var problemStatuses = []int{
+	http.StatusBadRequest,
+	http.StatusForbidden,
+	http.StatusNotFound,
+	http.StatusConflict,
+	http.StatusPreconditionFailed,
+	http.StatusRequestEntityTooLarge,
+	http.StatusUnsupportedMediaType,
+	http.StatusUnprocessableEntity,
+	http.StatusFailedDependency,
+	http.StatusPreconditionRequired,
+	http.StatusInternalServerError,
+	http.StatusInsufficientStorage,
+}
+
+var tokenSecurity = []map[string][]string{{"token": {}}}
+
+type builder struct {
+	schemas *schemas
+	paths   map[string]PathItem
+}
+
+// Spec describes every route registered by routes.RegisterRoutes.
+func Spec() *Document {
+	b := &builder{schemas: newSchemas(), paths: make(map[string]PathItem)}
+	b.files()
+	b.revisions()
+	b.trainingData()
+	b.projects()
+	b.service()
+	return &Document{
+		OpenAPI: "3.1.0",
+		Info: Info{
+			Title:   "pulse",
+			Version: "1",
+			Description: "Stores the training data of bots: their files, with folders, tags, revisions and archives, " +
+				"and the versioned training data document. Failed requests are answered with an RFC 7807 problem.",
+		},
+		Tags: []Tag{
+			{Name: tagFiles, Description: "Files of a bot."},
+			{Name: tagRevisions, Description: "Previous contents of files replaced in place."},
+			{Name: tagTrainingData, Description: "The training data document of a bot, written under an If-Match version."},
+			{Name: tagProjects, Description: "Storage usage and upload policy of a project."},
+			{Name: tagService, Description: "Probes, metrics and operations on the service."},
+		},
+		Paths:      b.paths,
+		Components: b.components(),
+	}
+}
+
+func (b *builder) components() Components {
+	responses := make(map[string]Response, len(problemStatuses)+1)
+	for _, status := range problemStatuses {
+		responses[problemName(status)] = Response{
+			Description: http.StatusText(status),
+			Headers:     requestIdHeader(),
+			Content:     map[string]MediaType{"application/problem+json": {Schema: b.schemas.ref(controllers.Problem{})}},
+		}
+	}
+	responses[problemName(http.StatusUnauthorized)] = Response{
+		Description: "The token is missing, invalid or not allowed the action. The body is empty.",
+	}
+	objectId := b.schemas.of(objectIdType)
+	return Components{
+		Schemas: b.schemas.components,
+		Parameters: map[string]Parameter{
+			"projectId":      {Name: "projectId", In: "path", Required: true, Schema: objectId},
+			"botId":          {Name: "botId", In: "path", Required: true, Schema: objectId},
+			"fileId":         {Name: "fileId", In: "path", Required: true, Schema: objectId},
+			"projectIdQuery": {Name: "projectId", In: "query", Required: true, Schema: objectId},
+			"botIdQuery":     {Name: "botId", In: "query", Required: true, Schema: objectId},
+			"idempotencyKey": {
+				Name:        controllers.IdempotencyKeyHeader,
+				In:          "header",
+				Description: "Makes the request safe to retry: a repeat with the same key gets the first response, a different request with the key is refused with 422.",
+				Schema:      Schema{"type": "string", "minLength": 1, "maxLength": 255},
+			},
+			"ifMatchVersion": {
+				Name:        "If-Match",
+				In:          "header",
+				Required:    true,
+				Description: `The ETag of the last read, "*" writes over any version.`,
+				Schema:      Schema{"type": "string"},
+			},
+			"ifNoneMatch": {
+				Name:        "If-None-Match",
+				In:          "header",
+				Description: "Answers 304 when the ETag still matches.",
+				Schema:      Schema{"type": "string"},
+			},
+			"range": {
+				Name:        "Range",
+				In:          "header",
+				Description: "Bytes of the content to send, answered with 206.",
+				Schema:      Schema{"type": "string"},
+			},
+		},
+		Headers: map[string]Header{
+			"requestId": {Description: "Id of the request, the one sent by the client when there was one.", Schema: Schema{"type": "string"}},
+			"etag":      {Description: "Entity tag of the returned resource.", Schema: Schema{"type": "string"}},
+			"idempotentReplayed": {
+				Description: "Set to true when the response is the stored response of an earlier request with the same Idempotency-Key.",
+				Schema:      Schema{"type": "string", "enum": []string{"true"}},
+			},
+		},
+		Responses: responses,
+		SecuritySchemes: map[string]SecurityScheme{
+			"token": {
+				Type:        "apiKey",
+				In:          "header",
+				Name:        "Authorization",
+				Description: "Token of the auth service, sent as is. Each route needs the read, write or all action.",
+			},
+		},
+	}
+}
+
+// add registers an operation, adding the responses every operation shares.
+func (b *builder) add(method string, path string, operation *Operation) {
+	if operation.Security != nil {
+		operation.Responses[strconv.Itoa(http.StatusUnauthorized)] = problem(http.StatusUnauthorized)
+	}
+	if _, ok := operation.Responses[strconv.Itoa(http.StatusInternalServerError)]; !ok {
+		operation.Responses[strconv.Itoa(http.StatusInternalServerError)] = problem(http.StatusInternalServerError)
+	}
+	item, ok := b.paths[path]
+	if !ok {
+		item = make(PathItem)
+		b.paths[path] = item
+	}
+	item[strings.ToLower(method)] = operation
+}
+
+// addWithHead registers a GET operation and the HEAD one answering with
+// its headers only.
+func (b *builder) addWithHead(path string, operation *Operation) {
+	b.add(http.MethodGet, path, operation)
+	head := *operation
+	head.OperationId = "head" + strings.ToUpper(operation.OperationId[:1]) + operation.OperationId[1:]
+	head.Summary = operation.Summary + ", headers only"
+	head.Responses = make(map[string]Response, len(operation.Responses))
+	for status, response := range operation.Responses {
+		response.Content = nil
+		head.Responses[status] = response
+	}
+	b.add(http.MethodHead, path, &head)
+}
+
+func problemName(status int) string {
+	return strings.ReplaceAll(http.StatusText(status), " ", "")
+}
+
+func problem(status int) Response {
+	return Response{Ref: "#/components/responses/" + problemName(status)}
+}
+
+// problems adds the shared problem responses of statuses.
+func problems(responses map[string]Response, statuses ...int) map[string]Response {
+	for _, status := range statuses {
+		responses[strconv.Itoa(status)] = problem(status)
+	}
+	return responses
+}
+
+func parameter(name string) Parameter {
+	return Parameter{Ref: "#/components/parameters/" + name}
+}
+
+func header(name string) Header {
+	return Header{Ref: "#/components/headers/" + name}
+}
+
+func requestIdHeader() map[string]Header {
+	return map[string]Header{controllers.RequestIdHeader: header("requestId")}
+}
+
+func query(name string, description string, schema Schema) Parameter {
+	return Parameter{Name: name, In: "query", Description: description, Schema: schema}
+}
+
+func (b *builder) json(description string, value any) Response {
+	return Response{
+		Description: description,
+		Headers:     requestIdHeader(),
+		Content:     map[string]MediaType{"application/json": {Schema: b.schemas.ref(value)}},
+	}
+}
+
+// tagged adds headers to a copy of a response.
+func tagged(response Response, names ...string) Response {
+	headers := make(map[string]Header, len(response.Headers)+len(names))
+	for name, value := range response.Headers {
+		headers[name] = value
+	}
+	response.Headers = headers
+	for _, name := range names {
+		switch name {
+		case "ETag":
+			response.Headers[name] = header("etag")
+		case "Idempotent-Replayed":
+			response.Headers[name] = header("idempotentReplayed")
+		}
+	}
+	return response
+}
+
+func (b *builder) jsonBody(description string, value any) *RequestBody {
+	return &RequestBody{
+		Description: description,
+		Required:    true,
+		Content:     map[string]MediaType{"application/json": {Schema: b.schemas.ref(value)}},
+	}
+}
+
+func multipartBody(description string, field string, many bool) *RequestBody {
+	file := Schema{"type": "string", "contentMediaType": "application/octet-stream"}
+	if many {
+		file = Schema{"type": "array", "items": file}
+	}
+	return &RequestBody{
+		Description: description,
+		Required:    true,
+		Content: map[string]MediaType{"multipart/form-data": {Schema: Schema{
+			"type":       "object",
+			"properties": map[string]Schema{field: file},
+			"required":   []string{field},
+		}}},
+	}
+}
+
+// download is the response of a route serving stored content, which
+// answers Range and the conditional headers.
+func download(description string) map[string]Response {
+	content := map[string]MediaType{"application/octet-stream": {Schema: Schema{"type": "string", "contentMediaType": "application/octet-stream"}}}
+	headers := func() map[string]Header {
+		headers := requestIdHeader()
+		headers["ETag"] = header("etag")
+		headers["Content-Disposition"] = Header{Description: "The name of the file.", Schema: Schema{"type": "string"}}
+		return headers
+	}
+	return problems(map[string]Response{
+		"200": {Description: description + ", with the type it was stored with.", Headers: headers(), Content: content},
+		"206": {Description: "The requested range of the content.", Headers: headers(), Content: content},
+		"304": {Description: "The content did not change.", Headers: headers()},
+		"416": {Description: "The range is outside of the content."},
+	}, http.StatusBadRequest, http.StatusForbidden, http.StatusNotFound)
+}
+
+func (b *builder) files() {
+	bot := []Parameter{parameter("projectId"), parameter("botId")}
+	file := []Parameter{parameter("projectId"), parameter("botId"), parameter("fileId")}
+	folder := query("folder", "Slash separated folder, the root when empty.", Schema{"type": "string"})
+	uploaded := tagged(b.json("The stored files and those the upload policy rejected.", core.UploadResult{}), "Idempotent-Replayed")
+
+	b.add(http.MethodPost, "/v1/upload/{projectId}/{botId}", &Operation{
+		OperationId: "uploadTrainingData",
+		Summary:     "Upload files",
+		Description: "Stores the files that pass the upload policy of the project. Needs the write action.",
+		Tags:        []string{tagFiles},
+		Security:    tokenSecurity,
+		Parameters: append(bot,
+			folder,
+			query("extract", "Replaces zip and tar archives by the files they hold.", Schema{"type": "boolean", "default": false}),
+			parameter("idempotencyKey")),
+		RequestBody: multipartBody("The files to store.", "files", true),
+		Responses: problems(map[string]Response{
+			"201": uploaded,
+			"422": {
+				Description: "Every file was rejected, or the Idempotency-Key was used for another request.",
+				Headers:     uploaded.Headers,
+				Content: map[string]MediaType{
+					"application/json":         uploaded.Content["application/json"],
+					"application/problem+json": {Schema: b.schemas.ref(controllers.Problem{})},
+				},
+			},
+		}, http.StatusBadRequest, http.StatusConflict, http.StatusRequestEntityTooLarge, http.StatusInsufficientStorage),
+	})
+	b.add(http.MethodDelete, "/v1/delete/{projectId}/{botId}/{fileId}", &Operation{
+		OperationId: "deleteFile",
+		Summary:     "Delete a file",
+		Description: "Needs the write action.",
+		Tags:        []string{tagFiles},
+		Security:    tokenSecurity,
+		Parameters:  file,
+		Responses: problems(map[string]Response{
+			"204": {Description: "The file was deleted.", Headers: requestIdHeader()},
+		}, http.StatusBadRequest, http.StatusNotFound),
+	})
+	b.addWithHead("/v1/download/{projectId}/{botId}/{fileId}", &Operation{
+		OperationId: "downloadFile",
+		Summary:     "Download a file",
+		Description: "The ETag is the SHA-256 of the content. Quarantined files cannot be downloaded. Needs the read action.",
+		Tags:        []string{tagFiles},
+		Security:    tokenSecurity,
+		Parameters:  append(file, parameter("range"), parameter("ifNoneMatch")),
+		Responses:   download("The content of the file"),
+	})
+	b.add(http.MethodGet, "/v1/download/{projectId}/{botId}", &Operation{
+		OperationId: "downloadArchive",
+		Summary:     "Download files as an archive",
+		Description: "Streams the files in their folders, all of them unless fileId is set. Needs the read action.",
+		Tags:        []string{tagFiles},
+		Security:    tokenSecurity,
+		Parameters: append(bot,
+			query("format", "Format of the archive.", Schema{"type": "string", "enum": []string{archive.FormatZip, archive.FormatTarGz}, "default": archive.FormatZip}),
+			query("fileId", "Files to put in the archive, may be repeated.", Schema{"type": "array", "items": b.schemas.of(objectIdType)}),
+		),
+		Responses: problems(map[string]Response{
+			"200": {
+				Description: "The archive.",
+				Headers:     requestIdHeader(),
+				Content: map[string]MediaType{
+					archive.ContentType(archive.FormatZip):   {Schema: Schema{"type": "string", "contentMediaType": archive.ContentType(archive.FormatZip)}},
+					archive.ContentType(archive.FormatTarGz): {Schema: Schema{"type": "string", "contentMediaType": archive.ContentType(archive.FormatTarGz)}},
+				},
+			},
+		}, http.StatusBadRequest, http.StatusNotFound, http.StatusRequestEntityTooLarge),
+	})
+	b.add(http.MethodGet, "/v1/files/{projectId}/{botId}", &Operation{
+		OperationId: "listFiles",
+		Summary:     "List files",
+		Description: "Without a folder the whole tree is listed. Needs the read action.",
+		Tags:        []string{tagFiles},
+		Security:    tokenSecurity,
+		Parameters: append(bot,
+			folder,
+			query("recursive", "Also lists the files in the subfolders, the default without a folder.", Schema{"type": "boolean"}),
+			query("tag", "Only lists files with every tag, comma separated or repeated.", Schema{"type": "array", "items": Schema{"type": "string"}}),
+			query("label", "Only lists files with the label, as key:value, may be repeated.", Schema{"type": "array", "items": Schema{"type": "string"}}),
+		),
+		Responses: problems(map[string]Response{
+			"200": b.json("The files, sorted by folder and name.", []repository.FileMetadata{}),
+		}, http.StatusBadRequest),
+	})
+	b.add(http.MethodPost, "/v1/files/{projectId}/{botId}/move", &Operation{
+		OperationId: "moveFiles",
+		Summary:     "Move files to a folder",
+		Description: "Needs the write action.",
+		Tags:        []string{tagFiles},
+		Security:    tokenSecurity,
+		Parameters:  bot,
+		RequestBody: b.jsonBody("The files and the folder to move them to.", controllers.MoveFilesRequest{}),
+		Responses: problems(map[string]Response{
+			"200": b.json("The moved files.", []repository.FileMetadata{}),
+		}, http.StatusBadRequest, http.StatusNotFound, http.StatusConflict),
+	})
+	b.add(http.MethodPost, "/v1/files/{projectId}/{botId}/tags", &Operation{
+		OperationId: "tagFiles",
+		Summary:     "Change the tags and labels of files",
+		Description: "Needs the write action.",
+		Tags:        []string{tagFiles},
+		Security:    tokenSecurity,
+		Parameters:  bot,
+		RequestBody: b.jsonBody("The files and the change to their tags and labels.", controllers.TagFilesRequest{}),
+		Responses: problems(map[string]Response{
+			"200": b.json("The changed files.", []repository.FileMetadata{}),
+		}, http.StatusBadRequest, http.StatusNotFound),
+	})
+	batch := b.json("The outcome of every operation on every file.", controllers.BatchResponse{})
+	b.add(http.MethodPost, "/v1/files/{projectId}/{botId}/batch", &Operation{
+		OperationId: "runBatch",
+		Summary:     "Run a batch of operations on files",
+		Description: "Deletes, moves, tags and restores files. An atomic batch is applied as a whole or not at all. Needs the write action.",
+		Tags:        []string{tagFiles},
+		Security:    tokenSecurity,
+		Parameters:  bot,
+		RequestBody: b.jsonBody("The operations.", controllers.BatchRequest{}),
+		Responses: problems(map[string]Response{
+			"200": batch,
+			"207": {Description: "Some operations failed, see the results.", Headers: batch.Headers, Content: batch.Content},
+			"422": {Description: "No operation was applied, see the results.", Headers: batch.Headers, Content: batch.Content},
+		}, http.StatusBadRequest, http.StatusNotFound, http.StatusRequestEntityTooLarge),
+	})
+	b.add(http.MethodPut, "/v1/files/{projectId}/{botId}/{fileId}", &Operation{
+		OperationId: "replaceFile",
+		Summary:     "Replace the content of a file",
+		Description: "Keeps the previous content as a revision. Needs the write action.",
+		Tags:        []string{tagFiles},
+		Security:    tokenSecurity,
+		Parameters: append(file, Parameter{
+			Name:        "If-Match",
+			In:          "header",
+			Description: `The ETag of the content to replace, any content is replaced without it or with "*".`,
+			Schema:      Schema{"type": "string"},
+		}),
+		RequestBody: multipartBody("The new content.", "file", false),
+		Responses: problems(map[string]Response{
+			"200": tagged(b.json("The file with its new content.", repository.FileMetadata{}), "ETag"),
+		}, http.StatusBadRequest, http.StatusForbidden, http.StatusNotFound, http.StatusPreconditionFailed,
+			http.StatusRequestEntityTooLarge, http.StatusUnsupportedMediaType, http.StatusInsufficientStorage),
+	})
+}
+
+func (b *builder) revisions() {
+	file := []Parameter{parameter("projectId"), parameter("botId"), parameter("fileId")}
+	b.add(http.MethodGet, "/v1/files/{projectId}/{botId}/{fileId}/revisions", &Operation{
+		OperationId: "listRevisions",
+		Summary:     "List the revisions of a file",
+		Description: "The current content is listed as well. Needs the read action.",
+		Tags:        []string{tagRevisions},
+		Security:    tokenSecurity,
+		Parameters:  file,
+		Responses: problems(map[string]Response{
+			"200": b.json("The revisions, latest first.", []repository.FileRevision{}),
+		}, http.StatusBadRequest, http.StatusNotFound),
+	})
+	b.addWithHead("/v1/files/{projectId}/{botId}/{fileId}/revisions/{revision}", &Operation{
+		OperationId: "getRevision",
+		Summary:     "Download a revision of a file",
+		Description: "Needs the read action.",
+		Tags:        []string{tagRevisions},
+		Security:    tokenSecurity,
+		Parameters: append(file,
+			Parameter{Name: "revision", In: "path", Required: true, Schema: Schema{"type": "integer", "format": "int64", "minimum": 1}},
+			parameter("range"), parameter("ifNoneMatch")),
+		Responses: download("The content of the revision"),
+	})
+}
+
+func (b *builder) trainingData() {
+	bot := []Parameter{parameter("projectIdQuery"), parameter("botIdQuery")}
+	versioned := tagged(b.json("The training data, the ETag holds its version.", repository.VersionedTrainingData{}), "ETag")
+	b.add(http.MethodPost, "/v1/trainingdata", &Operation{
+		OperationId: "addTrainingData",
+		Summary:     "Create the training data of a bot",
+		Description: "Needs the write action.",
+		Tags:        []string{tagTrainingData},
+		Security:    tokenSecurity,
+		Parameters:  []Parameter{parameter("idempotencyKey")},
+		RequestBody: b.jsonBody("The training data, projectId and botId name the bot.", models.TrainingData{}),
+		Responses: problems(map[string]Response{
+			"201": tagged(versioned, "Idempotent-Replayed"),
+		}, http.StatusBadRequest, http.StatusConflict, http.StatusUnprocessableEntity),
+	})
+	b.add(http.MethodGet, "/v1/trainingdata", &Operation{
+		OperationId: "getTrainingData",
+		Summary:     "Get the training data of a bot",
+		Description: "Needs the read action.",
+		Tags:        []string{tagTrainingData},
+		Security:    tokenSecurity,
+		Parameters:  append(bot, parameter("ifNoneMatch")),
+		Responses: problems(map[string]Response{
+			"200": versioned,
+			"304": {Description: "The training data did not change.", Headers: map[string]Header{"ETag": header("etag")}},
+		}, http.StatusBadRequest, http.StatusNotFound),
+	})
+	b.add(http.MethodPatch, "/v1/trainingdata", &Operation{
+		OperationId: "updateTrainingData",
+		Summary:     "Patch the training data of a bot",
+		Description: "Takes a JSON merge patch or a JSON patch. Needs the write action.",
+		Tags:        []string{tagTrainingData},
+		Security:    tokenSecurity,
+		Parameters:  append(bot, parameter("ifMatchVersion")),
+		RequestBody: &RequestBody{
+			Required: true,
+			Content: map[string]MediaType{
+				patch.MergePatchType: {Schema: b.schemas.ref(models.TrainingData{})},
+				patch.JSONPatchType:  {Schema: b.schemas.ref([]patch.Operation{})},
+			},
+		},
+		Responses: problems(map[string]Response{
+			"200": versioned,
+		}, http.StatusBadRequest, http.StatusNotFound, http.StatusPreconditionFailed, http.StatusRequestEntityTooLarge,
+			http.StatusUnsupportedMediaType, http.StatusPreconditionRequired),
+	})
+	b.add(http.MethodDelete, "/v1/trainingdata", &Operation{
+		OperationId: "resetTrainingData",
+		Summary:     "Reset the training data of a bot",
+		Description: "Deletes the training data along with its files and their revisions. Needs the write action.",
+		Tags:        []string{tagTrainingData},
+		Security:    tokenSecurity,
+		Parameters:  append(bot, parameter("ifMatchVersion")),
+		Responses: problems(map[string]Response{
+			"200": versioned,
+		}, http.StatusBadRequest, http.StatusNotFound, http.StatusPreconditionFailed, http.StatusPreconditionRequired),
+	})
+}
+
+func (b *builder) projects() {
+	project := []Parameter{parameter("projectId")}
+	b.add(http.MethodGet, "/v1/usage/{projectId}", &Operation{
+		OperationId: "getUsage",
+		Summary:     "Get the storage usage of a project",
+		Description: "Needs the read action.",
+		Tags:        []string{tagProjects},
+		Security:    tokenSecurity,
+		Parameters:  project,
+		Responses: problems(map[string]Response{
+			"200": b.json("The usage of the project and its bots, with the quota policy and the usage history.", core.ProjectUsage{}),
+		}, http.StatusBadRequest),
+	})
+	b.add(http.MethodGet, "/v1/policy/{projectId}", &Operation{
+		OperationId: "getUploadPolicy",
+		Summary:     "Get the upload policy of a project",
+		Description: "Needs the read action.",
+		Tags:        []string{tagProjects},
+		Security:    tokenSecurity,
+		Parameters:  project,
+		Responses: problems(map[string]Response{
+			"200": b.json("The policy of the project, the default one when it has none.", repository.UploadPolicy{}),
+		}, http.StatusBadRequest),
+	})
+	b.add(http.MethodPut, "/v1/policy/{projectId}", &Operation{
+		OperationId: "setUploadPolicy",
+		Summary:     "Set the upload policy of a project",
+		Description: "Needs the write action.",
+		Tags:        []string{tagProjects},
+		Security:    tokenSecurity,
+		Parameters:  project,
+		RequestBody: b.jsonBody("The policy, zero limits are unlimited and an empty allowlist accepts any type.", repository.UploadPolicy{}),
+		Responses: problems(map[string]Response{
+			"200": b.json("The stored policy.", repository.UploadPolicy{}),
+		}, http.StatusBadRequest, http.StatusForbidden),
+	})
+}
+
+func (b *builder) service() {
+	b.add(http.MethodPost, "/v1/keys/rotate", &Operation{
+		OperationId: "rotateKeys",
+		Summary:     "Re-wrap the data keys with the active master key",
+		Description: "Needs the all action.",
+		Tags:        []string{tagService},
+		Security:    tokenSecurity,
+		Responses: map[string]Response{
+			"200": b.json("The number of data keys re-wrapped.", struct {
+				Rotated int `json:"rotated"`
+			}{}),
+		},
+	})
+	b.addWithHead("/healthz", &Operation{
+		OperationId: "healthz",
+		Summary:     "Liveness probe",
+		Description: "Checks no dependency.",
+		Tags:        []string{tagService},
+		Responses: map[string]Response{
+			"200": b.json("The process serves requests.", struct {
+				Status string `json:"status"`
+			}{}),
+		},
+	})
+	readiness := b.json("The outcome of every dependency check.", core.Readiness{})
+	b.addWithHead("/readyz", &Operation{
+		OperationId: "readyz",
+		Summary:     "Readiness probe",
+		Tags:        []string{tagService},
+		Responses: map[string]Response{
+			"200": readiness,
+			"503": {Description: "A dependency is unavailable or the service is shutting down.", Headers: readiness.Headers, Content: readiness.Content},
+		},
+	})
+	b.add(http.MethodGet, "/debug/status", &Operation{
+		OperationId: "debugStatus",
+		Summary:     "Show the build, configuration and background jobs",
+		Description: "Secrets of the configuration are redacted. Needs the all action.",
+		Tags:        []string{tagService},
+		Security:    tokenSecurity,
+		Responses: map[string]Response{
+			"200": b.json("The status of the replica.", core.StatusReport{}),
+		},
+	})
+	b.add(http.MethodGet, "/metrics", &Operation{
+		OperationId: "metrics",
+		Summary:     "Prometheus metrics",
+		Tags:        []string{tagService},
+		Responses: map[string]Response{
+			"200": {Description: "The metrics in the Prometheus text format.", Content: map[string]MediaType{"text/plain": {Schema: Schema{"type": "string"}}}},
+		},
+	})
+	b.add(http.MethodGet, SpecPath, &Operation{
+		OperationId: "openapi",
+		Summary:     "This document",
+		Tags:        []string{tagService},
+		Responses: map[string]Response{
+			"200": {Description: "The OpenAPI document.", Content: map[string]MediaType{"application/json": {Schema: Schema{"type": "object"}}}},
+		},
+	})
+	b.add(http.MethodGet, UIPath, &Operation{
+		OperationId: "docs",
+		Summary:     "This document, rendered",
+		Tags:        []string{tagService},
+		Responses: map[string]Response{
+			"200": {Description: "A page rendering the OpenAPI document.", Content: map[string]MediaType{"text/html": {Schema: Schema{"type": "string"}}}},
+		},
+	})
+}
@@ -0,0 +1,149 @@
+package patch
+
+import (
+	"encoding/json"
+	"errors"
+	"reflect"
+	"testing"
+)
+
+// sameJSON tells whether two documents hold the same values, whatever the
+// order of their members.
+func sameJSON(t *testing.T, got []byte, want string) bool {
+	t.Helper()
+	var a, b any
+	if err := json.Unmarshal(got, &a); err != nil {
+		t.Fatalf("result %s: %v", got, err)
+	}
+	if err := json.Unmarshal([]byte(want), &b); err != nil {
+		t.Fatalf("want %s: %v", want, err)
+	}
+	return reflect.DeepEqual(a, b)
+}
+
+func TestApplyJSONPatch(t *testing.T) {
+	tests := []struct {
+		name     string
+		document string
+		patch    string
+		want     string
+	}{
+		{"add member", `{"a": 1}`, `[{"op": "add", "path": "/b", "value": [1, 2]}]`, `{"a": 1, "b": [1, 2]}`},
+		{"add replaces member", `{"a": 1}`, `[{"op": "add", "path": "/a", "value": 2}]`, `{"a": 2}`},
+		{"add into array", `{"a": [1, 3]}`, `[{"op": "add", "path": "/a/1", "value": 2}]`, `{"a": [1, 2, 3]}`},
+		{"add to array end", `{"a": [1, 2]}`, `[{"op": "add", "path": "/a/-", "value": 3}]`, `{"a": [1, 2, 3]}`},
+		{"add null", `{}`, `[{"op": "add", "path": "/a", "value": null}]`, `{"a": null}`},
+		{"add root", `{"a": 1}`, `[{"op": "add", "path": "", "value": [1]}]`, `[1]`},
+		{"remove member", `{"a": 1, "b": 2}`, `[{"op": "remove", "path": "/a"}]`, `{"b": 2}`},
+		{"remove from array", `{"a": [1, 2, 3]}`, `[{"op": "remove", "path": "/a/1"}]`, `{"a": [1, 3]}`},
+		{"replace", `{"a": {"b": 1}}`, `[{"op": "replace", "path": "/a/b", "value": "x"}]`, `{"a": {"b": "x"}}`},
+		{"move member", `{"a": {"b": 1}, "c": {}}`, `[{"op": "move", "from": "/a/b", "path": "/c/d"}]`, `{"a": {}, "c": {"d": 1}}`},
+		{"move in array", `{"a": [1, 2, 3]}`, `[{"op": "move", "from": "/a/0", "path": "/a/-"}]`, `{"a": [2, 3, 1]}`},
+		{"copy", `{"a": {"b": [1]}}`, `[{"op": "copy", "from": "/a", "path": "/c"}, {"op": "add", "path": "/c/b/-", "value": 2}]`, `{"a": {"b": [1]}, "c": {"b": [1, 2]}}`},
+		{"test string", `{"a": "x"}`, `[{"op": "test", "path": "/a", "value": "x"}]`, `{"a": "x"}`},
+		{"test number by value", `{"a": 1}`, `[{"op": "test", "path": "/a", "value": 1.0}, {"op": "test", "path": "/a", "value": 1e0}]`, `{"a": 1}`},
+		{"test nested numbers", `{"a": {"b": [10, 2.50]}}`, `[{"op": "test", "path": "/a", "value": {"b": [1e1, 2.5]}}]`, `{"a": {"b": [10, 2.5]}}`},
+		{"escaped slash", `{"a/b": 1}`, `[{"op": "replace", "path": "/a~1b", "value": 2}]`, `{"a/b": 2}`},
+		{"escaped tilde", `{"m~n": 1}`, `[{"op": "remove", "path": "/m~0n"}]`, `{}`},
+		{"escapes in order", `{"~1": 1}`, `[{"op": "test", "path": "/~01", "value": 1}]`, `{"~1": 1}`},
+		{"empty member name", `{"": 1}`, `[{"op": "replace", "path": "/", "value": 2}]`, `{"": 2}`},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			got, err := ApplyJSONPatch([]byte(test.document), []byte(test.patch))
+			if err != nil {
+				t.Fatal(err)
+			}
+			if !sameJSON(t, got, test.want) {
+				t.Fatalf("got %s, want %s", got, test.want)
+			}
+		})
+	}
+}
+
+func TestApplyJSONPatchFails(t *testing.T) {
+	tests := []struct {
+		name     string
+		document string
+		patch    string
+		want     error
+	}{
+		{"test different value", `{"a": 1}`, `[{"op": "test", "path": "/a", "value": 2}]`, ErrTestFailed},
+		{"test number against string", `{"a": 1}`, `[{"op": "test", "path": "/a", "value": "1"}]`, ErrTestFailed},
+		{"test longer array", `{"a": [1]}`, `[{"op": "test", "path": "/a", "value": [1, 1]}]`, ErrTestFailed},
+		{"test extra member", `{"a": {}}`, `[{"op": "test", "path": "/a", "value": {"b": null}}]`, ErrTestFailed},
+		{"test missing value", `{"a": 1}`, `[{"op": "test", "path": "/a"}]`, ErrInvalidPatch},
+		{"unknown operation", `{}`, `[{"op": "frobnicate", "path": "/a"}]`, ErrInvalidPatch},
+		{"pointer without slash", `{"a": 1}`, `[{"op": "remove", "path": "a"}]`, ErrInvalidPatch},
+		{"remove missing member", `{}`, `[{"op": "remove", "path": "/a"}]`, ErrInvalidPatch},
+		{"remove root", `{}`, `[{"op": "remove", "path": ""}]`, ErrInvalidPatch},
+		{"remove array end", `{"a": [1]}`, `[{"op": "remove", "path": "/a/-"}]`, ErrInvalidPatch},
+		{"index out of bounds", `{"a": [1]}`, `[{"op": "add", "path": "/a/2", "value": 0}]`, ErrInvalidPatch},
+		{"index with leading zero", `{"a": [1, 2]}`, `[{"op": "replace", "path": "/a/01", "value": 0}]`, ErrInvalidPatch},
+		{"add under missing parent", `{}`, `[{"op": "add", "path": "/a/b", "value": 0}]`, ErrInvalidPatch},
+		{"move into itself", `{"a": {"b": {}}}`, `[{"op": "move", "from": "/a", "path": "/a/b/c"}]`, ErrInvalidPatch},
+		{"not a list", `{}`, `{"op": "add"}`, ErrInvalidPatch},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			got, err := ApplyJSONPatch([]byte(test.document), []byte(test.patch))
+			if !errors.Is(err, test.want) {
+				t.Fatalf("got %s, %v, want %v", got, err, test.want)
+			}
+		})
+	}
+}
+
+func TestApplyJSONPatchIsAtomic(t *testing.T) {
+	document := []byte(`{"a": 1}`)
+	_, err := ApplyJSONPatch(document, []byte(`[{"op": "replace", "path": "/a", "value": 2}, {"op": "test", "path": "/a", "value": 1}]`))
+	if !errors.Is(err, ErrTestFailed) {
+		t.Fatalf("err = %v, want ErrTestFailed", err)
+	}
+	if string(document) != `{"a": 1}` {
+		t.Fatalf("document changed to %s", document)
+	}
+}
+
+func TestApplyMergePatch(t *testing.T) {
+	// the examples of RFC 7396 appendix A
+	tests := []struct {
+		document string
+		patch    string
+		want     string
+	}{
+		{`{"a": "b"}`, `{"a": "c"}`, `{"a": "c"}`},
+		{`{"a": "b"}`, `{"b": "c"}`, `{"a": "b", "b": "c"}`},
+		{`{"a": "b"}`, `{"a": null}`, `{}`},
+		{`{"a": "b", "b": "c"}`, `{"a": null}`, `{"b": "c"}`},
+		{`{"a": ["b"]}`, `{"a": "c"}`, `{"a": "c"}`},
+		{`{"a": "c"}`, `{"a": ["b"]}`, `{"a": ["b"]}`},
+		{`{"a": {"b": "c"}}`, `{"a": {"b": "d", "c": null}}`, `{"a": {"b": "d"}}`},
+		{`{"a": [{"b": "c"}]}`, `{"a": [1]}`, `{"a": [1]}`},
+		{`["a", "b"]`, `["c", "d"]`, `["c", "d"]`},
+		{`{"a": "b"}`, `["c"]`, `["c"]`},
+		{`{"a": "foo"}`, `null`, `null`},
+		{`{"a": "foo"}`, `"bar"`, `"bar"`},
+		{`{"e": null}`, `{"a": 1}`, `{"e": null, "a": 1}`},
+		{`[1, 2]`, `{"a": "b", "c": null}`, `{"a": "b"}`},
+		{`{}`, `{"a": {"bb": {"ccc": null}}}`, `{"a": {"bb": {}}}`},
+	}
+	for _, test := range tests {
+		t.Run(test.patch, func(t *testing.T) {
+			got, err := ApplyMergePatch([]byte(test.document), []byte(test.patch))
+			if err != nil {
+				t.Fatal(err)
+			}
+			if !sameJSON(t, got, test.want) {
+				t.Fatalf("got %s, want %s", got, test.want)
+			}
+		})
+	}
+}
+
+func TestApplyMergePatchInvalid(t *testing.T) {
+	_, err := ApplyMergePatch([]byte(`{}`), []byte(`{"a": `))
+	if !errors.Is(err, ErrInvalidPatch) {
+		t.Fatalf("err = %v, want ErrInvalidPatch", err)
+	}
+}
@@ -0,0 +1,197 @@
+package repository
+
+import (
+	"context"
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
+	"pulse/api"
+	"regexp"
+	"time"
+)
+
+type FileMetadata = api.FileMetadata
+
+type FileFilter = api.FileFilter
+
+type TagChange = api.TagChange
+
+type IFileRepository interface {
+	InsertOne(ctx context.Context, metadata *FileMetadata) (*FileMetadata, error)
+	FindOne(ctx context.Context, fileId primitive.ObjectID) (*FileMetadata, error)
+	// FindByBotId lists the files of a bot, all of them with a nil filter.
+	FindByBotId(ctx context.Context, botId primitive.ObjectID, projectId primitive.ObjectID, filter *FileFilter) ([]FileMetadata, error)
+	FindMany(ctx context.Context, botId primitive.ObjectID, projectId primitive.ObjectID, fileIds []primitive.ObjectID) ([]FileMetadata, error)
+	SetFolder(ctx context.Context, botId primitive.ObjectID, projectId primitive.ObjectID, fileIds []primitive.ObjectID, folder string) error
+	UpdateTags(ctx context.Context, botId primitive.ObjectID, projectId primitive.ObjectID, fileIds []primitive.ObjectID, change *TagChange) error
+	ReplaceOne(ctx context.Context, metadata *FileMetadata) (*FileMetadata, error)
+	DeleteOne(ctx context.Context, fileId primitive.ObjectID) (*FileMetadata, error)
+}
+
+type fileRepository struct {
+	IFileRepository
+	db *mongo.Database
+}
+
+func NewFileRepository(db *mongo.Database) IFileRepository {
+	return &fileRepository{
+		db: db,
+	}
+}
+
+func (fr *fileRepository) InsertOne(ctx context.Context, metadata *FileMetadata) (*FileMetadata, error) {
+	ownerId := ctx.Value("UserId").(primitive.ObjectID)
+	metadata.Owner = ownerId
+	if metadata.Tags == nil {
+		metadata.Tags = make([]string, 0)
+	}
+	if metadata.Labels == nil {
+		metadata.Labels = make(map[string]string)
+	}
+	if metadata.CreatedAt.IsZero() {
+		metadata.CreatedAt = time.Now().UTC()
+	}
+	_, err := fr.db.Collection("file-metadata").InsertOne(ctx, metadata)
+	if err != nil {
+		return nil, err
+	}
+	return metadata, nil
+}
+
+func (fr *fileRepository) FindOne(ctx context.Context, fileId primitive.ObjectID) (*FileMetadata, error) {
+	ownerId := ctx.Value("UserId").(primitive.ObjectID)
+	filter := bson.D{{Key: "_id", Value: fileId}, {Key: "owner", Value: ownerId}}
+	result := FileMetadata{}
+	err := fr.db.Collection("file-metadata").FindOne(ctx, filter).Decode(&result)
+	if err != nil {
+		return nil, err
+	}
+	return &result, nil
+}
+
+func (fr *fileRepository) FindByBotId(ctx context.Context, botId primitive.ObjectID, projectId primitive.ObjectID, filter *FileFilter) ([]FileMetadata, error) {
+	ownerId := ctx.Value("UserId").(primitive.ObjectID)
+	query := bson.D{{Key: "botId", Value: botId}, {Key: "owner", Value: ownerId}, {Key: "projectId", Value: projectId}}
+	if filter != nil {
+		if filter.Folder != "" && filter.Recursive {
+			query = append(query, bson.E{Key: "$or", Value: bson.A{
+				bson.M{"folder": filter.Folder},
+				bson.M{"folder": bson.M{"$regex": "^" + regexp.QuoteMeta(filter.Folder) + "/"}},
+			}})
+		} else if filter.Folder != "" || !filter.Recursive {
+			query = append(query, bson.E{Key: "folder", Value: filter.Folder})
+		}
+		if len(filter.Tags) > 0 {
+			query = append(query, bson.E{Key: "tags", Value: bson.M{"$all": filter.Tags}})
+		}
+		for key, value := range filter.Labels {
+			query = append(query, bson.E{Key: "labels." + key, Value: value})
+		}
+	}
+	opts := options.Find().SetSort(bson.D{{Key: "folder", Value: 1}, {Key: "fileName", Value: 1}})
+	cursor, err := fr.db.Collection("file-metadata").Find(ctx, query, opts)
+	if err != nil {
+		return nil, err
+	}
+	result := make([]FileMetadata, 0)
+	err = cursor.All(ctx, &result)
+	if err != nil {
+		return nil, err
+	}
+	return result, nil
+}
+
+func (fr *fileRepository) FindMany(ctx context.Context, botId primitive.ObjectID, projectId primitive.ObjectID, fileIds []primitive.ObjectID) ([]FileMetadata, error) {
+	ownerId := ctx.Value("UserId").(primitive.ObjectID)
+	filter := bson.D{{Key: "_id", Value: bson.M{"$in": fileIds}}, {Key: "botId", Value: botId}, {Key: "owner", Value: ownerId}, {Key: "projectId", Value: projectId}}
+	cursor, err := fr.db.Collection("file-metadata").Find(ctx, filter)
+	if err != nil {
+		return nil, err
+	}
+	result := make([]FileMetadata, 0)
+	err = cursor.All(ctx, &result)
+	if err != nil {
+		return nil, err
+	}
+	return result, nil
+}
+
+func (fr *fileRepository) SetFolder(ctx context.Context, botId primitive.ObjectID, projectId primitive.ObjectID, fileIds []primitive.ObjectID, folder string) error {
+	ownerId := ctx.Value("UserId").(primitive.ObjectID)
+	filter := bson.D{{Key: "_id", Value: bson.M{"$in": fileIds}}, {Key: "botId", Value: botId}, {Key: "owner", Value: ownerId}, {Key: "projectId", Value: projectId}}
+	_, err := fr.db.Collection("file-metadata").UpdateMany(ctx, filter, bson.M{"$set": bson.M{"folder": folder}})
+	return err
+}
+
+// UpdateTags applies a tag change. Tags are added and removed in two updates
+// as MongoDB cannot change the same array twice in one.
+func (fr *fileRepository) UpdateTags(ctx context.Context, botId primitive.ObjectID, projectId primitive.ObjectID, fileIds []primitive.ObjectID, change *TagChange) error {
+	ownerId := ctx.Value("UserId").(primitive.ObjectID)
+	filter := bson.D{{Key: "_id", Value: bson.M{"$in": fileIds}}, {Key: "botId", Value: botId}, {Key: "owner", Value: ownerId}, {Key: "projectId", Value: projectId}}
+	update := bson.M{}
+	if len(change.AddTags) > 0 {
+		update["$addToSet"] = bson.M{"tags": bson.M{"$each": change.AddTags}}
+	}
+	if len(change.SetLabels) > 0 {
+		set := bson.M{}
+		for key, value := range change.SetLabels {
+			set["labels."+key] = value
+		}
+		update["$set"] = set
+	}
+	if len(change.RemoveLabels) > 0 {
+		unset := bson.M{}
+		for _, key := range change.RemoveLabels {
+			unset["labels."+key] = ""
+		}
+		update["$unset"] = unset
+	}
+	collection := fr.db.Collection("file-metadata")
+	if len(update) > 0 {
+		// files stored before tags existed have no array or document to update
+		_, err := collection.UpdateMany(ctx, append(filter, bson.E{Key: "tags", Value: nil}), bson.M{"$set": bson.M{"tags": bson.A{}}})
+		if err != nil {
+			return err
+		}
+		_, err = collection.UpdateMany(ctx, append(filter, bson.E{Key: "labels", Value: nil}), bson.M{"$set": bson.M{"labels": bson.M{}}})
+		if err != nil {
+			return err
+		}
+		_, err = collection.UpdateMany(ctx, filter, update)
+		if err != nil {
+			return err
+		}
+	}
+	if len(change.RemoveTags) > 0 {
+		_, err := collection.UpdateMany(ctx, filter, bson.M{"$pull": bson.M{"tags": bson.M{"$in": change.RemoveTags}}})
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func (fr *fileRepository) ReplaceOne(ctx context.Context, metadata *FileMetadata) (*FileMetadata, error) {
+	ownerId := ctx.Value("UserId").(primitive.ObjectID)
+	filter := bson.D{{Key: "_id", Value: metadata.FileId}, {Key: "owner", Value: ownerId}}
+	metadata.Owner = ownerId
+	result, err := fr.db.Collection("file-metadata").ReplaceOne(ctx, filter, metadata)
+	if err != nil {
+		return nil, err
+	} else if result.MatchedCount == 0 {
+		return nil, mongo.ErrNoDocuments
+	}
+	return metadata, nil
+}
+
+func (fr *fileRepository) DeleteOne(ctx context.Context, fileId primitive.ObjectID) (*FileMetadata, error) {
+	ownerId := ctx.Value("UserId").(primitive.ObjectID)
+	filter := bson.D{{Key: "_id", Value: fileId}, {Key: "owner", Value: ownerId}}
+	result := FileMetadata{}
+	err := fr.db.Collection("file-metadata").FindOneAndDelete(ctx, filter).Decode(&result)
+	if err != nil {
+		return nil, err
+	}
+	return &result, nil
+}
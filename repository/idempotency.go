@@ -0,0 +1,98 @@
+package repository
+
+import (
+	"context"
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"go.mongodb.org/mongo-driver/mongo"
+	"time"
+)
+
+// IdempotencyRecord holds the outcome of a request sent with an
+// Idempotency-Key. It is pending until the request completes.
+type IdempotencyRecord struct {
+	Id          string             `bson:"_id"`
+	Owner       primitive.ObjectID `bson:"owner"`
+	Key         string             `bson:"key"`
+	Fingerprint string             `bson:"fingerprint"`
+	Completed   bool               `bson:"completed"`
+	Status      int                `bson:"status"`
+	Headers     map[string]string  `bson:"headers"`
+	Body        []byte             `bson:"body"`
+	CreatedAt   time.Time          `bson:"createdAt"`
+	ExpiresAt   time.Time          `bson:"expiresAt"`
+}
+
+type IIdempotencyRepository interface {
+	// InsertOne reserves a key and returns ErrRecordExists when it is taken.
+	InsertOne(ctx context.Context, record *IdempotencyRecord) error
+	FindOne(ctx context.Context, key string) (*IdempotencyRecord, error)
+	Complete(ctx context.Context, record *IdempotencyRecord) error
+	DeleteOne(ctx context.Context, key string) error
+}
+
+type idempotencyRepository struct {
+	IIdempotencyRepository
+	db *mongo.Database
+}
+
+func NewIdempotencyRepository(db *mongo.Database) IIdempotencyRepository {
+	return &idempotencyRepository{
+		db: db,
+	}
+}
+
+// idempotencyId scopes a key to its owner so that clients cannot collide.
+func idempotencyId(ctx context.Context, key string) (primitive.ObjectID, string) {
+	ownerId := ctx.Value("UserId").(primitive.ObjectID)
+	return ownerId, ownerId.Hex() + ":" + key
+}
+
+// InsertOne takes over an expired record the TTL monitor has not removed yet.
+func (ir *idempotencyRepository) InsertOne(ctx context.Context, record *IdempotencyRecord) error {
+	record.Owner, record.Id = idempotencyId(ctx, record.Key)
+	_, err := ir.db.Collection("idempotency-keys").InsertOne(ctx, record)
+	if !mongo.IsDuplicateKeyError(err) {
+		return err
+	}
+	filter := bson.D{{Key: "_id", Value: record.Id}, {Key: "expiresAt", Value: bson.M{"$lte": time.Now().UTC()}}}
+	result, err := ir.db.Collection("idempotency-keys").ReplaceOne(ctx, filter, record)
+	if err != nil {
+		return err
+	} else if result.MatchedCount == 0 {
+		return ErrRecordExists
+	}
+	return nil
+}
+
+// FindOne returns the record of a key. Records past their expiry count as
+// missing even before the TTL monitor removes them.
+func (ir *idempotencyRepository) FindOne(ctx context.Context, key string) (*IdempotencyRecord, error) {
+	_, id := idempotencyId(ctx, key)
+	filter := bson.D{{Key: "_id", Value: id}, {Key: "expiresAt", Value: bson.M{"$gt": time.Now().UTC()}}}
+	result := IdempotencyRecord{}
+	err := ir.db.Collection("idempotency-keys").FindOne(ctx, filter).Decode(&result)
+	if err != nil {
+		return nil, err
+	}
+	return &result, nil
+}
+
+func (ir *idempotencyRepository) Complete(ctx context.Context, record *IdempotencyRecord) error {
+	_, id := idempotencyId(ctx, record.Key)
+	record.Completed = true
+	update := bson.M{"$set": bson.M{
+		"completed": true,
+		"status":    record.Status,
+		"headers":   record.Headers,
+		"body":      record.Body,
+	}}
+	_, err := ir.db.Collection("idempotency-keys").UpdateByID(ctx, id, update)
+	return err
+}
+
+func (ir *idempotencyRepository) DeleteOne(ctx context.Context, key string) error {
+	_, id := idempotencyId(ctx, key)
+	_, err := ir.db.Collection("idempotency-keys").DeleteOne(ctx, bson.D{{Key: "_id", Value: id}})
+	return err
+}
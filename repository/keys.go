@@ -0,0 +1,98 @@
+package repository
+
+import (
+	"context"
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"go.mongodb.org/mongo-driver/mongo"
+	"time"
+)
+
+// DataKey is the per-project file encryption key, stored only wrapped by the
+// master key MasterKeyId. Each owner of a project has a key of their own.
+type DataKey struct {
+	Id          primitive.ObjectID `json:"-" bson:"_id,omitempty"`
+	ProjectId   primitive.ObjectID `json:"projectId" bson:"projectId"`
+	Owner       primitive.ObjectID `json:"owner" bson:"owner"`
+	WrappedKey  []byte             `json:"-" bson:"wrappedKey"`
+	MasterKeyId string             `json:"masterKeyId" bson:"masterKeyId"`
+	CreatedAt   time.Time          `json:"createdAt" bson:"createdAt"`
+	RotatedAt   time.Time          `json:"rotatedAt,omitempty" bson:"rotatedAt,omitempty"`
+}
+
+// IDataKeyRepository reads and writes data keys outside the transaction of
+// the context, a key outlives the upload that created it even when the
+// upload fails.
+type IDataKeyRepository interface {
+	FindByProjectId(ctx context.Context, projectId primitive.ObjectID) (*DataKey, error)
+	InsertOne(ctx context.Context, dataKey *DataKey) (*DataKey, error)
+	// FindNotWrappedWith lists the data keys wrapped by another master key. An
+	// empty owner lists the keys of every owner.
+	FindNotWrappedWith(ctx context.Context, owner primitive.ObjectID, masterKeyId string) ([]DataKey, error)
+	UpdateWrapping(ctx context.Context, dataKey *DataKey, previousMasterKeyId string) error
+}
+
+type dataKeyRepository struct {
+	IDataKeyRepository
+	db *mongo.Database
+}
+
+func NewDataKeyRepository(db *mongo.Database) IDataKeyRepository {
+	return &dataKeyRepository{
+		db: db,
+	}
+}
+
+func (kr *dataKeyRepository) FindByProjectId(ctx context.Context, projectId primitive.ObjectID) (*DataKey, error) {
+	ownerId := ctx.Value("UserId").(primitive.ObjectID)
+	filter := bson.D{{Key: "owner", Value: ownerId}, {Key: "projectId", Value: projectId}}
+	result := DataKey{}
+	err := kr.db.Collection("data-keys").FindOne(outsideTransaction(ctx), filter).Decode(&result)
+	if err != nil {
+		return nil, err
+	}
+	return &result, nil
+}
+
+func (kr *dataKeyRepository) InsertOne(ctx context.Context, dataKey *DataKey) (*DataKey, error) {
+	ownerId := ctx.Value("UserId").(primitive.ObjectID)
+	dataKey.Id = primitive.NewObjectID()
+	dataKey.Owner = ownerId
+	dataKey.CreatedAt = time.Now().UTC()
+	_, err := kr.db.Collection("data-keys").InsertOne(outsideTransaction(ctx), dataKey)
+	if err != nil {
+		return nil, err
+	}
+	return dataKey, nil
+}
+
+func (kr *dataKeyRepository) FindNotWrappedWith(ctx context.Context, owner primitive.ObjectID, masterKeyId string) ([]DataKey, error) {
+	filter := bson.D{{Key: "masterKeyId", Value: bson.M{"$ne": masterKeyId}}}
+	if !owner.IsZero() {
+		filter = append(filter, bson.E{Key: "owner", Value: owner})
+	}
+	cursor, err := kr.db.Collection("data-keys").Find(ctx, filter)
+	if err != nil {
+		return nil, err
+	}
+	result := make([]DataKey, 0)
+	err = cursor.All(ctx, &result)
+	if err != nil {
+		return nil, err
+	}
+	return result, nil
+}
+
+// UpdateWrapping stores a re-wrapped data key, provided nobody re-wrapped it
+// since it was read.
+func (kr *dataKeyRepository) UpdateWrapping(ctx context.Context, dataKey *DataKey, previousMasterKeyId string) error {
+	filter := bson.D{{Key: "_id", Value: dataKey.Id}, {Key: "masterKeyId", Value: previousMasterKeyId}}
+	dataKey.RotatedAt = time.Now().UTC()
+	update := bson.M{"$set": bson.M{
+		"wrappedKey":  dataKey.WrappedKey,
+		"masterKeyId": dataKey.MasterKeyId,
+		"rotatedAt":   dataKey.RotatedAt,
+	}}
+	_, err := kr.db.Collection("data-keys").UpdateOne(ctx, filter, update)
+	return err
+}
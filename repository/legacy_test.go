@@ -0,0 +1,60 @@
+package repository_test
+
+import (
+	"context"
+	"errors"
+	"github.com/draco121/horizon/utils"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"io"
+	"os"
+	"path"
+	"pulse/naming"
+	"pulse/repository"
+	"testing"
+)
+
+// Files stored before extensions were sanitized keep the extension of the
+// uploaded name as it was.
+func TestLegacyStorageKeys(t *testing.T) {
+	t.Setenv("XDG_CONFIG_HOME", t.TempDir())
+	repo := repository.NewTrainingRepository(nil)
+	ctx := context.Background()
+	botId, projectId := primitive.NewObjectID().Hex(), primitive.NewObjectID().Hex()
+	botSpace, err := utils.CreateBotSpace(botId, projectId)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, extension := range []string{".PDF", ".extension-too-long-to-keep", ".tar~"} {
+		t.Run(extension, func(t *testing.T) {
+			fileId := primitive.NewObjectID()
+			err := os.WriteFile(path.Join(botSpace, fileId.Hex()+extension), []byte("legacy"), 0600)
+			if err != nil {
+				t.Fatal(err)
+			}
+			key := naming.StorageKey(fileId, extension)
+			content, _, err := repo.OpenFile(ctx, botId, projectId, key, nil)
+			if err != nil {
+				t.Fatal(err)
+			}
+			data, err := io.ReadAll(content)
+			_ = content.Close()
+			if err != nil || string(data) != "legacy" {
+				t.Fatalf("read %q, %v", data, err)
+			}
+			err = repo.RemoveFile(ctx, botId, projectId, key)
+			if err != nil {
+				t.Fatal(err)
+			}
+			_, err = os.Stat(path.Join(botSpace, fileId.Hex()+extension))
+			if !errors.Is(err, os.ErrNotExist) {
+				t.Fatalf("legacy file was not removed: %v", err)
+			}
+		})
+	}
+	// another file sharing no id is never picked up
+	other := primitive.NewObjectID()
+	_, _, err = repo.OpenFile(ctx, botId, projectId, naming.StorageKey(other, ".pdf"), nil)
+	if !errors.Is(err, os.ErrNotExist) {
+		t.Fatalf("err = %v, want ErrNotExist", err)
+	}
+}
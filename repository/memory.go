@@ -0,0 +1,378 @@
+package repository
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"github.com/draco121/horizon/models"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"go.mongodb.org/mongo-driver/mongo"
+	"io"
+	"io/fs"
+	"mime/multipart"
+	"pulse/metrics"
+	"pulse/naming"
+	"sync"
+	"time"
+)
+
+// Spaces of the memory storage, matching the directories the filesystem
+// storage keeps files, revisions and quarantined uploads in.
+const (
+	spaceFiles      = "files"
+	spaceRevisions  = "revisions"
+	spaceQuarantine = "quarantine"
+)
+
+type memoryTrainingRepository struct {
+	ITrainingRepository
+	mu        sync.Mutex
+	documents map[primitive.ObjectID]VersionedTrainingData
+	files     map[string][]byte
+}
+
+// NewMemoryTrainingRepository keeps training data and stored files in memory.
+// It behaves like the repository of NewTrainingRepository, down to the errors
+// it returns, so that services can be tested without a database or a disk.
+func NewMemoryTrainingRepository() ITrainingRepository {
+	return &memoryTrainingRepository{
+		documents: map[primitive.ObjectID]VersionedTrainingData{},
+		files:     map[string][]byte{},
+	}
+}
+
+// cloneTrainingData copies the slices of a document so that callers never
+// share them with the stored one.
+func cloneTrainingData(document VersionedTrainingData) *VersionedTrainingData {
+	document.Files = append([]models.Files(nil), document.Files...)
+	document.QA = append([]models.FAQS(nil), document.QA...)
+	return &document
+}
+
+// put stores document under id, or removes the document of id when it is
+// nil. A write discarded with the transaction of ctx puts back what was there.
+// The lock must be held for it.
+func (mr *memoryTrainingRepository) put(ctx context.Context, id primitive.ObjectID, document *VersionedTrainingData) {
+	previous, existed := mr.documents[id]
+	if document == nil {
+		delete(mr.documents, id)
+	} else {
+		mr.documents[id] = *cloneTrainingData(*document)
+	}
+	onDiscard(ctx, func() {
+		mr.mu.Lock()
+		defer mr.mu.Unlock()
+		if existed {
+			mr.documents[id] = previous
+		} else {
+			delete(mr.documents, id)
+		}
+	})
+}
+
+// find returns the document of a bot, which the lock must be held for.
+func (mr *memoryTrainingRepository) find(ownerId primitive.ObjectID, botId primitive.ObjectID, projectId primitive.ObjectID) (VersionedTrainingData, bool) {
+	for _, document := range mr.documents {
+		if document.Owner == ownerId && document.BotId == botId && document.ProjectId == projectId {
+			return document, true
+		}
+	}
+	return VersionedTrainingData{}, false
+}
+
+// matchesVersion mirrors withVersion, documents without a version count as
+// version 0.
+func matchesVersion(document VersionedTrainingData, version int64) bool {
+	return version == AnyVersion || document.Version == version
+}
+
+// missedWrite mirrors the error of the Mongo repository for a write that
+// matched nothing.
+func missedWrite(found bool, version int64) error {
+	if found && version != AnyVersion {
+		return ErrVersionMismatch
+	}
+	return mongo.ErrNoDocuments
+}
+
+func (mr *memoryTrainingRepository) InsertOne(ctx context.Context, trainingData *models.TrainingData) (*VersionedTrainingData, error) {
+	ownerId := ctx.Value("UserId").(primitive.ObjectID)
+	mr.mu.Lock()
+	defer mr.mu.Unlock()
+	if _, ok := mr.find(ownerId, trainingData.BotId, trainingData.ProjectId); ok {
+		return nil, ErrRecordExists
+	}
+	trainingData.ID = primitive.NewObjectID()
+	trainingData.Owner = ownerId
+	document := VersionedTrainingData{TrainingData: *trainingData, Version: 1}
+	mr.put(ctx, document.ID, &document)
+	return cloneTrainingData(document), nil
+}
+
+func (mr *memoryTrainingRepository) UpdateOne(ctx context.Context, trainingData *models.TrainingData, version int64) (*VersionedTrainingData, error) {
+	ownerId := ctx.Value("UserId").(primitive.ObjectID)
+	mr.mu.Lock()
+	defer mr.mu.Unlock()
+	current, ok := mr.documents[trainingData.ID]
+	found := ok && current.Owner == ownerId
+	if !found || !matchesVersion(current, version) {
+		return nil, missedWrite(found, version)
+	}
+	trainingData.Owner = ownerId
+	document := VersionedTrainingData{TrainingData: *trainingData, Version: current.Version + 1}
+	mr.put(ctx, document.ID, &document)
+	return cloneTrainingData(document), nil
+}
+
+func (mr *memoryTrainingRepository) PatchOne(ctx context.Context, botId primitive.ObjectID, projectId primitive.ObjectID, version int64, mutate func(trainingData *models.TrainingData) error) (*VersionedTrainingData, error) {
+	return patchOne(ctx, mr, botId, projectId, version, mutate)
+}
+
+func (mr *memoryTrainingRepository) FindOneByBotId(ctx context.Context, botId primitive.ObjectID, projectId primitive.ObjectID) (*VersionedTrainingData, error) {
+	userId := ctx.Value("UserId").(primitive.ObjectID)
+	mr.mu.Lock()
+	defer mr.mu.Unlock()
+	document, ok := mr.find(userId, botId, projectId)
+	if !ok {
+		return nil, mongo.ErrNoDocuments
+	}
+	return cloneTrainingData(document), nil
+}
+
+func (mr *memoryTrainingRepository) DeleteOneByBotId(ctx context.Context, botId primitive.ObjectID, projectId primitive.ObjectID, version int64) (*VersionedTrainingData, error) {
+	ownerId := ctx.Value("UserId").(primitive.ObjectID)
+	mr.mu.Lock()
+	defer mr.mu.Unlock()
+	document, found := mr.find(ownerId, botId, projectId)
+	if !found || !matchesVersion(document, version) {
+		return nil, missedWrite(found, version)
+	}
+	mr.put(ctx, document.ID, nil)
+	return cloneTrainingData(document), nil
+}
+
+// memoryPath validates the ids and the key like resolveFile does and returns
+// the name the content is kept under.
+func memoryPath(space string, botId string, projectId string, key string) (string, error) {
+	if !primitive.IsValidObjectID(botId) || !primitive.IsValidObjectID(projectId) {
+		return "", fmt.Errorf("invalid bot or project id")
+	}
+	return naming.ResolveInBotSpace("/"+space+"/"+projectId+"/"+botId, key)
+}
+
+func notExist(op string, name string) error {
+	return &fs.PathError{Op: op, Path: name, Err: fs.ErrNotExist}
+}
+
+// create stores content under a name that must not be taken yet, the lock
+// must be held for it.
+func (mr *memoryTrainingRepository) create(name string, content []byte) error {
+	if _, ok := mr.files[name]; ok {
+		return &fs.PathError{Op: "open", Path: name, Err: fs.ErrExist}
+	}
+	mr.files[name] = content
+	return nil
+}
+
+func (mr *memoryTrainingRepository) remove(name string) error {
+	mr.mu.Lock()
+	defer mr.mu.Unlock()
+	if _, ok := mr.files[name]; !ok {
+		return notExist("remove", name)
+	}
+	delete(mr.files, name)
+	return nil
+}
+
+func (mr *memoryTrainingRepository) rename(from string, to string) error {
+	mr.mu.Lock()
+	defer mr.mu.Unlock()
+	content, ok := mr.files[from]
+	if !ok {
+		return notExist("rename", from)
+	}
+	delete(mr.files, from)
+	mr.files[to] = content
+	return nil
+}
+
+func (mr *memoryTrainingRepository) open(name string, key string, dataKey []byte) (io.ReadSeekCloser, int64, error) {
+	mr.mu.Lock()
+	stored, ok := mr.files[name]
+	mr.mu.Unlock()
+	if !ok {
+		return nil, 0, notExist("open", name)
+	}
+	content, size, err := decodeStored(bytes.NewReader(stored), int64(len(stored)), key, dataKey)
+	if err != nil {
+		return nil, 0, err
+	}
+	return struct {
+		io.ReadSeeker
+		io.Closer
+	}{content, nopCloser{}}, size, nil
+}
+
+func (mr *memoryTrainingRepository) SaveFile(ctx context.Context, botId string, projectId string, key string, file *multipart.FileHeader, dataKey []byte) (string, error) {
+	defer metrics.ObserveStorage(metrics.BackendMemory, "save", time.Now())
+	name, err := memoryPath(spaceFiles, botId, projectId, key)
+	if err != nil {
+		return "", err
+	}
+	var content bytes.Buffer
+	checksum, err := writeStored(ctx, &content, file, dataKey)
+	if err != nil {
+		return "", err
+	}
+	mr.mu.Lock()
+	defer mr.mu.Unlock()
+	err = mr.create(name, content.Bytes())
+	if err != nil {
+		return "", err
+	}
+	return checksum, nil
+}
+
+func (mr *memoryTrainingRepository) OpenFile(ctx context.Context, botId string, projectId string, key string, dataKey []byte) (io.ReadSeekCloser, int64, error) {
+	defer metrics.ObserveStorage(metrics.BackendMemory, "open", time.Now())
+	name, err := memoryPath(spaceFiles, botId, projectId, key)
+	if err != nil {
+		return nil, 0, err
+	}
+	return mr.open(name, key, dataKey)
+}
+
+// storedFile returns the name of a file listed in the training data of a bot.
+func (mr *memoryTrainingRepository) storedFile(ctx context.Context, botId string, projectId string, fileId primitive.ObjectID) (string, error) {
+	bid, err := primitive.ObjectIDFromHex(botId)
+	if err != nil {
+		return "", err
+	}
+	pid, err := primitive.ObjectIDFromHex(projectId)
+	if err != nil {
+		return "", err
+	}
+	td, err := mr.FindOneByBotId(ctx, bid, pid)
+	if err != nil {
+		return "", err
+	}
+	for _, j := range td.Files {
+		if j.FileId == fileId {
+			return memoryPath(spaceFiles, botId, projectId, naming.StorageKey(fileId, j.Extension))
+		}
+	}
+	return "", fmt.Errorf("file %s: %w", fileId.Hex(), fs.ErrNotExist)
+}
+
+func (mr *memoryTrainingRepository) DeleteFile(ctx context.Context, botId string, projectId string, fileId primitive.ObjectID) error {
+	defer metrics.ObserveStorage(metrics.BackendMemory, "delete", time.Now())
+	name, err := mr.storedFile(ctx, botId, projectId, fileId)
+	if err != nil {
+		return err
+	}
+	return mr.remove(name)
+}
+
+func (mr *memoryTrainingRepository) RemoveFile(ctx context.Context, botId string, projectId string, key string) error {
+	defer metrics.ObserveStorage(metrics.BackendMemory, "delete", time.Now())
+	name, err := memoryPath(spaceFiles, botId, projectId, key)
+	if err != nil {
+		return err
+	}
+	return mr.remove(name)
+}
+
+// GetFile returns the name a file is kept under, there is no path on disk to
+// hand out.
+func (mr *memoryTrainingRepository) GetFile(ctx context.Context, botId string, projectId string, fileId primitive.ObjectID) (string, error) {
+	return mr.storedFile(ctx, botId, projectId, fileId)
+}
+
+func (mr *memoryTrainingRepository) QuarantineFile(ctx context.Context, botId string, projectId string, key string, file *multipart.FileHeader) error {
+	defer metrics.ObserveStorage(metrics.BackendMemory, "quarantine", time.Now())
+	name, err := memoryPath(spaceQuarantine, botId, projectId, key)
+	if err != nil {
+		return err
+	}
+	reader, err := file.Open()
+	if err != nil {
+		return err
+	}
+	defer reader.Close()
+	content, err := io.ReadAll(reader)
+	if err != nil {
+		return err
+	}
+	mr.mu.Lock()
+	defer mr.mu.Unlock()
+	return mr.create(name, content)
+}
+
+func (mr *memoryTrainingRepository) ArchiveRevision(ctx context.Context, botId string, projectId string, key string, revisionKey string) error {
+	defer metrics.ObserveStorage(metrics.BackendMemory, "archive_revision", time.Now())
+	name, err := memoryPath(spaceFiles, botId, projectId, key)
+	if err != nil {
+		return err
+	}
+	revisionName, err := memoryPath(spaceRevisions, botId, projectId, revisionKey)
+	if err != nil {
+		return err
+	}
+	return mr.rename(name, revisionName)
+}
+
+func (mr *memoryTrainingRepository) RestoreRevision(ctx context.Context, botId string, projectId string, revisionKey string, key string) error {
+	defer metrics.ObserveStorage(metrics.BackendMemory, "restore_revision", time.Now())
+	revisionName, err := memoryPath(spaceRevisions, botId, projectId, revisionKey)
+	if err != nil {
+		return err
+	}
+	name, err := memoryPath(spaceFiles, botId, projectId, key)
+	if err != nil {
+		return err
+	}
+	return mr.rename(revisionName, name)
+}
+
+func (mr *memoryTrainingRepository) CopyRevision(ctx context.Context, botId string, projectId string, revisionKey string, key string) error {
+	defer metrics.ObserveStorage(metrics.BackendMemory, "copy_revision", time.Now())
+	revisionName, err := memoryPath(spaceRevisions, botId, projectId, revisionKey)
+	if err != nil {
+		return err
+	}
+	name, err := memoryPath(spaceFiles, botId, projectId, key)
+	if err != nil {
+		return err
+	}
+	mr.mu.Lock()
+	defer mr.mu.Unlock()
+	content, ok := mr.files[revisionName]
+	if !ok {
+		return notExist("open", revisionName)
+	}
+	return mr.create(name, content)
+}
+
+func (mr *memoryTrainingRepository) OpenRevision(ctx context.Context, botId string, projectId string, revisionKey string, dataKey []byte) (io.ReadSeekCloser, int64, error) {
+	defer metrics.ObserveStorage(metrics.BackendMemory, "open_revision", time.Now())
+	revisionName, err := memoryPath(spaceRevisions, botId, projectId, revisionKey)
+	if err != nil {
+		return nil, 0, err
+	}
+	return mr.open(revisionName, revisionKey, dataKey)
+}
+
+func (mr *memoryTrainingRepository) DeleteRevision(ctx context.Context, botId string, projectId string, revisionKey string) error {
+	defer metrics.ObserveStorage(metrics.BackendMemory, "delete_revision", time.Now())
+	revisionName, err := memoryPath(spaceRevisions, botId, projectId, revisionKey)
+	if err != nil {
+		return err
+	}
+	return mr.remove(revisionName)
+}
+
+type nopCloser struct{}
+
+func (nopCloser) Close() error {
+	return nil
+}
@@ -0,0 +1,570 @@
+package repository
+
+import (
+	"cmp"
+	"context"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"go.mongodb.org/mongo-driver/mongo"
+	"maps"
+	"pulse/api"
+	"slices"
+	"strings"
+	"sync"
+	"time"
+)
+
+// memoryTable holds the documents of a memory repository. Like the documents
+// of the memory training repository, the writes made in a memory transaction
+// are undone when it is discarded.
+type memoryTable[K comparable, V any] struct {
+	mu   sync.Mutex
+	rows map[K]V
+}
+
+func newMemoryTable[K comparable, V any]() *memoryTable[K, V] {
+	return &memoryTable[K, V]{rows: map[K]V{}}
+}
+
+// set stores value under key, or removes key when value is nil. The lock must
+// be held for it.
+func (t *memoryTable[K, V]) set(ctx context.Context, key K, value *V) {
+	previous, existed := t.rows[key]
+	if value == nil {
+		delete(t.rows, key)
+	} else {
+		t.rows[key] = *value
+	}
+	onDiscard(ctx, func() {
+		t.mu.Lock()
+		defer t.mu.Unlock()
+		if existed {
+			t.rows[key] = previous
+		} else {
+			delete(t.rows, key)
+		}
+	})
+}
+
+// duplicateKey mirrors the error of a write breaking a unique index.
+func duplicateKey(message string) error {
+	return mongo.WriteException{WriteErrors: mongo.WriteErrors{{Code: 11000, Message: "E11000 duplicate key error: " + message}}}
+}
+
+type memoryFileRepository struct {
+	IFileRepository
+	files *memoryTable[primitive.ObjectID, FileMetadata]
+}
+
+// NewMemoryFileRepository keeps file metadata in memory, see
+// NewMemoryTrainingRepository.
+func NewMemoryFileRepository() IFileRepository {
+	return &memoryFileRepository{files: newMemoryTable[primitive.ObjectID, FileMetadata]()}
+}
+
+// cloneMetadata copies the tags and labels of metadata so that callers never
+// share them with the stored one.
+func cloneMetadata(metadata FileMetadata) *FileMetadata {
+	metadata.Tags = slices.Clone(metadata.Tags)
+	metadata.Labels = maps.Clone(metadata.Labels)
+	return &metadata
+}
+
+// ofBot tells whether metadata belongs to a bot of the owner of ctx.
+func ofBot(ctx context.Context, metadata FileMetadata, botId primitive.ObjectID, projectId primitive.ObjectID) bool {
+	ownerId := ctx.Value("UserId").(primitive.ObjectID)
+	return metadata.Owner == ownerId && metadata.BotId == botId && metadata.ProjectId == projectId
+}
+
+// matchesFilter mirrors the query FindByBotId builds from a filter.
+func matchesFilter(metadata FileMetadata, filter *FileFilter) bool {
+	if filter == nil {
+		return true
+	}
+	if filter.Folder != "" && filter.Recursive {
+		if metadata.Folder != filter.Folder && !strings.HasPrefix(metadata.Folder, filter.Folder+"/") {
+			return false
+		}
+	} else if (filter.Folder != "" || !filter.Recursive) && metadata.Folder != filter.Folder {
+		return false
+	}
+	for _, tag := range filter.Tags {
+		if !slices.Contains(metadata.Tags, tag) {
+			return false
+		}
+	}
+	for key, value := range filter.Labels {
+		if label, ok := metadata.Labels[key]; !ok || label != value {
+			return false
+		}
+	}
+	return true
+}
+
+func (fr *memoryFileRepository) InsertOne(ctx context.Context, metadata *FileMetadata) (*FileMetadata, error) {
+	metadata.Owner = ctx.Value("UserId").(primitive.ObjectID)
+	if metadata.Tags == nil {
+		metadata.Tags = make([]string, 0)
+	}
+	if metadata.Labels == nil {
+		metadata.Labels = make(map[string]string)
+	}
+	if metadata.CreatedAt.IsZero() {
+		metadata.CreatedAt = time.Now().UTC()
+	}
+	fr.files.mu.Lock()
+	defer fr.files.mu.Unlock()
+	if _, ok := fr.files.rows[metadata.FileId]; ok {
+		return nil, duplicateKey("file-metadata _id")
+	}
+	fr.files.set(ctx, metadata.FileId, cloneMetadata(*metadata))
+	return metadata, nil
+}
+
+func (fr *memoryFileRepository) FindOne(ctx context.Context, fileId primitive.ObjectID) (*FileMetadata, error) {
+	ownerId := ctx.Value("UserId").(primitive.ObjectID)
+	fr.files.mu.Lock()
+	defer fr.files.mu.Unlock()
+	metadata, ok := fr.files.rows[fileId]
+	if !ok || metadata.Owner != ownerId {
+		return nil, mongo.ErrNoDocuments
+	}
+	return cloneMetadata(metadata), nil
+}
+
+func (fr *memoryFileRepository) FindByBotId(ctx context.Context, botId primitive.ObjectID, projectId primitive.ObjectID, filter *FileFilter) ([]FileMetadata, error) {
+	fr.files.mu.Lock()
+	defer fr.files.mu.Unlock()
+	result := make([]FileMetadata, 0)
+	for _, metadata := range fr.files.rows {
+		if ofBot(ctx, metadata, botId, projectId) && matchesFilter(metadata, filter) {
+			result = append(result, *cloneMetadata(metadata))
+		}
+	}
+	slices.SortFunc(result, func(a, b FileMetadata) int {
+		return cmp.Or(strings.Compare(a.Folder, b.Folder), strings.Compare(a.FileName, b.FileName))
+	})
+	return result, nil
+}
+
+func (fr *memoryFileRepository) FindMany(ctx context.Context, botId primitive.ObjectID, projectId primitive.ObjectID, fileIds []primitive.ObjectID) ([]FileMetadata, error) {
+	fr.files.mu.Lock()
+	defer fr.files.mu.Unlock()
+	result := make([]FileMetadata, 0)
+	for _, metadata := range fr.files.rows {
+		if ofBot(ctx, metadata, botId, projectId) && slices.Contains(fileIds, metadata.FileId) {
+			result = append(result, *cloneMetadata(metadata))
+		}
+	}
+	return result, nil
+}
+
+// updateMany applies update to the listed files of a bot.
+func (fr *memoryFileRepository) updateMany(ctx context.Context, botId primitive.ObjectID, projectId primitive.ObjectID, fileIds []primitive.ObjectID, update func(metadata *FileMetadata)) {
+	fr.files.mu.Lock()
+	defer fr.files.mu.Unlock()
+	for _, fileId := range fileIds {
+		metadata, ok := fr.files.rows[fileId]
+		if !ok || !ofBot(ctx, metadata, botId, projectId) {
+			continue
+		}
+		updated := cloneMetadata(metadata)
+		update(updated)
+		fr.files.set(ctx, fileId, updated)
+	}
+}
+
+func (fr *memoryFileRepository) SetFolder(ctx context.Context, botId primitive.ObjectID, projectId primitive.ObjectID, fileIds []primitive.ObjectID, folder string) error {
+	fr.updateMany(ctx, botId, projectId, fileIds, func(metadata *FileMetadata) {
+		metadata.Folder = folder
+	})
+	return nil
+}
+
+func (fr *memoryFileRepository) UpdateTags(ctx context.Context, botId primitive.ObjectID, projectId primitive.ObjectID, fileIds []primitive.ObjectID, change *TagChange) error {
+	fr.updateMany(ctx, botId, projectId, fileIds, func(metadata *FileMetadata) {
+		if metadata.Tags == nil {
+			metadata.Tags = make([]string, 0)
+		}
+		if metadata.Labels == nil {
+			metadata.Labels = make(map[string]string)
+		}
+		for _, tag := range change.AddTags {
+			if !slices.Contains(metadata.Tags, tag) {
+				metadata.Tags = append(metadata.Tags, tag)
+			}
+		}
+		for key, value := range change.SetLabels {
+			metadata.Labels[key] = value
+		}
+		for _, key := range change.RemoveLabels {
+			delete(metadata.Labels, key)
+		}
+		metadata.Tags = slices.DeleteFunc(metadata.Tags, func(tag string) bool {
+			return slices.Contains(change.RemoveTags, tag)
+		})
+	})
+	return nil
+}
+
+func (fr *memoryFileRepository) ReplaceOne(ctx context.Context, metadata *FileMetadata) (*FileMetadata, error) {
+	ownerId := ctx.Value("UserId").(primitive.ObjectID)
+	fr.files.mu.Lock()
+	defer fr.files.mu.Unlock()
+	current, ok := fr.files.rows[metadata.FileId]
+	if !ok || current.Owner != ownerId {
+		return nil, mongo.ErrNoDocuments
+	}
+	metadata.Owner = ownerId
+	fr.files.set(ctx, metadata.FileId, cloneMetadata(*metadata))
+	return metadata, nil
+}
+
+func (fr *memoryFileRepository) DeleteOne(ctx context.Context, fileId primitive.ObjectID) (*FileMetadata, error) {
+	ownerId := ctx.Value("UserId").(primitive.ObjectID)
+	fr.files.mu.Lock()
+	defer fr.files.mu.Unlock()
+	metadata, ok := fr.files.rows[fileId]
+	if !ok || metadata.Owner != ownerId {
+		return nil, mongo.ErrNoDocuments
+	}
+	fr.files.set(ctx, fileId, nil)
+	return cloneMetadata(metadata), nil
+}
+
+type memoryRevisionRepository struct {
+	IRevisionRepository
+	revisions *memoryTable[primitive.ObjectID, FileRevision]
+}
+
+// NewMemoryRevisionRepository keeps the revision records of files in memory,
+// see NewMemoryTrainingRepository.
+func NewMemoryRevisionRepository() IRevisionRepository {
+	return &memoryRevisionRepository{revisions: newMemoryTable[primitive.ObjectID, FileRevision]()}
+}
+
+func (rr *memoryRevisionRepository) InsertOne(ctx context.Context, revision *FileRevision) (*FileRevision, error) {
+	revision.Owner = ctx.Value("UserId").(primitive.ObjectID)
+	revision.Id = primitive.NewObjectID()
+	rr.revisions.mu.Lock()
+	defer rr.revisions.mu.Unlock()
+	for _, stored := range rr.revisions.rows {
+		if stored.Owner == revision.Owner && stored.FileId == revision.FileId && stored.Revision == revision.Revision {
+			return nil, duplicateKey("file-revisions fileId_revision")
+		}
+	}
+	stored := *revision
+	rr.revisions.set(ctx, revision.Id, &stored)
+	return revision, nil
+}
+
+func (rr *memoryRevisionRepository) FindOne(ctx context.Context, fileId primitive.ObjectID, revision int64) (*FileRevision, error) {
+	revisions, err := rr.FindByFileId(ctx, fileId)
+	if err != nil {
+		return nil, err
+	}
+	i := slices.IndexFunc(revisions, func(r FileRevision) bool { return r.Revision == revision })
+	if i < 0 {
+		return nil, mongo.ErrNoDocuments
+	}
+	return &revisions[i], nil
+}
+
+func (rr *memoryRevisionRepository) FindByFileId(ctx context.Context, fileId primitive.ObjectID) ([]FileRevision, error) {
+	ownerId := ctx.Value("UserId").(primitive.ObjectID)
+	rr.revisions.mu.Lock()
+	defer rr.revisions.mu.Unlock()
+	result := make([]FileRevision, 0)
+	for _, revision := range rr.revisions.rows {
+		if revision.Owner == ownerId && revision.FileId == fileId {
+			result = append(result, revision)
+		}
+	}
+	slices.SortFunc(result, func(a, b FileRevision) int {
+		return cmp.Compare(b.Revision, a.Revision)
+	})
+	return result, nil
+}
+
+func (rr *memoryRevisionRepository) DeleteByFileId(ctx context.Context, fileId primitive.ObjectID) ([]FileRevision, error) {
+	revisions, err := rr.FindByFileId(ctx, fileId)
+	if err != nil {
+		return nil, err
+	}
+	rr.revisions.mu.Lock()
+	defer rr.revisions.mu.Unlock()
+	for _, revision := range revisions {
+		rr.revisions.set(ctx, revision.Id, nil)
+	}
+	return revisions, nil
+}
+
+// usageKey identifies a counter, the counter of a project has no bot.
+type usageKey struct {
+	owner     primitive.ObjectID
+	projectId primitive.ObjectID
+	botId     primitive.ObjectID
+}
+
+type memoryUsageRepository struct {
+	IUsageRepository
+	counters *memoryTable[usageKey, Usage]
+	events   *memoryTable[primitive.ObjectID, UsageEvent]
+}
+
+// NewMemoryUsageRepository keeps usage counters and events in memory, see
+// NewMemoryTrainingRepository.
+func NewMemoryUsageRepository() IUsageRepository {
+	return &memoryUsageRepository{
+		counters: newMemoryTable[usageKey, Usage](),
+		events:   newMemoryTable[primitive.ObjectID, UsageEvent](),
+	}
+}
+
+func (ur *memoryUsageRepository) FindByProjectId(ctx context.Context, projectId primitive.ObjectID) ([]Usage, error) {
+	ownerId := ctx.Value("UserId").(primitive.ObjectID)
+	ur.counters.mu.Lock()
+	defer ur.counters.mu.Unlock()
+	result := make([]Usage, 0)
+	for key, usage := range ur.counters.rows {
+		if key.owner == ownerId && key.projectId == projectId && !key.botId.IsZero() {
+			result = append(result, usage)
+		}
+	}
+	return result, nil
+}
+
+// add adds bytes and files to the counter of key and returns the counter as
+// it left it. The lock must be held for it.
+func (ur *memoryUsageRepository) add(ctx context.Context, key usageKey, bytes int64, files int64) *Usage {
+	usage, ok := ur.counters.rows[key]
+	if !ok {
+		usage = Usage{ProjectId: key.projectId, BotId: key.botId, Owner: key.owner}
+	}
+	usage.Bytes += bytes
+	usage.Files += files
+	usage.UpdatedAt = time.Now().UTC()
+	ur.counters.set(ctx, key, &usage)
+	return &usage
+}
+
+func (ur *memoryUsageRepository) Increment(ctx context.Context, projectId primitive.ObjectID, botId primitive.ObjectID, bytes int64, files int64) error {
+	_, _, err := ur.IncrementWithin(ctx, projectId, botId, bytes, files, api.QuotaLimits{}, api.QuotaLimits{})
+	return err
+}
+
+func (ur *memoryUsageRepository) IncrementWithin(ctx context.Context, projectId primitive.ObjectID, botId primitive.ObjectID, bytes int64, files int64, project api.QuotaLimits, bot api.QuotaLimits) (*Usage, *Usage, error) {
+	ownerId := ctx.Value("UserId").(primitive.ObjectID)
+	ur.counters.mu.Lock()
+	defer ur.counters.mu.Unlock()
+	projectKey := usageKey{owner: ownerId, projectId: projectId}
+	botKey := usageKey{owner: ownerId, projectId: projectId, botId: botId}
+	// both limits are checked before either counter changes
+	for key, limits := range map[usageKey]api.QuotaLimits{projectKey: project, botKey: bot} {
+		usage := ur.counters.rows[key]
+		if (limits.MaxBytes > 0 && bytes > 0 && usage.Bytes+bytes > limits.MaxBytes) || (limits.MaxFiles > 0 && files > 0 && usage.Files+files > limits.MaxFiles) {
+			return nil, nil, ErrLimitReached
+		}
+	}
+	return ur.add(ctx, projectKey, bytes, files), ur.add(ctx, botKey, bytes, files), nil
+}
+
+func (ur *memoryUsageRepository) InsertEvent(ctx context.Context, event *UsageEvent) error {
+	event.ID = primitive.NewObjectID()
+	event.Owner = ctx.Value("UserId").(primitive.ObjectID)
+	event.CreatedAt = time.Now().UTC()
+	ur.events.mu.Lock()
+	defer ur.events.mu.Unlock()
+	stored := *event
+	ur.events.set(ctx, event.ID, &stored)
+	return nil
+}
+
+func (ur *memoryUsageRepository) FindEvents(ctx context.Context, projectId primitive.ObjectID, limit int64) ([]UsageEvent, error) {
+	ownerId := ctx.Value("UserId").(primitive.ObjectID)
+	if limit <= 0 {
+		limit = defaultUsageEventLimit
+	}
+	ur.events.mu.Lock()
+	defer ur.events.mu.Unlock()
+	result := make([]UsageEvent, 0)
+	for _, event := range ur.events.rows {
+		if event.Owner == ownerId && event.ProjectId == projectId {
+			result = append(result, event)
+		}
+	}
+	// ids break the ties of events created within the same instant
+	slices.SortFunc(result, func(a, b UsageEvent) int {
+		return cmp.Or(b.CreatedAt.Compare(a.CreatedAt), strings.Compare(b.ID.Hex(), a.ID.Hex()))
+	})
+	if int64(len(result)) > limit {
+		result = result[:limit]
+	}
+	return result, nil
+}
+
+// projectKey identifies the policy and the data key of a project.
+type projectKey struct {
+	owner     primitive.ObjectID
+	projectId primitive.ObjectID
+}
+
+type memoryPolicyRepository struct {
+	IPolicyRepository
+	policies *memoryTable[projectKey, UploadPolicy]
+}
+
+// NewMemoryPolicyRepository keeps upload policies in memory, see
+// NewMemoryTrainingRepository.
+func NewMemoryPolicyRepository() IPolicyRepository {
+	return &memoryPolicyRepository{policies: newMemoryTable[projectKey, UploadPolicy]()}
+}
+
+func (pr *memoryPolicyRepository) FindByProjectId(ctx context.Context, projectId primitive.ObjectID) (*UploadPolicy, error) {
+	ownerId := ctx.Value("UserId").(primitive.ObjectID)
+	pr.policies.mu.Lock()
+	defer pr.policies.mu.Unlock()
+	policy, ok := pr.policies.rows[projectKey{owner: ownerId, projectId: projectId}]
+	if !ok {
+		return nil, mongo.ErrNoDocuments
+	}
+	policy.AllowedMimeTypes = slices.Clone(policy.AllowedMimeTypes)
+	return &policy, nil
+}
+
+func (pr *memoryPolicyRepository) Upsert(ctx context.Context, policy *UploadPolicy) (*UploadPolicy, error) {
+	ownerId := ctx.Value("UserId").(primitive.ObjectID)
+	policy.Id = primitive.NilObjectID
+	policy.Owner = ownerId
+	policy.UpdatedAt = time.Now().UTC()
+	pr.policies.mu.Lock()
+	defer pr.policies.mu.Unlock()
+	stored := *policy
+	stored.AllowedMimeTypes = slices.Clone(policy.AllowedMimeTypes)
+	pr.policies.set(ctx, projectKey{owner: ownerId, projectId: policy.ProjectId}, &stored)
+	return policy, nil
+}
+
+type memoryDataKeyRepository struct {
+	IDataKeyRepository
+	keys *memoryTable[projectKey, DataKey]
+}
+
+// NewMemoryDataKeyRepository keeps data keys in memory, see
+// NewMemoryTrainingRepository. Like the Mongo repository it writes them
+// outside the transaction of the context.
+func NewMemoryDataKeyRepository() IDataKeyRepository {
+	return &memoryDataKeyRepository{keys: newMemoryTable[projectKey, DataKey]()}
+}
+
+func (kr *memoryDataKeyRepository) FindByProjectId(ctx context.Context, projectId primitive.ObjectID) (*DataKey, error) {
+	ownerId := ctx.Value("UserId").(primitive.ObjectID)
+	kr.keys.mu.Lock()
+	defer kr.keys.mu.Unlock()
+	dataKey, ok := kr.keys.rows[projectKey{owner: ownerId, projectId: projectId}]
+	if !ok {
+		return nil, mongo.ErrNoDocuments
+	}
+	return &dataKey, nil
+}
+
+func (kr *memoryDataKeyRepository) InsertOne(ctx context.Context, dataKey *DataKey) (*DataKey, error) {
+	dataKey.Id = primitive.NewObjectID()
+	dataKey.Owner = ctx.Value("UserId").(primitive.ObjectID)
+	dataKey.CreatedAt = time.Now().UTC()
+	kr.keys.mu.Lock()
+	defer kr.keys.mu.Unlock()
+	key := projectKey{owner: dataKey.Owner, projectId: dataKey.ProjectId}
+	if _, ok := kr.keys.rows[key]; ok {
+		return nil, duplicateKey("data-keys owner_projectId")
+	}
+	stored := *dataKey
+	kr.keys.set(outsideTransaction(ctx), key, &stored)
+	return dataKey, nil
+}
+
+func (kr *memoryDataKeyRepository) FindNotWrappedWith(ctx context.Context, owner primitive.ObjectID, masterKeyId string) ([]DataKey, error) {
+	kr.keys.mu.Lock()
+	defer kr.keys.mu.Unlock()
+	result := make([]DataKey, 0)
+	for _, dataKey := range kr.keys.rows {
+		if dataKey.MasterKeyId != masterKeyId && (owner.IsZero() || dataKey.Owner == owner) {
+			result = append(result, dataKey)
+		}
+	}
+	return result, nil
+}
+
+func (kr *memoryDataKeyRepository) UpdateWrapping(ctx context.Context, dataKey *DataKey, previousMasterKeyId string) error {
+	kr.keys.mu.Lock()
+	defer kr.keys.mu.Unlock()
+	key := projectKey{owner: dataKey.Owner, projectId: dataKey.ProjectId}
+	stored, ok := kr.keys.rows[key]
+	if !ok || stored.Id != dataKey.Id || stored.MasterKeyId != previousMasterKeyId {
+		return nil
+	}
+	dataKey.RotatedAt = time.Now().UTC()
+	stored.WrappedKey = dataKey.WrappedKey
+	stored.MasterKeyId = dataKey.MasterKeyId
+	stored.RotatedAt = dataKey.RotatedAt
+	kr.keys.set(ctx, key, &stored)
+	return nil
+}
+
+type memoryIdempotencyRepository struct {
+	IIdempotencyRepository
+	records *memoryTable[string, IdempotencyRecord]
+}
+
+// NewMemoryIdempotencyRepository keeps idempotency records in memory, see
+// NewMemoryTrainingRepository. Expired records count as missing.
+func NewMemoryIdempotencyRepository() IIdempotencyRepository {
+	return &memoryIdempotencyRepository{records: newMemoryTable[string, IdempotencyRecord]()}
+}
+
+func (ir *memoryIdempotencyRepository) InsertOne(ctx context.Context, record *IdempotencyRecord) error {
+	record.Owner, record.Id = idempotencyId(ctx, record.Key)
+	ir.records.mu.Lock()
+	defer ir.records.mu.Unlock()
+	if stored, ok := ir.records.rows[record.Id]; ok && stored.ExpiresAt.After(time.Now().UTC()) {
+		return ErrRecordExists
+	}
+	stored := *record
+	ir.records.set(ctx, record.Id, &stored)
+	return nil
+}
+
+func (ir *memoryIdempotencyRepository) FindOne(ctx context.Context, key string) (*IdempotencyRecord, error) {
+	_, id := idempotencyId(ctx, key)
+	ir.records.mu.Lock()
+	defer ir.records.mu.Unlock()
+	record, ok := ir.records.rows[id]
+	if !ok || !record.ExpiresAt.After(time.Now().UTC()) {
+		return nil, mongo.ErrNoDocuments
+	}
+	return &record, nil
+}
+
+func (ir *memoryIdempotencyRepository) Complete(ctx context.Context, record *IdempotencyRecord) error {
+	_, id := idempotencyId(ctx, record.Key)
+	record.Completed = true
+	ir.records.mu.Lock()
+	defer ir.records.mu.Unlock()
+	stored, ok := ir.records.rows[id]
+	if !ok {
+		return nil
+	}
+	stored.Completed = true
+	stored.Status = record.Status
+	stored.Headers = maps.Clone(record.Headers)
+	stored.Body = slices.Clone(record.Body)
+	ir.records.set(ctx, id, &stored)
+	return nil
+}
+
+func (ir *memoryIdempotencyRepository) DeleteOne(ctx context.Context, key string) error {
+	_, id := idempotencyId(ctx, key)
+	ir.records.mu.Lock()
+	defer ir.records.mu.Unlock()
+	ir.records.set(ctx, id, nil)
+	return nil
+}
@@ -0,0 +1,94 @@
+package repository_test
+
+import (
+	"context"
+	"errors"
+	"github.com/draco121/horizon/models"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"go.mongodb.org/mongo-driver/mongo"
+	"pulse/api"
+	"pulse/repository"
+	"pulse/repository/repotest"
+	"testing"
+)
+
+func TestMemoryTrainingRepository(t *testing.T) {
+	repotest.Run(t, func(t *testing.T) repository.ITrainingRepository {
+		return repository.NewMemoryTrainingRepository()
+	})
+}
+
+func TestMemoryTransactions(t *testing.T) {
+	repo := repository.NewMemoryTrainingRepository()
+	transactions := repository.NewMemoryTransactions()
+	ctx := repotest.WithOwner(context.Background(), primitive.NewObjectID())
+	botId, projectId := primitive.NewObjectID(), primitive.NewObjectID()
+
+	txCtx, tx, err := transactions.Begin(ctx)
+	if err != nil {
+		t.Fatal(err)
+	}
+	_, err = repo.InsertOne(txCtx, &models.TrainingData{BotId: botId, ProjectId: projectId})
+	if err != nil {
+		t.Fatal(err)
+	}
+	tx.End(txCtx)
+	_, err = repo.FindOneByBotId(ctx, botId, projectId)
+	if !errors.Is(err, mongo.ErrNoDocuments) {
+		t.Fatalf("err = %v, want the insert discarded", err)
+	}
+
+	txCtx, tx, err = transactions.Begin(ctx)
+	if err != nil {
+		t.Fatal(err)
+	}
+	_, err = repo.InsertOne(txCtx, &models.TrainingData{BotId: botId, ProjectId: projectId})
+	if err != nil {
+		t.Fatal(err)
+	}
+	err = tx.Commit(txCtx)
+	if err != nil {
+		t.Fatal(err)
+	}
+	tx.End(txCtx)
+	_, err = repo.FindOneByBotId(ctx, botId, projectId)
+	if err != nil {
+		t.Fatalf("committed insert lost: %v", err)
+	}
+}
+
+func TestMemoryUsageWithinLimits(t *testing.T) {
+	repo := repository.NewMemoryUsageRepository()
+	ctx := repotest.WithOwner(context.Background(), primitive.NewObjectID())
+	projectId, botId := primitive.NewObjectID(), primitive.NewObjectID()
+	project := api.QuotaLimits{MaxBytes: 100}
+	bot := api.QuotaLimits{MaxFiles: 2}
+	for i := 0; i < 2; i++ {
+		_, _, err := repo.IncrementWithin(ctx, projectId, botId, 30, 1, project, bot)
+		if err != nil {
+			t.Fatal(err)
+		}
+	}
+	_, _, err := repo.IncrementWithin(ctx, projectId, botId, 30, 1, project, bot)
+	if !errors.Is(err, repository.ErrLimitReached) {
+		t.Fatalf("err = %v, want the bot file limit reached", err)
+	}
+	_, _, err = repo.IncrementWithin(ctx, projectId, primitive.NewObjectID(), 50, 1, project, bot)
+	if !errors.Is(err, repository.ErrLimitReached) {
+		t.Fatalf("err = %v, want the project byte limit reached", err)
+	}
+	projectUsage, botUsage, err := repo.IncrementWithin(ctx, projectId, primitive.NewObjectID(), 40, 1, project, bot)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if projectUsage.Bytes != 100 || projectUsage.Files != 3 || botUsage.Bytes != 40 || botUsage.Files != 1 {
+		t.Fatalf("counters project %+v, bot %+v", projectUsage, botUsage)
+	}
+	usages, err := repo.FindByProjectId(ctx, projectId)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(usages) != 2 {
+		t.Fatalf("found %d bot counters, want 2", len(usages))
+	}
+}
@@ -0,0 +1,54 @@
+package repository
+
+import (
+	"context"
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
+	"pulse/api"
+	"time"
+)
+
+type UploadPolicy = api.UploadPolicy
+
+type IPolicyRepository interface {
+	FindByProjectId(ctx context.Context, projectId primitive.ObjectID) (*UploadPolicy, error)
+	Upsert(ctx context.Context, policy *UploadPolicy) (*UploadPolicy, error)
+}
+
+type policyRepository struct {
+	IPolicyRepository
+	db *mongo.Database
+}
+
+func NewPolicyRepository(db *mongo.Database) IPolicyRepository {
+	return &policyRepository{
+		db: db,
+	}
+}
+
+func (pr *policyRepository) FindByProjectId(ctx context.Context, projectId primitive.ObjectID) (*UploadPolicy, error) {
+	ownerId := ctx.Value("UserId").(primitive.ObjectID)
+	filter := bson.D{{Key: "owner", Value: ownerId}, {Key: "projectId", Value: projectId}}
+	result := UploadPolicy{}
+	err := pr.db.Collection("upload-policies").FindOne(ctx, filter).Decode(&result)
+	if err != nil {
+		return nil, err
+	}
+	return &result, nil
+}
+
+func (pr *policyRepository) Upsert(ctx context.Context, policy *UploadPolicy) (*UploadPolicy, error) {
+	ownerId := ctx.Value("UserId").(primitive.ObjectID)
+	// the id of a stored policy is kept, a new one gets its id on insert
+	policy.Id = primitive.NilObjectID
+	policy.Owner = ownerId
+	policy.UpdatedAt = time.Now().UTC()
+	filter := bson.D{{Key: "owner", Value: ownerId}, {Key: "projectId", Value: policy.ProjectId}}
+	_, err := pr.db.Collection("upload-policies").ReplaceOne(ctx, filter, policy, options.Replace().SetUpsert(true))
+	if err != nil {
+		return nil, err
+	}
+	return policy, nil
+}
@@ -2,6 +2,8 @@ package repository
 
 import (
 	"context"
+	"crypto/sha256"
+	"encoding/hex"
 	"errors"
 	"fmt"
 	"github.com/draco121/horizon/models"
@@ -9,21 +11,55 @@ import (
 	"go.mongodb.org/mongo-driver/bson"
 	"go.mongodb.org/mongo-driver/bson/primitive"
 	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
 	"io"
 	"mime/multipart"
 	"os"
 	"path"
-	"slices"
+	"pulse/api"
+	"pulse/encryption"
+	"pulse/metrics"
+	"pulse/naming"
+	"time"
 )
 
+var (
+	ErrRecordExists    = errors.New("record exists")
+	ErrVersionMismatch = errors.New("record was modified by another request")
+)
+
+// AnyVersion skips the optimistic concurrency check of a write.
+const AnyVersion int64 = -1
+
+// patchAttempts bounds the retries of an unconditional patch racing other
+// writes.
+const patchAttempts = 3
+
+type VersionedTrainingData = api.VersionedTrainingData
+
 type ITrainingRepository interface {
-	FindOneByBotId(ctx context.Context, botId primitive.ObjectID, projectId primitive.ObjectID) (*models.TrainingData, error)
-	UpdateOne(ctx context.Context, trainingData *models.TrainingData) (*models.TrainingData, error)
-	DeleteOneByBotId(ctx context.Context, botId primitive.ObjectID, projectId primitive.ObjectID) (*models.TrainingData, error)
-	InsertOne(ctx context.Context, trainingData *models.TrainingData) (*models.TrainingData, error)
-	SaveFile(ctx context.Context, botId string, projectId string, file *multipart.FileHeader) error
+	FindOneByBotId(ctx context.Context, botId primitive.ObjectID, projectId primitive.ObjectID) (*VersionedTrainingData, error)
+	UpdateOne(ctx context.Context, trainingData *models.TrainingData, version int64) (*VersionedTrainingData, error)
+	PatchOne(ctx context.Context, botId primitive.ObjectID, projectId primitive.ObjectID, version int64, mutate func(trainingData *models.TrainingData) error) (*VersionedTrainingData, error)
+	DeleteOneByBotId(ctx context.Context, botId primitive.ObjectID, projectId primitive.ObjectID, version int64) (*VersionedTrainingData, error)
+	InsertOne(ctx context.Context, trainingData *models.TrainingData) (*VersionedTrainingData, error)
+	SaveFile(ctx context.Context, botId string, projectId string, key string, file *multipart.FileHeader, dataKey []byte) (string, error)
+	OpenFile(ctx context.Context, botId string, projectId string, key string, dataKey []byte) (io.ReadSeekCloser, int64, error)
 	DeleteFile(ctx context.Context, botId string, projectId string, fileId primitive.ObjectID) error
+	// RemoveFile deletes a stored file by its storage key, for files already
+	// removed from the training data.
+	RemoveFile(ctx context.Context, botId string, projectId string, key string) error
 	GetFile(ctx context.Context, botId string, projectId string, fileId primitive.ObjectID) (string, error)
+	QuarantineFile(ctx context.Context, botId string, projectId string, key string, file *multipart.FileHeader) error
+	// ArchiveRevision moves a stored file out of the bot space into the
+	// revision history, RestoreRevision moves it back.
+	ArchiveRevision(ctx context.Context, botId string, projectId string, key string, revisionKey string) error
+	RestoreRevision(ctx context.Context, botId string, projectId string, revisionKey string, key string) error
+	// CopyRevision puts a copy of a revision back into the bot space, leaving
+	// the history untouched.
+	CopyRevision(ctx context.Context, botId string, projectId string, revisionKey string, key string) error
+	OpenRevision(ctx context.Context, botId string, projectId string, revisionKey string, dataKey []byte) (io.ReadSeekCloser, int64, error)
+	DeleteRevision(ctx context.Context, botId string, projectId string, revisionKey string) error
 }
 
 type trainingRepository struct {
@@ -37,42 +73,107 @@ func NewTrainingRepository(db *mongo.Database) ITrainingRepository {
 	}
 }
 
-func (ur *trainingRepository) InsertOne(ctx context.Context, trainingData *models.TrainingData) (*models.TrainingData, error) {
+// withVersion restricts a filter to the expected version. Documents written
+// before versioning have no version field and count as version 0.
+func withVersion(filter bson.D, version int64) bson.D {
+	if version == AnyVersion {
+		return filter
+	} else if version == 0 {
+		return append(filter, bson.E{Key: "$or", Value: bson.A{
+			bson.M{"version": 0},
+			bson.M{"version": bson.M{"$exists": false}},
+		}})
+	}
+	return append(filter, bson.E{Key: "version", Value: version})
+}
+
+// missedWrite tells apart a write that matched nothing because the document
+// does not exist from one that lost against a concurrent write.
+func (ur *trainingRepository) missedWrite(ctx context.Context, filter bson.D, version int64) error {
+	if version == AnyVersion {
+		return mongo.ErrNoDocuments
+	}
+	count, err := ur.db.Collection("training-data").CountDocuments(ctx, filter)
+	if err != nil {
+		return err
+	} else if count > 0 {
+		return ErrVersionMismatch
+	}
+	return mongo.ErrNoDocuments
+}
+
+func (ur *trainingRepository) InsertOne(ctx context.Context, trainingData *models.TrainingData) (*VersionedTrainingData, error) {
 	ownerId := ctx.Value("UserId").(primitive.ObjectID)
 	result, _ := ur.FindOneByBotId(ctx, trainingData.BotId, trainingData.ProjectId)
 	if result != nil {
-		return nil, fmt.Errorf("record exists")
+		return nil, ErrRecordExists
 	} else {
 		trainingData.ID = primitive.NewObjectID()
 		trainingData.Owner = ownerId
-		_, err := ur.db.Collection("training-data").InsertOne(ctx, trainingData)
-		if err != nil {
+		document := &VersionedTrainingData{TrainingData: *trainingData, Version: 1}
+		_, err := ur.db.Collection("training-data").InsertOne(ctx, document)
+		// the unique index catches an insert racing past the lookup above
+		if mongo.IsDuplicateKeyError(err) {
+			return nil, ErrRecordExists
+		} else if err != nil {
 			return nil, err
 		}
+		return document, nil
 	}
-	return trainingData, nil
 }
 
-func (ur *trainingRepository) UpdateOne(ctx context.Context, trainingData *models.TrainingData) (*models.TrainingData, error) {
+func (ur *trainingRepository) UpdateOne(ctx context.Context, trainingData *models.TrainingData, version int64) (*VersionedTrainingData, error) {
 	ownerId := ctx.Value("UserId").(primitive.ObjectID)
 	filter := bson.D{{Key: "_id", Value: trainingData.ID}, {Key: "owner", Value: ownerId}}
 	trainingData.Owner = ownerId
-	update := bson.M{"$set": trainingData}
-	result := models.TrainingData{}
-	err := ur.db.Collection("training-data").FindOneAndUpdate(ctx, filter, update).Decode(&result)
+	update := bson.M{"$set": trainingData, "$inc": bson.M{"version": 1}}
+	opts := options.FindOneAndUpdate().SetReturnDocument(options.After)
+	result := VersionedTrainingData{}
+	err := ur.db.Collection("training-data").FindOneAndUpdate(ctx, withVersion(filter, version), update, opts).Decode(&result)
 	if errors.Is(err, mongo.ErrNoDocuments) {
+		return nil, ur.missedWrite(ctx, filter, version)
+	} else if err != nil {
 		return nil, err
 	} else {
 		return &result, nil
 	}
 }
 
-func (ur *trainingRepository) FindOneByBotId(ctx context.Context, botId primitive.ObjectID, projectId primitive.ObjectID) (*models.TrainingData, error) {
+// PatchOne applies mutate to the stored document and writes the result back
+// only if nobody wrote it in between. With AnyVersion a lost race is retried
+// on the fresh document.
+func (ur *trainingRepository) PatchOne(ctx context.Context, botId primitive.ObjectID, projectId primitive.ObjectID, version int64, mutate func(trainingData *models.TrainingData) error) (*VersionedTrainingData, error) {
+	return patchOne(ctx, ur, botId, projectId, version, mutate)
+}
+
+func patchOne(ctx context.Context, repo ITrainingRepository, botId primitive.ObjectID, projectId primitive.ObjectID, version int64, mutate func(trainingData *models.TrainingData) error) (*VersionedTrainingData, error) {
+	for attempt := 0; attempt < patchAttempts; attempt++ {
+		current, err := repo.FindOneByBotId(ctx, botId, projectId)
+		if err != nil {
+			return nil, err
+		}
+		if version != AnyVersion && current.Version != version {
+			return nil, ErrVersionMismatch
+		}
+		err = mutate(&current.TrainingData)
+		if err != nil {
+			return nil, err
+		}
+		result, err := repo.UpdateOne(ctx, &current.TrainingData, current.Version)
+		if errors.Is(err, ErrVersionMismatch) && version == AnyVersion {
+			continue
+		}
+		return result, err
+	}
+	return nil, ErrVersionMismatch
+}
+
+func (ur *trainingRepository) FindOneByBotId(ctx context.Context, botId primitive.ObjectID, projectId primitive.ObjectID) (*VersionedTrainingData, error) {
 	userId := ctx.Value("UserId").(primitive.ObjectID)
-	filter := bson.D{{Key: "botId", Value: botId}, {Key: "owner", Value: userId}, {Key: "projectId", Value: projectId}}
-	result := models.TrainingData{}
+	filter := bson.D{{Key: "botid", Value: botId}, {Key: "owner", Value: userId}, {Key: "projectid", Value: projectId}}
+	result := VersionedTrainingData{}
 	err := ur.db.Collection("training-data").FindOne(ctx, filter).Decode(&result)
-	if errors.Is(err, mongo.ErrNoDocuments) {
+	if err != nil {
 		return nil, err
 	} else {
 		return &result, nil
@@ -80,42 +181,297 @@ func (ur *trainingRepository) FindOneByBotId(ctx context.Context, botId primitiv
 
 }
 
-func (ur *trainingRepository) DeleteOneByBotId(ctx context.Context, botId primitive.ObjectID, projectId primitive.ObjectID) (*models.TrainingData, error) {
+func (ur *trainingRepository) DeleteOneByBotId(ctx context.Context, botId primitive.ObjectID, projectId primitive.ObjectID, version int64) (*VersionedTrainingData, error) {
 	ownerId := ctx.Value("UserId").(primitive.ObjectID)
-	filter := bson.D{{Key: "botId", Value: botId}, {Key: "owner", Value: ownerId}, {Key: "projectId", Value: projectId}}
-	result := models.TrainingData{}
-	err := ur.db.Collection("training-data").FindOneAndDelete(ctx, filter).Decode(&result)
+	filter := bson.D{{Key: "botid", Value: botId}, {Key: "owner", Value: ownerId}, {Key: "projectid", Value: projectId}}
+	result := VersionedTrainingData{}
+	err := ur.db.Collection("training-data").FindOneAndDelete(ctx, withVersion(filter, version)).Decode(&result)
 	if errors.Is(err, mongo.ErrNoDocuments) {
+		return nil, ur.missedWrite(ctx, filter, version)
+	} else if err != nil {
 		return nil, err
 	} else {
 		return &result, nil
 	}
 }
 
-func (ur *trainingRepository) SaveFile(ctx context.Context, botId string, projectId string, file *multipart.FileHeader) error {
-	botPath, err := utils.CreateBotSpace(botId, projectId)
+// resolveFile returns the path of a storage key inside the bot space. The ids
+// are validated as they become directory names.
+func resolveFile(botId string, projectId string, key string) (string, error) {
+	if !primitive.IsValidObjectID(botId) || !primitive.IsValidObjectID(projectId) {
+		return "", fmt.Errorf("invalid bot or project id")
+	}
+	botSpace, err := utils.CreateBotSpace(botId, projectId)
 	if err != nil {
-		return err
-	} else {
-		newFile, err := os.Create(path.Join(botPath, file.Filename))
-		if err != nil {
-			return err
-		} else {
-			reader, err := file.Open()
-			if err != nil {
-				return err
-			} else {
-				_, err = io.Copy(newFile, reader)
-				if err != nil {
-					return err
-				}
-				return nil
-			}
+		return "", err
+	}
+	return naming.ResolveInBotSpace(botSpace, key)
+}
+
+// resolveStoredFile resolves the key of a file that is already stored. Files
+// stored before extensions were sanitized are found under their legacy name
+// when nothing is stored under key.
+func resolveStoredFile(botId string, projectId string, key string) (string, error) {
+	filePath, err := resolveFile(botId, projectId, key)
+	if err != nil {
+		return "", err
+	}
+	_, err = os.Lstat(filePath)
+	if !errors.Is(err, os.ErrNotExist) {
+		return filePath, nil
+	}
+	entries, err := os.ReadDir(path.Dir(filePath))
+	if err != nil {
+		return filePath, nil
+	}
+	for _, entry := range entries {
+		if entry.Type().IsRegular() && naming.IsLegacyKey(key, entry.Name()) {
+			return resolveFile(botId, projectId, entry.Name())
 		}
 	}
+	return filePath, nil
+}
+
+// SaveFile writes an uploaded file into the bot space under the storage key
+// and returns the SHA-256 checksum of its content. When a data key is given
+// the content is encrypted with it. A write that fails or is cancelled through
+// ctx leaves no file behind.
+func (ur *trainingRepository) SaveFile(ctx context.Context, botId string, projectId string, key string, file *multipart.FileHeader, dataKey []byte) (string, error) {
+	defer metrics.ObserveStorage(metrics.BackendFilesystem, "save", time.Now())
+	filePath, err := resolveFile(botId, projectId, key)
+	if err != nil {
+		return "", err
+	}
+	newFile, err := os.OpenFile(filePath, os.O_CREATE|os.O_WRONLY|os.O_EXCL, 0600)
+	if err != nil {
+		return "", err
+	}
+	checksum, err := writeStored(ctx, newFile, file, dataKey)
+	closeErr := newFile.Close()
+	if err == nil {
+		err = closeErr
+	}
+	if err != nil {
+		_ = os.Remove(filePath)
+		return "", err
+	}
+	return checksum, nil
+}
+
+func writeStored(ctx context.Context, newFile io.Writer, file *multipart.FileHeader, dataKey []byte) (string, error) {
+	reader, err := file.Open()
+	if err != nil {
+		return "", err
+	}
+	defer reader.Close()
+	source := contextReader{ctx: ctx, reader: reader}
+	checksum := sha256.New()
+	if dataKey == nil {
+		_, err = io.Copy(io.MultiWriter(newFile, checksum), source)
+		return hex.EncodeToString(checksum.Sum(nil)), err
+	}
+	writer, err := encryption.NewEncryptWriter(newFile, dataKey)
+	if err != nil {
+		return "", err
+	}
+	_, err = io.Copy(io.MultiWriter(writer, checksum), source)
+	if err != nil {
+		return "", err
+	}
+	return hex.EncodeToString(checksum.Sum(nil)), writer.Close()
+}
+
+// contextReader fails the copy it feeds once ctx is done, so that a request
+// aborted during shutdown stops writing instead of finishing a large file.
+type contextReader struct {
+	ctx    context.Context
+	reader io.Reader
+}
+
+func (r contextReader) Read(p []byte) (int, error) {
+	if err := r.ctx.Err(); err != nil {
+		return 0, err
+	}
+	return r.reader.Read(p)
+}
+
+// OpenFile opens a stored file of the bot space for reading, decrypting it
+// with the data key when it was stored encrypted. It returns the plaintext
+// size along with the content.
+func (ur *trainingRepository) OpenFile(ctx context.Context, botId string, projectId string, key string, dataKey []byte) (io.ReadSeekCloser, int64, error) {
+	defer metrics.ObserveStorage(metrics.BackendFilesystem, "open", time.Now())
+	filePath, err := resolveStoredFile(botId, projectId, key)
+	if err != nil {
+		return nil, 0, err
+	}
+	return openStored(filePath, key, dataKey)
+}
+
+func openStored(filePath string, key string, dataKey []byte) (io.ReadSeekCloser, int64, error) {
+	file, err := os.Open(filePath)
+	if err != nil {
+		return nil, 0, err
+	}
+	info, err := file.Stat()
+	if err != nil {
+		_ = file.Close()
+		return nil, 0, err
+	}
+	content, size, err := decodeStored(file, info.Size(), key, dataKey)
+	if err != nil {
+		_ = file.Close()
+		return nil, 0, err
+	}
+	return struct {
+		io.ReadSeeker
+		io.Closer
+	}{content, file}, size, nil
+}
+
+// decodeStored returns the plaintext of stored content, which is decrypted
+// with the data key when it was stored encrypted.
+func decodeStored(stored storedContent, size int64, key string, dataKey []byte) (io.ReadSeeker, int64, error) {
+	header := make([]byte, 16)
+	n, _ := stored.ReadAt(header, 0)
+	if !encryption.IsEncrypted(header[:n]) {
+		return stored, size, nil
+	}
+	if dataKey == nil {
+		return nil, 0, fmt.Errorf("file %s is encrypted but no data key is available", key)
+	}
+	reader, err := encryption.NewDecryptReader(stored, size, dataKey)
+	if err != nil {
+		return nil, 0, err
+	}
+	plaintextSize, _ := encryption.PlaintextSize(size)
+	return reader, plaintextSize, nil
+}
+
+type storedContent interface {
+	io.ReadSeeker
+	io.ReaderAt
+}
+
+// resolveRevision returns the path of a revision key. Revisions are kept
+// outside the bot space so that the training workers only see current files.
+func resolveRevision(botId string, projectId string, revisionKey string) (string, error) {
+	if !primitive.IsValidObjectID(botId) || !primitive.IsValidObjectID(projectId) {
+		return "", fmt.Errorf("invalid bot or project id")
+	}
+	revisionPath := path.Join(utils.BaseDir(), "revisions", projectId, botId)
+	err := os.MkdirAll(revisionPath, 0700)
+	if err != nil {
+		return "", err
+	}
+	return naming.ResolveInBotSpace(revisionPath, revisionKey)
+}
+
+func (ur *trainingRepository) ArchiveRevision(ctx context.Context, botId string, projectId string, key string, revisionKey string) error {
+	defer metrics.ObserveStorage(metrics.BackendFilesystem, "archive_revision", time.Now())
+	filePath, err := resolveStoredFile(botId, projectId, key)
+	if err != nil {
+		return err
+	}
+	revisionPath, err := resolveRevision(botId, projectId, revisionKey)
+	if err != nil {
+		return err
+	}
+	return os.Rename(filePath, revisionPath)
+}
+
+func (ur *trainingRepository) RestoreRevision(ctx context.Context, botId string, projectId string, revisionKey string, key string) error {
+	defer metrics.ObserveStorage(metrics.BackendFilesystem, "restore_revision", time.Now())
+	revisionPath, err := resolveRevision(botId, projectId, revisionKey)
+	if err != nil {
+		return err
+	}
+	filePath, err := resolveFile(botId, projectId, key)
+	if err != nil {
+		return err
+	}
+	return os.Rename(revisionPath, filePath)
+}
+
+// CopyRevision copies the stored bytes as they are, encrypted revisions stay
+// encrypted with the same data key.
+func (ur *trainingRepository) CopyRevision(ctx context.Context, botId string, projectId string, revisionKey string, key string) error {
+	defer metrics.ObserveStorage(metrics.BackendFilesystem, "copy_revision", time.Now())
+	revisionPath, err := resolveRevision(botId, projectId, revisionKey)
+	if err != nil {
+		return err
+	}
+	filePath, err := resolveFile(botId, projectId, key)
+	if err != nil {
+		return err
+	}
+	source, err := os.Open(revisionPath)
+	if err != nil {
+		return err
+	}
+	defer source.Close()
+	target, err := os.OpenFile(filePath, os.O_CREATE|os.O_WRONLY|os.O_EXCL, 0600)
+	if err != nil {
+		return err
+	}
+	_, err = io.Copy(target, source)
+	if err != nil {
+		_ = target.Close()
+		_ = os.Remove(filePath)
+		return err
+	}
+	return target.Close()
+}
+
+func (ur *trainingRepository) OpenRevision(ctx context.Context, botId string, projectId string, revisionKey string, dataKey []byte) (io.ReadSeekCloser, int64, error) {
+	defer metrics.ObserveStorage(metrics.BackendFilesystem, "open_revision", time.Now())
+	revisionPath, err := resolveRevision(botId, projectId, revisionKey)
+	if err != nil {
+		return nil, 0, err
+	}
+	return openStored(revisionPath, revisionKey, dataKey)
+}
+
+func (ur *trainingRepository) DeleteRevision(ctx context.Context, botId string, projectId string, revisionKey string) error {
+	defer metrics.ObserveStorage(metrics.BackendFilesystem, "delete_revision", time.Now())
+	revisionPath, err := resolveRevision(botId, projectId, revisionKey)
+	if err != nil {
+		return err
+	}
+	return os.Remove(revisionPath)
+}
+
+// QuarantineFile stores an infected upload outside the bot space so that it is
+// never picked up by the training workers.
+func (ur *trainingRepository) QuarantineFile(ctx context.Context, botId string, projectId string, key string, file *multipart.FileHeader) error {
+	defer metrics.ObserveStorage(metrics.BackendFilesystem, "quarantine", time.Now())
+	if !primitive.IsValidObjectID(botId) || !primitive.IsValidObjectID(projectId) {
+		return fmt.Errorf("invalid bot or project id")
+	}
+	quarantinePath := path.Join(utils.BaseDir(), "quarantine", projectId, botId)
+	err := os.MkdirAll(quarantinePath, 0700)
+	if err != nil {
+		return err
+	}
+	filePath, err := naming.ResolveInBotSpace(quarantinePath, key)
+	if err != nil {
+		return err
+	}
+	newFile, err := os.OpenFile(filePath, os.O_CREATE|os.O_WRONLY|os.O_EXCL, 0600)
+	if err != nil {
+		return err
+	}
+	defer newFile.Close()
+	reader, err := file.Open()
+	if err != nil {
+		return err
+	}
+	defer reader.Close()
+	_, err = io.Copy(newFile, reader)
+	return err
 }
 
 func (ur *trainingRepository) DeleteFile(ctx context.Context, botId string, projectId string, fileId primitive.ObjectID) error {
+	defer metrics.ObserveStorage(metrics.BackendFilesystem, "delete", time.Now())
 	bid, err := primitive.ObjectIDFromHex(botId)
 	if err != nil {
 		return err
@@ -129,26 +485,27 @@ func (ur *trainingRepository) DeleteFile(ctx context.Context, botId string, proj
 			return err
 		} else {
 			for _, j := range td.Files {
-				if slices.Contains(td.Files, j) {
-					botSpace, err := utils.CreateBotSpace(projectId, botId)
+				if j.FileId == fileId {
+					filePath, err := resolveStoredFile(botId, projectId, naming.StorageKey(fileId, j.Extension))
 					if err != nil {
 						return err
 					} else {
-						filePath := path.Join(botSpace, fileId.Hex()+j.Extension)
-						err = os.Remove(filePath)
-						if err != nil {
-							return err
-						} else {
-							return nil
-						}
+						return os.Remove(filePath)
 					}
-				} else {
-					return fmt.Errorf("file not exists")
 				}
 			}
 		}
-		return nil
+		return fmt.Errorf("file %s: %w", fileId.Hex(), os.ErrNotExist)
+	}
+}
+
+func (ur *trainingRepository) RemoveFile(ctx context.Context, botId string, projectId string, key string) error {
+	defer metrics.ObserveStorage(metrics.BackendFilesystem, "delete", time.Now())
+	filePath, err := resolveStoredFile(botId, projectId, key)
+	if err != nil {
+		return err
 	}
+	return os.Remove(filePath)
 }
 
 func (ur *trainingRepository) GetFile(ctx context.Context, botId string, projectId string, fileId primitive.ObjectID) (string, error) {
@@ -165,19 +522,16 @@ func (ur *trainingRepository) GetFile(ctx context.Context, botId string, project
 			return "", err
 		} else {
 			for _, j := range td.Files {
-				if slices.Contains(td.Files, j) {
-					botSpace, err := utils.CreateBotSpace(projectId, botId)
+				if j.FileId == fileId {
+					filePath, err := resolveStoredFile(botId, projectId, naming.StorageKey(fileId, j.Extension))
 					if err != nil {
 						return "", err
 					} else {
-						filePath := path.Join(botSpace, fileId.Hex()+j.Extension)
 						return filePath, nil
 					}
-				} else {
-					return "", fmt.Errorf("file not exists")
 				}
 			}
 		}
-		return "", nil
+		return "", fmt.Errorf("file %s: %w", fileId.Hex(), os.ErrNotExist)
 	}
 }
@@ -0,0 +1,172 @@
+package repository_test
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
+	"os"
+	"pulse/api"
+	"pulse/migrations"
+	"pulse/repository"
+	"pulse/repository/repotest"
+	"sync"
+	"testing"
+	"time"
+)
+
+// mongoURIEnv names the server the Mongo repositories are checked against,
+// the tests are skipped when it is not set.
+const mongoURIEnv = "PULSE_TEST_MONGO_URI"
+
+// mongoClient connects to the server named by mongoURIEnv.
+func mongoClient(t *testing.T) *mongo.Client {
+	t.Helper()
+	uri := os.Getenv(mongoURIEnv)
+	if uri == "" {
+		t.Skip(mongoURIEnv + " is not set")
+	}
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	client, err := mongo.Connect(ctx, options.Client().ApplyURI(uri))
+	cancel()
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() {
+		_ = client.Disconnect(context.Background())
+	})
+	return client
+}
+
+// migratedDatabase creates a database of its own for a test, dropped when it
+// ends.
+func migratedDatabase(t *testing.T, client *mongo.Client) *mongo.Database {
+	t.Helper()
+	db := client.Database("pulse_test_" + primitive.NewObjectID().Hex())
+	t.Cleanup(func() {
+		_ = db.Drop(context.Background())
+	})
+	ctx, cancel := context.WithTimeout(context.Background(), 30*time.Second)
+	defer cancel()
+	_, err := migrations.NewMigrator(db, migrations.All).Up(ctx, migrations.Latest)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return db
+}
+
+func TestMongoTrainingRepository(t *testing.T) {
+	client := mongoClient(t)
+	// files are stored below the user config folder
+	t.Setenv("XDG_CONFIG_HOME", t.TempDir())
+	repotest.Run(t, func(t *testing.T) repository.ITrainingRepository {
+		return repository.NewTrainingRepository(migratedDatabase(t, client))
+	})
+}
+
+func TestMongoDataKeysPerOwner(t *testing.T) {
+	repo := repository.NewDataKeyRepository(migratedDatabase(t, mongoClient(t)))
+	projectId := primitive.NewObjectID()
+	owners := []primitive.ObjectID{primitive.NewObjectID(), primitive.NewObjectID()}
+	for i, owner := range owners {
+		ctx := context.WithValue(context.Background(), "UserId", owner)
+		_, err := repo.InsertOne(ctx, &repository.DataKey{ProjectId: projectId, WrappedKey: []byte{byte(i)}, MasterKeyId: "a"})
+		if err != nil {
+			t.Fatalf("inserting the key of owner %d: %v", i, err)
+		}
+	}
+	for i, owner := range owners {
+		ctx := context.WithValue(context.Background(), "UserId", owner)
+		record, err := repo.FindByProjectId(ctx, projectId)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if record.Owner != owner || !bytes.Equal(record.WrappedKey, []byte{byte(i)}) {
+			t.Fatalf("owner %d found %+v", i, record)
+		}
+		_, err = repo.InsertOne(ctx, &repository.DataKey{ProjectId: projectId, MasterKeyId: "a"})
+		if !mongo.IsDuplicateKeyError(err) {
+			t.Fatalf("second key of owner %d: err = %v, want a duplicate key error", i, err)
+		}
+	}
+}
+
+func TestMongoUploadPoliciesPerOwner(t *testing.T) {
+	repo := repository.NewPolicyRepository(migratedDatabase(t, mongoClient(t)))
+	projectId := primitive.NewObjectID()
+	owners := []primitive.ObjectID{primitive.NewObjectID(), primitive.NewObjectID()}
+	for i, owner := range owners {
+		ctx := context.WithValue(context.Background(), "UserId", owner)
+		// the second upsert of an owner replaces its policy
+		for _, size := range []int64{1, int64(i + 10)} {
+			_, err := repo.Upsert(ctx, &repository.UploadPolicy{ProjectId: projectId, MaxFileSize: size})
+			if err != nil {
+				t.Fatalf("storing the policy of owner %d: %v", i, err)
+			}
+		}
+	}
+	for i, owner := range owners {
+		ctx := context.WithValue(context.Background(), "UserId", owner)
+		policy, err := repo.FindByProjectId(ctx, projectId)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if policy.Owner != owner || policy.ProjectId != projectId || policy.MaxFileSize != int64(i+10) {
+			t.Fatalf("owner %d found %+v", i, policy)
+		}
+	}
+}
+
+func TestMongoUsageWithinLimits(t *testing.T) {
+	repo := repository.NewUsageRepository(migratedDatabase(t, mongoClient(t)))
+	ctx := context.WithValue(context.Background(), "UserId", primitive.NewObjectID())
+	projectId := primitive.NewObjectID()
+	bots := []primitive.ObjectID{primitive.NewObjectID(), primitive.NewObjectID()}
+	project := api.QuotaLimits{MaxBytes: 100}
+	bot := api.QuotaLimits{MaxFiles: 4}
+	var wg sync.WaitGroup
+	var mu sync.Mutex
+	counted := 0
+	for i := 0; i < 20; i++ {
+		wg.Add(1)
+		go func(botId primitive.ObjectID) {
+			defer wg.Done()
+			_, _, err := repo.IncrementWithin(ctx, projectId, botId, 10, 1, project, bot)
+			if err == nil {
+				mu.Lock()
+				counted++
+				mu.Unlock()
+			} else if !errors.Is(err, repository.ErrLimitReached) {
+				t.Error(err)
+			}
+		}(bots[i%len(bots)])
+	}
+	wg.Wait()
+	// the bots reach their file limit before the project its byte limit
+	if counted != 8 {
+		t.Fatalf("counted %d uploads, want 8", counted)
+	}
+	usages, err := repo.FindByProjectId(ctx, projectId)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, usage := range usages {
+		if usage.Files != 4 || usage.Bytes != 40 {
+			t.Fatalf("bot usage %+v, want 4 files of 40 bytes", usage)
+		}
+	}
+	// the project limit holds too
+	_, _, err = repo.IncrementWithin(ctx, projectId, primitive.NewObjectID(), 30, 1, project, bot)
+	if !errors.Is(err, repository.ErrLimitReached) {
+		t.Fatalf("err = %v, want ErrLimitReached", err)
+	}
+	projectUsage, botUsage, err := repo.IncrementWithin(ctx, projectId, primitive.NewObjectID(), 20, 1, project, bot)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if projectUsage.Bytes != 100 || projectUsage.Files != 9 || botUsage.Bytes != 20 || botUsage.Files != 1 {
+		t.Fatalf("counters project %+v, bot %+v", projectUsage, botUsage)
+	}
+}
@@ -0,0 +1,420 @@
+// Package repotest checks that implementations of the repository interfaces
+// behave alike. Each implementation runs the same suite from a test of its
+// own, the Mongo one against a test database:
+//
+//	func TestMemoryTrainingRepository(t *testing.T) {
+//		repotest.Run(t, func(t *testing.T) repository.ITrainingRepository {
+//			return repository.NewMemoryTrainingRepository()
+//		})
+//	}
+package repotest
+
+import (
+	"bytes"
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"github.com/draco121/horizon/models"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"go.mongodb.org/mongo-driver/mongo"
+	"io"
+	"mime/multipart"
+	"os"
+	"pulse/encryption"
+	"pulse/naming"
+	"pulse/repository"
+	"testing"
+)
+
+// WithOwner returns a context scoped to an owner the way the auth middleware
+// scopes requests.
+func WithOwner(ctx context.Context, ownerId primitive.ObjectID) context.Context {
+	return context.WithValue(ctx, "UserId", ownerId)
+}
+
+// FileHeader builds the header of an uploaded file holding content, as gin
+// hands it to the controllers.
+func FileHeader(t testing.TB, name string, content []byte) *multipart.FileHeader {
+	t.Helper()
+	var body bytes.Buffer
+	writer := multipart.NewWriter(&body)
+	part, err := writer.CreateFormFile("files", name)
+	if err != nil {
+		t.Fatal(err)
+	}
+	_, err = part.Write(content)
+	if err != nil {
+		t.Fatal(err)
+	}
+	err = writer.Close()
+	if err != nil {
+		t.Fatal(err)
+	}
+	form, err := multipart.NewReader(&body, writer.Boundary()).ReadForm(int64(len(content)) + 1024)
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() {
+		_ = form.RemoveAll()
+	})
+	return form.File["files"][0]
+}
+
+// Run runs the conformance suite of ITrainingRepository.
+// newRepository is called once per subtest and must return an empty
+// repository.
+func Run(t *testing.T, newRepository func(t *testing.T) repository.ITrainingRepository) {
+	tests := []struct {
+		name string
+		run  func(t *testing.T, repo repository.ITrainingRepository)
+	}{
+		{"InsertAndFind", testInsertAndFind},
+		{"OwnerScoping", testOwnerScoping},
+		{"Versions", testVersions},
+		{"PatchOne", testPatchOne},
+		{"DeleteOneByBotId", testDeleteOneByBotId},
+		{"Files", testFiles},
+		{"EncryptedFiles", testEncryptedFiles},
+		{"FileKeys", testFileKeys},
+		{"CancelledSave", testCancelledSave},
+		{"Revisions", testRevisions},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			test.run(t, newRepository(t))
+		})
+	}
+}
+
+func newTrainingData() *models.TrainingData {
+	return &models.TrainingData{
+		BotId:       primitive.NewObjectID(),
+		ProjectId:   primitive.NewObjectID(),
+		Description: "support bot",
+		QA:          []models.FAQS{{Question: "hours?", Answer: "9 to 5"}},
+	}
+}
+
+func insert(t *testing.T, ctx context.Context, repo repository.ITrainingRepository) *repository.VersionedTrainingData {
+	t.Helper()
+	inserted, err := repo.InsertOne(ctx, newTrainingData())
+	if err != nil {
+		t.Fatalf("InsertOne: %v", err)
+	}
+	return inserted
+}
+
+func expectError(t *testing.T, operation string, err error, target error) {
+	t.Helper()
+	if !errors.Is(err, target) {
+		t.Fatalf("%s: got error %v, want %v", operation, err, target)
+	}
+}
+
+func testInsertAndFind(t *testing.T, repo repository.ITrainingRepository) {
+	ownerId := primitive.NewObjectID()
+	ctx := WithOwner(context.Background(), ownerId)
+	inserted := insert(t, ctx, repo)
+	if inserted.ID.IsZero() || inserted.Owner != ownerId || inserted.Version != 1 {
+		t.Fatalf("InsertOne returned id %s, owner %s and version %d", inserted.ID.Hex(), inserted.Owner.Hex(), inserted.Version)
+	}
+	found, err := repo.FindOneByBotId(ctx, inserted.BotId, inserted.ProjectId)
+	if err != nil {
+		t.Fatalf("FindOneByBotId: %v", err)
+	}
+	if found.ID != inserted.ID || found.Description != "support bot" || len(found.QA) != 1 || found.Version != 1 {
+		t.Fatalf("FindOneByBotId returned %+v, want %+v", found, inserted)
+	}
+	duplicate := newTrainingData()
+	duplicate.BotId, duplicate.ProjectId = inserted.BotId, inserted.ProjectId
+	_, err = repo.InsertOne(ctx, duplicate)
+	expectError(t, "InsertOne of an existing bot", err, repository.ErrRecordExists)
+	_, err = repo.FindOneByBotId(ctx, inserted.BotId, primitive.NewObjectID())
+	expectError(t, "FindOneByBotId in another project", err, mongo.ErrNoDocuments)
+}
+
+func testOwnerScoping(t *testing.T, repo repository.ITrainingRepository) {
+	ctx := WithOwner(context.Background(), primitive.NewObjectID())
+	other := WithOwner(context.Background(), primitive.NewObjectID())
+	inserted := insert(t, ctx, repo)
+	_, err := repo.FindOneByBotId(other, inserted.BotId, inserted.ProjectId)
+	expectError(t, "FindOneByBotId of another owner", err, mongo.ErrNoDocuments)
+	_, err = repo.UpdateOne(other, &inserted.TrainingData, inserted.Version)
+	expectError(t, "UpdateOne of another owner", err, mongo.ErrNoDocuments)
+	_, err = repo.DeleteOneByBotId(other, inserted.BotId, inserted.ProjectId, repository.AnyVersion)
+	expectError(t, "DeleteOneByBotId of another owner", err, mongo.ErrNoDocuments)
+
+	// another owner may use the same bot and project ids
+	theirs := newTrainingData()
+	theirs.BotId, theirs.ProjectId = inserted.BotId, inserted.ProjectId
+	_, err = repo.InsertOne(other, theirs)
+	if err != nil {
+		t.Fatalf("InsertOne of another owner: %v", err)
+	}
+	found, err := repo.FindOneByBotId(ctx, inserted.BotId, inserted.ProjectId)
+	if err != nil || found.ID != inserted.ID {
+		t.Fatalf("FindOneByBotId returned %v, %v after another owner inserted the same bot", found, err)
+	}
+}
+
+func testVersions(t *testing.T, repo repository.ITrainingRepository) {
+	ctx := WithOwner(context.Background(), primitive.NewObjectID())
+	inserted := insert(t, ctx, repo)
+	inserted.Greeting = "hello"
+	updated, err := repo.UpdateOne(ctx, &inserted.TrainingData, 1)
+	if err != nil {
+		t.Fatalf("UpdateOne: %v", err)
+	}
+	if updated.Version != 2 || updated.Greeting != "hello" {
+		t.Fatalf("UpdateOne returned version %d and greeting %q", updated.Version, updated.Greeting)
+	}
+	_, err = repo.UpdateOne(ctx, &inserted.TrainingData, 1)
+	expectError(t, "UpdateOne of a stale version", err, repository.ErrVersionMismatch)
+	updated, err = repo.UpdateOne(ctx, &inserted.TrainingData, repository.AnyVersion)
+	if err != nil || updated.Version != 3 {
+		t.Fatalf("UpdateOne of any version returned %v, %v", updated, err)
+	}
+	missing := newTrainingData()
+	missing.ID = primitive.NewObjectID()
+	_, err = repo.UpdateOne(ctx, missing, 1)
+	expectError(t, "UpdateOne of a missing document", err, mongo.ErrNoDocuments)
+}
+
+func testPatchOne(t *testing.T, repo repository.ITrainingRepository) {
+	ctx := WithOwner(context.Background(), primitive.NewObjectID())
+	inserted := insert(t, ctx, repo)
+	patched, err := repo.PatchOne(ctx, inserted.BotId, inserted.ProjectId, 1, func(trainingData *models.TrainingData) error {
+		trainingData.Persona = "friendly"
+		return nil
+	})
+	if err != nil || patched.Persona != "friendly" || patched.Version != 2 {
+		t.Fatalf("PatchOne returned %v, %v", patched, err)
+	}
+	_, err = repo.PatchOne(ctx, inserted.BotId, inserted.ProjectId, 1, func(trainingData *models.TrainingData) error {
+		return nil
+	})
+	expectError(t, "PatchOne of a stale version", err, repository.ErrVersionMismatch)
+	refused := errors.New("refused")
+	_, err = repo.PatchOne(ctx, inserted.BotId, inserted.ProjectId, repository.AnyVersion, func(trainingData *models.TrainingData) error {
+		trainingData.Persona = "grumpy"
+		return refused
+	})
+	expectError(t, "PatchOne failing to mutate", err, refused)
+	found, err := repo.FindOneByBotId(ctx, inserted.BotId, inserted.ProjectId)
+	if err != nil || found.Persona != "friendly" {
+		t.Fatalf("a failed patch was written: %v, %v", found, err)
+	}
+	_, err = repo.PatchOne(ctx, primitive.NewObjectID(), inserted.ProjectId, repository.AnyVersion, func(trainingData *models.TrainingData) error {
+		return nil
+	})
+	expectError(t, "PatchOne of a missing document", err, mongo.ErrNoDocuments)
+}
+
+func testDeleteOneByBotId(t *testing.T, repo repository.ITrainingRepository) {
+	ctx := WithOwner(context.Background(), primitive.NewObjectID())
+	inserted := insert(t, ctx, repo)
+	_, err := repo.DeleteOneByBotId(ctx, inserted.BotId, inserted.ProjectId, 2)
+	expectError(t, "DeleteOneByBotId of a stale version", err, repository.ErrVersionMismatch)
+	deleted, err := repo.DeleteOneByBotId(ctx, inserted.BotId, inserted.ProjectId, 1)
+	if err != nil || deleted.ID != inserted.ID {
+		t.Fatalf("DeleteOneByBotId returned %v, %v", deleted, err)
+	}
+	_, err = repo.FindOneByBotId(ctx, inserted.BotId, inserted.ProjectId)
+	expectError(t, "FindOneByBotId of a deleted document", err, mongo.ErrNoDocuments)
+	_, err = repo.DeleteOneByBotId(ctx, inserted.BotId, inserted.ProjectId, repository.AnyVersion)
+	expectError(t, "DeleteOneByBotId of a deleted document", err, mongo.ErrNoDocuments)
+}
+
+func checksum(content []byte) string {
+	sum := sha256.Sum256(content)
+	return hex.EncodeToString(sum[:])
+}
+
+func readAll(t *testing.T, content io.ReadSeekCloser) []byte {
+	t.Helper()
+	defer content.Close()
+	data, err := io.ReadAll(content)
+	if err != nil {
+		t.Fatalf("reading stored content: %v", err)
+	}
+	return data
+}
+
+func testFiles(t *testing.T, repo repository.ITrainingRepository) {
+	ctx := WithOwner(context.Background(), primitive.NewObjectID())
+	inserted := insert(t, ctx, repo)
+	botId, projectId := inserted.BotId.Hex(), inserted.ProjectId.Hex()
+	fileId := primitive.NewObjectID()
+	key := naming.StorageKey(fileId, ".txt")
+	content := []byte("opening hours are 9 to 5")
+
+	sum, err := repo.SaveFile(ctx, botId, projectId, key, FileHeader(t, "hours.txt", content), nil)
+	if err != nil {
+		t.Fatalf("SaveFile: %v", err)
+	}
+	if sum != checksum(content) {
+		t.Fatalf("SaveFile returned checksum %s, want %s", sum, checksum(content))
+	}
+	_, err = repo.SaveFile(ctx, botId, projectId, key, FileHeader(t, "hours.txt", content), nil)
+	expectError(t, "SaveFile over a stored file", err, os.ErrExist)
+
+	stored, size, err := repo.OpenFile(ctx, botId, projectId, key, nil)
+	if err != nil {
+		t.Fatalf("OpenFile: %v", err)
+	}
+	if size != int64(len(content)) {
+		t.Fatalf("OpenFile returned size %d, want %d", size, len(content))
+	}
+	_, err = stored.Seek(8, io.SeekStart)
+	if err != nil {
+		t.Fatalf("Seek: %v", err)
+	}
+	if data := readAll(t, stored); !bytes.Equal(data, content[8:]) {
+		t.Fatalf("read %q after seeking, want %q", data, content[8:])
+	}
+
+	err = repo.DeleteFile(ctx, botId, projectId, fileId)
+	expectError(t, "DeleteFile of a file missing from the training data", err, os.ErrNotExist)
+	_, err = repo.PatchOne(ctx, inserted.BotId, inserted.ProjectId, repository.AnyVersion, func(trainingData *models.TrainingData) error {
+		trainingData.Files = append(trainingData.Files, models.Files{FileName: "hours.txt", FileId: fileId, Extension: ".txt"})
+		return nil
+	})
+	if err != nil {
+		t.Fatalf("PatchOne: %v", err)
+	}
+	_, err = repo.GetFile(ctx, botId, projectId, fileId)
+	if err != nil {
+		t.Fatalf("GetFile: %v", err)
+	}
+	err = repo.DeleteFile(ctx, botId, projectId, fileId)
+	if err != nil {
+		t.Fatalf("DeleteFile: %v", err)
+	}
+	_, _, err = repo.OpenFile(ctx, botId, projectId, key, nil)
+	expectError(t, "OpenFile of a deleted file", err, os.ErrNotExist)
+	err = repo.RemoveFile(ctx, botId, projectId, key)
+	expectError(t, "RemoveFile of a deleted file", err, os.ErrNotExist)
+}
+
+func testEncryptedFiles(t *testing.T, repo repository.ITrainingRepository) {
+	ctx := WithOwner(context.Background(), primitive.NewObjectID())
+	botId, projectId := primitive.NewObjectID().Hex(), primitive.NewObjectID().Hex()
+	key := naming.StorageKey(primitive.NewObjectID(), ".txt")
+	content := bytes.Repeat([]byte("secret "), 20000)
+	dataKey, err := encryption.NewDataKey()
+	if err != nil {
+		t.Fatal(err)
+	}
+	sum, err := repo.SaveFile(ctx, botId, projectId, key, FileHeader(t, "secret.txt", content), dataKey)
+	if err != nil {
+		t.Fatalf("SaveFile: %v", err)
+	}
+	if sum != checksum(content) {
+		t.Fatalf("SaveFile returned the checksum %s instead of the one of the plaintext", sum)
+	}
+	_, _, err = repo.OpenFile(ctx, botId, projectId, key, nil)
+	if err == nil {
+		t.Fatal("OpenFile of an encrypted file succeeded without a data key")
+	}
+	stored, size, err := repo.OpenFile(ctx, botId, projectId, key, dataKey)
+	if err != nil {
+		t.Fatalf("OpenFile: %v", err)
+	}
+	if size != int64(len(content)) {
+		t.Fatalf("OpenFile returned size %d, want the plaintext size %d", size, len(content))
+	}
+	if data := readAll(t, stored); !bytes.Equal(data, content) {
+		t.Fatal("OpenFile returned content that differs from the saved one")
+	}
+	err = repo.RemoveFile(ctx, botId, projectId, key)
+	if err != nil {
+		t.Fatalf("RemoveFile: %v", err)
+	}
+}
+
+func testFileKeys(t *testing.T, repo repository.ITrainingRepository) {
+	ctx := WithOwner(context.Background(), primitive.NewObjectID())
+	botId, projectId := primitive.NewObjectID().Hex(), primitive.NewObjectID().Hex()
+	for _, key := range []string{"", ".", "..", "../escape.txt", "a/b.txt", `a\b.txt`} {
+		_, err := repo.SaveFile(ctx, botId, projectId, key, FileHeader(t, "file.txt", []byte("x")), nil)
+		expectError(t, "SaveFile under key "+key, err, naming.ErrInvalidFileName)
+	}
+	_, err := repo.SaveFile(ctx, "not-an-id", projectId, "file.txt", FileHeader(t, "file.txt", []byte("x")), nil)
+	if err == nil {
+		t.Fatal("SaveFile accepted an invalid bot id")
+	}
+}
+
+func testCancelledSave(t *testing.T, repo repository.ITrainingRepository) {
+	ctx, cancel := context.WithCancel(WithOwner(context.Background(), primitive.NewObjectID()))
+	botId, projectId := primitive.NewObjectID().Hex(), primitive.NewObjectID().Hex()
+	key := naming.StorageKey(primitive.NewObjectID(), ".txt")
+	cancel()
+	_, err := repo.SaveFile(ctx, botId, projectId, key, FileHeader(t, "file.txt", []byte("content")), nil)
+	expectError(t, "SaveFile with a cancelled context", err, context.Canceled)
+	_, _, err = repo.OpenFile(context.WithoutCancel(ctx), botId, projectId, key, nil)
+	expectError(t, "OpenFile after a cancelled save", err, os.ErrNotExist)
+}
+
+func testRevisions(t *testing.T, repo repository.ITrainingRepository) {
+	ctx := WithOwner(context.Background(), primitive.NewObjectID())
+	botId, projectId := primitive.NewObjectID().Hex(), primitive.NewObjectID().Hex()
+	fileId := primitive.NewObjectID()
+	key := naming.StorageKey(fileId, ".txt")
+	revisionKey := naming.RevisionKey(fileId, 1, ".txt")
+	content := []byte("first revision")
+	_, err := repo.SaveFile(ctx, botId, projectId, key, FileHeader(t, "file.txt", content), nil)
+	if err != nil {
+		t.Fatalf("SaveFile: %v", err)
+	}
+
+	err = repo.ArchiveRevision(ctx, botId, projectId, key, revisionKey)
+	if err != nil {
+		t.Fatalf("ArchiveRevision: %v", err)
+	}
+	_, _, err = repo.OpenFile(ctx, botId, projectId, key, nil)
+	expectError(t, "OpenFile of an archived file", err, os.ErrNotExist)
+	revision, _, err := repo.OpenRevision(ctx, botId, projectId, revisionKey, nil)
+	if err != nil {
+		t.Fatalf("OpenRevision: %v", err)
+	}
+	if data := readAll(t, revision); !bytes.Equal(data, content) {
+		t.Fatalf("OpenRevision read %q, want %q", data, content)
+	}
+
+	err = repo.CopyRevision(ctx, botId, projectId, revisionKey, key)
+	if err != nil {
+		t.Fatalf("CopyRevision: %v", err)
+	}
+	err = repo.CopyRevision(ctx, botId, projectId, revisionKey, key)
+	expectError(t, "CopyRevision over a stored file", err, os.ErrExist)
+	stored, _, err := repo.OpenFile(ctx, botId, projectId, key, nil)
+	if err != nil {
+		t.Fatalf("OpenFile of a copied revision: %v", err)
+	}
+	if data := readAll(t, stored); !bytes.Equal(data, content) {
+		t.Fatalf("OpenFile of a copied revision read %q, want %q", data, content)
+	}
+	err = repo.RemoveFile(ctx, botId, projectId, key)
+	if err != nil {
+		t.Fatalf("RemoveFile: %v", err)
+	}
+
+	err = repo.RestoreRevision(ctx, botId, projectId, revisionKey, key)
+	if err != nil {
+		t.Fatalf("RestoreRevision: %v", err)
+	}
+	_, _, err = repo.OpenRevision(ctx, botId, projectId, revisionKey, nil)
+	expectError(t, "OpenRevision of a restored revision", err, os.ErrNotExist)
+	err = repo.ArchiveRevision(ctx, botId, projectId, key, revisionKey)
+	if err != nil {
+		t.Fatalf("ArchiveRevision: %v", err)
+	}
+	err = repo.DeleteRevision(ctx, botId, projectId, revisionKey)
+	if err != nil {
+		t.Fatalf("DeleteRevision: %v", err)
+	}
+	err = repo.DeleteRevision(ctx, botId, projectId, revisionKey)
+	expectError(t, "DeleteRevision of a deleted revision", err, os.ErrNotExist)
+}
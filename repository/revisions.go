@@ -0,0 +1,82 @@
+package repository
+
+import (
+	"context"
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
+	"pulse/api"
+)
+
+type FileRevision = api.FileRevision
+
+type IRevisionRepository interface {
+	InsertOne(ctx context.Context, revision *FileRevision) (*FileRevision, error)
+	FindOne(ctx context.Context, fileId primitive.ObjectID, revision int64) (*FileRevision, error)
+	// FindByFileId lists the revisions of a file, newest first.
+	FindByFileId(ctx context.Context, fileId primitive.ObjectID) ([]FileRevision, error)
+	DeleteByFileId(ctx context.Context, fileId primitive.ObjectID) ([]FileRevision, error)
+}
+
+type revisionRepository struct {
+	IRevisionRepository
+	db *mongo.Database
+}
+
+func NewRevisionRepository(db *mongo.Database) IRevisionRepository {
+	return &revisionRepository{
+		db: db,
+	}
+}
+
+func (rr *revisionRepository) InsertOne(ctx context.Context, revision *FileRevision) (*FileRevision, error) {
+	revision.Owner = ctx.Value("UserId").(primitive.ObjectID)
+	revision.Id = primitive.NewObjectID()
+	_, err := rr.db.Collection("file-revisions").InsertOne(ctx, revision)
+	if err != nil {
+		return nil, err
+	}
+	return revision, nil
+}
+
+func (rr *revisionRepository) FindOne(ctx context.Context, fileId primitive.ObjectID, revision int64) (*FileRevision, error) {
+	ownerId := ctx.Value("UserId").(primitive.ObjectID)
+	filter := bson.D{{Key: "fileId", Value: fileId}, {Key: "owner", Value: ownerId}, {Key: "revision", Value: revision}}
+	result := FileRevision{}
+	err := rr.db.Collection("file-revisions").FindOne(ctx, filter).Decode(&result)
+	if err != nil {
+		return nil, err
+	}
+	return &result, nil
+}
+
+func (rr *revisionRepository) FindByFileId(ctx context.Context, fileId primitive.ObjectID) ([]FileRevision, error) {
+	ownerId := ctx.Value("UserId").(primitive.ObjectID)
+	filter := bson.D{{Key: "fileId", Value: fileId}, {Key: "owner", Value: ownerId}}
+	opts := options.Find().SetSort(bson.D{{Key: "revision", Value: -1}})
+	cursor, err := rr.db.Collection("file-revisions").Find(ctx, filter, opts)
+	if err != nil {
+		return nil, err
+	}
+	result := make([]FileRevision, 0)
+	err = cursor.All(ctx, &result)
+	if err != nil {
+		return nil, err
+	}
+	return result, nil
+}
+
+func (rr *revisionRepository) DeleteByFileId(ctx context.Context, fileId primitive.ObjectID) ([]FileRevision, error) {
+	revisions, err := rr.FindByFileId(ctx, fileId)
+	if err != nil {
+		return nil, err
+	}
+	ownerId := ctx.Value("UserId").(primitive.ObjectID)
+	filter := bson.D{{Key: "fileId", Value: fileId}, {Key: "owner", Value: ownerId}}
+	_, err = rr.db.Collection("file-revisions").DeleteMany(ctx, filter)
+	if err != nil {
+		return nil, err
+	}
+	return revisions, nil
+}
@@ -0,0 +1,36 @@
+package repository
+
+import (
+	"errors"
+	"github.com/draco121/horizon/utils"
+	"os"
+)
+
+// ErrFreeSpaceUnsupported is returned by StorageFreeBytes on platforms that do
+// not report free disk space.
+var ErrFreeSpaceUnsupported = errors.New("free disk space is not reported on this platform")
+
+// ProbeStorage checks that the storage directory accepts writes by creating,
+// syncing and removing a file in it.
+func ProbeStorage() error {
+	probe, err := os.CreateTemp(utils.BaseDir(), ".probe-*")
+	if err != nil {
+		return err
+	}
+	defer os.Remove(probe.Name())
+	_, err = probe.Write([]byte("ok"))
+	if err == nil {
+		err = probe.Sync()
+	}
+	closeErr := probe.Close()
+	if err != nil {
+		return err
+	}
+	return closeErr
+}
+
+// StorageFreeBytes returns the disk space left to unprivileged users in the
+// storage directory.
+func StorageFreeBytes() (uint64, error) {
+	return freeBytes(utils.BaseDir())
+}
@@ -0,0 +1,7 @@
+//go:build !unix
+
+package repository
+
+func freeBytes(dir string) (uint64, error) {
+	return 0, ErrFreeSpaceUnsupported
+}
@@ -0,0 +1,135 @@
+package repository
+
+import (
+	"context"
+	"github.com/draco121/horizon/models"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"io"
+	"mime/multipart"
+	"pulse/tracing"
+)
+
+// tracedTrainingRepository wraps every call to the training repository in a
+// span, which separates the time spent in MongoDB from the time spent on
+// disk.
+type tracedTrainingRepository struct {
+	ITrainingRepository
+}
+
+func NewTracedTrainingRepository(repo ITrainingRepository) ITrainingRepository {
+	return &tracedTrainingRepository{
+		ITrainingRepository: repo,
+	}
+}
+
+func (ur *tracedTrainingRepository) FindOneByBotId(ctx context.Context, botId primitive.ObjectID, projectId primitive.ObjectID) (*VersionedTrainingData, error) {
+	ctx, span := tracing.Start(ctx, "TrainingRepository.FindOneByBotId", tracing.BotId(botId.Hex()), tracing.ProjectId(projectId.Hex()))
+	result, err := ur.ITrainingRepository.FindOneByBotId(ctx, botId, projectId)
+	tracing.End(span, err)
+	return result, err
+}
+
+func (ur *tracedTrainingRepository) UpdateOne(ctx context.Context, trainingData *models.TrainingData, version int64) (*VersionedTrainingData, error) {
+	ctx, span := tracing.Start(ctx, "TrainingRepository.UpdateOne", tracing.BotId(trainingData.BotId.Hex()), tracing.ProjectId(trainingData.ProjectId.Hex()))
+	result, err := ur.ITrainingRepository.UpdateOne(ctx, trainingData, version)
+	tracing.End(span, err)
+	return result, err
+}
+
+func (ur *tracedTrainingRepository) PatchOne(ctx context.Context, botId primitive.ObjectID, projectId primitive.ObjectID, version int64, mutate func(trainingData *models.TrainingData) error) (*VersionedTrainingData, error) {
+	ctx, span := tracing.Start(ctx, "TrainingRepository.PatchOne", tracing.BotId(botId.Hex()), tracing.ProjectId(projectId.Hex()))
+	result, err := ur.ITrainingRepository.PatchOne(ctx, botId, projectId, version, mutate)
+	tracing.End(span, err)
+	return result, err
+}
+
+func (ur *tracedTrainingRepository) DeleteOneByBotId(ctx context.Context, botId primitive.ObjectID, projectId primitive.ObjectID, version int64) (*VersionedTrainingData, error) {
+	ctx, span := tracing.Start(ctx, "TrainingRepository.DeleteOneByBotId", tracing.BotId(botId.Hex()), tracing.ProjectId(projectId.Hex()))
+	result, err := ur.ITrainingRepository.DeleteOneByBotId(ctx, botId, projectId, version)
+	tracing.End(span, err)
+	return result, err
+}
+
+func (ur *tracedTrainingRepository) InsertOne(ctx context.Context, trainingData *models.TrainingData) (*VersionedTrainingData, error) {
+	ctx, span := tracing.Start(ctx, "TrainingRepository.InsertOne", tracing.BotId(trainingData.BotId.Hex()), tracing.ProjectId(trainingData.ProjectId.Hex()))
+	result, err := ur.ITrainingRepository.InsertOne(ctx, trainingData)
+	tracing.End(span, err)
+	return result, err
+}
+
+func (ur *tracedTrainingRepository) SaveFile(ctx context.Context, botId string, projectId string, key string, file *multipart.FileHeader, dataKey []byte) (string, error) {
+	ctx, span := tracing.Start(ctx, "TrainingRepository.SaveFile", tracing.BotId(botId), tracing.ProjectId(projectId), tracing.StorageKey(key))
+	checksum, err := ur.ITrainingRepository.SaveFile(ctx, botId, projectId, key, file, dataKey)
+	tracing.End(span, err)
+	return checksum, err
+}
+
+func (ur *tracedTrainingRepository) OpenFile(ctx context.Context, botId string, projectId string, key string, dataKey []byte) (io.ReadSeekCloser, int64, error) {
+	ctx, span := tracing.Start(ctx, "TrainingRepository.OpenFile", tracing.BotId(botId), tracing.ProjectId(projectId), tracing.StorageKey(key))
+	content, size, err := ur.ITrainingRepository.OpenFile(ctx, botId, projectId, key, dataKey)
+	tracing.End(span, err)
+	return content, size, err
+}
+
+func (ur *tracedTrainingRepository) DeleteFile(ctx context.Context, botId string, projectId string, fileId primitive.ObjectID) error {
+	ctx, span := tracing.Start(ctx, "TrainingRepository.DeleteFile", tracing.BotId(botId), tracing.ProjectId(projectId), tracing.FileId(fileId.Hex()))
+	err := ur.ITrainingRepository.DeleteFile(ctx, botId, projectId, fileId)
+	tracing.End(span, err)
+	return err
+}
+
+func (ur *tracedTrainingRepository) RemoveFile(ctx context.Context, botId string, projectId string, key string) error {
+	ctx, span := tracing.Start(ctx, "TrainingRepository.RemoveFile", tracing.BotId(botId), tracing.ProjectId(projectId), tracing.StorageKey(key))
+	err := ur.ITrainingRepository.RemoveFile(ctx, botId, projectId, key)
+	tracing.End(span, err)
+	return err
+}
+
+func (ur *tracedTrainingRepository) GetFile(ctx context.Context, botId string, projectId string, fileId primitive.ObjectID) (string, error) {
+	ctx, span := tracing.Start(ctx, "TrainingRepository.GetFile", tracing.BotId(botId), tracing.ProjectId(projectId), tracing.FileId(fileId.Hex()))
+	filePath, err := ur.ITrainingRepository.GetFile(ctx, botId, projectId, fileId)
+	tracing.End(span, err)
+	return filePath, err
+}
+
+func (ur *tracedTrainingRepository) QuarantineFile(ctx context.Context, botId string, projectId string, key string, file *multipart.FileHeader) error {
+	ctx, span := tracing.Start(ctx, "TrainingRepository.QuarantineFile", tracing.BotId(botId), tracing.ProjectId(projectId), tracing.StorageKey(key))
+	err := ur.ITrainingRepository.QuarantineFile(ctx, botId, projectId, key, file)
+	tracing.End(span, err)
+	return err
+}
+
+func (ur *tracedTrainingRepository) ArchiveRevision(ctx context.Context, botId string, projectId string, key string, revisionKey string) error {
+	ctx, span := tracing.Start(ctx, "TrainingRepository.ArchiveRevision", tracing.BotId(botId), tracing.ProjectId(projectId), tracing.StorageKey(key))
+	err := ur.ITrainingRepository.ArchiveRevision(ctx, botId, projectId, key, revisionKey)
+	tracing.End(span, err)
+	return err
+}
+
+func (ur *tracedTrainingRepository) RestoreRevision(ctx context.Context, botId string, projectId string, revisionKey string, key string) error {
+	ctx, span := tracing.Start(ctx, "TrainingRepository.RestoreRevision", tracing.BotId(botId), tracing.ProjectId(projectId), tracing.StorageKey(key))
+	err := ur.ITrainingRepository.RestoreRevision(ctx, botId, projectId, revisionKey, key)
+	tracing.End(span, err)
+	return err
+}
+
+func (ur *tracedTrainingRepository) CopyRevision(ctx context.Context, botId string, projectId string, revisionKey string, key string) error {
+	ctx, span := tracing.Start(ctx, "TrainingRepository.CopyRevision", tracing.BotId(botId), tracing.ProjectId(projectId), tracing.StorageKey(key))
+	err := ur.ITrainingRepository.CopyRevision(ctx, botId, projectId, revisionKey, key)
+	tracing.End(span, err)
+	return err
+}
+
+func (ur *tracedTrainingRepository) OpenRevision(ctx context.Context, botId string, projectId string, revisionKey string, dataKey []byte) (io.ReadSeekCloser, int64, error) {
+	ctx, span := tracing.Start(ctx, "TrainingRepository.OpenRevision", tracing.BotId(botId), tracing.ProjectId(projectId), tracing.StorageKey(revisionKey))
+	content, size, err := ur.ITrainingRepository.OpenRevision(ctx, botId, projectId, revisionKey, dataKey)
+	tracing.End(span, err)
+	return content, size, err
+}
+
+func (ur *tracedTrainingRepository) DeleteRevision(ctx context.Context, botId string, projectId string, revisionKey string) error {
+	ctx, span := tracing.Start(ctx, "TrainingRepository.DeleteRevision", tracing.BotId(botId), tracing.ProjectId(projectId), tracing.StorageKey(revisionKey))
+	err := ur.ITrainingRepository.DeleteRevision(ctx, botId, projectId, revisionKey)
+	tracing.End(span, err)
+	return err
+}
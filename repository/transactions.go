@@ -0,0 +1,134 @@
+package repository
+
+import (
+	"context"
+	"errors"
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/x/mongo/driver"
+	"go.mongodb.org/mongo-driver/x/mongo/driver/session"
+	"pulse/metrics"
+	"sync"
+)
+
+// ITransactions starts the transactions the services run their writes in.
+type ITransactions interface {
+	// Begin starts a transaction. The repositories make the writes of the
+	// returned context part of it, they are discarded unless it is committed
+	// before it ends.
+	Begin(ctx context.Context) (context.Context, ITransaction, error)
+}
+
+type ITransaction interface {
+	Commit(ctx context.Context) error
+	// End discards the writes of the transaction when it was not committed.
+	End(ctx context.Context)
+}
+
+type mongoTransactions struct {
+	client *mongo.Client
+}
+
+func NewTransactions(client *mongo.Client) ITransactions {
+	return &mongoTransactions{client: client}
+}
+
+func (t *mongoTransactions) Begin(ctx context.Context) (context.Context, ITransaction, error) {
+	mongoSession, err := t.client.StartSession()
+	if err != nil {
+		return nil, nil, err
+	}
+	err = mongoSession.StartTransaction()
+	if err != nil {
+		mongoSession.EndSession(ctx)
+		return nil, nil, err
+	}
+	return mongo.NewSessionContext(ctx, mongoSession), &mongoTransaction{session: mongoSession}, nil
+}
+
+type mongoTransaction struct {
+	session mongo.Session
+}
+
+func (t *mongoTransaction) Commit(ctx context.Context) error {
+	return t.session.CommitTransaction(ctx)
+}
+
+// End ends the session, counting its transaction as aborted when it was not
+// committed. The driver only exposes the transaction state through XSession.
+func (t *mongoTransaction) End(ctx context.Context) {
+	if x, ok := t.session.(mongo.XSession); ok {
+		state := x.ClientSession().TransactionState
+		if state != session.None && state != session.Committed {
+			metrics.TransactionAborts.Inc()
+		}
+	}
+	t.session.EndSession(ctx)
+}
+
+// outsideTransaction returns ctx without the transaction it may carry, for
+// writes that must last even when that transaction is discarded.
+func outsideTransaction(ctx context.Context) context.Context {
+	ctx = context.WithValue(ctx, memoryTransactionKey{}, (*memoryTransaction)(nil))
+	return mongo.NewSessionContext(ctx, nil)
+}
+
+// IsTransient tells whether err ended a transaction that may succeed when
+// run again, as when it conflicted with a concurrent one.
+func IsTransient(err error) bool {
+	var labeled mongo.LabeledError
+	return errors.As(err, &labeled) && labeled.HasErrorLabel(driver.TransientTransactionError)
+}
+
+type memoryTransactions struct{}
+
+// NewMemoryTransactions starts transactions for the memory repositories. The
+// writes they make in one are undone when it ends without a commit, they are
+// seen by other callers before it does.
+func NewMemoryTransactions() ITransactions {
+	return memoryTransactions{}
+}
+
+type memoryTransactionKey struct{}
+
+func (memoryTransactions) Begin(ctx context.Context) (context.Context, ITransaction, error) {
+	tx := &memoryTransaction{}
+	return context.WithValue(ctx, memoryTransactionKey{}, tx), tx, nil
+}
+
+type memoryTransaction struct {
+	mu        sync.Mutex
+	undo      []func()
+	committed bool
+}
+
+func (t *memoryTransaction) Commit(ctx context.Context) error {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	t.committed = true
+	return nil
+}
+
+func (t *memoryTransaction) End(ctx context.Context) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	if t.committed {
+		return
+	}
+	metrics.TransactionAborts.Inc()
+	for i := len(t.undo) - 1; i >= 0; i-- {
+		t.undo[i]()
+	}
+	t.undo = nil
+}
+
+// onDiscard registers undo with the memory transaction of ctx, if any, to run
+// when it ends without a commit.
+func onDiscard(ctx context.Context, undo func()) {
+	tx, ok := ctx.Value(memoryTransactionKey{}).(*memoryTransaction)
+	if !ok || tx == nil {
+		return
+	}
+	tx.mu.Lock()
+	defer tx.mu.Unlock()
+	tx.undo = append(tx.undo, undo)
+}
@@ -0,0 +1,169 @@
+package repository
+
+import (
+	"context"
+	"errors"
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
+	"pulse/api"
+	"time"
+)
+
+const (
+	UsageEventUpload       = "upload"
+	UsageEventDelete       = "delete"
+	UsageEventSoftQuota    = "soft_quota_warning"
+	UsageEventHardQuota    = "hard_quota_rejected"
+	defaultUsageEventLimit = 100
+)
+
+// ErrLimitReached is returned by IncrementWithin when the increment would take
+// a counter over its limits.
+var ErrLimitReached = errors.New("usage limit reached")
+
+type Usage = api.Usage
+
+type UsageEvent = api.UsageEvent
+
+type IUsageRepository interface {
+	FindByProjectId(ctx context.Context, projectId primitive.ObjectID) ([]Usage, error)
+	Increment(ctx context.Context, projectId primitive.ObjectID, botId primitive.ObjectID, bytes int64, files int64) error
+	// IncrementWithin increments the counters of the project and of the bot
+	// only when neither goes over its limits, checked and written at once. It
+	// returns both counters as the increment left them.
+	IncrementWithin(ctx context.Context, projectId primitive.ObjectID, botId primitive.ObjectID, bytes int64, files int64, project api.QuotaLimits, bot api.QuotaLimits) (*Usage, *Usage, error)
+	InsertEvent(ctx context.Context, event *UsageEvent) error
+	FindEvents(ctx context.Context, projectId primitive.ObjectID, limit int64) ([]UsageEvent, error)
+}
+
+type usageRepository struct {
+	IUsageRepository
+	db *mongo.Database
+}
+
+func NewUsageRepository(db *mongo.Database) IUsageRepository {
+	return &usageRepository{
+		db: db,
+	}
+}
+
+func (ur *usageRepository) FindByProjectId(ctx context.Context, projectId primitive.ObjectID) ([]Usage, error) {
+	ownerId := ctx.Value("UserId").(primitive.ObjectID)
+	filter := bson.D{{Key: "projectId", Value: projectId}, {Key: "owner", Value: ownerId}}
+	cursor, err := ur.db.Collection("usage").Find(ctx, filter)
+	if err != nil {
+		return nil, err
+	}
+	result := make([]Usage, 0)
+	err = cursor.All(ctx, &result)
+	if err != nil {
+		return nil, err
+	}
+	return result, nil
+}
+
+func (ur *usageRepository) Increment(ctx context.Context, projectId primitive.ObjectID, botId primitive.ObjectID, bytes int64, files int64) error {
+	_, err := ur.increment(ctx, "project-usage", ur.projectFilter(ctx, projectId), bytes, files, api.QuotaLimits{})
+	if err != nil {
+		return err
+	}
+	_, err = ur.increment(ctx, "usage", ur.botFilter(ctx, projectId, botId), bytes, files, api.QuotaLimits{})
+	return err
+}
+
+func (ur *usageRepository) IncrementWithin(ctx context.Context, projectId primitive.ObjectID, botId primitive.ObjectID, bytes int64, files int64, project api.QuotaLimits, bot api.QuotaLimits) (*Usage, *Usage, error) {
+	projectFilter := ur.projectFilter(ctx, projectId)
+	projectUsage, err := ur.increment(ctx, "project-usage", projectFilter, bytes, files, project)
+	if err != nil {
+		return nil, nil, err
+	}
+	botUsage, err := ur.increment(ctx, "usage", ur.botFilter(ctx, projectId, botId), bytes, files, bot)
+	if err != nil {
+		// give back what the project counter took
+		_, undoErr := ur.increment(context.WithoutCancel(ctx), "project-usage", projectFilter, -bytes, -files, api.QuotaLimits{})
+		if undoErr != nil {
+			return nil, nil, errors.Join(err, undoErr)
+		}
+		return nil, nil, err
+	}
+	return projectUsage, botUsage, nil
+}
+
+// projectFilter matches the counter of a project, which sums the counters of
+// its bots.
+func (ur *usageRepository) projectFilter(ctx context.Context, projectId primitive.ObjectID) bson.D {
+	ownerId := ctx.Value("UserId").(primitive.ObjectID)
+	return bson.D{{Key: "projectId", Value: projectId}, {Key: "owner", Value: ownerId}}
+}
+
+func (ur *usageRepository) botFilter(ctx context.Context, projectId primitive.ObjectID, botId primitive.ObjectID) bson.D {
+	ownerId := ctx.Value("UserId").(primitive.ObjectID)
+	return bson.D{{Key: "projectId", Value: projectId}, {Key: "botId", Value: botId}, {Key: "owner", Value: ownerId}}
+}
+
+// increment adds bytes and files to the counter matched by filter and returns
+// the counter as it left it. Non zero limits are part of the filter, so that
+// a counter already too high for the increment is not matched. No write of it
+// fails, which would abort the transaction it may be part of.
+func (ur *usageRepository) increment(ctx context.Context, collection string, filter bson.D, bytes int64, files int64, limits api.QuotaLimits) (*Usage, error) {
+	if (limits.MaxBytes > 0 && bytes > limits.MaxBytes) || (limits.MaxFiles > 0 && files > limits.MaxFiles) {
+		return nil, ErrLimitReached
+	}
+	// creates the counter first, a counter that is not matched below is then
+	// one over its limits
+	create := bson.M{"$setOnInsert": bson.M{"bytes": int64(0), "files": int64(0)}}
+	_, err := ur.db.Collection(collection).UpdateOne(ctx, filter, create, options.Update().SetUpsert(true))
+	if err != nil {
+		return nil, err
+	}
+	filter = append(bson.D{}, filter...)
+	if limits.MaxBytes > 0 && bytes > 0 {
+		filter = append(filter, bson.E{Key: "bytes", Value: bson.M{"$lte": limits.MaxBytes - bytes}})
+	}
+	if limits.MaxFiles > 0 && files > 0 {
+		filter = append(filter, bson.E{Key: "files", Value: bson.M{"$lte": limits.MaxFiles - files}})
+	}
+	update := bson.M{
+		"$inc": bson.M{"bytes": bytes, "files": files},
+		"$set": bson.M{"updatedAt": time.Now().UTC()},
+	}
+	result := Usage{}
+	opts := options.FindOneAndUpdate().SetReturnDocument(options.After)
+	err = ur.db.Collection(collection).FindOneAndUpdate(ctx, filter, update, opts).Decode(&result)
+	if errors.Is(err, mongo.ErrNoDocuments) {
+		return nil, ErrLimitReached
+	} else if err != nil {
+		return nil, err
+	}
+	return &result, nil
+}
+
+func (ur *usageRepository) InsertEvent(ctx context.Context, event *UsageEvent) error {
+	ownerId := ctx.Value("UserId").(primitive.ObjectID)
+	event.ID = primitive.NewObjectID()
+	event.Owner = ownerId
+	event.CreatedAt = time.Now().UTC()
+	_, err := ur.db.Collection("usage-events").InsertOne(ctx, event)
+	return err
+}
+
+func (ur *usageRepository) FindEvents(ctx context.Context, projectId primitive.ObjectID, limit int64) ([]UsageEvent, error) {
+	ownerId := ctx.Value("UserId").(primitive.ObjectID)
+	if limit <= 0 {
+		limit = defaultUsageEventLimit
+	}
+	filter := bson.D{{Key: "projectId", Value: projectId}, {Key: "owner", Value: ownerId}}
+	opts := options.Find().SetSort(bson.D{{Key: "createdAt", Value: -1}}).SetLimit(limit)
+	cursor, err := ur.db.Collection("usage-events").Find(ctx, filter, opts)
+	if err != nil {
+		return nil, err
+	}
+	result := make([]UsageEvent, 0)
+	err = cursor.All(ctx, &result)
+	if err != nil {
+		return nil, err
+	}
+	return result, nil
+}
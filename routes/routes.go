@@ -6,31 +6,88 @@ import (
 	"github.com/draco121/horizon/utils"
 	"github.com/gin-gonic/gin"
 	"pulse/controllers"
+	"pulse/metrics"
+	"pulse/openapi"
 )
 
 func RegisterRoutes(controllers controllers.Controllers, router *gin.Engine) {
 	utils.Logger.Info("Registering routes...")
 	v1 := router.Group("/v1")
 	// Register UploadTrainingData controller function
-	v1.POST("/upload/:projectId/:botId", middlewares.AuthMiddleware(constants.Write), controllers.UploadTrainingData)
+	v1.POST("/upload/:projectId/:botId", middlewares.AuthMiddleware(constants.Write), controllers.Idempotent(), controllers.UploadTrainingData)
 
 	// Register DeleteFile controller function
 	v1.DELETE("/delete/:projectId/:botId/:fileId", middlewares.AuthMiddleware(constants.Write), controllers.DeleteFile)
 
 	// Register GetFile controller function
 	v1.GET("/download/:projectId/:botId/:fileId", middlewares.AuthMiddleware(constants.Read), controllers.GetFile)
+	v1.HEAD("/download/:projectId/:botId/:fileId", middlewares.AuthMiddleware(constants.Read), controllers.GetFile)
+
+	// Register DownloadArchive controller function
+	v1.GET("/download/:projectId/:botId", middlewares.AuthMiddleware(constants.Read), controllers.DownloadArchive)
+
+	// Register ListFiles controller function
+	v1.GET("/files/:projectId/:botId", middlewares.AuthMiddleware(constants.Read), controllers.ListFiles)
+
+	// Register MoveFiles controller function
+	v1.POST("/files/:projectId/:botId/move", middlewares.AuthMiddleware(constants.Write), controllers.MoveFiles)
+
+	// Register TagFiles controller function
+	v1.POST("/files/:projectId/:botId/tags", middlewares.AuthMiddleware(constants.Write), controllers.TagFiles)
+
+	// Register RunBatch controller function
+	v1.POST("/files/:projectId/:botId/batch", middlewares.AuthMiddleware(constants.Write), controllers.RunBatch)
+
+	// Register ReplaceFile controller function
+	v1.PUT("/files/:projectId/:botId/:fileId", middlewares.AuthMiddleware(constants.Write), controllers.ReplaceFile)
+
+	// Register ListRevisions controller function
+	v1.GET("/files/:projectId/:botId/:fileId/revisions", middlewares.AuthMiddleware(constants.Read), controllers.ListRevisions)
+
+	// Register GetRevision controller function
+	v1.GET("/files/:projectId/:botId/:fileId/revisions/:revision", middlewares.AuthMiddleware(constants.Read), controllers.GetRevision)
+	v1.HEAD("/files/:projectId/:botId/:fileId/revisions/:revision", middlewares.AuthMiddleware(constants.Read), controllers.GetRevision)
 
 	// Register AddTrainingData controller function
-	v1.POST("/trainingdata", middlewares.AuthMiddleware(constants.Write), controllers.AddTrainingData)
+	v1.POST("/trainingdata", middlewares.AuthMiddleware(constants.Write), controllers.Idempotent(), controllers.AddTrainingData)
 
 	// Register GetTrainingData controller function
 	v1.GET("/trainingdata", middlewares.AuthMiddleware(constants.Read), controllers.GetTrainingData)
 
 	// Register UpdateTrainingData controller function
-	v1.PATCH("/trainingdata", middlewares.AuthMiddleware(constants.Write), controllers.GetTrainingData)
+	v1.PATCH("/trainingdata", middlewares.AuthMiddleware(constants.Write), controllers.UpdateTrainingData)
 
 	// Register ResetTrainingData controller function
 	v1.DELETE("/trainingdata", middlewares.AuthMiddleware(constants.Write), controllers.DeleteTrainingData)
 
+	// Register GetUsage controller function
+	v1.GET("/usage/:projectId", middlewares.AuthMiddleware(constants.Read), controllers.GetUsage)
+
+	// Register GetUploadPolicy controller function
+	v1.GET("/policy/:projectId", middlewares.AuthMiddleware(constants.Read), controllers.GetUploadPolicy)
+
+	// Register SetUploadPolicy controller function
+	v1.PUT("/policy/:projectId", middlewares.AuthMiddleware(constants.Write), controllers.SetUploadPolicy)
+
+	// Register RotateKeys controller function
+	v1.POST("/keys/rotate", middlewares.AuthMiddleware(constants.All), controllers.RotateKeys)
+
+	// Register Healthz controller function
+	router.GET("/healthz", controllers.Healthz)
+	router.HEAD("/healthz", controllers.Healthz)
+
+	// Register Readyz controller function
+	router.GET("/readyz", controllers.Readyz)
+	router.HEAD("/readyz", controllers.Readyz)
+
+	// Register DebugStatus controller function
+	router.GET("/debug/status", middlewares.AuthMiddleware(constants.All), controllers.DebugStatus)
+
+	// Register metrics handler
+	router.GET("/metrics", gin.WrapH(metrics.Handler()))
+
+	// Register the OpenAPI document and the page rendering it
+	router.GET(openapi.SpecPath, openapi.Serve)
+	router.GET(openapi.UIPath, openapi.ServeUI)
 	utils.Logger.Info("Routes registered")
 }
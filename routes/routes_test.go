@@ -0,0 +1,20 @@
+package routes_test
+
+import (
+	"github.com/gin-gonic/gin"
+	"pulse/controllers"
+	"pulse/openapi"
+	"pulse/routes"
+	"testing"
+)
+
+func TestRoutesAreDocumented(t *testing.T) {
+	gin.SetMode(gin.TestMode)
+	router := gin.New()
+	// handlers are only registered, never called, so no service is needed
+	routes.RegisterRoutes(controllers.Controllers{}, router)
+	err := openapi.Check(router.Routes())
+	if err != nil {
+		t.Fatal(err)
+	}
+}
@@ -0,0 +1,143 @@
+package scanner
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"net/url"
+	"strings"
+	"time"
+)
+
+const (
+	StatusClean    = "clean"
+	StatusInfected = "infected"
+	StatusSkipped  = "not_scanned"
+)
+
+// chunkSize is the size of the INSTREAM chunks sent to clamd, well below its
+// default StreamMaxLength.
+const chunkSize = 64 << 10
+
+var ErrScanFailed = errors.New("malware scan failed")
+
+type ScanResult struct {
+	Status    string
+	Signature string
+	ScannedAt time.Time
+}
+
+type IScanner interface {
+	Scan(ctx context.Context, reader io.Reader) (*ScanResult, error)
+}
+
+type clamdScanner struct {
+	IScanner
+	network string
+	address string
+	timeout time.Duration
+}
+
+// NewClamdScanner returns a scanner talking to clamd at the given address,
+// either tcp://host:port or unix:///path/to/clamd.sock.
+func NewClamdScanner(address string, timeout time.Duration) (IScanner, error) {
+	u, err := url.Parse(address)
+	if err != nil {
+		return nil, err
+	}
+	s := &clamdScanner{timeout: timeout}
+	switch u.Scheme {
+	case "tcp":
+		s.network, s.address = "tcp", u.Host
+	case "unix":
+		s.network, s.address = "unix", u.Path
+	default:
+		return nil, fmt.Errorf("unsupported clamd address %q", address)
+	}
+	return s, nil
+}
+
+func (s *clamdScanner) Scan(ctx context.Context, reader io.Reader) (*ScanResult, error) {
+	dialer := net.Dialer{Timeout: s.timeout}
+	conn, err := dialer.DialContext(ctx, s.network, s.address)
+	if err != nil {
+		return nil, fmt.Errorf("%w: %s", ErrScanFailed, err.Error())
+	}
+	defer conn.Close()
+	if deadline, ok := ctx.Deadline(); ok {
+		_ = conn.SetDeadline(deadline)
+	} else if s.timeout > 0 {
+		_ = conn.SetDeadline(time.Now().Add(s.timeout))
+	}
+
+	_, err = conn.Write([]byte("zINSTREAM\x00"))
+	if err != nil {
+		return nil, fmt.Errorf("%w: %s", ErrScanFailed, err.Error())
+	}
+	buf := make([]byte, chunkSize)
+	size := make([]byte, 4)
+	for {
+		n, readErr := reader.Read(buf)
+		if n > 0 {
+			binary.BigEndian.PutUint32(size, uint32(n))
+			if _, err = conn.Write(size); err != nil {
+				return nil, fmt.Errorf("%w: %s", ErrScanFailed, err.Error())
+			}
+			if _, err = conn.Write(buf[:n]); err != nil {
+				return nil, fmt.Errorf("%w: %s", ErrScanFailed, err.Error())
+			}
+		}
+		if errors.Is(readErr, io.EOF) {
+			break
+		} else if readErr != nil {
+			return nil, readErr
+		}
+	}
+	_, err = conn.Write([]byte{0, 0, 0, 0})
+	if err != nil {
+		return nil, fmt.Errorf("%w: %s", ErrScanFailed, err.Error())
+	}
+
+	reply, err := bufio.NewReader(conn).ReadBytes(0)
+	if err != nil && !errors.Is(err, io.EOF) {
+		return nil, fmt.Errorf("%w: %s", ErrScanFailed, err.Error())
+	}
+	return parseReply(string(bytes.TrimRight(reply, "\x00\n")))
+}
+
+// parseReply interprets a clamd answer such as "stream: OK" or
+// "stream: Eicar-Signature FOUND".
+func parseReply(reply string) (*ScanResult, error) {
+	result := strings.TrimSpace(strings.TrimPrefix(reply, "stream:"))
+	switch {
+	case result == "OK":
+		return &ScanResult{Status: StatusClean, ScannedAt: time.Now().UTC()}, nil
+	case strings.HasSuffix(result, " FOUND"):
+		return &ScanResult{
+			Status:    StatusInfected,
+			Signature: strings.TrimSuffix(result, " FOUND"),
+			ScannedAt: time.Now().UTC(),
+		}, nil
+	default:
+		return nil, fmt.Errorf("%w: clamd replied %q", ErrScanFailed, reply)
+	}
+}
+
+type noopScanner struct {
+	IScanner
+}
+
+// NewNoopScanner returns a scanner that marks every file as not scanned, used
+// when no clamd is configured.
+func NewNoopScanner() IScanner {
+	return &noopScanner{}
+}
+
+func (s *noopScanner) Scan(ctx context.Context, reader io.Reader) (*ScanResult, error) {
+	return &ScanResult{Status: StatusSkipped}, nil
+}
@@ -0,0 +1,148 @@
+package scanner
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"encoding/binary"
+	"errors"
+	"io"
+	"net"
+	"strings"
+	"testing"
+	"time"
+)
+
+// eicar stands for malware, the fake clamd finds it in any stream holding it.
+const eicar = "EICAR-TEST"
+
+// fakeClamd speaks the INSTREAM command of clamd and answers with reply when
+// it is set, otherwise with what it found in the stream. It reports the size
+// of every chunk it received.
+func fakeClamd(t *testing.T, reply string) (string, <-chan []int) {
+	t.Helper()
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() {
+		_ = listener.Close()
+	})
+	chunks := make(chan []int, 1)
+	go func() {
+		for {
+			conn, err := listener.Accept()
+			if err != nil {
+				return
+			}
+			sizes, err := serveInstream(conn, reply)
+			_ = conn.Close()
+			if err != nil {
+				t.Error(err)
+			}
+			chunks <- sizes
+		}
+	}()
+	return "tcp://" + listener.Addr().String(), chunks
+}
+
+func serveInstream(conn net.Conn, reply string) ([]int, error) {
+	reader := bufio.NewReader(conn)
+	command, err := reader.ReadString(0)
+	if err != nil {
+		return nil, err
+	} else if command != "zINSTREAM\x00" {
+		return nil, errors.New("unexpected command " + command)
+	}
+	var stream bytes.Buffer
+	sizes := make([]int, 0)
+	for {
+		var size uint32
+		err = binary.Read(reader, binary.BigEndian, &size)
+		if err != nil {
+			return sizes, err
+		} else if size == 0 {
+			break
+		}
+		sizes = append(sizes, int(size))
+		_, err = io.CopyN(&stream, reader, int64(size))
+		if err != nil {
+			return sizes, err
+		}
+	}
+	if reply == "" {
+		reply = "stream: OK"
+		if strings.Contains(stream.String(), eicar) {
+			reply = "stream: Eicar-Test-Signature FOUND"
+		}
+	}
+	_, err = conn.Write([]byte(reply + "\x00"))
+	return sizes, err
+}
+
+func TestClamdScan(t *testing.T) {
+	address, chunks := fakeClamd(t, "")
+	scanner, err := NewClamdScanner(address, 5*time.Second)
+	if err != nil {
+		t.Fatal(err)
+	}
+	result, err := scanner.Scan(context.Background(), strings.NewReader("hello"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if result.Status != StatusClean || result.ScannedAt.IsZero() {
+		t.Fatalf("result = %+v, want clean", result)
+	}
+	<-chunks
+
+	// the signature spans two chunks
+	content := bytes.Repeat([]byte{'a'}, chunkSize-4)
+	content = append(content, eicar...)
+	result, err = scanner.Scan(context.Background(), bytes.NewReader(content))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if result.Status != StatusInfected || result.Signature != "Eicar-Test-Signature" {
+		t.Fatalf("result = %+v, want infected", result)
+	}
+	sizes := <-chunks
+	if len(sizes) != 2 || sizes[0] != chunkSize || sizes[1] != len(content)-chunkSize {
+		t.Fatalf("chunk sizes = %v, want %d then %d", sizes, chunkSize, len(content)-chunkSize)
+	}
+}
+
+func TestClamdScanFailures(t *testing.T) {
+	address, _ := fakeClamd(t, "INSTREAM size limit exceeded. ERROR")
+	scanner, err := NewClamdScanner(address, 5*time.Second)
+	if err != nil {
+		t.Fatal(err)
+	}
+	_, err = scanner.Scan(context.Background(), strings.NewReader("hello"))
+	if !errors.Is(err, ErrScanFailed) {
+		t.Fatalf("err = %v, want ErrScanFailed", err)
+	}
+
+	// nothing listens on a closed listener
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	_ = listener.Close()
+	scanner, err = NewClamdScanner("tcp://"+listener.Addr().String(), time.Second)
+	if err != nil {
+		t.Fatal(err)
+	}
+	_, err = scanner.Scan(context.Background(), strings.NewReader("hello"))
+	if !errors.Is(err, ErrScanFailed) {
+		t.Fatalf("err = %v, want ErrScanFailed", err)
+	}
+}
+
+func TestNewClamdScannerAddress(t *testing.T) {
+	for _, address := range []string{"clamd:3310", "http://clamd:3310"} {
+		_, err := NewClamdScanner(address, time.Second)
+		if err == nil {
+			t.Errorf("NewClamdScanner(%q) accepted the address", address)
+		}
+	}
+}
@@ -0,0 +1,156 @@
+package main
+
+import (
+	"context"
+	"crypto/tls"
+	"github.com/draco121/horizon/utils"
+	"go.mongodb.org/mongo-driver/mongo"
+	"net"
+	"net/http"
+	"os/signal"
+	"pulse/config"
+	"pulse/core"
+	"strconv"
+	"sync"
+	"syscall"
+	"time"
+)
+
+// abortGrace is the time aborted requests and jobs get to clean up their
+// partial writes once the shutdown deadline has passed.
+const abortGrace = 5 * time.Second
+
+// server runs the HTTP server and the background jobs of the service and
+// stops them in order on SIGINT or SIGTERM.
+type server struct {
+	http           *http.Server
+	tls            config.TLS
+	health         core.IHealthService
+	client         *mongo.Client
+	delay          time.Duration
+	timeout        time.Duration
+	cancelRequests context.CancelFunc
+	jobs           context.Context
+	cancelJobs     context.CancelFunc
+	background     sync.WaitGroup
+}
+
+// newServer serves router on the configured port. On shutdown readiness
+// fails for the shutdown delay before the listener closes, then in-flight
+// requests and background jobs have the shutdown timeout to finish before
+// they are cancelled.
+func newServer(cfg config.Server, router http.Handler, health core.IHealthService, client *mongo.Client) *server {
+	requests, cancelRequests := context.WithCancel(context.Background())
+	jobs, cancelJobs := context.WithCancel(context.Background())
+	minVersion := uint16(tls.VersionTLS12)
+	if cfg.TLS.MinVersion == "1.3" {
+		minVersion = tls.VersionTLS13
+	}
+	return &server{
+		http: &http.Server{
+			Addr:              ":" + strconv.Itoa(cfg.Port),
+			Handler:           router,
+			ReadHeaderTimeout: cfg.ReadHeaderTimeout,
+			IdleTimeout:       cfg.IdleTimeout,
+			TLSConfig:         &tls.Config{MinVersion: minVersion},
+			BaseContext: func(net.Listener) context.Context {
+				return requests
+			},
+		},
+		tls:            cfg.TLS,
+		health:         health,
+		client:         client,
+		delay:          cfg.ShutdownDelay,
+		timeout:        cfg.ShutdownTimeout,
+		cancelRequests: cancelRequests,
+		jobs:           jobs,
+		cancelJobs:     cancelJobs,
+	}
+}
+
+// Go runs job in the background. Shutdown waits for it and cancels its
+// context once the deadline has passed.
+func (s *server) Go(name string, job func(ctx context.Context) error) {
+	done := s.health.StartWorker(name)
+	s.background.Add(1)
+	go func() {
+		defer s.background.Done()
+		done(job(s.jobs))
+	}()
+}
+
+// Run serves until a signal asks to stop and the shutdown has completed.
+func (s *server) Run() error {
+	stop, cancel := signal.NotifyContext(context.Background(), syscall.SIGINT, syscall.SIGTERM)
+	defer cancel()
+	failed := make(chan error, 1)
+	go func() {
+		if s.tls.Enabled() {
+			failed <- s.http.ListenAndServeTLS(s.tls.CertFile, s.tls.KeyFile)
+			return
+		}
+		failed <- s.http.ListenAndServe()
+	}()
+	select {
+	case err := <-failed:
+		return err
+	case <-stop.Done():
+	}
+	// a second signal kills the process right away
+	cancel()
+	s.shutdown()
+	return nil
+}
+
+func (s *server) shutdown() {
+	// keep serving while failing readiness so that the load balancer stops
+	// routing here before the listener closes
+	s.health.BeginShutdown()
+	utils.Logger.Info("shutting down trainingservice...")
+	time.Sleep(s.delay)
+
+	ctx, cancel := context.WithTimeout(context.Background(), s.timeout)
+	defer cancel()
+	err := s.http.Shutdown(ctx)
+	if err == nil {
+		err = s.waitJobs(ctx)
+	}
+	if err != nil {
+		utils.Logger.Warn("requests and background jobs still running after ", s.timeout.String(), ", aborting them")
+		s.cancelRequests()
+		s.cancelJobs()
+		grace, cancelGrace := context.WithTimeout(context.Background(), abortGrace)
+		defer cancelGrace()
+		err = s.http.Shutdown(grace)
+		if err != nil {
+			utils.Logger.Error("closing connections of requests that did not stop: ", err.Error())
+			_ = s.http.Close()
+		}
+		err = s.waitJobs(grace)
+		if err != nil {
+			utils.Logger.Error("background jobs did not stop: ", err.Error())
+		}
+	}
+
+	ctx, cancel = context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+	err = s.client.Disconnect(ctx)
+	if err != nil {
+		utils.Logger.Error("failed to disconnect from mongo db: ", err.Error())
+	}
+	utils.Logger.Info("trainingservice stopped")
+}
+
+func (s *server) waitJobs(ctx context.Context) error {
+	done := make(chan struct{})
+	go func() {
+		s.background.Wait()
+		close(done)
+	}()
+	select {
+	case <-done:
+		return nil
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+}
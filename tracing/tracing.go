@@ -0,0 +1,150 @@
+package tracing
+
+import (
+	"context"
+	"fmt"
+	"go.mongodb.org/mongo-driver/event"
+	"go.opentelemetry.io/otel"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/codes"
+	"go.opentelemetry.io/otel/exporters/otlp/otlptrace/otlptracehttp"
+	"go.opentelemetry.io/otel/exporters/stdout/stdouttrace"
+	"go.opentelemetry.io/otel/propagation"
+	"go.opentelemetry.io/otel/sdk/resource"
+	sdktrace "go.opentelemetry.io/otel/sdk/trace"
+	"go.opentelemetry.io/otel/sdk/trace/tracetest"
+	"go.opentelemetry.io/otel/trace"
+	"sync"
+)
+
+// Name is the instrumentation scope and default service name of the spans.
+const Name = "pulse"
+
+const (
+	ExporterNone = "none"
+	// ExporterOTLP sends spans over OTLP/HTTP, configured by the standard
+	// OTEL_EXPORTER_OTLP_* variables.
+	ExporterOTLP = "otlp"
+	// ExporterStdout prints spans, ExporterMemory keeps them in
+	// MemoryExporter; both are meant for development and tests.
+	ExporterStdout = "stdout"
+	ExporterMemory = "memory"
+)
+
+// MemoryExporter holds the spans recorded with ExporterMemory.
+var MemoryExporter = tracetest.NewInMemoryExporter()
+
+// Setup installs the tracer provider exporting to the named exporter along
+// with the W3C trace context and baggage propagators. The returned function
+// flushes the pending spans. With ExporterNone spans are not recorded but
+// incoming trace context is still passed on.
+func Setup(ctx context.Context, exporter string) (func(context.Context) error, error) {
+	otel.SetTextMapPropagator(propagation.NewCompositeTextMapPropagator(propagation.TraceContext{}, propagation.Baggage{}))
+	var processor sdktrace.TracerProviderOption
+	switch exporter {
+	case ExporterNone, "":
+		return func(context.Context) error { return nil }, nil
+	case ExporterOTLP:
+		client, err := otlptracehttp.New(ctx)
+		if err != nil {
+			return nil, err
+		}
+		processor = sdktrace.WithBatcher(client)
+	case ExporterStdout:
+		client, err := stdouttrace.New()
+		if err != nil {
+			return nil, err
+		}
+		processor = sdktrace.WithSyncer(client)
+	case ExporterMemory:
+		processor = sdktrace.WithSyncer(MemoryExporter)
+	default:
+		return nil, fmt.Errorf("unknown trace exporter %q", exporter)
+	}
+	// OTEL_SERVICE_NAME and OTEL_RESOURCE_ATTRIBUTES override the defaults
+	res, err := resource.New(ctx,
+		resource.WithAttributes(attribute.String("service.name", Name)),
+		resource.WithTelemetrySDK(),
+		resource.WithFromEnv(),
+	)
+	if err != nil {
+		return nil, err
+	}
+	provider := sdktrace.NewTracerProvider(processor, sdktrace.WithResource(res))
+	otel.SetTracerProvider(provider)
+	return provider.Shutdown, nil
+}
+
+// Start starts a span as a child of the span in ctx.
+func Start(ctx context.Context, name string, attributes ...attribute.KeyValue) (context.Context, trace.Span) {
+	return otel.Tracer(Name).Start(ctx, name, trace.WithAttributes(attributes...))
+}
+
+// StartServer starts the span of an incoming request.
+func StartServer(ctx context.Context, name string, attributes ...attribute.KeyValue) (context.Context, trace.Span) {
+	return otel.Tracer(Name).Start(ctx, name, trace.WithAttributes(attributes...), trace.WithSpanKind(trace.SpanKindServer))
+}
+
+// End records err on span when set and ends the span.
+func End(span trace.Span, err error) {
+	if err != nil {
+		span.RecordError(err)
+		span.SetStatus(codes.Error, err.Error())
+	}
+	span.End()
+}
+
+func BotId(botId string) attribute.KeyValue {
+	return attribute.String("pulse.bot_id", botId)
+}
+
+func ProjectId(projectId string) attribute.KeyValue {
+	return attribute.String("pulse.project_id", projectId)
+}
+
+func FileId(fileId string) attribute.KeyValue {
+	return attribute.String("pulse.file_id", fileId)
+}
+
+// StorageKey names the stored file an operation touches.
+func StorageKey(key string) attribute.KeyValue {
+	return attribute.String("pulse.storage.key", key)
+}
+
+// CommandMonitor traces every MongoDB command as a client span under the
+// span of the operation that sent it, then passes the events on to next.
+func CommandMonitor(next *event.CommandMonitor) *event.CommandMonitor {
+	var spans sync.Map
+	return &event.CommandMonitor{
+		Started: func(ctx context.Context, started *event.CommandStartedEvent) {
+			_, span := otel.Tracer(Name).Start(ctx, "mongo."+started.CommandName,
+				trace.WithSpanKind(trace.SpanKindClient),
+				trace.WithAttributes(
+					attribute.String("db.system", "mongodb"),
+					attribute.String("db.operation.name", started.CommandName),
+					attribute.String("db.namespace", started.DatabaseName),
+				))
+			spans.Store(started.RequestID, span)
+			if next != nil && next.Started != nil {
+				next.Started(ctx, started)
+			}
+		},
+		Succeeded: func(ctx context.Context, succeeded *event.CommandSucceededEvent) {
+			if span, ok := spans.LoadAndDelete(succeeded.RequestID); ok {
+				span.(trace.Span).End()
+			}
+			if next != nil && next.Succeeded != nil {
+				next.Succeeded(ctx, succeeded)
+			}
+		},
+		Failed: func(ctx context.Context, failed *event.CommandFailedEvent) {
+			if span, ok := spans.LoadAndDelete(failed.RequestID); ok {
+				span.(trace.Span).SetStatus(codes.Error, failed.Failure)
+				span.(trace.Span).End()
+			}
+			if next != nil && next.Failed != nil {
+				next.Failed(ctx, failed)
+			}
+		},
+	}
+}